@@ -0,0 +1,72 @@
+package etlog
+
+import (
+	"github.com/edditen/etlog/common/utils"
+	"github.com/edditen/etlog/config"
+	"github.com/edditen/etlog/handler/cleaner"
+	"github.com/pkg/errors"
+	"regexp"
+	"time"
+)
+
+// initCleaner creates the standalone cleaner by the rules of config.
+func initCleaner(conf *config.CleanerConfig) (*cleaner.RuleCleaner, error) {
+	rules := make([]*cleaner.Rule, 0, len(conf.Rules))
+	for _, rc := range conf.Rules {
+		rule, err := newCleanRule(rc)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+
+	options := []cleaner.RuleOption{cleaner.SetRuleDryRun(conf.DryRun)}
+	if conf.Interval != "" {
+		interval, err := utils.ParseSeconds(conf.Interval)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse cleaner interval error")
+		}
+		options = append(options, cleaner.SetRuleCheckInterval(time.Duration(interval)*time.Second))
+	}
+
+	rc, err := cleaner.NewRuleCleaner(rules, options...)
+	if err != nil {
+		return nil, errors.Wrap(err, "create cleaner error")
+	}
+	if err := rc.Init(); err != nil {
+		return nil, errors.Wrap(err, "init cleaner error")
+	}
+	return rc, nil
+}
+
+func newCleanRule(conf *config.CleanRule) (*cleaner.Rule, error) {
+	rule := &cleaner.Rule{
+		Name:       conf.Name,
+		Pattern:    conf.Pattern,
+		TimeLayout: conf.TimeLayout,
+		MaxCount:   conf.MaxCount,
+	}
+
+	if conf.TimeRegex != "" {
+		re, err := regexp.Compile(conf.TimeRegex)
+		if err != nil {
+			return nil, errors.Wrapf(err, "compile time regex of rule %s error", rule)
+		}
+		rule.TimeRegex = re
+	}
+	if conf.MaxAge != "" {
+		age, err := utils.ParseSeconds(conf.MaxAge)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse max age of rule %s error", rule)
+		}
+		rule.MaxAge = time.Duration(age) * time.Second
+	}
+	if conf.MaxSize != "" {
+		size, err := utils.ParseSize(conf.MaxSize)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse max size of rule %s error", rule)
+		}
+		rule.MaxSize = int64(size)
+	}
+	return rule, nil
+}
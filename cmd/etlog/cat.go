@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+)
+
+const catUsage = "cat [-no-backups] <file>...    print the backups, archives and the file in order"
+
+var catCommand = &command{
+	name:  "cat",
+	usage: catUsage,
+	run:   runCat,
+}
+
+func runCat(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("cat", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	sf := newSourceFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fmt.Fprintln(stderr, "usage: etlog", catUsage)
+		return 2
+	}
+
+	sources, err := sf.sources(fs.Args())
+	if err != nil {
+		fmt.Fprintf(stderr, "etlog: cat: %v\n", err)
+		return 1
+	}
+	err = sf.eachSourceLine(sources, func(src *source, line []byte) error {
+		return writeLine(stdout, line)
+	})
+	if err != nil {
+		fmt.Fprintf(stderr, "etlog: cat: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func writeLine(w io.Writer, line []byte) error {
+	if _, err := w.Write(line); err != nil {
+		return err
+	}
+	_, err := w.Write([]byte{'\n'})
+	return err
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/edditen/etlog/handler/archiver"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"strings"
+)
+
+const decryptUsage = "decrypt -key ID=file:PATH|env:VAR [-o out] <file>    decrypt the encrypted archive"
+
+var decryptCommand = &command{
+	name:  "decrypt",
+	usage: decryptUsage,
+	run:   runDecrypt,
+}
+
+// keyFlags the repeated -key flags, the key of each ID is loaded
+// from a file or an env var.
+type keyFlags archiver.Keyring
+
+func (kf keyFlags) String() string {
+	ids := make([]string, 0, len(kf))
+	for id := range kf {
+		ids = append(ids, id)
+	}
+	return strings.Join(ids, ",")
+}
+
+func (kf keyFlags) Set(value string) error {
+	i := strings.Index(value, "=")
+	if i < 0 {
+		return errors.New("key should be ID=file:PATH or ID=env:VAR")
+	}
+	id, source := value[:i], value[i+1:]
+
+	var key []byte
+	var err error
+	switch {
+	case strings.HasPrefix(source, "file:"):
+		key, err = archiver.LoadKey(strings.TrimPrefix(source, "file:"), "")
+	case strings.HasPrefix(source, "env:"):
+		key, err = archiver.LoadKey("", strings.TrimPrefix(source, "env:"))
+	default:
+		return errors.New("key source should be file:PATH or env:VAR")
+	}
+	if err != nil {
+		return err
+	}
+	kf[id] = key
+	return nil
+}
+
+func runDecrypt(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("decrypt", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	keys := keyFlags{}
+	fs.Var(keys, "key", "the key of ID, from file:PATH or env:VAR, repeated for rotated keys")
+	output := fs.String("o", "", "the output file, - for stdout, the input without "+archiver.EncryptExt+" by default")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 || len(keys) == 0 {
+		fmt.Fprintln(stderr, "usage: etlog", decryptUsage)
+		return 2
+	}
+
+	input := fs.Arg(0)
+	if *output == "-" {
+		f, err := os.Open(input)
+		if err != nil {
+			fmt.Fprintf(stderr, "etlog: decrypt: %v\n", err)
+			return 1
+		}
+		defer f.Close()
+		if err := archiver.Decrypt(stdout, f, archiver.Keyring(keys)); err != nil {
+			fmt.Fprintf(stderr, "etlog: decrypt: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	if *output == "" {
+		if !strings.HasSuffix(input, archiver.EncryptExt) {
+			fmt.Fprintf(stderr, "etlog: decrypt: -o is required, %s has no %s extension\n", input, archiver.EncryptExt)
+			return 2
+		}
+		*output = strings.TrimSuffix(input, archiver.EncryptExt)
+	}
+	if err := archiver.DecryptFile(input, *output, archiver.Keyring(keys)); err != nil {
+		fmt.Fprintf(stderr, "etlog: decrypt: %v\n", err)
+		return 1
+	}
+	return 0
+}
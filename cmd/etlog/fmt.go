@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/edditen/etlog/core"
+	"github.com/pkg/errors"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const fmtUsage = "fmt -to simple|full|json|logfmt [-in format] <file>...    convert the entries to another format"
+
+var fmtCommand = &command{
+	name:  "fmt",
+	usage: fmtUsage,
+	run:   runFmt,
+}
+
+const (
+	autoFormat   = "auto"
+	logfmtFormat = "logfmt"
+	// fullTimeSep the offset of the first separator in full format
+	fullTimeSep = len("2006-01-02 15:04:05.000000")
+)
+
+// autoParser detects the format of each line, so the files written in
+// different formats could be read together.
+type autoParser struct {
+	simple core.Parser
+	full   core.Parser
+	json   core.Parser
+}
+
+func newAutoParser() *autoParser {
+	return &autoParser{
+		simple: core.NewSimpleParser(),
+		full:   core.NewFullParser(),
+		json:   core.NewJSONParser(),
+	}
+}
+
+func (ap *autoParser) Parse(line []byte) (*core.LogEntry, error) {
+	switch {
+	case isJSONLine(line):
+		return ap.json.Parse(line)
+	case len(line) > fullTimeSep && line[fullTimeSep] == '|':
+		return ap.full.Parse(line)
+	}
+	return ap.simple.Parse(line)
+}
+
+// isJSONLine reports whether the line is in json format, the only format
+// carrying the marker.
+func isJSONLine(line []byte) bool {
+	return len(line) > 0 && line[0] == '{'
+}
+
+// newParser returns the parser of the input format, auto by default.
+func newParser(format string) (core.Parser, error) {
+	switch strings.ToLower(format) {
+	case "", autoFormat:
+		return newAutoParser(), nil
+	case "simple", "full", "json":
+		return core.NewParser(core.NewFormat(format)), nil
+	}
+	return nil, errors.Errorf("unknown input format: %s", format)
+}
+
+// entryWriter writes the entries in the output format.
+type entryWriter func(w io.Writer, entry *core.LogEntry) error
+
+func newEntryWriter(format string) (entryWriter, error) {
+	switch strings.ToLower(format) {
+	case "simple", "full", "json":
+		formatter := core.FormatterFactory(core.NewFormat(format))
+		return func(w io.Writer, entry *core.LogEntry) error {
+			buf := formatter.Format(entry)
+			defer buf.Free()
+			_, err := w.Write(buf.Bytes())
+			return err
+		}, nil
+	case logfmtFormat:
+		return func(w io.Writer, entry *core.LogEntry) error {
+			_, err := w.Write(formatLogfmt(entry))
+			return err
+		}, nil
+	}
+	return nil, errors.Errorf("unknown output format: %s", format)
+}
+
+// formatLogfmt formats the entry as the key=value pairs, the fields are
+// sorted by key after the built-in keys.
+func formatLogfmt(entry *core.LogEntry) []byte {
+	var buf bytes.Buffer
+	appendPair := func(key, value string) {
+		if buf.Len() > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(key)
+		buf.WriteByte('=')
+		buf.WriteString(logfmtValue(value))
+	}
+
+	appendPair("time", entry.Time.Format(time.RFC3339Nano))
+	appendPair("level", entry.Level.String())
+	if entry.UseLoc {
+		appendPair("caller", entry.SrcFile+":"+strconv.Itoa(entry.Line))
+		appendPair("func", entry.FuncName)
+	}
+	if entry.Marker != "" {
+		appendPair("marker", entry.Marker)
+	}
+	appendPair("msg", entry.Msg)
+	if entry.Err != nil {
+		appendPair("error", entry.Err.Error())
+	}
+
+	keys := make([]string, 0, len(entry.Fields))
+	for k := range entry.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		appendPair(k, fieldString(entry.Fields[k]))
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
+
+// logfmtValue quotes the value with spaces, quotes, equal signs or the
+// control characters, and the empty value.
+func logfmtValue(value string) string {
+	if value == "" {
+		return `""`
+	}
+	for _, r := range value {
+		if r <= ' ' || r == '=' || r == '"' || r == 0x7f {
+			return strconv.Quote(value)
+		}
+	}
+	return value
+}
+
+// fieldString the text of field value, the objects and arrays in json.
+func fieldString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case map[string]interface{}, []interface{}:
+		b, err := json.Marshal(v)
+		if err == nil {
+			return string(b)
+		}
+	}
+	return fmt.Sprint(value)
+}
+
+// runFmt converts all the entries, the invalid lines are reported and
+// skipped, and exits with 1 at last.
+func runFmt(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("fmt", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	in := fs.String("in", autoFormat, "the input format, simple, full, json or auto")
+	to := fs.String("to", "", "the output format, simple, full, json or logfmt")
+	sf := newSourceFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 || *to == "" {
+		fmt.Fprintln(stderr, "usage: etlog", fmtUsage)
+		return 2
+	}
+	parser, err := newParser(*in)
+	if err != nil {
+		fmt.Fprintf(stderr, "etlog: fmt: %v\n", err)
+		return 2
+	}
+	write, err := newEntryWriter(*to)
+	if err != nil {
+		fmt.Fprintf(stderr, "etlog: fmt: %v\n", err)
+		return 2
+	}
+
+	sources, err := sf.sources(fs.Args())
+	if err != nil {
+		fmt.Fprintf(stderr, "etlog: fmt: %v\n", err)
+		return 1
+	}
+	invalid := 0
+	err = sf.eachSourceLine(sources, func(src *source, line []byte) error {
+		entry, err := parser.Parse(line)
+		if err != nil {
+			invalid++
+			fmt.Fprintf(stderr, "etlog: fmt: %s: %v\n", src.path, err)
+			return nil
+		}
+		return write(stdout, entry)
+	})
+	if err != nil {
+		fmt.Fprintf(stderr, "etlog: fmt: %v\n", err)
+		return 1
+	}
+	if invalid > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/edditen/etlog/core"
+	"github.com/edditen/etlog/handler/index"
+	"github.com/pkg/errors"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const grepUsage = "grep [-level L] [-marker M] [-since T] [-until T] [-field K=V|K~RE] [-msg RE] <file>...    filter the entries"
+
+var grepCommand = &command{
+	name:  "grep",
+	usage: grepUsage,
+	run:   runGrep,
+}
+
+// timeLayouts the layouts of -since and -until, in local time.
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999",
+	"2006-01-02T15:04:05.999999",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// timeFlag the time parsed by timeLayouts.
+type timeFlag struct {
+	time.Time
+}
+
+func (tf *timeFlag) String() string {
+	if tf.IsZero() {
+		return ""
+	}
+	return tf.Format(time.RFC3339Nano)
+}
+
+func (tf *timeFlag) Set(value string) error {
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			tf.Time = t
+			return nil
+		}
+	}
+	return errors.Errorf("invalid time %q, such as 2006-01-02 15:04:05", value)
+}
+
+// fieldFilter matches the field by the value, or the regexp.
+type fieldFilter struct {
+	key   string
+	value string
+	re    *regexp.Regexp
+}
+
+func (ff *fieldFilter) match(fields core.Fields) bool {
+	val, ok := fields[ff.key]
+	if !ok {
+		return false
+	}
+	// the same text as the bloom filters hash
+	s := index.FieldValue(val)
+	if ff.re != nil {
+		return ff.re.MatchString(s)
+	}
+	return s == ff.value
+}
+
+// fieldFlags the repeated -field flags, all of them should match.
+type fieldFlags []*fieldFilter
+
+func (ff *fieldFlags) String() string {
+	return fmt.Sprint(len(*ff))
+}
+
+func (ff *fieldFlags) Set(value string) error {
+	i := strings.IndexAny(value, "=~")
+	if i <= 0 {
+		return errors.New("field should be KEY=VALUE or KEY~REGEXP")
+	}
+	filter := &fieldFilter{key: value[:i], value: value[i+1:]}
+	if value[i] == '~' {
+		re, err := regexp.Compile(filter.value)
+		if err != nil {
+			return errors.Wrap(err, "invalid field regexp")
+		}
+		filter.re = re
+	}
+	*ff = append(*ff, filter)
+	return nil
+}
+
+// entryFilter all the conditions of grep.
+type entryFilter struct {
+	level  string
+	marker string
+	since  timeFlag
+	until  timeFlag
+	fields fieldFlags
+	msg    string
+
+	minLevel core.Level
+	msgRe    *regexp.Regexp
+}
+
+func (ef *entryFilter) compile() error {
+	if ef.level != "" {
+		level, err := core.ParseLevel(ef.level)
+		if err != nil {
+			return err
+		}
+		ef.minLevel = level
+	}
+	if ef.msg != "" {
+		re, err := regexp.Compile(ef.msg)
+		if err != nil {
+			return errors.Wrap(err, "invalid msg regexp")
+		}
+		ef.msgRe = re
+	}
+	return nil
+}
+
+func (ef *entryFilter) match(entry *core.LogEntry) bool {
+	if entry.Level < ef.minLevel {
+		return false
+	}
+	if ef.marker != "" && entry.Marker != ef.marker {
+		return false
+	}
+	if !ef.since.IsZero() && entry.Time.Before(ef.since.Time) {
+		return false
+	}
+	if !ef.until.IsZero() && !entry.Time.Before(ef.until.Time) {
+		return false
+	}
+	if ef.msgRe != nil && !ef.msgRe.MatchString(entry.Msg) {
+		return false
+	}
+	for _, f := range ef.fields {
+		if !f.match(entry.Fields) {
+			return false
+		}
+	}
+	return true
+}
+
+// bloomSources keeps the sources which may have the values of all the
+// -field KEY=VALUE, by the bloom filters next to them.
+func bloomSources(sources []*source, fields fieldFlags) ([]*source, error) {
+	for _, f := range fields {
+		if f.re != nil {
+			continue
+		}
+		files := make([]string, 0, len(sources))
+		for _, src := range sources {
+			files = append(files, src.path)
+		}
+		matched, err := index.LookupField(files, f.key, f.value)
+		if err != nil {
+			return nil, err
+		}
+		kept := sources[:0]
+		for _, src := range sources {
+			if len(matched) > 0 && matched[0] == src.path {
+				kept = append(kept, src)
+				matched = matched[1:]
+			}
+		}
+		sources = kept
+	}
+	return sources, nil
+}
+
+// markerFormat reports whether the input format may carry the marker.
+func markerFormat(format string) bool {
+	switch strings.ToLower(format) {
+	case "simple", "full":
+		return false
+	}
+	return true
+}
+
+// runGrep prints the matched lines as they are, or converted by -o, and
+// exits with 1 if nothing matched like grep.
+func runGrep(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("grep", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	filter := &entryFilter{}
+	fs.StringVar(&filter.level, "level", "", "the minimum level, such as WARN")
+	fs.StringVar(&filter.marker, "marker", "", "the marker, only the json lines carry the marker")
+	fs.Var(&filter.since, "since", "the entries at or after the time, such as 2006-01-02 15:04:05")
+	fs.Var(&filter.until, "until", "the entries before the time")
+	fs.Var(&filter.fields, "field", "the field equal to KEY=VALUE, skipping the files by bloom filters, or matched by KEY~REGEXP, repeated for all")
+	fs.StringVar(&filter.msg, "msg", "", "the regexp of message")
+	in := fs.String("in", autoFormat, "the input format, simple, full, json or auto")
+	out := fs.String("o", "", "the output format, simple, full, json or logfmt, the line as it is by default")
+	sf := newSourceFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fmt.Fprintln(stderr, "usage: etlog", grepUsage)
+		return 2
+	}
+	if err := filter.compile(); err != nil {
+		fmt.Fprintf(stderr, "etlog: grep: %v\n", err)
+		return 2
+	}
+	parser, err := newParser(*in)
+	if err != nil {
+		fmt.Fprintf(stderr, "etlog: grep: %v\n", err)
+		return 2
+	}
+	if filter.marker != "" && !markerFormat(*in) {
+		fmt.Fprintf(stderr, "etlog: grep: -marker needs -in json or auto, the %s format carries no marker\n", strings.ToLower(*in))
+		return 2
+	}
+	var write entryWriter
+	if *out != "" {
+		if write, err = newEntryWriter(*out); err != nil {
+			fmt.Fprintf(stderr, "etlog: grep: %v\n", err)
+			return 2
+		}
+	}
+
+	sources, err := sf.sources(fs.Args())
+	if err != nil {
+		fmt.Fprintf(stderr, "etlog: grep: %v\n", err)
+		return 1
+	}
+	// the bloom filters skip the sources without the field values
+	if sources, err = bloomSources(sources, filter.fields); err != nil {
+		fmt.Fprintf(stderr, "etlog: grep: %v\n", err)
+		return 1
+	}
+	matched := 0
+	warned := make(map[string]bool)
+	// the time index skips the sources and the lines out of the range
+	err = sf.eachRangeLine(sources, filter.since.Time, filter.until.Time, func(src *source, line []byte) error {
+		entry, err := parser.Parse(line)
+		if err != nil {
+			fmt.Fprintf(stderr, "etlog: grep: %s: %v\n", src.path, err)
+			return nil
+		}
+		if filter.marker != "" && !isJSONLine(line) && !warned[src.path] {
+			warned[src.path] = true
+			fmt.Fprintf(stderr, "etlog: grep: %s: the lines not in json format carry no marker, never matching -marker\n", src.path)
+		}
+		if !filter.match(entry) {
+			return nil
+		}
+		matched++
+		if write != nil {
+			return write(stdout, entry)
+		}
+		return writeLine(stdout, line)
+	})
+	if err != nil {
+		fmt.Fprintf(stderr, "etlog: grep: %v\n", err)
+		return 1
+	}
+	if matched == 0 {
+		return 1
+	}
+	return 0
+}
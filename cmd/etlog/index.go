@@ -0,0 +1,109 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/edditen/etlog/handler/index"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const indexUsage = "index [-v] <file>...    print the time index and the bloom filter of the file, its backups and archives"
+
+var indexCommand = &command{
+	name:  "index",
+	usage: indexUsage,
+	run:   runIndex,
+}
+
+const indexTimeFormat = "2006-01-02 15:04:05.000"
+
+func runIndex(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("index", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	verbose := fs.Bool("v", false, "print the points too")
+	sf := newSourceFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fmt.Fprintln(stderr, "usage: etlog", indexUsage)
+		return 2
+	}
+
+	sources, err := sf.sources(fs.Args())
+	if err != nil {
+		fmt.Fprintf(stderr, "etlog: index: %v\n", err)
+		return 1
+	}
+	code := 0
+	for _, src := range sources {
+		if err := printTimeIndex(stdout, src.path, *verbose); err != nil {
+			fmt.Fprintf(stderr, "etlog: index: %s: %v\n", src.path, err)
+			code = 1
+		}
+		if err := printBloom(stdout, src.path); err != nil {
+			fmt.Fprintf(stderr, "etlog: index: %s: %v\n", src.path, err)
+			code = 1
+		}
+	}
+	return code
+}
+
+func printTimeIndex(w io.Writer, file string, verbose bool) error {
+	ix, err := index.ReadFile(file + index.Ext)
+	if err != nil {
+		if os.IsNotExist(err) {
+			fmt.Fprintf(w, "%s\tno index\n", file)
+			return nil
+		}
+		return err
+	}
+
+	state := "closed"
+	if !ix.Closed {
+		state = "open"
+	}
+	if ix.Skew > 0 {
+		// the entries written out of order
+		state += fmt.Sprintf("\tskew %s", ix.Skew)
+	}
+	fmt.Fprintf(w, "%s\t%s\t%s\t%d entries\t%d points\t%s\n", file,
+		formatIndexTime(ix.First), formatIndexTime(ix.Last), ix.Entries, len(ix.Points), state)
+	if verbose {
+		for _, p := range ix.Points {
+			fmt.Fprintf(w, "\t%s\t%d\n", formatIndexTime(p.Time), p.Offset)
+		}
+	}
+	return nil
+}
+
+// printBloom prints nothing without the bloom filter, which is optional.
+func printBloom(w io.Writer, file string) error {
+	bloom, err := index.ReadBloomFile(file + index.BloomExt)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	state := "complete"
+	if bloom.Partial() {
+		state = "partial"
+	}
+	fmt.Fprintf(w, "%s\tbloom %s\t%d values\t%s\n", file, strings.Join(bloom.Keys(), ","), bloom.Count(), state)
+	return nil
+}
+
+// formatIndexTime the epoch marks the content written before the index.
+func formatIndexTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	if t.UnixNano() == 0 {
+		return "unindexed"
+	}
+	return t.Format(indexTimeFormat)
+}
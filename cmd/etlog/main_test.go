@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"github.com/edditen/etlog/handler/archiver"
+	"io/ioutil"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	archiveFile := path.Join(dir, "app.2021-01-01.000000.log.gz")
+	_ = ioutil.WriteFile(archiveFile, []byte("archive"), 0644)
+	m := &archiver.Manifest{Archive: path.Base(archiveFile), ArchiveSize: 7,
+		SHA256: "0000000000000000000000000000000000000000000000000000000000000000"}
+	if err := m.WriteFile(archiveFile + archiver.ManifestExt); err != nil {
+		t.Fatal(err)
+	}
+
+	bareDir := t.TempDir()
+	_ = ioutil.WriteFile(path.Join(bareDir, "app.2021-01-01.000000.log.zip"), []byte("archive"), 0644)
+
+	key := bytes.Repeat([]byte{1}, 32)
+	keyFile := path.Join(dir, "key")
+	_ = ioutil.WriteFile(keyFile, key, 0600)
+	encrypted := path.Join(dir, "secret.log"+archiver.EncryptExt)
+	plain := path.Join(dir, "secret.log")
+	_ = ioutil.WriteFile(plain, []byte("secret"), 0644)
+	if err := archiver.EncryptFile(plain, encrypted, "k1", key); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		args     []string
+		wantCode int
+		wantOut  string
+	}{
+		{"when no command then usage", nil, 2, ""},
+		{"when unknown command then usage", []string{"unknown"}, 2, ""},
+		{"when help then usage", []string{"help"}, 0, "Usage"},
+		{"when verify mismatched then exit 1", []string{"verify", dir}, 1, "MISMATCH"},
+		{"when verify archive without manifest then exit 1", []string{"verify", bareDir}, 1, "UNVERIFIED\tapp.2021-01-01.000000.log.zip"},
+		{"when verify without dir then usage", []string{"verify"}, 2, ""},
+		{"when decrypt to stdout then print", []string{"decrypt", "-key", "k1=file:" + keyFile, "-o", "-", encrypted}, 0, "secret"},
+		{"when decrypt by other key then exit 1", []string{"decrypt", "-key", "k2=file:" + keyFile, encrypted}, 1, ""},
+		{"when decrypt without key then usage", []string{"decrypt", encrypted}, 2, ""},
+		{"when decrypt key malformed then usage", []string{"decrypt", "-key", "k1", encrypted}, 2, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			if code := run(tt.args, &stdout, &stderr); code != tt.wantCode {
+				t.Errorf("run() = %d, want %d, stderr: %s", code, tt.wantCode, stderr.String())
+			}
+			if !strings.Contains(stdout.String(), tt.wantOut) {
+				t.Errorf("stdout = %s, want %s", stdout.String(), tt.wantOut)
+			}
+		})
+	}
+}
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"flag"
+	"github.com/edditen/etlog/handler/archiver"
+	"github.com/edditen/etlog/handler/index"
+	"github.com/edditen/etlog/handler/naming"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// streamExt the extension of the active file in stream archive mode
+	streamExt = ".gz"
+	zipExt    = ".zip"
+	tarGzExt  = ".tar.gz"
+)
+
+// source a file to read, the active file or a backup of it.
+type source struct {
+	path   string
+	time   time.Time
+	seq    int
+	active bool
+}
+
+// sourceFlags finds the rotated backups and archives of the active files,
+// the same as the rollover config of FileHandler.
+type sourceFlags struct {
+	naming     string
+	timezone   string
+	archiveDir string
+	noBackups  bool
+	keys       keyFlags
+}
+
+func newSourceFlags(fs *flag.FlagSet) *sourceFlags {
+	sf := &sourceFlags{keys: keyFlags{}}
+	fs.StringVar(&sf.naming, "naming", naming.DefaultTemplate, "the backup naming template, the rollover backup_naming")
+	fs.StringVar(&sf.timezone, "timezone", "", "the timezone of the backup names, the rollover timezone, local by default")
+	fs.StringVar(&sf.archiveDir, "archive-dir", "", "the archive dir, the dir of file by default")
+	fs.BoolVar(&sf.noBackups, "no-backups", false, "read the given files only, without the backups")
+	fs.Var(sf.keys, "key", "the key of ID to read the encrypted archives, from file:PATH or env:VAR")
+	return sf
+}
+
+// sources lists the backups and archives of each file, then the file itself,
+// in chronological order.
+func (sf *sourceFlags) sources(files []string) ([]*source, error) {
+	tmpl, err := naming.ParseTemplate(sf.naming)
+	if err != nil {
+		return nil, err
+	}
+	if sf.timezone != "" {
+		loc, err := time.LoadLocation(sf.timezone)
+		if err != nil {
+			return nil, errors.Wrap(err, "load timezone error")
+		}
+		tmpl = tmpl.In(loc)
+	}
+
+	sources := make([]*source, 0)
+	for _, file := range files {
+		var backups []*source
+		if !sf.noBackups {
+			if backups, err = sf.backups(tmpl, file); err != nil {
+				return nil, err
+			}
+		}
+		sources = append(sources, backups...)
+
+		if _, err := os.Stat(file); err != nil {
+			if os.IsNotExist(err) && len(backups) > 0 {
+				continue
+			}
+			return nil, errors.Wrap(err, "stat file error")
+		}
+		sources = append(sources, &source{path: file, active: true})
+	}
+	return sources, nil
+}
+
+// backups finds the backups in the dir of file and the archive dir, the
+// uncompressed backup is preferred if it is being archived.
+func (sf *sourceFlags) backups(tmpl *naming.Template, file string) ([]*source, error) {
+	fileName := strings.TrimSuffix(path.Base(file), streamExt)
+	ext := path.Ext(fileName)
+	baseName := fileName[:len(fileName)-len(ext)]
+
+	matchers := make([]*naming.Matcher, 0)
+	for _, suffix := range backupSuffixes() {
+		matcher, err := tmpl.Matcher(baseName, ext, suffix)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, matcher)
+	}
+
+	dirs := []string{path.Dir(file)}
+	if sf.archiveDir != "" && path.Clean(sf.archiveDir) != path.Clean(path.Dir(file)) {
+		dirs = append(dirs, sf.archiveDir)
+	}
+
+	type key struct {
+		time time.Time
+		seq  int
+	}
+	found := make(map[key]*source)
+	for _, dir := range dirs {
+		infos, err := ioutil.ReadDir(dir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, errors.Wrap(err, "read dir error")
+		}
+		for _, info := range infos {
+			if !info.Mode().IsRegular() {
+				continue
+			}
+			for i, matcher := range matchers {
+				tm, seq, ok := matcher.Match(info.Name())
+				if !ok {
+					continue
+				}
+				k := key{tm, seq}
+				if _, exists := found[k]; !exists || i == 0 {
+					found[k] = &source{path: path.Join(dir, info.Name()), time: tm, seq: seq}
+				}
+				break
+			}
+		}
+	}
+
+	backups := make([]*source, 0, len(found))
+	for _, src := range found {
+		backups = append(backups, src)
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		if !backups[i].time.Equal(backups[j].time) {
+			return backups[i].time.Before(backups[j].time)
+		}
+		return backups[i].seq < backups[j].seq
+	})
+	return backups, nil
+}
+
+// backupSuffixes the suffixes of backups, the uncompressed one first,
+// then the archives of all formats, encrypted or not.
+func backupSuffixes() []string {
+	suffixes := []string{""}
+	for _, ext := range archiver.Exts() {
+		if ext != "" {
+			suffixes = append(suffixes, ext)
+		}
+	}
+	for _, ext := range archiver.Exts() {
+		suffixes = append(suffixes, ext+archiver.EncryptExt)
+	}
+	return suffixes
+}
+
+// open opens the source as the plain text, the archives are decrypted
+// and decompressed by the extension.
+func (sf *sourceFlags) open(file string) (io.ReadCloser, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "open file error")
+	}
+
+	name := file
+	var r io.ReadCloser = f
+	if strings.HasSuffix(name, archiver.EncryptExt) {
+		name = strings.TrimSuffix(name, archiver.EncryptExt)
+		r = decryptReader(f, archiver.Keyring(sf.keys))
+	}
+
+	switch {
+	case strings.HasSuffix(name, zipExt):
+		return openZip(r)
+	case strings.HasSuffix(name, tarGzExt):
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			_ = r.Close()
+			return nil, errors.Wrap(err, "open gzip error")
+		}
+		return &readCloser{Reader: &tarReader{tr: tar.NewReader(gr)}, closer: r}, nil
+	case strings.HasSuffix(name, streamExt):
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			_ = r.Close()
+			return nil, errors.Wrap(err, "open gzip error")
+		}
+		return &readCloser{Reader: &liveGzipReader{gr}, closer: r}, nil
+	}
+	return r, nil
+}
+
+// decryptReader decrypts in the background, the error of decryption is
+// returned by the reader.
+func decryptReader(f *os.File, keys archiver.Keyring) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		defer f.Close()
+		_ = pw.CloseWithError(archiver.Decrypt(pw, f, keys))
+	}()
+	return pr
+}
+
+// openZip reads all the entries of zip in order, the zip needs the random
+// access, so the encrypted one is decrypted into memory first.
+func openZip(r io.ReadCloser) (io.ReadCloser, error) {
+	var ra io.ReaderAt
+	var size int64
+	var closer io.Closer = r
+	if f, ok := r.(*os.File); ok {
+		info, err := f.Stat()
+		if err != nil {
+			_ = f.Close()
+			return nil, errors.Wrap(err, "stat zip error")
+		}
+		ra, size = f, info.Size()
+	} else {
+		b, err := ioutil.ReadAll(r)
+		_ = r.Close()
+		if err != nil {
+			return nil, errors.Wrap(err, "read zip error")
+		}
+		ra, size = bytes.NewReader(b), int64(len(b))
+		closer = ioutil.NopCloser(nil)
+	}
+
+	zr, err := zip.NewReader(ra, size)
+	if err != nil {
+		_ = closer.Close()
+		return nil, errors.Wrap(err, "open zip error")
+	}
+	readers := make([]io.Reader, 0, len(zr.File))
+	for _, zf := range zr.File {
+		if zf.FileInfo().IsDir() {
+			continue
+		}
+		readers = append(readers, &zipEntryReader{zf: zf})
+	}
+	return &readCloser{Reader: io.MultiReader(readers...), closer: closer}, nil
+}
+
+// zipEntryReader opens the entry on the first read.
+type zipEntryReader struct {
+	zf *zip.File
+	rc io.ReadCloser
+}
+
+func (zr *zipEntryReader) Read(p []byte) (int, error) {
+	if zr.rc == nil {
+		rc, err := zr.zf.Open()
+		if err != nil {
+			return 0, errors.Wrap(err, "open zip entry error")
+		}
+		zr.rc = rc
+	}
+	n, err := zr.rc.Read(p)
+	if err == io.EOF {
+		_ = zr.rc.Close()
+	}
+	return n, err
+}
+
+// tarReader reads the regular files of tar in order.
+type tarReader struct {
+	tr      *tar.Reader
+	started bool
+}
+
+func (tr *tarReader) Read(p []byte) (int, error) {
+	for {
+		if tr.started {
+			n, err := tr.tr.Read(p)
+			if err != io.EOF {
+				return n, err
+			}
+			if n > 0 {
+				return n, nil
+			}
+		}
+		hdr, err := tr.tr.Next()
+		if err != nil {
+			return 0, err
+		}
+		tr.started = hdr.Typeflag == tar.TypeReg
+	}
+}
+
+// liveGzipReader reads the gzip stream being written, the unfinished
+// stream ends at the last flushed block.
+type liveGzipReader struct {
+	gr *gzip.Reader
+}
+
+func (lr *liveGzipReader) Read(p []byte) (int, error) {
+	n, err := lr.gr.Read(p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+type readCloser struct {
+	io.Reader
+	closer io.Closer
+}
+
+func (rc *readCloser) Close() error {
+	return rc.closer.Close()
+}
+
+// eachLine calls fn with the lines of reader, without the line endings,
+// the blank lines are skipped.
+func eachLine(r io.Reader, fn func(line []byte) error) error {
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return errors.Wrap(err, "read line error")
+		}
+		if trimmed := bytes.TrimRight(line, "\r\n"); len(trimmed) > 0 {
+			if fnErr := fn(trimmed); fnErr != nil {
+				return fnErr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// eachSourceLine calls fn with the lines of all sources in order.
+func (sf *sourceFlags) eachSourceLine(sources []*source, fn func(src *source, line []byte) error) error {
+	for _, src := range sources {
+		r, err := sf.open(src.path)
+		if err != nil {
+			return errors.Wrap(err, src.path)
+		}
+		err = eachLine(r, func(line []byte) error {
+			return fn(src, line)
+		})
+		_ = r.Close()
+		if err != nil {
+			return errors.Wrap(err, src.path)
+		}
+	}
+	return nil
+}
+
+// eachRangeLine calls fn with the lines of sources which may be in
+// [since, until), the sources are skipped or seeked by the time index.
+func (sf *sourceFlags) eachRangeLine(sources []*source, since, until time.Time, fn func(src *source, line []byte) error) error {
+	for _, src := range sources {
+		hits, err := index.Lookup([]string{src.path}, since, until)
+		if err != nil {
+			return err
+		}
+		if len(hits) == 0 {
+			continue
+		}
+		if err := sf.readRange(src, hits[0], fn); err != nil {
+			return errors.Wrap(err, src.path)
+		}
+	}
+	return nil
+}
+
+// readRange seeks the plain file to the offset, or discards the content
+// before the offset of the decompressed one.
+func (sf *sourceFlags) readRange(src *source, hit index.Hit, fn func(src *source, line []byte) error) error {
+	rc, err := sf.open(src.path)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	var r io.Reader = rc
+	if hit.Offset > 0 {
+		if f, ok := rc.(*os.File); ok {
+			_, err = f.Seek(hit.Offset, io.SeekStart)
+		} else {
+			_, err = io.CopyN(ioutil.Discard, rc, hit.Offset)
+		}
+		if err != nil {
+			return errors.Wrap(err, "seek error")
+		}
+	}
+	if hit.End >= 0 {
+		r = io.LimitReader(r, hit.End-hit.Offset)
+	}
+	return eachLine(r, func(line []byte) error {
+		return fn(src, line)
+	})
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bytes"
+	"github.com/edditen/etlog/core"
+	"github.com/edditen/etlog/handler/archiver"
+	"github.com/edditen/etlog/handler/index"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// writeLogs writes the backups of app.log in all archive formats, an
+// encrypted one, a plain one and the active file, each with one entry.
+func writeLogs(t *testing.T) (logFile, archiveDir, keyFile string) {
+	t.Helper()
+	dir := t.TempDir()
+	archiveDir = path.Join(dir, "archive")
+	if err := os.Mkdir(archiveDir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	key := bytes.Repeat([]byte{7}, 32)
+	keyFile = path.Join(dir, "key")
+	_ = ioutil.WriteFile(keyFile, key, 0600)
+
+	at := func(hour int) time.Time {
+		return time.Date(2021, 6, 15, hour, 30, 0, 0, time.Local)
+	}
+	entries := []struct {
+		entry  *core.LogEntry
+		format core.Format
+		backup string
+	}{
+		{&core.LogEntry{Time: at(9), Level: core.INFO, Msg: "one", Fields: core.Fields{"trace_id": "a1"}}, core.SIMPLE, "app.2021-06-15.100000.log.zip"},
+		{&core.LogEntry{Time: at(10), Level: core.WARN, Msg: "two|2", Fields: core.Fields{"trace_id": "b2"}}, core.FULL, "app.2021-06-15.110000.log.gz"},
+		{&core.LogEntry{Time: at(11), Level: core.DEBUG, Msg: "three", Marker: "audit"}, core.JSON, "app.2021-06-15.120000.log.tar.gz"},
+		{&core.LogEntry{Time: at(12), Level: core.ERROR, Msg: "four", Err: errors.New("oops")}, core.SIMPLE, "app.2021-06-15.130000.log.zip.enc"},
+		{&core.LogEntry{Time: at(13), Level: core.INFO, Msg: "five"}, core.FULL, "app.2021-06-15.140000.log"},
+		{&core.LogEntry{Time: at(14), Level: core.INFO, Msg: "six"}, core.SIMPLE, "app.log"},
+	}
+	for _, e := range entries {
+		buf := core.FormatterFactory(e.format).Format(e.entry)
+		plain := path.Join(dir, strings.SplitN(e.backup, ".log", 2)[0]+".log")
+		err := ioutil.WriteFile(plain, buf.Bytes(), 0644)
+		buf.Free()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		name := strings.TrimSuffix(e.backup, archiver.EncryptExt)
+		switch {
+		case strings.HasSuffix(name, ".zip"):
+			err = archiver.FormatZip.Compress(plain, path.Join(archiveDir, name), 0)
+		case strings.HasSuffix(name, ".tar.gz"):
+			err = archiver.FormatTarGz.Compress(plain, path.Join(archiveDir, name), 0)
+		case strings.HasSuffix(name, ".gz"):
+			err = archiver.FormatGzip.Compress(plain, path.Join(archiveDir, name), 0)
+		default:
+			continue
+		}
+		if err == nil && name != e.backup {
+			err = archiver.EncryptFile(path.Join(archiveDir, name), path.Join(archiveDir, e.backup), "k1", key)
+			_ = os.Remove(path.Join(archiveDir, name))
+		}
+		_ = os.Remove(plain)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return path.Join(dir, "app.log"), archiveDir, keyFile
+}
+
+func TestRun_Logs(t *testing.T) {
+	logFile, archiveDir, keyFile := writeLogs(t)
+	src := []string{"-archive-dir", archiveDir, "-key", "k1=file:" + keyFile}
+
+	tests := []struct {
+		name     string
+		args     []string
+		wantCode int
+		wantMsgs []string
+		wantOut  string
+	}{
+		{"when cat then all in order", append(append([]string{"cat"}, src...), logFile), 0,
+			[]string{"one", "two", "three", "four", "five", "six"}, ""},
+		{"when cat no backups then active only", []string{"cat", "-no-backups", logFile}, 0,
+			[]string{"six"}, ""},
+		{"when cat encrypted without key then exit 1", []string{"cat", "-archive-dir", archiveDir, logFile}, 1,
+			nil, ""},
+		{"when grep level then at least the level", append(append([]string{"grep", "-level", "WARN"}, src...), logFile), 0,
+			[]string{"two", "four"}, ""},
+		{"when grep field then logfmt output", append(append([]string{"grep", "-field", "trace_id=b2", "-o", "logfmt"}, src...), logFile), 0,
+			[]string{"two"}, `level=WARN msg=two|2 trace_id=b2`},
+		{"when grep field regexp and marker", append(append([]string{"grep", "-marker", "audit", "-field", "trace_id~^a"}, src...), logFile), 1,
+			nil, ""},
+		{"when grep marker then the json lines", append(append([]string{"grep", "-marker", "audit"}, src...), logFile), 0,
+			[]string{"three"}, ""},
+		{"when grep marker of simple input then usage", []string{"grep", "-in", "simple", "-marker", "audit", logFile}, 2, nil, ""},
+		{"when grep time range then the entries in range", append(append([]string{"grep", "-since", "2021-06-15 11:00", "-until", "2021-06-15 13:31"}, src...), logFile), 0,
+			[]string{"three", "four", "five"}, ""},
+		{"when grep msg then matched", append(append([]string{"grep", "-msg", "^f"}, src...), logFile), 0,
+			[]string{"four", "five"}, ""},
+		{"when grep invalid level then usage", []string{"grep", "-level", "TRACE", logFile}, 2, nil, ""},
+		{"when fmt to json then json lines", append(append([]string{"fmt", "-to", "json"}, src...), logFile), 0,
+			[]string{"one", "two", "three", "four", "five", "six"}, `"msg":"two|2"`},
+		{"when fmt to unknown then usage", []string{"fmt", "-to", "xml", logFile}, 2, nil, ""},
+		{"when tail then last lines across backups", append(append([]string{"tail", "-n", "2"}, src...), logFile), 0,
+			[]string{"five", "six"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			if code := run(tt.args, &stdout, &stderr); code != tt.wantCode {
+				t.Fatalf("run() = %d, want %d, stderr: %s", code, tt.wantCode, stderr.String())
+			}
+			if got := lineMsgs(t, stdout.String()); tt.wantMsgs != nil && strings.Join(got, ",") != strings.Join(tt.wantMsgs, ",") {
+				t.Errorf("msgs = %v, want %v", got, tt.wantMsgs)
+			}
+			if !strings.Contains(stdout.String(), tt.wantOut) {
+				t.Errorf("stdout = %s, want %s", stdout.String(), tt.wantOut)
+			}
+		})
+	}
+}
+
+func TestRun_GrepMarkerWarning(t *testing.T) {
+	logFile, archiveDir, keyFile := writeLogs(t)
+	args := []string{"grep", "-marker", "audit", "-archive-dir", archiveDir, "-key", "k1=file:" + keyFile, logFile}
+
+	var stdout, stderr bytes.Buffer
+	if code := run(args, &stdout, &stderr); code != 0 {
+		t.Fatalf("run() = %d, want 0, stderr: %s", code, stderr.String())
+	}
+	// warned once for each source with the lines not in json format
+	if got := strings.Count(stderr.String(), "carry no marker"); got != 5 {
+		t.Errorf("warnings = %d, want 5, stderr: %s", got, stderr.String())
+	}
+	if strings.Contains(stderr.String(), "120000") {
+		t.Errorf("warned the json source, stderr: %s", stderr.String())
+	}
+}
+
+// lineMsgs parses the messages of output lines, the logfmt lines are
+// checked by the output.
+func lineMsgs(t *testing.T, out string) []string {
+	t.Helper()
+	msgs := make([]string, 0)
+	parser := newAutoParser()
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "time=") {
+			line = strings.SplitN(line, "msg=", 2)[1]
+			msgs = append(msgs, strings.SplitN(strings.Fields(line)[0], "|", 2)[0])
+			continue
+		}
+		entry, err := parser.Parse([]byte(line))
+		if err != nil {
+			t.Fatalf("parse %q error: %v", line, err)
+		}
+		msgs = append(msgs, strings.SplitN(entry.Msg, "|", 2)[0])
+	}
+	return msgs
+}
+
+// syncBuffer the output written by the follower and read by the test.
+type syncBuffer struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (sb *syncBuffer) Write(p []byte) (int, error) {
+	sb.mutex.Lock()
+	defer sb.mutex.Unlock()
+	return sb.buf.Write(p)
+}
+
+func (sb *syncBuffer) String() string {
+	sb.mutex.Lock()
+	defer sb.mutex.Unlock()
+	return sb.buf.String()
+}
+
+func TestFollower(t *testing.T) {
+	followInterval = 10 * time.Millisecond
+	waitOutput := func(t *testing.T, out *syncBuffer, want string) {
+		t.Helper()
+		for i := 0; i < 200 && out.String() != want; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if got := out.String(); got != want {
+			t.Fatalf("output = %q, want %q", got, want)
+		}
+	}
+	appendFile := func(t *testing.T, file, s string) {
+		t.Helper()
+		f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = f.WriteString(s)
+		_ = f.Close()
+	}
+
+	t.Run("when renamed or truncated then follow the new content", func(t *testing.T) {
+		file := path.Join(t.TempDir(), "app.log")
+		appendFile(t, file, "old\n")
+		out := &syncBuffer{}
+		stop := make(chan struct{})
+		done := make(chan error)
+		go func() {
+			done <- newFollower(file, 4, out).run(stop)
+		}()
+
+		appendFile(t, file, "a\nb")
+		waitOutput(t, out, "a\n")
+		appendFile(t, file, "\n")
+		waitOutput(t, out, "a\nb\n")
+
+		// rotated by renaming, the lines written before are not lost
+		appendFile(t, file, "c\n")
+		if err := os.Rename(file, file+".1"); err != nil {
+			t.Fatal(err)
+		}
+		appendFile(t, file+".1", "d\n")
+		appendFile(t, file, "e\n")
+		waitOutput(t, out, "a\nb\nc\nd\ne\n")
+
+		// copytruncate
+		if err := os.Truncate(file, 0); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(50 * time.Millisecond)
+		appendFile(t, file, "f\n")
+		waitOutput(t, out, "a\nb\nc\nd\ne\nf\n")
+
+		close(stop)
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestRun_TimeIndex(t *testing.T) {
+	dir := t.TempDir()
+	logFile := path.Join(dir, "app.log")
+	at := func(m int) time.Time {
+		return time.Date(2021, 6, 15, 10, m, 0, 0, time.Local)
+	}
+	// write indexes the entries at the index times, which may differ from
+	// the entry times to prove the index used
+	write := func(file string, from int, msgs []string, indexTimes []time.Time) {
+		w, err := index.NewWriter(file+index.Ext, 0, 1, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var content bytes.Buffer
+		for i, msg := range msgs {
+			buf := core.NewSimpleFormatter().Format(&core.LogEntry{Time: at(from + i*10), Level: core.INFO, Msg: msg})
+			_ = w.Add(indexTimes[i], buf.Len())
+			content.Write(buf.Bytes())
+			buf.Free()
+		}
+		_ = w.Close()
+		if err := ioutil.WriteFile(file, content.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the backup indexed before the range is skipped without reading
+	write(path.Join(dir, "app.2021-06-15.100000.log"), 30, []string{"decoy"}, []time.Time{at(-60)})
+	write(logFile, 0, []string{"first", "second", "third"}, []time.Time{at(0), at(10), at(20)})
+
+	tests := []struct {
+		name     string
+		args     []string
+		wantCode int
+		wantOut  string
+	}{
+		{"when since then skip and seek by index", []string{"grep", "-since", "2021-06-15 10:15", logFile}, 0, "third"},
+		{"when until then end by index", []string{"grep", "-no-backups", "-until", "2021-06-15 10:10", logFile}, 0, "first"},
+		{"when index then print summary", []string{"index", "-no-backups", logFile}, 0, "3 entries\t3 points\tclosed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			if code := run(tt.args, &stdout, &stderr); code != tt.wantCode {
+				t.Fatalf("run() = %d, want %d, stderr: %s", code, tt.wantCode, stderr.String())
+			}
+			if !strings.Contains(stdout.String(), tt.wantOut) || strings.Count(stdout.String(), "\n") != 1 {
+				t.Errorf("stdout = %s, want %s", stdout.String(), tt.wantOut)
+			}
+		})
+	}
+}
+
+func TestRun_Bloom(t *testing.T) {
+	dir := t.TempDir()
+	logFile := path.Join(dir, "app.log")
+	// write filters the values, which may differ from the fields written to
+	// prove the filter used
+	write := func(file, msg, traceID string, filtered []string) {
+		buf := core.NewSimpleFormatter().Format(&core.LogEntry{
+			Time:   time.Date(2021, 6, 15, 10, 0, 0, 0, time.Local),
+			Level:  core.INFO,
+			Msg:    msg,
+			Fields: core.Fields{"trace_id": traceID},
+		})
+		defer buf.Free()
+		if err := ioutil.WriteFile(file, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if filtered == nil {
+			return
+		}
+		bloom, err := index.NewBloom([]string{"trace_id"}, 100, 0.001)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, value := range filtered {
+			bloom.Add("trace_id", value)
+		}
+		if err := bloom.WriteFile(file + index.BloomExt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the backup without the value in the filter is skipped without reading
+	write(path.Join(dir, "app.2021-06-15.100000.log"), "decoy", "abc", []string{"xyz"})
+	write(path.Join(dir, "app.2021-06-15.110000.log"), "filtered", "abc", []string{"abc"})
+	write(logFile, "active", "abc", nil)
+
+	tests := []struct {
+		name     string
+		args     []string
+		wantCode int
+		wantOut  []string
+	}{
+		{"when field value then skip by bloom", []string{"grep", "-field", "trace_id=abc", logFile}, 0, []string{"filtered", "active"}},
+		{"when field regexp then no skip", []string{"grep", "-field", "trace_id~^ab", logFile}, 0, []string{"decoy", "filtered", "active"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			if code := run(tt.args, &stdout, &stderr); code != tt.wantCode {
+				t.Fatalf("run() = %d, want %d, stderr: %s", code, tt.wantCode, stderr.String())
+			}
+			if got := lineMsgs(t, stdout.String()); strings.Join(got, ",") != strings.Join(tt.wantOut, ",") {
+				t.Errorf("msgs = %v, want %v", got, tt.wantOut)
+			}
+		})
+	}
+
+	t.Run("when index then print bloom", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		if code := run([]string{"index", logFile}, &stdout, &stderr); code != 0 {
+			t.Fatalf("run() = %d, stderr: %s", code, stderr.String())
+		}
+		if !strings.Contains(stdout.String(), "bloom trace_id\t1 values\tcomplete") {
+			t.Errorf("stdout = %s", stdout.String())
+		}
+	})
+}
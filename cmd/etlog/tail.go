@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const tailUsage = "tail [-n N] [-f] <file>    print the last lines, and follow the rotation with -f"
+
+var tailCommand = &command{
+	name:  "tail",
+	usage: tailUsage,
+	run:   runTail,
+}
+
+// followInterval how often the followed file is checked for new lines
+// and the rotation.
+var followInterval = 250 * time.Millisecond
+
+func runTail(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("tail", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	n := fs.Int("n", 10, "the number of last lines")
+	follow := fs.Bool("f", false, "output the appended lines, following the rotation")
+	sf := newSourceFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 || *n < 0 {
+		fmt.Fprintln(stderr, "usage: etlog", tailUsage)
+		return 2
+	}
+	file := fs.Arg(0)
+	if *follow && strings.HasSuffix(file, streamExt) {
+		fmt.Fprintf(stderr, "etlog: tail: can not follow the gzip stream %s\n", file)
+		return 2
+	}
+
+	sources, err := sf.sources([]string{file})
+	if err != nil {
+		fmt.Fprintf(stderr, "etlog: tail: %v\n", err)
+		return 1
+	}
+	lines, offset, err := sf.lastLines(sources, *n)
+	if err != nil {
+		fmt.Fprintf(stderr, "etlog: tail: %v\n", err)
+		return 1
+	}
+	for _, line := range lines {
+		if err := writeLine(stdout, line); err != nil {
+			fmt.Fprintf(stderr, "etlog: tail: %v\n", err)
+			return 1
+		}
+	}
+
+	if *follow {
+		if err := newFollower(file, offset, stdout).run(nil); err != nil {
+			fmt.Fprintf(stderr, "etlog: tail: %v\n", err)
+			return 1
+		}
+	}
+	return 0
+}
+
+// lastLines collects the last n lines from the newest source backwards,
+// the older sources are read only if the newer ones have less lines.
+// The offset is the size of the active file read.
+func (sf *sourceFlags) lastLines(sources []*source, n int) ([][]byte, int64, error) {
+	var offset int64
+	lines := make([][]byte, 0, n)
+	for i := len(sources) - 1; i >= 0 && (len(lines) < n || sources[i].active); i-- {
+		src := sources[i]
+		r, err := sf.open(src.path)
+		if err != nil {
+			return nil, 0, errors.Wrap(err, src.path)
+		}
+		counter := &countingReader{r: r}
+		ring := make([][]byte, 0, n)
+		err = eachLine(counter, func(line []byte) error {
+			if n == 0 {
+				return nil
+			}
+			if len(ring) == n {
+				ring = ring[1:]
+			}
+			ring = append(ring, append([]byte(nil), line...))
+			return nil
+		})
+		_ = r.Close()
+		if err != nil {
+			return nil, 0, errors.Wrap(err, src.path)
+		}
+		if src.active {
+			offset = counter.n
+		}
+
+		if need := n - len(lines); len(ring) > need {
+			ring = ring[len(ring)-need:]
+		}
+		lines = append(ring, lines...)
+	}
+	return lines, offset, nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// follower follows the file as FileHandler rotates it, the renamed file
+// is read to the end before the new file opened, and the truncated file
+// by copytruncate is read from the start.
+type follower struct {
+	file    string
+	f       *os.File
+	reader  *bufio.Reader
+	offset  int64
+	partial []byte
+	w       io.Writer
+}
+
+func newFollower(file string, offset int64, w io.Writer) *follower {
+	return &follower{file: file, offset: offset, w: w}
+}
+
+// run follows until stop closed, or forever if stop is nil.
+func (fl *follower) run(stop <-chan struct{}) error {
+	defer fl.close()
+	ticker := time.NewTicker(followInterval)
+	defer ticker.Stop()
+	for {
+		if err := fl.check(); err != nil {
+			return err
+		}
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return nil
+		}
+	}
+}
+
+func (fl *follower) check() error {
+	if fl.f == nil {
+		f, err := os.Open(fl.file)
+		if err != nil {
+			// the new file is not created yet after the rotation
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return errors.Wrap(err, "open file error")
+		}
+		if _, err := f.Seek(fl.offset, io.SeekStart); err != nil {
+			_ = f.Close()
+			return errors.Wrap(err, "seek file error")
+		}
+		fl.f = f
+		fl.reader = bufio.NewReader(f)
+	}
+
+	if err := fl.drain(); err != nil {
+		return err
+	}
+
+	openedInfo, err := fl.f.Stat()
+	if err != nil {
+		return errors.Wrap(err, "stat file error")
+	}
+	pathInfo, err := os.Stat(fl.file)
+	switch {
+	case err != nil || !os.SameFile(pathInfo, openedInfo):
+		// renamed, the lines written before the rotation are drained above
+		if err := fl.drain(); err != nil {
+			return err
+		}
+		fl.flushPartial()
+		fl.close()
+		fl.offset = 0
+	case pathInfo.Size() < fl.offset:
+		// truncated by copytruncate
+		if _, err := fl.f.Seek(0, io.SeekStart); err != nil {
+			return errors.Wrap(err, "seek file error")
+		}
+		fl.reader.Reset(fl.f)
+		fl.offset = 0
+		fl.partial = nil
+	}
+	return nil
+}
+
+// drain writes the complete lines, the partial line is kept until its end.
+func (fl *follower) drain() error {
+	for {
+		line, err := fl.reader.ReadBytes('\n')
+		fl.offset += int64(len(line))
+		fl.partial = append(fl.partial, line...)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "read file error")
+		}
+		if _, err := fl.w.Write(fl.partial); err != nil {
+			return err
+		}
+		fl.partial = fl.partial[:0]
+	}
+}
+
+func (fl *follower) flushPartial() {
+	if len(fl.partial) > 0 {
+		_ = writeLine(fl.w, fl.partial)
+		fl.partial = nil
+	}
+}
+
+func (fl *follower) close() {
+	if fl.f != nil {
+		_ = fl.f.Close()
+		fl.f = nil
+	}
+}
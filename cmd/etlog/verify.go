@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/edditen/etlog/handler/archiver"
+	"io"
+)
+
+const verifyUsage = "verify [-v] <dir>    re-hash the archives by the manifests in dir, and list the archives without manifest"
+
+var verifyCommand = &command{
+	name:  "verify",
+	usage: verifyUsage,
+	run:   runVerify,
+}
+
+// runVerify exits with 1 if any archive mismatched, missing or unverified.
+func runVerify(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	verbose := fs.Bool("v", false, "print the verified archives too")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(stderr, "usage: etlog", verifyUsage)
+		return 2
+	}
+
+	report, err := archiver.Verify(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(stderr, "etlog: verify: %v\n", err)
+		return 1
+	}
+
+	if *verbose {
+		for _, name := range report.Verified {
+			fmt.Fprintf(stdout, "OK\t%s\n", name)
+		}
+	}
+	for _, name := range report.Mismatched {
+		fmt.Fprintf(stdout, "MISMATCH\t%s\n", name)
+	}
+	for _, name := range report.Missing {
+		fmt.Fprintf(stdout, "MISSING\t%s\n", name)
+	}
+	for _, name := range report.Invalid {
+		fmt.Fprintf(stdout, "INVALID\t%s\n", name)
+	}
+	for _, name := range report.Unverified {
+		fmt.Fprintf(stdout, "UNVERIFIED\t%s\n", name)
+	}
+	fmt.Fprintf(stdout, "%d verified, %d mismatched, %d missing, %d invalid, %d unverified\n",
+		len(report.Verified), len(report.Mismatched), len(report.Missing), len(report.Invalid),
+		len(report.Unverified))
+
+	if !report.OK() {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,21 @@
+package diskusage
+
+import "github.com/pkg/errors"
+
+var ErrUnsupported = errors.New("disk usage is not supported on this platform")
+
+// Usage the space of the file system.
+type Usage struct {
+	// Free the bytes available to the unprivileged user
+	Free  uint64
+	Total uint64
+}
+
+// Get returns the usage of the file system containing the path.
+func Get(path string) (Usage, error) {
+	usage, err := statfs(path)
+	if err != nil {
+		return Usage{}, errors.Wrap(err, "statfs error")
+	}
+	return usage, nil
+}
@@ -0,0 +1,8 @@
+//go:build windows || plan9 || js
+// +build windows plan9 js
+
+package diskusage
+
+func statfs(_ string) (Usage, error) {
+	return Usage{}, ErrUnsupported
+}
@@ -0,0 +1,19 @@
+//go:build !windows && !plan9 && !js
+// +build !windows,!plan9,!js
+
+package diskusage
+
+import "testing"
+
+func TestGet(t *testing.T) {
+	usage, err := Get(t.TempDir())
+	if err != nil {
+		t.Fatalf("Get() err: %+v", err)
+	}
+	if usage.Total == 0 || usage.Free > usage.Total {
+		t.Errorf("Get() = %+v", usage)
+	}
+	if _, err := Get("/not/exist/dir"); err == nil {
+		t.Errorf("Get() want error")
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !windows && !plan9 && !js
+// +build !windows,!plan9,!js
+
+package diskusage
+
+import "syscall"
+
+func statfs(path string) (Usage, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return Usage{}, err
+	}
+	return Usage{
+		Free:  uint64(stat.Bavail) * uint64(stat.Bsize),
+		Total: uint64(stat.Blocks) * uint64(stat.Bsize),
+	}, nil
+}
@@ -0,0 +1,74 @@
+package flock
+
+import (
+	"github.com/pkg/errors"
+	"os"
+	"sync"
+)
+
+var ErrUnsupported = errors.New("file lock is not supported on this platform")
+
+// Flock is the advisory lock on a file, which coordinates the processes,
+// the goroutines of the same process should be coordinated by themselves.
+type Flock struct {
+	path  string
+	file  *os.File
+	mutex *sync.Mutex
+}
+
+func New(path string) *Flock {
+	return &Flock{
+		path:  path,
+		mutex: new(sync.Mutex),
+	}
+}
+
+// Lock acquires the exclusive lock, blocks until it is available.
+func (fl *Flock) Lock() error {
+	fl.mutex.Lock()
+	defer fl.mutex.Unlock()
+
+	if fl.file == nil {
+		file, err := os.OpenFile(fl.path, os.O_CREATE|os.O_RDWR, 0644)
+		if err != nil {
+			return errors.Wrap(err, "open lock file error")
+		}
+		fl.file = file
+	}
+
+	if err := lockFile(fl.file); err != nil {
+		return errors.Wrap(err, "lock file error")
+	}
+	return nil
+}
+
+func (fl *Flock) Unlock() error {
+	fl.mutex.Lock()
+	defer fl.mutex.Unlock()
+
+	if fl.file == nil {
+		return nil
+	}
+	if err := unlockFile(fl.file); err != nil {
+		return errors.Wrap(err, "unlock file error")
+	}
+	return nil
+}
+
+// Close releases the lock and closes the lock file, the lock file is kept
+// on disk, because other processes may be waiting on it.
+func (fl *Flock) Close() error {
+	fl.mutex.Lock()
+	defer fl.mutex.Unlock()
+
+	if fl.file == nil {
+		return nil
+	}
+	err := fl.file.Close()
+	fl.file = nil
+	return err
+}
+
+func (fl *Flock) Path() string {
+	return fl.path
+}
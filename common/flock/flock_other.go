@@ -0,0 +1,17 @@
+//go:build windows || plan9 || js
+// +build windows plan9 js
+
+package flock
+
+import "os"
+
+// Supported reports whether the file lock works on this platform.
+const Supported = false
+
+func lockFile(_ *os.File) error {
+	return ErrUnsupported
+}
+
+func unlockFile(_ *os.File) error {
+	return ErrUnsupported
+}
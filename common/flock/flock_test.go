@@ -0,0 +1,50 @@
+//go:build !windows && !plan9 && !js
+// +build !windows,!plan9,!js
+
+package flock
+
+import (
+	"path"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFlock_Lock(t *testing.T) {
+	t.Run("when locked by another then wait until unlocked", func(t *testing.T) {
+		lockPath := path.Join(t.TempDir(), "app.log.lock")
+		// flock locks on the open file description, so the two locks
+		// exclude each other even in the same process
+		first, second := New(lockPath), New(lockPath)
+		defer first.Close()
+		defer second.Close()
+
+		if err := first.Lock(); err != nil {
+			t.Fatalf("lock err: %+v", err)
+		}
+
+		var acquired int32
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			if err := second.Lock(); err != nil {
+				t.Errorf("lock err: %+v", err)
+				return
+			}
+			atomic.StoreInt32(&acquired, 1)
+			_ = second.Unlock()
+		}()
+
+		time.Sleep(50 * time.Millisecond)
+		if atomic.LoadInt32(&acquired) != 0 {
+			t.Fatalf("acquired while locked")
+		}
+		if err := first.Unlock(); err != nil {
+			t.Fatalf("unlock err: %+v", err)
+		}
+		<-done
+		if atomic.LoadInt32(&acquired) != 1 {
+			t.Errorf("not acquired after unlocked")
+		}
+	})
+}
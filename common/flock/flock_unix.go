@@ -0,0 +1,25 @@
+//go:build !windows && !plan9 && !js
+// +build !windows,!plan9,!js
+
+package flock
+
+import (
+	"os"
+	"syscall"
+)
+
+// Supported reports whether the file lock works on this platform.
+const Supported = true
+
+func lockFile(file *os.File) error {
+	for {
+		err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX)
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
+
+func unlockFile(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,414 @@
+package msgpack
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/pkg/errors"
+	"math"
+	"reflect"
+	"time"
+)
+
+// A minimal MessagePack encoder and decoder, only the types required
+// by the log handlers are supported,
+// refer to https://github.com/msgpack/msgpack/blob/master/spec.md
+
+var (
+	ErrShortBuffer = errors.New("msgpack: short buffer")
+	ErrUnsupported = errors.New("msgpack: unsupported type")
+)
+
+// Ext an extension value decoded.
+type Ext struct {
+	Type int8
+	Data []byte
+}
+
+// AppendNil appends the nil value.
+func AppendNil(b []byte) []byte {
+	return append(b, 0xc0)
+}
+
+// AppendBool appends a bool value.
+func AppendBool(b []byte, v bool) []byte {
+	if v {
+		return append(b, 0xc3)
+	}
+	return append(b, 0xc2)
+}
+
+// AppendInt appends an integer in the smallest format.
+func AppendInt(b []byte, v int64) []byte {
+	switch {
+	case v >= 0:
+		return AppendUint(b, uint64(v))
+	case v >= -32:
+		return append(b, byte(v))
+	case v >= math.MinInt8:
+		return append(b, 0xd0, byte(v))
+	case v >= math.MinInt16:
+		return append(b, 0xd1, byte(v>>8), byte(v))
+	case v >= math.MinInt32:
+		return appendUint32(append(b, 0xd2), uint32(v))
+	}
+	return appendUint64(append(b, 0xd3), uint64(v))
+}
+
+// AppendUint appends an unsigned integer in the smallest format.
+func AppendUint(b []byte, v uint64) []byte {
+	switch {
+	case v <= 0x7f:
+		return append(b, byte(v))
+	case v <= math.MaxUint8:
+		return append(b, 0xcc, byte(v))
+	case v <= math.MaxUint16:
+		return append(b, 0xcd, byte(v>>8), byte(v))
+	case v <= math.MaxUint32:
+		return appendUint32(append(b, 0xce), uint32(v))
+	}
+	return appendUint64(append(b, 0xcf), v)
+}
+
+// AppendFloat64 appends a float64 value.
+func AppendFloat64(b []byte, v float64) []byte {
+	return appendUint64(append(b, 0xcb), math.Float64bits(v))
+}
+
+// AppendString appends a string value.
+func AppendString(b []byte, s string) []byte {
+	n := len(s)
+	switch {
+	case n <= 31:
+		b = append(b, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		b = append(b, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		b = append(b, 0xda, byte(n>>8), byte(n))
+	default:
+		b = appendUint32(append(b, 0xdb), uint32(n))
+	}
+	return append(b, s...)
+}
+
+// AppendBytes appends a bin value.
+func AppendBytes(b []byte, bs []byte) []byte {
+	n := len(bs)
+	switch {
+	case n <= math.MaxUint8:
+		b = append(b, 0xc4, byte(n))
+	case n <= math.MaxUint16:
+		b = append(b, 0xc5, byte(n>>8), byte(n))
+	default:
+		b = appendUint32(append(b, 0xc6), uint32(n))
+	}
+	return append(b, bs...)
+}
+
+// AppendArrayHeader appends the header of an array with n elements.
+func AppendArrayHeader(b []byte, n int) []byte {
+	switch {
+	case n <= 15:
+		return append(b, 0x90|byte(n))
+	case n <= math.MaxUint16:
+		return append(b, 0xdc, byte(n>>8), byte(n))
+	}
+	return appendUint32(append(b, 0xdd), uint32(n))
+}
+
+// AppendMapHeader appends the header of a map with n pairs.
+func AppendMapHeader(b []byte, n int) []byte {
+	switch {
+	case n <= 15:
+		return append(b, 0x80|byte(n))
+	case n <= math.MaxUint16:
+		return append(b, 0xde, byte(n>>8), byte(n))
+	}
+	return appendUint32(append(b, 0xdf), uint32(n))
+}
+
+// AppendExt appends an extension value.
+func AppendExt(b []byte, typ int8, data []byte) []byte {
+	n := len(data)
+	switch n {
+	case 1:
+		b = append(b, 0xd4)
+	case 2:
+		b = append(b, 0xd5)
+	case 4:
+		b = append(b, 0xd6)
+	case 8:
+		b = append(b, 0xd7)
+	case 16:
+		b = append(b, 0xd8)
+	default:
+		switch {
+		case n <= math.MaxUint8:
+			b = append(b, 0xc7, byte(n))
+		case n <= math.MaxUint16:
+			b = append(b, 0xc8, byte(n>>8), byte(n))
+		default:
+			b = appendUint32(append(b, 0xc9), uint32(n))
+		}
+	}
+	b = append(b, byte(typ))
+	return append(b, data...)
+}
+
+// AppendValue appends a value of the basic go types, the unsupported
+// values are appended as their string representations.
+func AppendValue(b []byte, v interface{}) []byte {
+	switch val := v.(type) {
+	case nil:
+		return AppendNil(b)
+	case bool:
+		return AppendBool(b, val)
+	case string:
+		return AppendString(b, val)
+	case []byte:
+		return AppendBytes(b, val)
+	case int:
+		return AppendInt(b, int64(val))
+	case int8:
+		return AppendInt(b, int64(val))
+	case int16:
+		return AppendInt(b, int64(val))
+	case int32:
+		return AppendInt(b, int64(val))
+	case int64:
+		return AppendInt(b, val)
+	case uint:
+		return AppendUint(b, uint64(val))
+	case uint8:
+		return AppendUint(b, uint64(val))
+	case uint16:
+		return AppendUint(b, uint64(val))
+	case uint32:
+		return AppendUint(b, uint64(val))
+	case uint64:
+		return AppendUint(b, val)
+	case float32:
+		return AppendFloat64(b, float64(val))
+	case float64:
+		return AppendFloat64(b, val)
+	case time.Time:
+		return AppendString(b, val.Format(time.RFC3339Nano))
+	case error:
+		return AppendString(b, val.Error())
+	case []interface{}:
+		b = AppendArrayHeader(b, len(val))
+		for _, it := range val {
+			b = AppendValue(b, it)
+		}
+		return b
+	case map[string]interface{}:
+		b = AppendMapHeader(b, len(val))
+		for k, it := range val {
+			b = AppendString(b, k)
+			b = AppendValue(b, it)
+		}
+		return b
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Map && rv.Type().Key().Kind() == reflect.String {
+		b = AppendMapHeader(b, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			b = AppendString(b, iter.Key().String())
+			b = AppendValue(b, iter.Value().Interface())
+		}
+		return b
+	}
+	return AppendString(b, fmt.Sprint(v))
+}
+
+// Decode decodes the first value in b, and returns the value with
+// the count of bytes read. Integers are returned as int64 or uint64,
+// arrays as []interface{}, maps as map[string]interface{} and
+// extensions as Ext.
+func Decode(b []byte) (interface{}, int, error) {
+	if len(b) == 0 {
+		return nil, 0, ErrShortBuffer
+	}
+
+	c := b[0]
+	switch {
+	case c <= 0x7f:
+		return int64(c), 1, nil
+	case c >= 0xe0:
+		return int64(int8(c)), 1, nil
+	case c&0xf0 == 0x80:
+		return decodeMap(b, 1, int(c&0x0f))
+	case c&0xf0 == 0x90:
+		return decodeArray(b, 1, int(c&0x0f))
+	case c&0xe0 == 0xa0:
+		return decodeString(b, 1, int(c&0x1f))
+	}
+
+	switch c {
+	case 0xc0:
+		return nil, 1, nil
+	case 0xc2:
+		return false, 1, nil
+	case 0xc3:
+		return true, 1, nil
+	case 0xc4, 0xd9:
+		n, err := readLen(b, 1, 1)
+		if err != nil {
+			return nil, 0, err
+		}
+		return decodeRaw(b, 2, n, c == 0xd9)
+	case 0xc5, 0xda:
+		n, err := readLen(b, 1, 2)
+		if err != nil {
+			return nil, 0, err
+		}
+		return decodeRaw(b, 3, n, c == 0xda)
+	case 0xc6, 0xdb:
+		n, err := readLen(b, 1, 4)
+		if err != nil {
+			return nil, 0, err
+		}
+		return decodeRaw(b, 5, n, c == 0xdb)
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return decodeExt(b, 1, 1<<(c-0xd4))
+	case 0xc7, 0xc8, 0xc9:
+		size := 1 << (c - 0xc7)
+		n, err := readLen(b, 1, size)
+		if err != nil {
+			return nil, 0, err
+		}
+		return decodeExt(b, 1+size, n)
+	case 0xca:
+		if len(b) < 5 {
+			return nil, 0, ErrShortBuffer
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b[1:]))), 5, nil
+	case 0xcb:
+		if len(b) < 9 {
+			return nil, 0, ErrShortBuffer
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b[1:])), 9, nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		size := 1 << (c - 0xcc)
+		n, err := readUint(b, 1, size)
+		if err != nil {
+			return nil, 0, err
+		}
+		return n, 1 + size, nil
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (c - 0xd0)
+		n, err := readUint(b, 1, size)
+		if err != nil {
+			return nil, 0, err
+		}
+		shift := uint(64 - 8*size)
+		return int64(n<<shift) >> shift, 1 + size, nil
+	case 0xdc:
+		n, err := readLen(b, 1, 2)
+		if err != nil {
+			return nil, 0, err
+		}
+		return decodeArray(b, 3, n)
+	case 0xdd:
+		n, err := readLen(b, 1, 4)
+		if err != nil {
+			return nil, 0, err
+		}
+		return decodeArray(b, 5, n)
+	case 0xde:
+		n, err := readLen(b, 1, 2)
+		if err != nil {
+			return nil, 0, err
+		}
+		return decodeMap(b, 3, n)
+	case 0xdf:
+		n, err := readLen(b, 1, 4)
+		if err != nil {
+			return nil, 0, err
+		}
+		return decodeMap(b, 5, n)
+	}
+	return nil, 0, ErrUnsupported
+}
+
+func decodeString(b []byte, offset, n int) (interface{}, int, error) {
+	return decodeRaw(b, offset, n, true)
+}
+
+func decodeRaw(b []byte, offset, n int, str bool) (interface{}, int, error) {
+	if len(b) < offset+n {
+		return nil, 0, ErrShortBuffer
+	}
+	if str {
+		return string(b[offset : offset+n]), offset + n, nil
+	}
+	bs := make([]byte, n)
+	copy(bs, b[offset:offset+n])
+	return bs, offset + n, nil
+}
+
+func decodeExt(b []byte, offset, n int) (interface{}, int, error) {
+	if len(b) < offset+1+n {
+		return nil, 0, ErrShortBuffer
+	}
+	data := make([]byte, n)
+	copy(data, b[offset+1:offset+1+n])
+	return Ext{Type: int8(b[offset]), Data: data}, offset + 1 + n, nil
+}
+
+func decodeArray(b []byte, offset, n int) (interface{}, int, error) {
+	arr := make([]interface{}, 0, n)
+	for i := 0; i < n; i++ {
+		v, read, err := Decode(b[offset:])
+		if err != nil {
+			return nil, 0, err
+		}
+		arr = append(arr, v)
+		offset += read
+	}
+	return arr, offset, nil
+}
+
+func decodeMap(b []byte, offset, n int) (interface{}, int, error) {
+	m := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		k, read, err := Decode(b[offset:])
+		if err != nil {
+			return nil, 0, err
+		}
+		offset += read
+		v, read, err := Decode(b[offset:])
+		if err != nil {
+			return nil, 0, err
+		}
+		offset += read
+		m[fmt.Sprint(k)] = v
+	}
+	return m, offset, nil
+}
+
+func readLen(b []byte, offset, size int) (int, error) {
+	n, err := readUint(b, offset, size)
+	return int(n), err
+}
+
+func readUint(b []byte, offset, size int) (uint64, error) {
+	if len(b) < offset+size {
+		return 0, ErrShortBuffer
+	}
+	var n uint64
+	for _, c := range b[offset : offset+size] {
+		n = n<<8 | uint64(c)
+	}
+	return n, nil
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	return append(b, byte(v>>56), byte(v>>48), byte(v>>40), byte(v>>32),
+		byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
@@ -0,0 +1,86 @@
+package msgpack
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAppendValue(t *testing.T) {
+	tests := []struct {
+		name string
+		v    interface{}
+		want []byte
+	}{
+		{name: "when nil then nil", v: nil, want: []byte{0xc0}},
+		{name: "when true then true", v: true, want: []byte{0xc3}},
+		{name: "when positive fixint then one byte", v: 5, want: []byte{0x05}},
+		{name: "when negative fixint then one byte", v: -1, want: []byte{0xff}},
+		{name: "when uint16 then 3 bytes", v: 300, want: []byte{0xcd, 0x01, 0x2c}},
+		{name: "when int8 then 2 bytes", v: -100, want: []byte{0xd0, 0x9c}},
+		{name: "when fixstr then header with len", v: "abc", want: []byte{0xa3, 'a', 'b', 'c'}},
+		{name: "when array then fixarray", v: []interface{}{1, "a"}, want: []byte{0x92, 0x01, 0xa1, 'a'}},
+		{name: "when map then fixmap", v: map[string]interface{}{"a": 1}, want: []byte{0x81, 0xa1, 'a', 0x01}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := AppendValue(nil, tt.v); !bytes.Equal(got, tt.want) {
+				t.Errorf("AppendValue() = %x, want %x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecode(t *testing.T) {
+	long := strings.Repeat("x", 300)
+	tests := []struct {
+		name string
+		v    interface{}
+		want interface{}
+	}{
+		{name: "when int then int64", v: -70000, want: int64(-70000)},
+		{name: "when uint then uint64", v: uint64(math.MaxUint32 + 1), want: uint64(math.MaxUint32 + 1)},
+		{name: "when float then float64", v: 1.5, want: 1.5},
+		{name: "when long string then string", v: long, want: long},
+		{name: "when bin then bytes", v: []byte{1, 2}, want: []byte{1, 2}},
+		{
+			name: "when nested then decode all",
+			v:    map[string]interface{}{"ack": "abc", "list": []interface{}{true, nil}},
+			want: map[string]interface{}{"ack": "abc", "list": []interface{}{true, nil}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := AppendValue(nil, tt.v)
+			got, n, err := Decode(b)
+			if err != nil {
+				t.Fatalf("Decode() err: %+v", err)
+			}
+			if n != len(b) {
+				t.Errorf("Decode() read %d bytes, want %d", n, len(b))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Decode() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("when ext then decode ext", func(t *testing.T) {
+		b := AppendExt(nil, 0, []byte{1, 2, 3, 4, 5, 6, 7, 8})
+		got, n, err := Decode(b)
+		if err != nil || n != 10 {
+			t.Fatalf("Decode() n: %d, err: %+v", n, err)
+		}
+		if !reflect.DeepEqual(got, Ext{Type: 0, Data: []byte{1, 2, 3, 4, 5, 6, 7, 8}}) {
+			t.Errorf("Decode() = %v", got)
+		}
+	})
+
+	t.Run("when short buffer then error", func(t *testing.T) {
+		if _, _, err := Decode([]byte{0xa3, 'a'}); err != ErrShortBuffer {
+			t.Errorf("expect ErrShortBuffer, got: %v", err)
+		}
+	})
+}
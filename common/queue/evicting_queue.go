@@ -0,0 +1,213 @@
+package queue
+
+import (
+	"container/list"
+	"sync"
+)
+
+// seqBits the bits of sequence in the priority of heap item,
+// items with the same priority are evicted from the oldest.
+const seqBits = 40
+
+// EvictingQueue is a bounded FIFO queue, when it is full, the elements
+// with the lowest priority could be evicted to make room for a higher one.
+// The queue is bounded by the count of elements, and optionally by the
+// total weight of elements, such as the bytes.
+type EvictingQueue struct {
+	mu        *sync.Mutex
+	fifo      *list.List
+	pq        *PriorityQueue
+	seq       int64
+	cap       int
+	weight    int64
+	maxWeight int64
+	notEmptyC chan struct{}
+	notFullC  chan struct{}
+}
+
+type evictingElem struct {
+	val      interface{}
+	priority int
+	weight   int64
+	item     *Item
+}
+
+func NewEvictingQueue(cap int) *EvictingQueue {
+	return NewWeightedEvictingQueue(cap, 0)
+}
+
+// NewWeightedEvictingQueue creates the queue bounded by the total weight too,
+// no weight limit if maxWeight is not positive.
+func NewWeightedEvictingQueue(cap int, maxWeight int64) *EvictingQueue {
+	return &EvictingQueue{
+		mu:        new(sync.Mutex),
+		fifo:      list.New(),
+		pq:        NewPriorityQueue(cap),
+		cap:       cap,
+		maxWeight: maxWeight,
+		notEmptyC: make(chan struct{}, 1),
+		notFullC:  make(chan struct{}, 1),
+	}
+}
+
+// Offer inserts the element at the tail, returns ErrQueueFull if it is full.
+func (eq *EvictingQueue) Offer(val interface{}, priority int, weight int64) error {
+	eq.mu.Lock()
+	defer eq.mu.Unlock()
+
+	if eq.full(weight) {
+		return ErrQueueFull
+	}
+	eq.push(val, priority, weight)
+	return nil
+}
+
+// OfferEvict inserts the element, when the queue is full, the oldest elements
+// of the lowest priority will be evicted if their priority is lower than
+// both the priority of the element and maxEvict. Nothing will be evicted if
+// there is no enough room could be made.
+func (eq *EvictingQueue) OfferEvict(val interface{}, priority, maxEvict int, weight int64) ([]interface{}, error) {
+	eq.mu.Lock()
+	defer eq.mu.Unlock()
+
+	victims := eq.victims(priority, maxEvict, weight)
+	if victims == nil {
+		return nil, ErrQueueFull
+	}
+
+	evicted := make([]interface{}, 0, len(victims))
+	for _, elem := range victims {
+		evicted = append(evicted, eq.remove(elem))
+	}
+	eq.push(val, priority, weight)
+	return evicted, nil
+}
+
+// victims returns the elements should be evicted, or nil if it is impossible.
+func (eq *EvictingQueue) victims(priority, maxEvict int, weight int64) []*list.Element {
+	victims := make([]*list.Element, 0)
+	if !eq.full(weight) {
+		return victims
+	}
+
+	// take from the lowest, and put them back after checked
+	popped := make([]*Item, 0)
+	defer func() {
+		for _, item := range popped {
+			_ = eq.pq.Push(item)
+		}
+	}()
+
+	count := eq.fifo.Len()
+	total := eq.weight
+	for eq.pq.Len() > 0 {
+		item := eq.pq.Pop()
+		popped = append(popped, item)
+
+		elem := item.value.(*list.Element)
+		lowest := elem.Value.(*evictingElem)
+		if lowest.priority >= priority || lowest.priority >= maxEvict {
+			return nil
+		}
+		victims = append(victims, elem)
+		count--
+		total -= lowest.weight
+		if !eq.exceeded(count, total, weight) {
+			return victims
+		}
+	}
+	return nil
+}
+
+func (eq *EvictingQueue) full(weight int64) bool {
+	return eq.exceeded(eq.fifo.Len(), eq.weight, weight)
+}
+
+// exceeded the element is allowed even it is overweight when the queue is empty
+func (eq *EvictingQueue) exceeded(count int, total, weight int64) bool {
+	if count >= eq.cap {
+		return true
+	}
+	return eq.maxWeight > 0 && count > 0 && total+weight > eq.maxWeight
+}
+
+// Poll removes and returns the head of queue.
+func (eq *EvictingQueue) Poll() (interface{}, bool) {
+	eq.mu.Lock()
+	front := eq.fifo.Front()
+	if front == nil {
+		eq.mu.Unlock()
+		return nil, false
+	}
+	val := eq.remove(front)
+	eq.mu.Unlock()
+
+	eq.signal(eq.notFullC)
+	return val, true
+}
+
+// NotEmpty the channel notified when elements offered.
+func (eq *EvictingQueue) NotEmpty() <-chan struct{} {
+	return eq.notEmptyC
+}
+
+// NotFull the channel notified when elements polled.
+func (eq *EvictingQueue) NotFull() <-chan struct{} {
+	return eq.notFullC
+}
+
+// Signal notifies the other waiters of NotFull if the queue has room.
+func (eq *EvictingQueue) Signal() {
+	eq.mu.Lock()
+	full := eq.full(0)
+	eq.mu.Unlock()
+
+	if !full {
+		eq.signal(eq.notFullC)
+	}
+}
+
+func (eq *EvictingQueue) Len() int {
+	eq.mu.Lock()
+	defer eq.mu.Unlock()
+	return eq.fifo.Len()
+}
+
+func (eq *EvictingQueue) Cap() int {
+	return eq.cap
+}
+
+// Weight returns the total weight of the elements in queue.
+func (eq *EvictingQueue) Weight() int64 {
+	eq.mu.Lock()
+	defer eq.mu.Unlock()
+	return eq.weight
+}
+
+func (eq *EvictingQueue) push(val interface{}, priority int, weight int64) {
+	eq.seq++
+	eq.weight += weight
+	ee := &evictingElem{val: val, priority: priority, weight: weight}
+	elem := eq.fifo.PushBack(ee)
+	ee.item = &Item{
+		priority: int64(priority)<<seqBits | (eq.seq & (1<<seqBits - 1)),
+		value:    elem,
+	}
+	_ = eq.pq.Push(ee.item)
+
+	eq.signal(eq.notEmptyC)
+}
+
+func (eq *EvictingQueue) remove(elem *list.Element) interface{} {
+	ee := eq.fifo.Remove(elem).(*evictingElem)
+	eq.pq.Remove(ee.item.index)
+	eq.weight -= ee.weight
+	return ee.val
+}
+
+func (eq *EvictingQueue) signal(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,114 @@
+package queue
+
+import (
+	"testing"
+)
+
+func TestEvictingQueue(t *testing.T) {
+	pollAll := func(q *EvictingQueue) []interface{} {
+		vals := make([]interface{}, 0)
+		for {
+			val, ok := q.Poll()
+			if !ok {
+				return vals
+			}
+			vals = append(vals, val)
+		}
+	}
+
+	t.Run("when offer then poll in fifo order", func(t *testing.T) {
+		q := NewEvictingQueue(3)
+		_ = q.Offer("a", 3, 0)
+		_ = q.Offer("b", 1, 0)
+		_ = q.Offer("c", 2, 0)
+		if err := q.Offer("d", 5, 0); err != ErrQueueFull {
+			t.Errorf("expect ErrQueueFull, got: %v", err)
+		}
+		got := pollAll(q)
+		if len(got) != 3 || got[0] != "a" || got[1] != "b" || got[2] != "c" {
+			t.Errorf("unexpected order: %v", got)
+		}
+	})
+
+	t.Run("when full then evict the oldest of lowest priority", func(t *testing.T) {
+		q := NewEvictingQueue(3)
+		_ = q.Offer("a", 1, 0)
+		_ = q.Offer("b", 3, 0)
+		_ = q.Offer("c", 1, 0)
+		evicted, err := q.OfferEvict("d", 4, 3, 0)
+		if err != nil || len(evicted) != 1 || evicted[0] != "a" {
+			t.Errorf("expect a evicted, got: %v, err: %v", evicted, err)
+		}
+		evicted, err = q.OfferEvict("e", 4, 3, 0)
+		if err != nil || len(evicted) != 1 || evicted[0] != "c" {
+			t.Errorf("expect c evicted, got: %v, err: %v", evicted, err)
+		}
+		if _, err = q.OfferEvict("f", 4, 3, 0); err != ErrQueueFull {
+			t.Errorf("expect ErrQueueFull, got: %v", err)
+		}
+		got := pollAll(q)
+		if len(got) != 3 || got[0] != "b" || got[1] != "d" || got[2] != "e" {
+			t.Errorf("unexpected order: %v", got)
+		}
+	})
+
+	t.Run("when same priority then not evict", func(t *testing.T) {
+		q := NewEvictingQueue(1)
+		_ = q.Offer("a", 1, 0)
+		if _, err := q.OfferEvict("b", 1, 3, 0); err != ErrQueueFull {
+			t.Errorf("expect ErrQueueFull, got: %v", err)
+		}
+	})
+
+	t.Run("when overweight then full", func(t *testing.T) {
+		q := NewWeightedEvictingQueue(10, 100)
+		if err := q.Offer("big", 1, 150); err != nil {
+			t.Errorf("expect overweight allowed when empty, got: %v", err)
+		}
+		if err := q.Offer("a", 1, 10); err != ErrQueueFull {
+			t.Errorf("expect ErrQueueFull, got: %v", err)
+		}
+		q.Poll()
+		_ = q.Offer("a", 1, 40)
+		_ = q.Offer("b", 1, 40)
+		if q.Weight() != 80 {
+			t.Errorf("expect weight 80, got: %d", q.Weight())
+		}
+	})
+
+	t.Run("when overweight then evict until fits", func(t *testing.T) {
+		q := NewWeightedEvictingQueue(10, 100)
+		_ = q.Offer("a", 1, 40)
+		_ = q.Offer("b", 1, 40)
+		_ = q.Offer("c", 3, 20)
+		evicted, err := q.OfferEvict("d", 4, 3, 70)
+		if err != nil || len(evicted) != 2 {
+			t.Errorf("expect 2 evicted, got: %v, err: %v", evicted, err)
+		}
+		if q.Weight() != 90 {
+			t.Errorf("expect weight 90, got: %d", q.Weight())
+		}
+		if _, err := q.OfferEvict("e", 4, 3, 50); err != ErrQueueFull {
+			t.Errorf("expect ErrQueueFull, got: %v", err)
+		}
+		if q.Len() != 2 {
+			t.Errorf("expect nothing evicted when impossible, len: %d", q.Len())
+		}
+	})
+
+	t.Run("when offer then notify not empty", func(t *testing.T) {
+		q := NewEvictingQueue(1)
+		_ = q.Offer("a", 1, 0)
+		select {
+		case <-q.NotEmpty():
+		default:
+			t.Errorf("expect not empty notified")
+		}
+		q.Poll()
+		select {
+		case <-q.NotFull():
+		default:
+			t.Errorf("expect not full notified")
+		}
+	})
+}
@@ -41,7 +41,9 @@ func newSorter(size int) *sorter {
 
 // Push heap.Interface: Push, Pop, Len, Less, Swap
 func (s *sorter) Push(elem interface{}) {
-	s.items = append(s.items, elem.(*Item))
+	item := elem.(*Item)
+	item.index = len(s.items)
+	s.items = append(s.items, item)
 }
 
 func (s *sorter) Pop() interface{} {
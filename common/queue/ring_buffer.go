@@ -0,0 +1,77 @@
+package queue
+
+import (
+	"sync/atomic"
+	"unsafe"
+)
+
+type ringSlot struct {
+	seq uint64
+	val interface{}
+}
+
+// RingBuffer is a fixed-size lock-free ring, the oldest element
+// will be overwritten when the ring is full.
+type RingBuffer struct {
+	slots []unsafe.Pointer
+	size  uint64
+	next  uint64
+}
+
+func NewRingBuffer(size int) *RingBuffer {
+	if size <= 0 {
+		size = 1
+	}
+	return &RingBuffer{
+		slots: make([]unsafe.Pointer, size),
+		size:  uint64(size),
+	}
+}
+
+// Put puts the element into the ring, and returns the sequence of it.
+func (rb *RingBuffer) Put(val interface{}) uint64 {
+	seq := atomic.AddUint64(&rb.next, 1)
+	slot := &ringSlot{seq: seq, val: val}
+	atomic.StorePointer(&rb.slots[(seq-1)%rb.size], unsafe.Pointer(slot))
+	return seq
+}
+
+// Snapshot returns the elements with sequence greater than after,
+// from the oldest to the newest, and the sequence of the newest one.
+// It stops at the first slot reserved but not written yet, so the
+// elements from there are returned by the next snapshot after it.
+func (rb *RingBuffer) Snapshot(after uint64) ([]interface{}, uint64) {
+	last := atomic.LoadUint64(&rb.next)
+	first := after + 1
+	if last >= rb.size && last-rb.size+1 > first {
+		first = last - rb.size + 1
+	}
+
+	vals := make([]interface{}, 0, rb.size)
+	newest := after
+	for seq := first; seq <= last; seq++ {
+		p := atomic.LoadPointer(&rb.slots[(seq-1)%rb.size])
+		if p == nil || (*ringSlot)(p).seq < seq {
+			// not written yet
+			break
+		}
+		// skip the one overwritten by the newer one
+		if slot := (*ringSlot)(p); slot.seq == seq {
+			vals = append(vals, slot.val)
+			newest = seq
+		}
+	}
+	return vals, newest
+}
+
+func (rb *RingBuffer) Len() int {
+	last := atomic.LoadUint64(&rb.next)
+	if last > rb.size {
+		return int(rb.size)
+	}
+	return int(last)
+}
+
+func (rb *RingBuffer) Cap() int {
+	return int(rb.size)
+}
@@ -0,0 +1,100 @@
+package queue
+
+import (
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"unsafe"
+)
+
+func TestRingBuffer_Snapshot(t *testing.T) {
+	t.Run("when not full then return all", func(t *testing.T) {
+		rb := NewRingBuffer(4)
+		rb.Put(1)
+		rb.Put(2)
+		got, last := rb.Snapshot(0)
+		if !reflect.DeepEqual(got, []interface{}{1, 2}) || last != 2 {
+			t.Errorf("Snapshot() = %v, %d", got, last)
+		}
+	})
+
+	t.Run("when full then keep the newest", func(t *testing.T) {
+		rb := NewRingBuffer(3)
+		for i := 1; i <= 5; i++ {
+			rb.Put(i)
+		}
+		got, last := rb.Snapshot(0)
+		if !reflect.DeepEqual(got, []interface{}{3, 4, 5}) || last != 5 {
+			t.Errorf("Snapshot() = %v, %d", got, last)
+		}
+		if rb.Len() != 3 {
+			t.Errorf("Len() = %d, want 3", rb.Len())
+		}
+	})
+
+	t.Run("when after given then skip the older", func(t *testing.T) {
+		rb := NewRingBuffer(3)
+		for i := 1; i <= 4; i++ {
+			rb.Put(i)
+		}
+		got, last := rb.Snapshot(3)
+		if !reflect.DeepEqual(got, []interface{}{4}) || last != 4 {
+			t.Errorf("Snapshot() = %v, %d", got, last)
+		}
+		got, last = rb.Snapshot(4)
+		if len(got) != 0 || last != 4 {
+			t.Errorf("Snapshot() = %v, %d", got, last)
+		}
+	})
+
+	t.Run("when slot reserved but not written then stop at it", func(t *testing.T) {
+		rb := NewRingBuffer(4)
+		rb.Put(1)
+		// a put reserved the sequence 2, but not written yet
+		atomic.AddUint64(&rb.next, 1)
+		rb.Put(3)
+		got, last := rb.Snapshot(0)
+		if !reflect.DeepEqual(got, []interface{}{1}) || last != 1 {
+			t.Errorf("Snapshot() = %v, %d", got, last)
+		}
+
+		slot := &ringSlot{seq: 2, val: 2}
+		atomic.StorePointer(&rb.slots[1], unsafe.Pointer(slot))
+		got, last = rb.Snapshot(last)
+		if !reflect.DeepEqual(got, []interface{}{2, 3}) || last != 3 {
+			t.Errorf("Snapshot() = %v, %d", got, last)
+		}
+	})
+
+	t.Run("when reserved slot holds the older one then stop at it", func(t *testing.T) {
+		rb := NewRingBuffer(2)
+		rb.Put(1)
+		rb.Put(2)
+		// the sequence 3 reserved, the slot still holds the sequence 1
+		atomic.AddUint64(&rb.next, 1)
+		got, last := rb.Snapshot(1)
+		if !reflect.DeepEqual(got, []interface{}{2}) || last != 2 {
+			t.Errorf("Snapshot() = %v, %d", got, last)
+		}
+	})
+
+	t.Run("when concurrent put then no more than cap", func(t *testing.T) {
+		rb := NewRingBuffer(16)
+		wg := new(sync.WaitGroup)
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 100; j++ {
+					rb.Put(j)
+				}
+			}()
+		}
+		wg.Wait()
+		got, last := rb.Snapshot(0)
+		if len(got) != 16 || last != 800 {
+			t.Errorf("Snapshot() len: %d, last: %d", len(got), last)
+		}
+	})
+}
@@ -0,0 +1,185 @@
+package schedule
+
+import (
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxSearchYears the years to search the next time, an expression such as
+// "0 0 30 2 *" never matches.
+const maxSearchYears = 5
+
+type cronField struct {
+	min, max int
+}
+
+var (
+	minuteField = cronField{0, 59}
+	hourField   = cronField{0, 23}
+	domField    = cronField{1, 31}
+	monthField  = cronField{1, 12}
+	dowField    = cronField{0, 7}
+)
+
+var cronAliases = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+	"@yearly":   "0 0 1 1 *",
+}
+
+// CronSchedule activates at the times matched the cron expression in
+// the location, the expression has 5 fields: minute hour day-of-month
+// month day-of-week, each field supports "*", "n", "a-b", "a,b" and "*/n".
+// When both day-of-month and day-of-week are restricted, either matched
+// will be activated.
+type CronSchedule struct {
+	minutes uint64
+	hours   uint64
+	doms    uint64
+	months  uint64
+	dows    uint64
+	anyDom  bool
+	anyDow  bool
+	loc     *time.Location
+	spec    string
+}
+
+func ParseCron(spec string, loc *time.Location) (*CronSchedule, error) {
+	if loc == nil {
+		loc = time.Local
+	}
+	expr := strings.TrimSpace(spec)
+	if alias, ok := cronAliases[strings.ToLower(expr)]; ok {
+		expr = alias
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, errors.Errorf("cron expression should have 5 fields: %s", spec)
+	}
+
+	cs := &CronSchedule{loc: loc, spec: spec}
+	var err error
+	if cs.minutes, err = parseCronField(fields[0], minuteField); err != nil {
+		return nil, err
+	}
+	if cs.hours, err = parseCronField(fields[1], hourField); err != nil {
+		return nil, err
+	}
+	if cs.doms, err = parseCronField(fields[2], domField); err != nil {
+		return nil, err
+	}
+	if cs.months, err = parseCronField(fields[3], monthField); err != nil {
+		return nil, err
+	}
+	if cs.dows, err = parseCronField(fields[4], dowField); err != nil {
+		return nil, err
+	}
+	// 7 is sunday too
+	if cs.dows&(1<<7) != 0 {
+		cs.dows |= 1
+	}
+	cs.anyDom = fields[2] == "*"
+	cs.anyDow = fields[4] == "*"
+	if cs.Next(time.Now()).IsZero() {
+		return nil, errors.Errorf("cron expression never matches: %s", spec)
+	}
+	return cs, nil
+}
+
+func parseCronField(field string, bounds cronField) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, errors.Errorf("invalid cron step: %s", part)
+			}
+			part = part[:i]
+		}
+
+		low, high := bounds.min, bounds.max
+		if part != "*" {
+			values := strings.SplitN(part, "-", 2)
+			var err error
+			if low, err = strconv.Atoi(values[0]); err != nil {
+				return 0, errors.Errorf("invalid cron value: %s", part)
+			}
+			high = low
+			if len(values) == 2 {
+				if high, err = strconv.Atoi(values[1]); err != nil {
+					return 0, errors.Errorf("invalid cron range: %s", part)
+				}
+			} else if step > 1 {
+				// "n/step" means from n to the max of field
+				high = bounds.max
+			}
+		}
+		if low < bounds.min || high > bounds.max || low > high {
+			return 0, errors.Errorf("cron value out of range: %s", field)
+		}
+
+		for v := low; v <= high; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// Next returns the zero time if nothing matched in the next years. The
+// times are matched by the wall clock, the one at or before t on the wall
+// clock is skipped, such as the repeated hour when the daylight saving ends,
+// so it is not activated twice.
+func (cs *CronSchedule) Next(t time.Time) time.Time {
+	t = t.In(cs.loc)
+	from := wallClock(t)
+	// start from the next whole minute
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, cs.loc).Add(time.Minute)
+	limit := t.AddDate(maxSearchYears, 0, 0)
+
+	for t.Before(limit) {
+		if cs.months&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, cs.loc)
+			continue
+		}
+		if !cs.dayMatched(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, cs.loc)
+			continue
+		}
+		if cs.hours&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, cs.loc)
+			continue
+		}
+		if cs.minutes&(1<<uint(t.Minute())) == 0 || !wallClock(t).After(from) {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// wallClock returns the time of the same wall clock in UTC, to compare
+// the wall clocks regardless of the offset.
+func wallClock(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+}
+
+func (cs *CronSchedule) dayMatched(t time.Time) bool {
+	dom := cs.doms&(1<<uint(t.Day())) != 0
+	dow := cs.dows&(1<<uint(t.Weekday())) != 0
+	if cs.anyDom || cs.anyDow {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+func (cs *CronSchedule) String() string {
+	return cs.spec
+}
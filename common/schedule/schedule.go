@@ -0,0 +1,117 @@
+package schedule
+
+import (
+	"github.com/pkg/errors"
+	"strings"
+	"time"
+)
+
+const (
+	hour = time.Hour
+	day  = 24 * time.Hour
+	week = 7 * day
+)
+
+// Schedule decides the next time of an activity, such as file rotation.
+type Schedule interface {
+	// Next returns the next activation time later than t.
+	Next(t time.Time) time.Time
+}
+
+// Prev returns the last activation earlier than t, such as the start of
+// the period ending at t, or the zero time if nothing activated in the
+// last years.
+func Prev(s Schedule, t time.Time) time.Time {
+	limit := t.AddDate(-maxSearchYears, 0, 0)
+	// look back further and further until an activation is found
+	for from := t.Add(-time.Minute); from.After(limit); from = t.Add(2 * from.Sub(t)) {
+		prev := s.Next(from)
+		if prev.IsZero() || !prev.Before(t) {
+			continue
+		}
+		for next := s.Next(prev); !next.IsZero() && next.Before(t); next = s.Next(prev) {
+			prev = next
+		}
+		return prev
+	}
+	return time.Time{}
+}
+
+// IntervalSchedule activates every interval from the given time.
+type IntervalSchedule struct {
+	interval time.Duration
+}
+
+func NewIntervalSchedule(interval time.Duration) *IntervalSchedule {
+	return &IntervalSchedule{interval: interval}
+}
+
+func (is *IntervalSchedule) Next(t time.Time) time.Time {
+	return t.Add(is.interval)
+}
+
+// AlignedSchedule activates at the calendar boundaries of the interval in
+// the location, such as the top of hour for 1h, midnight for 1d, and the
+// midnight of weekday for 7d.
+type AlignedSchedule struct {
+	interval time.Duration
+	loc      *time.Location
+	weekday  time.Weekday
+}
+
+func NewAlignedSchedule(interval time.Duration, loc *time.Location, weekday time.Weekday) (*AlignedSchedule, error) {
+	if interval <= 0 {
+		return nil, errors.New("interval should be positive")
+	}
+	if interval < day && day%interval != 0 {
+		return nil, errors.Errorf("interval %v can not be aligned within a day", interval)
+	}
+	if interval > day && interval%day != 0 {
+		return nil, errors.Errorf("interval %v can not be aligned to days", interval)
+	}
+	if loc == nil {
+		loc = time.Local
+	}
+	return &AlignedSchedule{interval: interval, loc: loc, weekday: weekday}, nil
+}
+
+func (as *AlignedSchedule) Next(t time.Time) time.Time {
+	t = t.In(as.loc)
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, as.loc)
+
+	switch {
+	case as.interval <= day:
+		// walk by wall clock, keep aligned when daylight saving changes
+		elapsed := time.Duration(t.Hour())*hour + time.Duration(t.Minute())*time.Minute +
+			time.Duration(t.Second())*time.Second + time.Duration(t.Nanosecond())
+		next := (elapsed/as.interval + 1) * as.interval
+		if next >= day {
+			return midnight.AddDate(0, 0, 1)
+		}
+		return time.Date(t.Year(), t.Month(), t.Day(), int(next/hour),
+			int(next%hour/time.Minute), int(next%time.Minute/time.Second), 0, as.loc)
+	case as.interval == week:
+		days := (int(as.weekday) - int(t.Weekday()) + 7) % 7
+		if days == 0 {
+			days = 7
+		}
+		return midnight.AddDate(0, 0, days)
+	}
+
+	// align the days since unix epoch
+	n := int(as.interval / day)
+	epochDays := int(time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC).Unix() / int64(day/time.Second))
+	return midnight.AddDate(0, 0, n-epochDays%n)
+}
+
+// ParseWeekday parses the weekday name, such as "monday" or "mon".
+func ParseWeekday(weekday string) (time.Weekday, error) {
+	w := strings.ToLower(weekday)
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		name := strings.ToLower(d.String())
+		if w == name || w == name[:3] {
+			return d, nil
+		}
+	}
+	return time.Sunday, errors.Errorf("unknown weekday: %s", weekday)
+}
@@ -0,0 +1,165 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+var shanghai = time.FixedZone("CST", 8*3600)
+
+func date(y int, m time.Month, d, h, min int) time.Time {
+	return time.Date(y, m, d, h, min, 0, 0, shanghai)
+}
+
+func TestAlignedSchedule_Next(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval time.Duration
+		weekday  time.Weekday
+		from     time.Time
+		want     time.Time
+	}{
+		{"top of hour", time.Hour, time.Monday, date(2021, 6, 1, 14, 37), date(2021, 6, 1, 15, 0)},
+		{"at the boundary", time.Hour, time.Monday, date(2021, 6, 1, 15, 0), date(2021, 6, 1, 16, 0)},
+		{"every 6 hours", 6 * time.Hour, time.Monday, date(2021, 6, 1, 19, 5), date(2021, 6, 2, 0, 0)},
+		{"every 15 minutes", 15 * time.Minute, time.Monday, date(2021, 6, 1, 19, 5), date(2021, 6, 1, 19, 15)},
+		{"midnight", day, time.Monday, date(2021, 6, 1, 14, 37), date(2021, 6, 2, 0, 0)},
+		{"midnight of month end", day, time.Monday, date(2021, 6, 30, 23, 59), date(2021, 7, 1, 0, 0)},
+		{"weekly", week, time.Monday, date(2021, 6, 2, 10, 0), date(2021, 6, 7, 0, 0)},
+		{"weekly on the weekday", week, time.Tuesday, date(2021, 6, 1, 0, 0), date(2021, 6, 8, 0, 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			as, err := NewAlignedSchedule(tt.interval, shanghai, tt.weekday)
+			if err != nil {
+				t.Fatalf("NewAlignedSchedule() error = %v", err)
+			}
+			if got := as.Next(tt.from); !got.Equal(tt.want) {
+				t.Errorf("Next() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewAlignedSchedule(t *testing.T) {
+	for _, interval := range []time.Duration{0, 7 * time.Hour, 36 * time.Hour} {
+		if _, err := NewAlignedSchedule(interval, shanghai, time.Monday); err == nil {
+			t.Errorf("NewAlignedSchedule(%v) want error", interval)
+		}
+	}
+}
+
+func TestCronSchedule_Next(t *testing.T) {
+	tests := []struct {
+		name string
+		spec string
+		from time.Time
+		want time.Time
+	}{
+		{"every minute", "* * * * *", date(2021, 6, 1, 14, 37), date(2021, 6, 1, 14, 38)},
+		{"hourly", "@hourly", date(2021, 6, 1, 14, 37), date(2021, 6, 1, 15, 0)},
+		{"daily at time", "30 2 * * *", date(2021, 6, 1, 14, 37), date(2021, 6, 2, 2, 30)},
+		{"steps", "*/20 * * * *", date(2021, 6, 1, 14, 41), date(2021, 6, 1, 15, 0)},
+		{"start with step", "5/20 * * * *", date(2021, 6, 1, 14, 26), date(2021, 6, 1, 14, 45)},
+		{"list and range", "0 9-11,18 * * *", date(2021, 6, 1, 11, 30), date(2021, 6, 1, 18, 0)},
+		{"weekly on sunday", "0 0 * * 7", date(2021, 6, 1, 14, 37), date(2021, 6, 6, 0, 0)},
+		{"weekdays", "0 0 * * 1-5", date(2021, 6, 4, 14, 37), date(2021, 6, 7, 0, 0)},
+		{"monthly", "@monthly", date(2021, 6, 1, 14, 37), date(2021, 7, 1, 0, 0)},
+		{"dom or dow", "0 0 15 * 1", date(2021, 6, 8, 1, 0), date(2021, 6, 14, 0, 0)},
+		{"leap day", "0 0 29 2 *", date(2021, 6, 1, 0, 0), date(2024, 2, 29, 0, 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs, err := ParseCron(tt.spec, shanghai)
+			if err != nil {
+				t.Fatalf("ParseCron() error = %v", err)
+			}
+			if got := cs.Next(tt.from); !got.Equal(tt.want) {
+				t.Errorf("Next() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCronSchedule_NextDST(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("load location err: %v", err)
+	}
+	cs, err := ParseCron("30 1 * * *", ny)
+	if err != nil {
+		t.Fatalf("ParseCron() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		from time.Time
+		want time.Time
+	}{
+		// 2021-11-07 01:00-01:59 is repeated, EDT then EST
+		{"when fall back then activated once", time.Date(2021, 11, 7, 1, 30, 0, 0, ny), time.Date(2021, 11, 8, 1, 30, 0, 0, ny)},
+		{"when before fall back then the first 1:30", time.Date(2021, 11, 7, 0, 10, 0, 0, ny), time.Date(2021, 11, 7, 1, 30, 0, 0, ny)},
+		// 2021-03-14 02:00-02:59 is skipped
+		{"when spring forward then as usual", time.Date(2021, 3, 13, 1, 30, 0, 0, ny), time.Date(2021, 3, 14, 1, 30, 0, 0, ny)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cs.Next(tt.from); !got.Equal(tt.want) {
+				t.Errorf("Next() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrev(t *testing.T) {
+	daily, _ := NewAlignedSchedule(day, shanghai, time.Monday)
+	weekly, _ := NewAlignedSchedule(week, shanghai, time.Monday)
+	cron, _ := ParseCron("0 0,1 * * *", shanghai)
+	yearly, _ := ParseCron("@yearly", shanghai)
+	tests := []struct {
+		name string
+		s    Schedule
+		t    time.Time
+		want time.Time
+	}{
+		{"when midnight then the day before", daily, date(2021, 6, 2, 0, 0), date(2021, 6, 1, 0, 0)},
+		{"when in the day then its midnight", daily, date(2021, 6, 2, 14, 37), date(2021, 6, 2, 0, 0)},
+		{"when weekly then the monday before", weekly, date(2021, 6, 7, 0, 0), date(2021, 5, 31, 0, 0)},
+		{"when uneven cron then the last", cron, date(2021, 6, 2, 0, 0), date(2021, 6, 1, 1, 0)},
+		{"when yearly then the year before", yearly, date(2021, 1, 1, 0, 0), date(2020, 1, 1, 0, 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Prev(tt.s, tt.t); !got.Equal(tt.want) {
+				t.Errorf("Prev() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCron(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "5-1 * * * *", "*/0 * * * *", "a * * * *", "0 0 30 2 *"} {
+		if _, err := ParseCron(spec, shanghai); err == nil {
+			t.Errorf("ParseCron(%q) want error", spec)
+		}
+	}
+}
+
+func TestParseWeekday(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Weekday
+		wantErr bool
+	}{
+		{"monday", time.Monday, false},
+		{"Sun", time.Sunday, false},
+		{"FRI", time.Friday, false},
+		{"someday", time.Sunday, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseWeekday(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseWeekday(%q) = %v, %v", tt.in, got, err)
+		}
+	}
+}
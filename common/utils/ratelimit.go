@@ -34,11 +34,11 @@ func (rl *RateLimiter) Allowable() bool {
 	}
 
 	rl.mu.RLock()
-	if *rl.token <= 0 {
+	defer rl.mu.RUnlock()
+	if atomic.LoadInt64(rl.token) <= 0 {
 		return false
 	}
 	rl.decrement(rl.token)
-	rl.mu.RUnlock()
 	return true
 }
 
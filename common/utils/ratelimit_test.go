@@ -218,3 +218,16 @@ func Test_decrement(t *testing.T) {
 		}
 	})
 }
+
+func TestRateLimiter_Reset(t *testing.T) {
+	t.Run("when exhausted then allowable after interval", func(t *testing.T) {
+		rl := NewRateLimiter(1, 10)
+		if !rl.Allowable() || rl.Allowable() {
+			t.Fatalf("expect only one allowed")
+		}
+		time.Sleep(20 * time.Millisecond)
+		if !rl.Allowable() {
+			t.Errorf("expect allowed after interval")
+		}
+	})
+}
@@ -27,6 +27,7 @@ var (
 )
 
 var timeUnits = map[byte]int{
+	's': 1,
 	'h': HourSeconds,
 	'd': DaySeconds,
 	'm': MinuteSeconds,
@@ -70,7 +71,7 @@ func ParseSize(fileSize string) (int, error) {
 	return sd, nil
 }
 
-// ParseSeconds from 1h 10m 10d
+// ParseSeconds from 5s 1h 10m 10d
 func ParseSeconds(interval string) (int, error) {
 	if len(interval) == 0 {
 		return -1, errors.New("interval is blank")
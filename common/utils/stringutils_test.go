@@ -259,6 +259,14 @@ func TestParseSeconds(t *testing.T) {
 			want:    -1,
 			wantErr: true,
 		},
+		{
+			name: "when 5s then return 5",
+			args: args{
+				interval: "5s",
+			},
+			want:    5,
+			wantErr: false,
+		},
 		{
 			name: "when 1w then return error",
 			args: args{
@@ -1,7 +1,10 @@
 package utils
 
 import (
+	"archive/tar"
 	"archive/zip"
+	"compress/flate"
+	"compress/gzip"
 	"github.com/pkg/errors"
 	"io"
 	"os"
@@ -9,6 +12,11 @@ import (
 )
 
 func ZipCompress(sourceFile, archiveFile string) error {
+	return ZipCompressLevel(sourceFile, archiveFile, flate.DefaultCompression)
+}
+
+// ZipCompressLevel compresses by the deflate level, from flate.HuffmanOnly to flate.BestCompression.
+func ZipCompressLevel(sourceFile, archiveFile string, level int) error {
 	archive, err := os.Create(archiveFile)
 	if err != nil {
 		return errors.Wrap(err, "create archive file error")
@@ -17,6 +25,9 @@ func ZipCompress(sourceFile, archiveFile string) error {
 
 	zipWriter := zip.NewWriter(archive)
 	defer zipWriter.Close()
+	zipWriter.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(out, level)
+	})
 
 	source, err := os.Open(sourceFile)
 	if err != nil {
@@ -33,5 +44,84 @@ func ZipCompress(sourceFile, archiveFile string) error {
 		return errors.Wrap(err, "archive file error")
 	}
 
-	return nil
+	if err := zipWriter.Close(); err != nil {
+		return errors.Wrap(err, "close archive error")
+	}
+	return archive.Close()
+}
+
+func GzipCompress(sourceFile, archiveFile string, level int) error {
+	archive, err := os.Create(archiveFile)
+	if err != nil {
+		return errors.Wrap(err, "create archive file error")
+	}
+	defer archive.Close()
+
+	gzipWriter, err := gzip.NewWriterLevel(archive, level)
+	if err != nil {
+		return errors.Wrap(err, "create gzip writer error")
+	}
+	defer gzipWriter.Close()
+
+	source, err := os.Open(sourceFile)
+	if err != nil {
+		return errors.Wrap(err, "open sourceFile file error")
+	}
+	defer source.Close()
+
+	gzipWriter.Name = path.Base(sourceFile)
+	if _, err := io.Copy(gzipWriter, source); err != nil {
+		return errors.Wrap(err, "archive file error")
+	}
+
+	if err := gzipWriter.Close(); err != nil {
+		return errors.Wrap(err, "close archive error")
+	}
+	return archive.Close()
+}
+
+func TarGzCompress(sourceFile, archiveFile string, level int) error {
+	archive, err := os.Create(archiveFile)
+	if err != nil {
+		return errors.Wrap(err, "create archive file error")
+	}
+	defer archive.Close()
+
+	gzipWriter, err := gzip.NewWriterLevel(archive, level)
+	if err != nil {
+		return errors.Wrap(err, "create gzip writer error")
+	}
+	defer gzipWriter.Close()
+
+	tarWriter := tar.NewWriter(gzipWriter)
+	defer tarWriter.Close()
+
+	source, err := os.Open(sourceFile)
+	if err != nil {
+		return errors.Wrap(err, "open sourceFile file error")
+	}
+	defer source.Close()
+
+	info, err := source.Stat()
+	if err != nil {
+		return errors.Wrap(err, "stat sourceFile file error")
+	}
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return errors.Wrap(err, "create tar header error")
+	}
+	if err := tarWriter.WriteHeader(header); err != nil {
+		return errors.Wrap(err, "write tar header error")
+	}
+	if _, err := io.Copy(tarWriter, source); err != nil {
+		return errors.Wrap(err, "archive file error")
+	}
+
+	if err := tarWriter.Close(); err != nil {
+		return errors.Wrap(err, "close archive error")
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return errors.Wrap(err, "close archive error")
+	}
+	return archive.Close()
 }
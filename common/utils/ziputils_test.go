@@ -1,7 +1,13 @@
 package utils
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io/ioutil"
 	"os"
+	"path"
+	"strings"
 	"testing"
 )
 
@@ -33,3 +39,68 @@ func TestZipCompress(t *testing.T) {
 		})
 	}
 }
+
+func TestCompressFormats(t *testing.T) {
+	dir := t.TempDir()
+	src := path.Join(dir, "info.log")
+	content := strings.Repeat("hello etlog\n", 100)
+	if err := os.WriteFile(src, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("when gzip then decompressed equals", func(t *testing.T) {
+		dst := src + ".gz"
+		if err := GzipCompress(src, dst, gzip.BestSpeed); err != nil {
+			t.Fatalf("GzipCompress() err: %+v", err)
+		}
+		f, _ := os.Open(dst)
+		defer f.Close()
+		r, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(r)
+		if string(b) != content || r.Name != "info.log" {
+			t.Errorf("unexpected content of %s", r.Name)
+		}
+	})
+
+	t.Run("when tar.gz then decompressed equals", func(t *testing.T) {
+		dst := src + ".tar.gz"
+		if err := TarGzCompress(src, dst, gzip.DefaultCompression); err != nil {
+			t.Fatalf("TarGzCompress() err: %+v", err)
+		}
+		f, _ := os.Open(dst)
+		defer f.Close()
+		gr, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr := tar.NewReader(gr)
+		header, err := tr.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(tr)
+		if string(b) != content || header.Name != "info.log" {
+			t.Errorf("unexpected content of %s", header.Name)
+		}
+	})
+
+	t.Run("when zip with level then decompressed equals", func(t *testing.T) {
+		dst := src + ".zip"
+		if err := ZipCompressLevel(src, dst, 9); err != nil {
+			t.Fatalf("ZipCompressLevel() err: %+v", err)
+		}
+		zr, err := zip.OpenReader(dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer zr.Close()
+		r, _ := zr.File[0].Open()
+		b, _ := ioutil.ReadAll(r)
+		if string(b) != content {
+			t.Errorf("unexpected content")
+		}
+	})
+}
@@ -50,8 +50,32 @@ func (c *Config) Init() error {
 }
 
 type LogConfig struct {
-	Handlers []HandlerConfig `yaml:"handlers"`
-	Level    string          `yaml:"level"`
+	Handlers       []HandlerConfig `yaml:"handlers"`
+	Level          string          `yaml:"level"`
+	ReopenOnSighup bool            `yaml:"reopen_on_sighup"`
+	// Cleaner cleans the files by rules, no cleaner if nil
+	Cleaner *CleanerConfig `yaml:"cleaner"`
+}
+
+// CleanerConfig the standalone cleaner, which cleans the files of any tools
+// on the same schedule.
+type CleanerConfig struct {
+	// Interval the interval of cleaning, such as "10m"
+	Interval string       `yaml:"interval"`
+	DryRun   bool         `yaml:"dry_run"`
+	Rules    []*CleanRule `yaml:"rules"`
+}
+
+// CleanRule selects the files by the glob pattern, the time of file is
+// parsed from the name by TimeRegex and TimeLayout, or the modified time.
+type CleanRule struct {
+	Name       string `yaml:"name"`
+	Pattern    string `yaml:"pattern"`
+	TimeRegex  string `yaml:"time_regex"`
+	TimeLayout string `yaml:"time_layout"`
+	MaxAge     string `yaml:"max_age"`
+	MaxCount   int    `yaml:"max_count"`
+	MaxSize    string `yaml:"max_size"`
 }
 
 func NewLogConfig() *LogConfig {
@@ -65,9 +89,18 @@ type HandlerConfig struct {
 	Marker   string          `yaml:"marker"`
 	Levels   []string        `yaml:"levels"`
 	File     string          `yaml:"file"`
+	Shared   bool            `yaml:"shared"`
 	Rollover *RolloverConfig `yaml:"rollover"`
 	Sync     *SyncConfig     `yaml:"sync"`
 	Message  *MessageConfig  `yaml:"message"`
+	Remote   *RemoteConfig   `yaml:"remote"`
+	Ring     *RingConfig     `yaml:"ring"`
+	Crossed  *CrossedConfig  `yaml:"fingers_crossed"`
+	// TimeIndex writes the sparse time index next to the file, no index if nil
+	TimeIndex *TimeIndexConfig `yaml:"time_index"`
+	// Bloom writes the bloom filter of the field values next to the file, no filter if nil,
+	// not allowed with the encrypted archives
+	Bloom *BloomConfig `yaml:"bloom"`
 }
 
 func NewHandlerConfig() *HandlerConfig {
@@ -75,25 +108,133 @@ func NewHandlerConfig() *HandlerConfig {
 		Rollover: NewRolloverConfig(),
 		Sync:     NewSyncConfig(),
 		Message:  NewMessageConfig(),
+		Remote:   NewRemoteConfig(),
+		Ring:     NewRingConfig(),
+		Crossed:  NewCrossedConfig(),
 	}
 }
 
 type RolloverConfig struct {
 	RolloverInterval string `yaml:"rollover_interval"`
 	RolloverSize     string `yaml:"rollover_size"`
+	RolloverAlign    bool   `yaml:"rollover_align"`
+	RolloverWeekday  string `yaml:"rollover_weekday"`
+	RolloverAt       string `yaml:"rollover_at"`
+	Timezone         string `yaml:"timezone"`
+	BackupNaming     string `yaml:"backup_naming"`
 	BackupCount      int    `yaml:"backup_count"`
 	BackupTime       string `yaml:"backup_time"`
+	MaxTotalSize     string `yaml:"max_total_size"`
+	CurrentLink      string `yaml:"current_link"`
+	// DiskLowWater the free space such as "1G" or "10%", under which the oldest
+	// backups are deleted, then the entries below WARN are dropped
+	DiskLowWater string `yaml:"disk_low_water"`
+	// DiskStopWater the free space under which writing is stopped
+	DiskStopWater string `yaml:"disk_stop_water"`
+	// DiskCheckInterval the interval in ms to check the free space
+	DiskCheckInterval int `yaml:"disk_check_interval"`
+	// ExternalRotate the mode of the rotation by other tools such as logrotate,
+	// "reopen" recreates the file moved or deleted, "copytruncate" follows truncation.
+	ExternalRotate string `yaml:"external_rotate"`
+	// ExternalCheckInterval the interval in ms to check the file
+	ExternalCheckInterval int            `yaml:"external_check_interval"`
+	Archive               *ArchiveConfig `yaml:"archive"`
+	// Retention the tiers keeping one backup per period, such as
+	// [{every: all, for: 1d}, {every: 1h, for: 7d}, {every: 1d, for: 90d}]
+	Retention []*RetentionTier `yaml:"retention"`
+	// CleanDryRun logs the backups to be cleaned without removing them
+	CleanDryRun bool `yaml:"clean_dry_run"`
+}
+
+// RetentionTier keeps one backup per Every for the backups younger than For,
+// Every "all" keeps all.
+type RetentionTier struct {
+	Every string `yaml:"every"`
+	For   string `yaml:"for"`
 }
 
 func NewRolloverConfig() *RolloverConfig {
-	return &RolloverConfig{}
+	return &RolloverConfig{
+		Archive: NewArchiveConfig(),
+	}
+}
+
+type ArchiveConfig struct {
+	// Format zip|gzip|tar.gz|none
+	Format string `yaml:"format"`
+	// Level the compression level from 1 to 9, 0 means the default
+	Level int `yaml:"level"`
+	// Delay the delay before archiving, such as "5s"
+	Delay string `yaml:"delay"`
+	// Dir the dir of archives, the dir of log file by default
+	Dir string `yaml:"dir"`
+	// Stream writes gzip into the active file directly, no archiving after rotation
+	Stream bool `yaml:"stream"`
+	// Manifest writes a manifest with the SHA-256 checksum next to each archive
+	Manifest bool `yaml:"manifest"`
+	// Encrypt encrypts the archives by AES-256-GCM, no encryption if nil
+	Encrypt *EncryptConfig `yaml:"encrypt"`
+	// Upload uploads the archives, the cleaner deletes only the uploaded ones
+	Upload *UploadConfig `yaml:"upload"`
+}
+
+func NewArchiveConfig() *ArchiveConfig {
+	return &ArchiveConfig{}
+}
+
+// EncryptConfig the key is read from KeyFile, or KeyEnv if KeyFile is blank,
+// the KeyID is written into the encrypted files to select the key to decrypt.
+type EncryptConfig struct {
+	KeyID   string `yaml:"key_id"`
+	KeyFile string `yaml:"key_file"`
+	KeyEnv  string `yaml:"key_env"`
+}
+
+// UploadConfig uploads by http put to the endpoint, the "{name}" in it is
+// replaced by the archive name, or copies into the dir.
+type UploadConfig struct {
+	// Type http|dir
+	Type     string            `yaml:"type"`
+	Endpoint string            `yaml:"endpoint"`
+	Headers  map[string]string `yaml:"headers"`
+	// Timeout the timeout of each request in ms
+	Timeout int    `yaml:"timeout"`
+	Dir     string `yaml:"dir"`
+}
+
+// TimeIndexConfig one point of the time index per Every entries or Interval,
+// whichever first.
+type TimeIndexConfig struct {
+	Every int `yaml:"every"`
+	// Interval such as "1m"
+	Interval string `yaml:"interval"`
+}
+
+// BloomConfig one bloom filter per file of the values of Fields, sized for
+// Expected values at the FalsePositive rate.
+type BloomConfig struct {
+	// Fields such as ["trace_id", "user_id"]
+	Fields        []string `yaml:"fields"`
+	Expected      int      `yaml:"expected"`
+	FalsePositive float64  `yaml:"false_positive"`
 }
 
 type SyncConfig struct {
-	AsyncWrite    bool `yaml:"async_write"`
-	FlushInterval int  `yaml:"flush_interval"`
-	FlushSize     int  `yaml:"flush_size"`
-	QueueSize     int  `yaml:"queue_size"`
+	AsyncWrite     bool   `yaml:"async_write"`
+	FlushInterval  int    `yaml:"flush_interval"`
+	FlushSize      int    `yaml:"flush_size"`
+	QueueSize      int    `yaml:"queue_size"`
+	Overflow       string `yaml:"overflow"`
+	DropLevel      string `yaml:"drop_level"`
+	MaxBufferBytes string `yaml:"max_buffer_bytes"`
+	// Durability none|interval|batch|every_write, when to fsync the file
+	Durability string `yaml:"durability"`
+	// FsyncInterval the interval in ms for the interval durability
+	FsyncInterval int `yaml:"fsync_interval"`
+	// DurableLevel the entries at or above the level are written synchronously
+	// and synced to the disk before the handling returns, as the entries logged
+	// by etlog.Durable, such as "error" for the audit marker, none if blank
+	DurableLevel string `yaml:"durable_level"`
 }
 
 func NewSyncConfig() *SyncConfig {
@@ -107,3 +248,63 @@ type MessageConfig struct {
 func NewMessageConfig() *MessageConfig {
 	return &MessageConfig{}
 }
+
+// RemoteConfig the setting of handlers which send entries over network,
+// the time values are in milliseconds. The failed batch is retried 3 times
+// if max_retries is not set, 0 disables retrying. The batch is sent once it
+// has batch_size entries, or the estimated batch_bytes such as "5M", which
+// should be under the request limit of server, such as http.max_content_length.
+//
+// The batch is retried before collecting the next one, the entries arriving
+// meanwhile wait in the queue of queue_size, and are dropped once it is full.
+// The queue should hold the entries of the longest retry, which is about
+// retry_backoff * 2^max_retries, or the retries should be fewer.
+type RemoteConfig struct {
+	Endpoint      string            `yaml:"endpoint"`
+	Headers       map[string]string `yaml:"headers"`
+	Timeout       int               `yaml:"timeout"`
+	BatchSize     int               `yaml:"batch_size"`
+	BatchBytes    string            `yaml:"batch_bytes"`
+	BatchInterval int               `yaml:"batch_interval"`
+	QueueSize     int               `yaml:"queue_size"`
+	MaxRetries    *int              `yaml:"max_retries"`
+	RetryBackoff  int               `yaml:"retry_backoff"`
+	Index         string            `yaml:"index"`
+	Service       string            `yaml:"service"`
+	Tag           string            `yaml:"tag"`
+	Mode          string            `yaml:"mode"`
+	RequireAck    bool              `yaml:"require_ack"`
+}
+
+func NewRemoteConfig() *RemoteConfig {
+	return &RemoteConfig{}
+}
+
+// RingConfig the setting of ring handler, the entries in ring will be dumped
+// into the dump file or the dump handler.
+type RingConfig struct {
+	Size      int            `yaml:"size"`
+	DumpLevel string         `yaml:"dump_level"`
+	DumpFile  string         `yaml:"dump_file"`
+	Dump      *HandlerConfig `yaml:"dump"`
+}
+
+func NewRingConfig() *RingConfig {
+	return &RingConfig{}
+}
+
+// CrossedConfig the setting of fingers crossed handler, the entries are
+// buffered per scope, and written into the wrapped handler only when
+// an entry at or above the trigger level arrived in the same scope.
+type CrossedConfig struct {
+	TriggerLevel string         `yaml:"trigger_level"`
+	ScopeField   string         `yaml:"scope_field"`
+	BufferSize   int            `yaml:"buffer_size"`
+	MaxScopes    int            `yaml:"max_scopes"`
+	ScopeTTL     string         `yaml:"scope_ttl"`
+	Handler      *HandlerConfig `yaml:"handler"`
+}
+
+func NewCrossedConfig() *CrossedConfig {
+	return &CrossedConfig{}
+}
@@ -13,8 +13,26 @@ type Format int
 const (
 	defaultTimeFormat = "2006-01-02 15:04:05.000000"
 	simpleTimeFormat  = "2006-01-02 15:04:05"
+	// fullNone the placeholder of the absent value in full format
+	fullNone = "-"
 )
 
+var (
+	// simpleEscaper escapes the tabs, so the separator "\t|" is unambiguous
+	simpleEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+	// fullEscaper escapes the separator "|"
+	fullEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, "\r", `\r`, "|", `\|`)
+)
+
+// escapeFull escapes the value of full format, the value same as the
+// placeholder is escaped too.
+func escapeFull(s string) string {
+	if s == fullNone {
+		return `\` + fullNone
+	}
+	return fullEscaper.Replace(s)
+}
+
 const (
 	defaultFormat        = SIMPLE
 	SIMPLE        Format = iota
@@ -83,11 +101,11 @@ func (sf *SimpleFormatter) Format(entry *LogEntry) *bufferpool.Buffer {
 	buf.AppendByte('\t')
 
 	// msg
-	buf.AppendValue(entry.Msg)
+	buf.AppendString(simpleEscaper.Replace(entry.Msg))
 
 	if entry.Err != nil {
 		buf.AppendString("\t|err:=")
-		buf.AppendString(fmt.Sprint(entry.Err))
+		buf.AppendString(simpleEscaper.Replace(fmt.Sprint(entry.Err)))
 	}
 
 	if entry.Fields != nil && len(entry.Fields) > 0 {
@@ -120,25 +138,25 @@ func (ff *FullFormatter) Format(entry *LogEntry) *bufferpool.Buffer {
 
 	// line & func
 	if entry.UseLoc {
-		buf.AppendString(entry.SrcFile)
+		buf.AppendString(fullEscaper.Replace(entry.SrcFile))
 		buf.AppendByte(':')
 		buf.AppendInt(int64(entry.Line))
 		buf.AppendByte('|')
-		buf.AppendString(entry.FuncName)
+		buf.AppendString(escapeFull(entry.FuncName))
 	} else {
 		buf.AppendString("-|-")
 	}
 	buf.AppendByte('|')
 
 	// msg
-	buf.AppendString(entry.Msg)
+	buf.AppendString(fullEscaper.Replace(entry.Msg))
 	buf.AppendByte('|')
 
 	// error
 	if entry.Err != nil {
-		buf.AppendString(fmt.Sprint(entry.Err))
+		buf.AppendString(escapeFull(fmt.Sprint(entry.Err)))
 	} else {
-		buf.AppendByte('-')
+		buf.AppendString(fullNone)
 	}
 	buf.AppendByte('|')
 
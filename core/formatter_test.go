@@ -164,7 +164,7 @@ func TestJSONFormatter_Format(t *testing.T) {
 					Fields:   map[string]interface{}{"Hello": "world", "abc": 123},
 				},
 			},
-			want: `{"time":"2021-06-15T12:20:45.152+08:00","level":2,"srcf":"hello.go","line":123,"func":"TestFormatter.func1","msg":"hello world","error":{},"fields":{"Hello":"world","abc":123}}
+			want: `{"time":"2021-06-15T12:20:45.152+08:00","level":2,"srcf":"hello.go","line":123,"func":"TestFormatter.func1","msg":"hello world","error":"oops","fields":{"Hello":"world","abc":123}}
 `,
 		},
 	}
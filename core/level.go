@@ -1,6 +1,9 @@
 package core
 
-import "strings"
+import (
+	"github.com/pkg/errors"
+	"strings"
+)
 
 type Level int
 
@@ -50,3 +53,13 @@ func (l Level) String() string {
 	}
 	return ""
 }
+
+// ParseLevel parses the level name, unlike NewLevel, the unknown name is
+// an error.
+func ParseLevel(level string) (Level, error) {
+	l := NewLevel(level)
+	if !strings.EqualFold(l.String(), level) {
+		return l, errors.Errorf("unknown level: %s", level)
+	}
+	return l, nil
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"github.com/edditen/etlog/common/utils"
 	"github.com/edditen/etlog/opt"
+	"github.com/pkg/errors"
 	"time"
 )
 
@@ -21,6 +22,90 @@ type LogEntry struct {
 	Err      error     `json:"error,omitempty"`
 	Fields   Fields    `json:"fields,omitempty"`
 	UseLoc   bool      `json:"-"`
+	Scope    uint64    `json:"-"`
+	// Durable the entry is synced to the disk before the handling returns
+	Durable bool `json:"-"`
+}
+
+// jsonEntry the json form of LogEntry, the error is written as its text,
+// the keys are in the same order as LogEntry.
+type jsonEntry struct {
+	Time     time.Time `json:"time,omitempty"`
+	Level    Level     `json:"level,omitempty"`
+	SrcFile  string    `json:"srcf,omitempty"`
+	Line     int       `json:"line,omitempty"`
+	FuncName string    `json:"func,omitempty"`
+	Msg      string    `json:"msg,omitempty"`
+	Marker   string    `json:"marker,omitempty"`
+	Err      string    `json:"error,omitempty"`
+	Fields   Fields    `json:"fields,omitempty"`
+}
+
+type entryAlias LogEntry
+
+// MarshalJSON writes the error as its text, which was the json form of the
+// error value before, "{}" for most errors, so it could not be parsed back.
+func (le *LogEntry) MarshalJSON() ([]byte, error) {
+	je := jsonEntry{
+		Time:     le.Time,
+		Level:    le.Level,
+		SrcFile:  le.SrcFile,
+		Line:     le.Line,
+		FuncName: le.FuncName,
+		Msg:      le.Msg,
+		Marker:   le.Marker,
+		Fields:   le.Fields,
+	}
+	if le.Err != nil {
+		je.Err = le.Err.Error()
+	}
+	return json.Marshal(je)
+}
+
+// UnmarshalJSON reads the level in number or name, and the error text.
+func (le *LogEntry) UnmarshalJSON(b []byte) error {
+	var je struct {
+		*entryAlias
+		Level  json.RawMessage `json:"level,omitempty"`
+		Err    string          `json:"error,omitempty"`
+		Fields json.RawMessage `json:"fields,omitempty"`
+	}
+	je.entryAlias = (*entryAlias)(le)
+	if err := json.Unmarshal(b, &je); err != nil {
+		return err
+	}
+
+	if len(je.Level) > 0 {
+		level, err := unmarshalLevel(je.Level)
+		if err != nil {
+			return err
+		}
+		le.Level = level
+	}
+	if je.Err != "" {
+		le.Err = errors.New(je.Err)
+	}
+	if len(je.Fields) > 0 {
+		// keep the numbers as they were written
+		decoder := json.NewDecoder(bytes.NewReader(je.Fields))
+		decoder.UseNumber()
+		if err := decoder.Decode(&le.Fields); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func unmarshalLevel(b []byte) (Level, error) {
+	var num int
+	if err := json.Unmarshal(b, &num); err == nil {
+		return Level(num), nil
+	}
+	var name string
+	if err := json.Unmarshal(b, &name); err != nil {
+		return DEBUG, err
+	}
+	return ParseLevel(name)
 }
 
 func NewLogEntry() *LogEntry {
@@ -39,7 +124,37 @@ func (le *LogEntry) Copy() *LogEntry {
 		Err:      le.Err,
 		Fields:   le.Fields,
 		UseLoc:   le.UseLoc,
+		Scope:    le.Scope,
+		Durable:  le.Durable,
+	}
+}
+
+// entryOverhead the estimated size of time, level and separators
+const entryOverhead = 64
+
+// EstimateSize estimates the formatted size of entry without formatting it.
+func (le *LogEntry) EstimateSize() int {
+	size := entryOverhead + len(le.Msg) + len(le.Marker)
+	if le.UseLoc {
+		size += len(le.SrcFile) + len(le.FuncName) + 8
+	}
+	if le.Err != nil {
+		size += len(le.Err.Error())
+	}
+	for k, v := range le.Fields {
+		size += len(k) + 4
+		switch val := v.(type) {
+		case string:
+			size += len(val)
+		case []byte:
+			size += len(val)
+		case error:
+			size += len(val.Error())
+		default:
+			size += 16
+		}
 	}
+	return size
 }
 
 func (f Fields) String() string {
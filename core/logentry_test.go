@@ -1,6 +1,10 @@
 package core
 
-import "testing"
+import (
+	"errors"
+	"strings"
+	"testing"
+)
 
 func TestFields_String(t *testing.T) {
 	tests := []struct {
@@ -32,3 +36,20 @@ func TestFields_String(t *testing.T) {
 		})
 	}
 }
+
+func TestLogEntry_EstimateSize(t *testing.T) {
+	t.Run("when payload large then estimate grows with it", func(t *testing.T) {
+		small := &LogEntry{Msg: "hello"}
+		large := &LogEntry{
+			Msg:    strings.Repeat("x", 1024),
+			Err:    errors.New("oops"),
+			Fields: Fields{"payload": strings.Repeat("y", 2048), "count": 1},
+		}
+		if got := small.EstimateSize(); got != entryOverhead+5 {
+			t.Errorf("EstimateSize() = %d, want %d", got, entryOverhead+5)
+		}
+		if got := large.EstimateSize(); got < 1024+2048 {
+			t.Errorf("EstimateSize() = %d, want at least %d", got, 1024+2048)
+		}
+	})
+}
@@ -0,0 +1,269 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	simpleErrSep    = "\t|err:="
+	simpleFieldsSep = "\t|fields:="
+	// fullSeps the separators before the fields in full format
+	fullSeps = 6
+)
+
+// Parser parses a line written by the formatter of same format back into
+// LogEntry, the line is without the trailing newline.
+type Parser interface {
+	Parse(line []byte) (*LogEntry, error)
+}
+
+func NewParser(format Format) Parser {
+	switch format {
+	case SIMPLE:
+		return NewSimpleParser()
+	case FULL:
+		return NewFullParser()
+	case JSON:
+		return NewJSONParser()
+	}
+	return NewSimpleParser()
+}
+
+type SimpleParser struct {
+}
+
+func NewSimpleParser() *SimpleParser {
+	// format: "time [level]\tmsg\t|err:=error\t|fields:=fields"
+	return &SimpleParser{}
+}
+
+func (sp *SimpleParser) Parse(line []byte) (*LogEntry, error) {
+	s := string(line)
+	if len(s) < len(simpleTimeFormat)+1 || s[len(simpleTimeFormat)] != ' ' {
+		return nil, errors.New("invalid simple line, time not found")
+	}
+	entry := NewLogEntry()
+	t, err := time.ParseInLocation(simpleTimeFormat, s[:len(simpleTimeFormat)], time.Local)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse time error")
+	}
+	entry.Time = t
+
+	s = s[len(simpleTimeFormat)+1:]
+	end := strings.Index(s, "]\t")
+	if !strings.HasPrefix(s, "[") || end < 0 {
+		return nil, errors.New("invalid simple line, level not found")
+	}
+	if entry.Level, err = ParseLevel(s[1:end]); err != nil {
+		return nil, err
+	}
+	s = s[end+2:]
+
+	// the tabs of msg and error are escaped, so the separators are unique
+	if i := strings.Index(s, simpleFieldsSep); i >= 0 {
+		if entry.Fields, err = parseFields(s[i+len(simpleFieldsSep):]); err != nil {
+			return nil, err
+		}
+		s = s[:i]
+	}
+	if i := strings.Index(s, simpleErrSep); i >= 0 {
+		entry.Err = errors.New(unescape(s[i+len(simpleErrSep):]))
+		s = s[:i]
+	}
+	entry.Msg = unescape(s)
+	return entry, nil
+}
+
+type FullParser struct {
+}
+
+func NewFullParser() *FullParser {
+	// format: "time|level|src:line|func|message|error|fields"
+	return &FullParser{}
+}
+
+func (fp *FullParser) Parse(line []byte) (*LogEntry, error) {
+	parts, err := splitFull(string(line))
+	if err != nil {
+		return nil, err
+	}
+
+	entry := NewLogEntry()
+	if entry.Time, err = time.ParseInLocation(defaultTimeFormat, parts[0], time.Local); err != nil {
+		return nil, errors.Wrap(err, "parse time error")
+	}
+	if entry.Level, err = ParseLevel(parts[1]); err != nil {
+		return nil, err
+	}
+
+	// location
+	if parts[2] != fullNone || parts[3] != fullNone {
+		i := strings.LastIndexByte(parts[2], ':')
+		if i < 0 {
+			return nil, errors.Errorf("invalid location: %s", parts[2])
+		}
+		if entry.Line, err = strconv.Atoi(parts[2][i+1:]); err != nil {
+			return nil, errors.Wrapf(err, "invalid line of location: %s", parts[2])
+		}
+		entry.SrcFile = unescape(parts[2][:i])
+		entry.FuncName = unescape(parts[3])
+		entry.UseLoc = true
+	}
+
+	entry.Msg = unescape(parts[4])
+	if parts[5] != fullNone {
+		entry.Err = errors.New(unescape(parts[5]))
+	}
+	if entry.Fields, err = parseFields(parts[6]); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// splitFull splits the line by the unescaped "|", the fields are the rest
+// after the last separator, which is json and not escaped.
+func splitFull(s string) ([]string, error) {
+	parts := make([]string, 0, fullSeps+1)
+	start := 0
+	for i := 0; i < len(s) && len(parts) < fullSeps; i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '|':
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	if len(parts) < fullSeps {
+		return nil, errors.Errorf("invalid full line, %d separators expected, got %d", fullSeps, len(parts))
+	}
+	return append(parts, s[start:]), nil
+}
+
+type JSONParser struct {
+}
+
+func NewJSONParser() *JSONParser {
+	return &JSONParser{}
+}
+
+func (jp *JSONParser) Parse(line []byte) (*LogEntry, error) {
+	entry := NewLogEntry()
+	if err := json.Unmarshal(line, entry); err != nil {
+		return nil, errors.Wrap(err, "unmarshal entry error")
+	}
+	entry.UseLoc = entry.SrcFile != "" || entry.FuncName != ""
+	return entry, nil
+}
+
+func parseFields(s string) (Fields, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var fields Fields
+	decoder := json.NewDecoder(strings.NewReader(s))
+	decoder.UseNumber()
+	if err := decoder.Decode(&fields); err != nil {
+		return nil, errors.Wrap(err, "parse fields error")
+	}
+	return fields, nil
+}
+
+// unescape reverts the escaping of simpleEscaper, fullEscaper and escapeFull.
+func unescape(s string) string {
+	if strings.IndexByte(s, '\\') < 0 {
+		return s
+	}
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '\\' || i == len(s)-1 {
+			b.WriteByte(c)
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// Scanner reads the entries line by line, the line length is unlimited.
+// The scanning stops at the first invalid line, which is reported by Err
+// with its line number.
+type Scanner struct {
+	reader *bufio.Reader
+	parser Parser
+	entry  *LogEntry
+	lineNo int
+	err    error
+}
+
+func NewScanner(r io.Reader, parser Parser) *Scanner {
+	return &Scanner{
+		reader: bufio.NewReader(r),
+		parser: parser,
+	}
+}
+
+// Scan advances to the next entry, the blank lines are skipped. It returns
+// false at the end of input or an error.
+func (s *Scanner) Scan() bool {
+	if s.err != nil {
+		return false
+	}
+	for {
+		line, err := s.reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			s.err = errors.Wrap(err, "read line error")
+			return false
+		}
+		if len(line) > 0 {
+			s.lineNo++
+		}
+		line = bytes.TrimRight(line, "\r\n")
+		if len(line) > 0 {
+			entry, parseErr := s.parser.Parse(line)
+			if parseErr != nil {
+				s.err = errors.Wrapf(parseErr, "line %d", s.lineNo)
+				return false
+			}
+			s.entry = entry
+			return true
+		}
+		if err == io.EOF {
+			return false
+		}
+	}
+}
+
+// Entry returns the entry of the last Scan.
+func (s *Scanner) Entry() *LogEntry {
+	return s.entry
+}
+
+// Line returns the line number of the last Scan, starts from 1.
+func (s *Scanner) Line() int {
+	return s.lineNo
+}
+
+// Err returns the first error except io.EOF.
+func (s *Scanner) Err() error {
+	return s.err
+}
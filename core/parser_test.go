@@ -0,0 +1,154 @@
+package core
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func roundTripEntries() []*LogEntry {
+	ts := time.Date(2021, 6, 15, 12, 20, 45, 152*1e6, time.Local)
+	return []*LogEntry{
+		{Time: ts, Level: INFO, Msg: "hello world"},
+		{Time: ts, Level: DEBUG, Msg: ""},
+		{Time: ts, Level: WARN, Msg: "a|b\\c\nnext\r\tline", Err: errors.New("bad|err\t\n")},
+		{Time: ts, Level: ERROR, Msg: "-", Err: errors.New("-")},
+		{Time: ts, Level: DATA, Msg: "\t|err:=fake\t|fields:=fake", Fields: Fields{"k": "v|\n", "n": json.Number("123")}},
+		{Time: ts, Level: FATAL, Msg: `trailing\`, UseLoc: true, SrcFile: "a|b:c.go", Line: 12, FuncName: "-"},
+		{Time: ts, Level: INFO, Msg: "loc", UseLoc: true, SrcFile: "hello.go", Line: 123, FuncName: "main.func1",
+			Err: errors.New("oops"), Fields: Fields{"abc": json.Number("1.5"), "nested": map[string]interface{}{"x": "y"}}},
+	}
+}
+
+func TestParser_RoundTrip(t *testing.T) {
+	for _, format := range []Format{SIMPLE, FULL, JSON} {
+		formatter := FormatterFactory(format)
+		parser := NewParser(format)
+		for i, entry := range roundTripEntries() {
+			buf := formatter.Format(entry)
+			line := buf.String()
+			buf.Free()
+
+			got, err := parser.Parse([]byte(strings.TrimSuffix(line, "\n")))
+			if err != nil {
+				t.Errorf("%v #%d Parse(%q) error: %v", format, i, line, err)
+				continue
+			}
+			buf = formatter.Format(got)
+			if buf.String() != line {
+				t.Errorf("%v #%d round trip = %q, want %q", format, i, buf.String(), line)
+			}
+			buf.Free()
+
+			if got.Msg != entry.Msg || got.Level != entry.Level {
+				t.Errorf("%v #%d msg, level = %q, %v, want %q, %v", format, i, got.Msg, got.Level, entry.Msg, entry.Level)
+			}
+			if (got.Err == nil) != (entry.Err == nil) || (got.Err != nil && got.Err.Error() != entry.Err.Error()) {
+				t.Errorf("%v #%d err = %v, want %v", format, i, got.Err, entry.Err)
+			}
+			if !reflect.DeepEqual(got.Fields, entry.Fields) {
+				t.Errorf("%v #%d fields = %v, want %v", format, i, got.Fields, entry.Fields)
+			}
+		}
+	}
+}
+
+func TestFullParser_Parse(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    *LogEntry
+		wantErr bool
+	}{
+		{
+			name: "when no location then not use loc",
+			line: "2021-06-15 12:20:45.152000|INFO|-|-|hello world|-|",
+			want: &LogEntry{
+				Time:  time.Date(2021, 6, 15, 12, 20, 45, 152*1e6, time.Local),
+				Level: INFO,
+				Msg:   "hello world",
+			},
+		},
+		{
+			name: "when location then use loc",
+			line: `2021-06-15 12:20:45.152000|WARN|src/a.go:12|\-|x\|y|-|`,
+			want: &LogEntry{
+				Time:     time.Date(2021, 6, 15, 12, 20, 45, 152*1e6, time.Local),
+				Level:    WARN,
+				SrcFile:  "src/a.go",
+				Line:     12,
+				FuncName: "-",
+				Msg:      "x|y",
+				UseLoc:   true,
+			},
+		},
+		{
+			name:    "when unknown level then error",
+			line:    "2021-06-15 12:20:45.152000|TRACE|-|-|hello|-|",
+			wantErr: true,
+		},
+		{
+			name:    "when separators missing then error",
+			line:    `2021-06-15 12:20:45.152000|INFO|-|-|hello\|-`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewFullParser().Parse([]byte(tt.line))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJSONParser_Parse(t *testing.T) {
+	t.Run("when level name then parse level", func(t *testing.T) {
+		got, err := NewJSONParser().Parse([]byte(`{"time":"2021-06-15T12:20:45.152+08:00","level":"warn","msg":"hi"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Level != WARN || got.Msg != "hi" || got.UseLoc {
+			t.Errorf("Parse() = %+v", got)
+		}
+	})
+}
+
+func TestScanner(t *testing.T) {
+	t.Run("when lines then scan entries", func(t *testing.T) {
+		long := strings.Repeat("x", 128*1024)
+		input := "2021-06-15 12:20:45 [INFO]\tfirst\r\n\n" +
+			"2021-06-15 12:20:46 [WARN]\t" + long + "\n" +
+			"2021-06-15 12:20:47 [ERROR]\tlast"
+		scanner := NewScanner(strings.NewReader(input), NewSimpleParser())
+		msgs := make([]string, 0)
+		for scanner.Scan() {
+			msgs = append(msgs, scanner.Entry().Msg)
+		}
+		if err := scanner.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"first", long, "last"}; !reflect.DeepEqual(msgs, want) {
+			t.Errorf("got %d entries, want %d", len(msgs), len(want))
+		}
+	})
+
+	t.Run("when invalid line then error with line number", func(t *testing.T) {
+		input := "2021-06-15 12:20:45 [INFO]\tfirst\nbad line\n2021-06-15 12:20:47 [INFO]\tlast\n"
+		scanner := NewScanner(strings.NewReader(input), NewSimpleParser())
+		count := 0
+		for scanner.Scan() {
+			count++
+		}
+		if count != 1 || scanner.Err() == nil || !strings.Contains(scanner.Err().Error(), "line 2") {
+			t.Errorf("count = %d, err = %v", count, scanner.Err())
+		}
+	})
+}
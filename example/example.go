@@ -26,6 +26,7 @@ func main() {
 	RunAll()
 	RunRotate()
 	time.Sleep(10 * time.Second)
+	logger.Shutdown()
 	log.Println("done")
 
 }
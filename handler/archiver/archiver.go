@@ -1,12 +1,18 @@
 package archiver
 
 import (
+	"fmt"
 	"github.com/edditen/etlog/common/queue"
 	"github.com/edditen/etlog/common/runnable"
-	"github.com/edditen/etlog/common/utils"
+	"github.com/edditen/etlog/handler/naming"
+	"github.com/edditen/etlog/opt"
 	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
 	"os"
 	"path"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -15,11 +21,36 @@ const (
 	defaultBackupDelay    = 5 * time.Second
 	defaultDelayQueueSize = 1000
 	defaultTimeout        = 5 * time.Second
+	defaultRetryBackoff   = time.Second
+	defaultMaxBackoff     = time.Minute
+	defaultMaxRetries     = 5
 )
 
 type Archiver interface {
 	runnable.Runnable
 	Archive(sourceFile string) error
+	Stats() Stats
+}
+
+// Stats the counters of archiver, BytesSaved is the size of the sources
+// minus the size of the archives.
+type Stats struct {
+	Archived   uint64
+	Failed     uint64
+	Retries    uint64
+	Pending    int64
+	BytesSaved int64
+	// Uploaded and UploadFailed count the archives, not with manifests
+	Uploaded     uint64
+	UploadFailed uint64
+}
+
+// task the backup file to archive, with the attempts failed.
+type task struct {
+	sourceFile string
+	// archiveFile the archive to upload, set once archived
+	archiveFile string
+	attempts    int
 }
 
 type ArchiverOpt func(*LogArchiver) error
@@ -27,18 +58,42 @@ type ArchiverOpt func(*LogArchiver) error
 type LogArchiver struct {
 	backupDir   string
 	backupExt   string
+	format      Format
+	level       int
 	backupDelay time.Duration
 	delayQueue  *queue.DelayQueue
-	exitC       chan interface{}
+	// sourceDir and matcher find the backups not archived before restart
+	sourceDir    string
+	matcher      *naming.Matcher
+	manifest     bool
+	sidecarExts  []string
+	encryptKeyID string
+	encryptKey   []byte
+	retryBackoff time.Duration
+	maxBackoff   time.Duration
+	maxRetries   int
+	archived     uint64
+	failed       uint64
+	retries      uint64
+	pending      int64
+	bytesSaved   int64
+	uploader     Uploader
+	uploaded     uint64
+	uploadFailed uint64
+	exitC        chan interface{}
 }
 
 func NewLogArchiver(backupDir string, options ...ArchiverOpt) (*LogArchiver, error) {
 	a := &LogArchiver{
-		backupDir:   backupDir,
-		backupExt:   defaultBackupExt,
-		backupDelay: defaultBackupDelay,
-		delayQueue:  queue.NewDelayQueue(defaultDelayQueueSize),
-		exitC:       make(chan interface{}),
+		backupDir:    backupDir,
+		backupExt:    defaultBackupExt,
+		format:       FormatZip,
+		backupDelay:  defaultBackupDelay,
+		delayQueue:   queue.NewDelayQueue(defaultDelayQueueSize),
+		retryBackoff: defaultRetryBackoff,
+		maxBackoff:   defaultMaxBackoff,
+		maxRetries:   defaultMaxRetries,
+		exitC:        make(chan interface{}),
 	}
 
 	for _, opt := range options {
@@ -54,14 +109,77 @@ func (la *LogArchiver) Init() error {
 	if err := os.MkdirAll(la.backupDir, os.ModePerm); err != nil {
 		return errors.Wrap(err, "create archive dir error")
 	}
+	if err := la.resume(); err != nil {
+		opt.GetErrLog().Printf("resume pending archives err: %+v\n", err)
+	}
 	go la.Run()
 	return nil
 }
 
+// resume queues the backups left in the source dir, which were rotated
+// but not archived before the last exit.
+func (la *LogArchiver) resume() error {
+	if la.matcher == nil {
+		return nil
+	}
+	if err := la.resumeUploads(); err != nil {
+		return err
+	}
+	if la.format == FormatNone && la.encryptKey == nil && la.sourceDir == la.backupDir {
+		return nil
+	}
+
+	files, err := ioutil.ReadDir(la.sourceDir)
+	if err != nil {
+		return errors.Wrap(err, "read source dir error")
+	}
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		if _, _, ok := la.matcher.Match(f.Name()); !ok {
+			continue
+		}
+		if err := la.Archive(path.Join(la.sourceDir, f.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resumeUploads queues the archives not uploaded before the last exit.
+func (la *LogArchiver) resumeUploads() error {
+	if la.uploader == nil {
+		return nil
+	}
+
+	files, err := ioutil.ReadDir(la.backupDir)
+	if err != nil {
+		return errors.Wrap(err, "read backup dir error")
+	}
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || !strings.HasSuffix(name, la.backupExt) {
+			continue
+		}
+		if _, _, ok := la.matcher.Match(strings.TrimSuffix(name, la.backupExt)); !ok {
+			continue
+		}
+		archiveFile := path.Join(la.backupDir, name)
+		if fileExists(archiveFile + UploadedExt) {
+			continue
+		}
+		if err := la.offer(&task{archiveFile: archiveFile}, time.Now()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (la *LogArchiver) Run() error {
 	for {
-		if val, ok := la.delayQueue.Take(defaultTimeout).(string); ok {
-			_ = la.archive(val)
+		if t, ok := la.delayQueue.Take(defaultTimeout).(*task); ok {
+			la.handle(t)
 		}
 		if la.isDown() && la.delayQueue.Len() == 0 {
 			// exit util all data handled
@@ -90,20 +208,229 @@ func (la *LogArchiver) Archive(sourceFile string) error {
 		return errors.New("log cleaner already shutdown")
 	}
 
-	expiredAt := time.Now().Add(la.backupDelay)
-	if err := la.delayQueue.Offer(sourceFile, expiredAt); err != nil {
+	return la.offer(&task{sourceFile: sourceFile}, time.Now().Add(la.backupDelay))
+}
+
+func (la *LogArchiver) offer(t *task, expiredAt time.Time) error {
+	if err := la.delayQueue.Offer(t, expiredAt); err != nil {
 		return err
 	}
+	atomic.AddInt64(&la.pending, 1)
 	return nil
 }
 
-func (la *LogArchiver) archive(sourceFile string) error {
+// Stats returns the counters of archiver.
+func (la *LogArchiver) Stats() Stats {
+	return Stats{
+		Archived:     atomic.LoadUint64(&la.archived),
+		Failed:       atomic.LoadUint64(&la.failed),
+		Retries:      atomic.LoadUint64(&la.retries),
+		Pending:      atomic.LoadInt64(&la.pending),
+		BytesSaved:   atomic.LoadInt64(&la.bytesSaved),
+		Uploaded:     atomic.LoadUint64(&la.uploaded),
+		UploadFailed: atomic.LoadUint64(&la.uploadFailed),
+	}
+}
+
+// handle archives then uploads the file, and retries with exponential
+// backoff if failed, the file given up is left for the next resume.
+func (la *LogArchiver) handle(t *task) {
+	if t.archiveFile == "" {
+		srcInfo, err := os.Stat(t.sourceFile)
+		if os.IsNotExist(err) {
+			// archived by another process, or removed by cleaner
+			atomic.AddInt64(&la.pending, -1)
+			return
+		}
+
+		archiveFile, err := la.archive(t.sourceFile)
+		if err != nil {
+			la.retry(t, err)
+			return
+		}
+		atomic.AddUint64(&la.archived, 1)
+		if archiveInfo, err := os.Stat(archiveFile); err == nil && srcInfo != nil {
+			atomic.AddInt64(&la.bytesSaved, srcInfo.Size()-archiveInfo.Size())
+		}
+		t.archiveFile = archiveFile
+		t.attempts = 0
+	}
+
+	if la.uploader != nil {
+		if err := la.upload(t.archiveFile); err != nil {
+			la.retry(t, err)
+			return
+		}
+	}
+	atomic.AddInt64(&la.pending, -1)
+}
+
+func (la *LogArchiver) retry(t *task, err error) {
+	stage, file, failed := "archive", t.sourceFile, &la.failed
+	if t.archiveFile != "" {
+		stage, file, failed = "upload", t.archiveFile, &la.uploadFailed
+	}
+
+	t.attempts++
+	if t.attempts > la.maxRetries || la.isDown() {
+		atomic.AddInt64(&la.pending, -1)
+		atomic.AddUint64(failed, 1)
+		opt.GetErrLog().Printf("%s %s failed after %d attempts, err: %+v\n", stage, file, t.attempts, err)
+		return
+	}
+
+	backoff := la.backoff(t.attempts)
+	opt.GetErrLog().Printf("%s %s err, retry in %v: %+v\n", stage, file, backoff, err)
+	atomic.AddUint64(&la.retries, 1)
+	if err := la.delayQueue.Offer(t, time.Now().Add(backoff)); err != nil {
+		atomic.AddInt64(&la.pending, -1)
+		atomic.AddUint64(failed, 1)
+		opt.GetErrLog().Printf("requeue %s %s err: %+v\n", stage, file, err)
+	}
+}
+
+// upload uploads the archive with its manifest and sidecars, then marks
+// it uploaded.
+func (la *LogArchiver) upload(archiveFile string) error {
+	if _, err := os.Stat(archiveFile); os.IsNotExist(err) {
+		// removed by cleaner
+		return nil
+	}
+	if err := la.uploader.Upload(archiveFile); err != nil {
+		return err
+	}
+	for _, ext := range append([]string{ManifestExt}, la.sidecarExts...) {
+		if sidecar := archiveFile + ext; fileExists(sidecar) {
+			if err := la.uploader.Upload(sidecar); err != nil {
+				return err
+			}
+		}
+	}
+
+	marker := []byte(time.Now().Format(time.RFC3339) + "\n")
+	if err := ioutil.WriteFile(archiveFile+UploadedExt, marker, 0644); err != nil {
+		return errors.Wrap(err, "write uploaded marker error")
+	}
+	atomic.AddUint64(&la.uploaded, 1)
+	return nil
+}
+
+func fileExists(filename string) bool {
+	_, err := os.Stat(filename)
+	return err == nil
+}
+
+func (la *LogArchiver) backoff(attempts int) time.Duration {
+	backoff := la.retryBackoff
+	for i := 1; i < attempts && backoff < la.maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > la.maxBackoff {
+		backoff = la.maxBackoff
+	}
+	return backoff
+}
+
+// archive returns the archive file of the source file.
+func (la *LogArchiver) archive(sourceFile string) (string, error) {
 	archiveName := path.Base(sourceFile) + la.backupExt
 	archiveFile := path.Join(la.backupDir, archiveName)
-	if err := utils.ZipCompress(sourceFile, archiveFile); err != nil {
-		return errors.Wrap(err, "archive error")
+
+	if la.format == FormatNone && la.encryptKey == nil {
+		if err := la.writeManifest(sourceFile, sourceFile, archiveFile); err != nil {
+			return "", err
+		}
+		if archiveFile == sourceFile {
+			return archiveFile, nil
+		}
+		if err := os.Rename(sourceFile, archiveFile); err != nil {
+			return "", errors.Wrap(err, "move backup error")
+		}
+		la.moveSidecars(sourceFile, archiveFile)
+		return archiveFile, nil
+	}
+
+	// compress into the temp file, the partial archive is never seen as a backup,
+	// the pid avoids the conflict with other processes resuming the same file
+	tmpFile := fmt.Sprintf("%s.%d.tmp", archiveFile, os.Getpid())
+	if err := la.compress(sourceFile, tmpFile); err != nil {
+		return "", err
+	}
+	if err := la.writeManifest(sourceFile, tmpFile, archiveFile); err != nil {
+		_ = os.Remove(tmpFile)
+		return "", err
+	}
+	if err := os.Rename(tmpFile, archiveFile); err != nil {
+		_ = os.Remove(tmpFile)
+		return "", errors.Wrap(err, "rename archive error")
+	}
+	la.moveSidecars(sourceFile, archiveFile)
+	return archiveFile, la.removeSource(sourceFile)
+}
+
+// moveSidecars moves the sidecar files of the source next to the archive,
+// the sidecar is kept with the source if failed.
+func (la *LogArchiver) moveSidecars(sourceFile, archiveFile string) {
+	for _, ext := range la.sidecarExts {
+		if !fileExists(sourceFile + ext) {
+			continue
+		}
+		if err := moveFile(sourceFile+ext, archiveFile+ext); err != nil {
+			opt.GetErrLog().Printf("move sidecar %s err: %+v\n", sourceFile+ext, err)
+		}
 	}
-	return la.removeSource(sourceFile)
+}
+
+// moveFile renames the file, or copies then removes it across file systems.
+func moveFile(from, to string) error {
+	if err := os.Rename(from, to); err == nil {
+		return nil
+	}
+	err := transformFile(from, to, func(dst io.Writer, src io.Reader) error {
+		_, err := io.Copy(dst, src)
+		return errors.Wrap(err, "copy file error")
+	})
+	if err != nil {
+		return err
+	}
+	return os.Remove(from)
+}
+
+// compress compresses then encrypts the source file into the temp file.
+func (la *LogArchiver) compress(sourceFile, tmpFile string) error {
+	if la.encryptKey == nil {
+		if err := la.format.Compress(sourceFile, tmpFile, la.level); err != nil {
+			_ = os.Remove(tmpFile)
+			return errors.Wrap(err, "archive error")
+		}
+		return nil
+	}
+
+	plainFile := sourceFile
+	if la.format != FormatNone {
+		plainFile = tmpFile + ".plain"
+		defer os.Remove(plainFile)
+		if err := la.format.Compress(sourceFile, plainFile, la.level); err != nil {
+			return errors.Wrap(err, "archive error")
+		}
+	}
+	if err := EncryptFile(plainFile, tmpFile, la.encryptKeyID, la.encryptKey); err != nil {
+		return errors.Wrap(err, "encrypt archive error")
+	}
+	return nil
+}
+
+// writeManifest writes the manifest before the archive is renamed,
+// so an archive without manifest was never completed.
+func (la *LogArchiver) writeManifest(sourceFile, compressedFile, archiveFile string) error {
+	if !la.manifest {
+		return nil
+	}
+	m, err := NewManifest(sourceFile, compressedFile, path.Base(archiveFile))
+	if err != nil {
+		return errors.Wrap(err, "create manifest error")
+	}
+	return m.WriteFile(archiveFile + ManifestExt)
 }
 
 func (la *LogArchiver) removeSource(sourceFile string) error {
@@ -120,9 +447,97 @@ func SetBackupExt(ext string) ArchiverOpt {
 	}
 }
 
+// SetFormat sets the format, and the extension of the format.
+func SetFormat(format Format) ArchiverOpt {
+	return func(archiver *LogArchiver) error {
+		archiver.format = format
+		archiver.backupExt = format.Ext()
+		return nil
+	}
+}
+
+// SetLevel sets the compression level, from 1 to 9, 0 means the default.
+func SetLevel(level int) ArchiverOpt {
+	return func(archiver *LogArchiver) error {
+		if level < 0 || level > 9 {
+			return errors.Errorf("invalid compression level: %d", level)
+		}
+		archiver.level = level
+		return nil
+	}
+}
+
 func SetBackupDelay(delay time.Duration) ArchiverOpt {
 	return func(archiver *LogArchiver) error {
 		archiver.backupDelay = delay
 		return nil
 	}
 }
+
+// SetEncryption encrypts the archives by AES-256-GCM with the key,
+// the key ID is written in the header to select the key when decrypting.
+// It should be set after SetFormat, since it appends to the extension.
+func SetEncryption(keyID string, key []byte) ArchiverOpt {
+	return func(archiver *LogArchiver) error {
+		if len(key) != encryptKeySize {
+			return errors.Errorf("encryption key should be %d bytes", encryptKeySize)
+		}
+		if len(keyID) > maxKeyIDSize {
+			return errors.Errorf("key id too long: %d", len(keyID))
+		}
+		archiver.encryptKeyID = keyID
+		archiver.encryptKey = key
+		archiver.backupExt += EncryptExt
+		return nil
+	}
+}
+
+// SetUploader uploads the archives after archived, the uploaded archive
+// is marked by a file with UploadedExt.
+func SetUploader(uploader Uploader) ArchiverOpt {
+	return func(archiver *LogArchiver) error {
+		archiver.uploader = uploader
+		return nil
+	}
+}
+
+// SetManifest writes the manifest with checksum next to each archive.
+func SetManifest(manifest bool) ArchiverOpt {
+	return func(archiver *LogArchiver) error {
+		archiver.manifest = manifest
+		return nil
+	}
+}
+
+// SetSidecarExts moves the sidecar files of the backup, such as the index,
+// next to the archive, and uploads them with the archive.
+func SetSidecarExts(exts ...string) ArchiverOpt {
+	return func(archiver *LogArchiver) error {
+		archiver.sidecarExts = exts
+		return nil
+	}
+}
+
+// SetResume finds the backups in sourceDir by matcher on Init,
+// which were rotated but not archived before the last exit.
+func SetResume(sourceDir string, matcher *naming.Matcher) ArchiverOpt {
+	return func(archiver *LogArchiver) error {
+		archiver.sourceDir = sourceDir
+		archiver.matcher = matcher
+		return nil
+	}
+}
+
+// SetRetry retries the failed archiving at most maxRetries times,
+// the backoff doubles from retryBackoff up to maxBackoff.
+func SetRetry(maxRetries int, retryBackoff, maxBackoff time.Duration) ArchiverOpt {
+	return func(archiver *LogArchiver) error {
+		if maxRetries < 0 || retryBackoff <= 0 || maxBackoff < retryBackoff {
+			return errors.Errorf("invalid retry: %d, %v, %v", maxRetries, retryBackoff, maxBackoff)
+		}
+		archiver.maxRetries = maxRetries
+		archiver.retryBackoff = retryBackoff
+		archiver.maxBackoff = maxBackoff
+		return nil
+	}
+}
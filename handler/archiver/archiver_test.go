@@ -0,0 +1,159 @@
+package archiver
+
+import (
+	"github.com/edditen/etlog/handler/naming"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func waitStats(t *testing.T, la *LogArchiver, done func(s Stats) bool) Stats {
+	for i := 0; i < 200; i++ {
+		if s := la.Stats(); done(s) {
+			return s
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("stats not reached: %+v", la.Stats())
+	return Stats{}
+}
+
+func TestLogArchiver_Resume(t *testing.T) {
+	t.Run("when backups left then archive on init", func(t *testing.T) {
+		dir := t.TempDir()
+		content := strings.Repeat("resumed line\n", 100)
+		for _, name := range []string{"app.2021-01-01.000000.log", "app.log", "other.2021-01-01.000000.log"} {
+			if err := ioutil.WriteFile(path.Join(dir, name), []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		tmpl, _ := naming.ParseTemplate(naming.DefaultTemplate)
+		matcher, _ := tmpl.Matcher("app", ".log", "")
+		la, err := NewLogArchiver(dir, SetFormat(FormatGzip), SetBackupDelay(10*time.Millisecond),
+			SetResume(dir, matcher))
+		if err != nil {
+			t.Fatalf("new archiver err: %+v", err)
+		}
+		if err := la.Init(); err != nil {
+			t.Fatalf("init err: %+v", err)
+		}
+		defer la.Shutdown()
+
+		stats := waitStats(t, la, func(s Stats) bool { return s.Archived == 1 })
+		if stats.Pending != 0 || stats.BytesSaved <= 0 {
+			t.Errorf("Stats() = %+v", stats)
+		}
+		if _, err := os.Stat(path.Join(dir, "app.2021-01-01.000000.log.gz")); err != nil {
+			t.Errorf("archive not found: %v", err)
+		}
+		for _, name := range []string{"app.log", "other.2021-01-01.000000.log"} {
+			if _, err := os.Stat(path.Join(dir, name)); err != nil {
+				t.Errorf("%s should be kept: %v", name, err)
+			}
+		}
+	})
+}
+
+func TestLogArchiver_Retry(t *testing.T) {
+	t.Run("when archive failed then retry and give up", func(t *testing.T) {
+		dir := t.TempDir()
+		// a directory can not be compressed
+		source := path.Join(dir, "app.2021-01-01.000000.log")
+		if err := os.Mkdir(source, os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+
+		la, err := NewLogArchiver(dir, SetBackupDelay(time.Millisecond),
+			SetRetry(2, 5*time.Millisecond, 10*time.Millisecond))
+		if err != nil {
+			t.Fatalf("new archiver err: %+v", err)
+		}
+		if err := la.Init(); err != nil {
+			t.Fatalf("init err: %+v", err)
+		}
+		defer la.Shutdown()
+		if err := la.Archive(source); err != nil {
+			t.Fatalf("archive err: %+v", err)
+		}
+
+		stats := waitStats(t, la, func(s Stats) bool { return s.Failed == 1 })
+		if stats.Retries != 2 || stats.Pending != 0 || stats.Archived != 0 {
+			t.Errorf("Stats() = %+v", stats)
+		}
+	})
+
+	t.Run("when missing source then skip", func(t *testing.T) {
+		la, _ := NewLogArchiver(t.TempDir(), SetBackupDelay(time.Millisecond))
+		_ = la.Init()
+		defer la.Shutdown()
+		_ = la.Archive(path.Join(t.TempDir(), "missing.log"))
+
+		stats := waitStats(t, la, func(s Stats) bool { return s.Pending == 0 })
+		if stats.Failed != 0 || stats.Archived != 0 {
+			t.Errorf("Stats() = %+v", stats)
+		}
+	})
+}
+
+func TestLogArchiver_Backoff(t *testing.T) {
+	la, _ := NewLogArchiver("", SetRetry(10, time.Second, 5*time.Second))
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 5 * time.Second},
+		{9, 5 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := la.backoff(tt.attempts); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestLogArchiver_Sidecars(t *testing.T) {
+	tests := []struct {
+		name   string
+		format Format
+	}{
+		{"when compressed then sidecar next to archive", FormatZip},
+		{"when moved then sidecar moved too", FormatNone},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, archiveDir := t.TempDir(), t.TempDir()
+			source := path.Join(dir, "app.2021-01-01.000000.log")
+			_ = ioutil.WriteFile(source, []byte("line\n"), 0644)
+			_ = ioutil.WriteFile(source+".idx", []byte("index\n"), 0644)
+
+			la, err := NewLogArchiver(archiveDir, SetFormat(tt.format), SetBackupDelay(10*time.Millisecond),
+				SetSidecarExts(".idx"))
+			if err != nil {
+				t.Fatalf("new archiver err: %+v", err)
+			}
+			if err := la.Init(); err != nil {
+				t.Fatalf("init err: %+v", err)
+			}
+			defer la.Shutdown()
+			if err := la.Archive(source); err != nil {
+				t.Fatal(err)
+			}
+
+			waitStats(t, la, func(s Stats) bool { return s.Archived == 1 })
+			archiveFile := path.Join(archiveDir, path.Base(source)+tt.format.Ext())
+			if b, err := ioutil.ReadFile(archiveFile + ".idx"); err != nil || string(b) != "index\n" {
+				t.Errorf("sidecar of archive: %q, err: %v", b, err)
+			}
+			if _, err := os.Stat(source + ".idx"); !os.IsNotExist(err) {
+				t.Errorf("sidecar of source should be moved, err: %v", err)
+			}
+		})
+	}
+}
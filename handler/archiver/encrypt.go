@@ -0,0 +1,290 @@
+package archiver
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// EncryptExt the extension appended to the encrypted archive.
+const EncryptExt = ".enc"
+
+const (
+	encryptMagic     = "ETLE"
+	encryptVersion   = 1
+	encryptChunkSize = 64 * 1024
+	encryptKeySize   = 32
+	noncePrefixSize  = 7
+	chunkLast        = 1
+	maxKeyIDSize     = 255
+)
+
+// Keyring the keys by key ID, the key ID in the header of encrypted file
+// selects the key to decrypt, so the keys could be rotated.
+type Keyring map[string][]byte
+
+// LoadKey loads the AES-256 key from the file, or the env var if file is
+// blank, the key is 32 raw bytes, or encoded in hex or base64.
+func LoadKey(file, env string) ([]byte, error) {
+	var raw []byte
+	switch {
+	case file != "":
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, errors.Wrap(err, "read key file error")
+		}
+		raw = b
+	case env != "":
+		val, ok := os.LookupEnv(env)
+		if !ok {
+			return nil, errors.Errorf("key env %s not set", env)
+		}
+		raw = []byte(val)
+	default:
+		return nil, errors.New("key file or env is required")
+	}
+
+	if len(raw) == encryptKeySize {
+		return raw, nil
+	}
+	text := strings.TrimSpace(string(raw))
+	if key, err := hex.DecodeString(text); err == nil && len(key) == encryptKeySize {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil && len(key) == encryptKeySize {
+		return key, nil
+	}
+	return nil, errors.Errorf("key should be %d bytes, in raw, hex or base64", encryptKeySize)
+}
+
+// Encrypt encrypts src by AES-256-GCM in chunks, so the file of any size
+// is streamed. The format is:
+//
+//	header: "ETLE" | version(1) | chunk size(4) | key id size(1) | key id | nonce prefix(7)
+//	chunk:  flag(1) | ciphertext size(4) | ciphertext
+//
+// The nonce of chunk is the prefix, the counter(4) and the flag(1), the
+// flag marks the last chunk, which prevents the truncation. The header is
+// authenticated as the additional data of every chunk.
+func Encrypt(dst io.Writer, src io.Reader, keyID string, key []byte) error {
+	if len(keyID) > maxKeyIDSize {
+		return errors.Errorf("key id too long: %d", len(keyID))
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+
+	var header bytes.Buffer
+	header.WriteString(encryptMagic)
+	header.WriteByte(encryptVersion)
+	_ = binary.Write(&header, binary.BigEndian, uint32(encryptChunkSize))
+	header.WriteByte(byte(len(keyID)))
+	header.WriteString(keyID)
+	prefix := make([]byte, noncePrefixSize)
+	if _, err := io.ReadFull(rand.Reader, prefix); err != nil {
+		return errors.Wrap(err, "generate nonce error")
+	}
+	header.Write(prefix)
+	ad := header.Bytes()
+	if _, err := dst.Write(ad); err != nil {
+		return errors.Wrap(err, "write header error")
+	}
+
+	// read ahead one chunk to know whether the current one is the last
+	cur := make([]byte, encryptChunkSize)
+	next := make([]byte, encryptChunkSize)
+	n, err := readChunk(src, cur)
+	if err != nil {
+		return err
+	}
+	sealed := make([]byte, 0, encryptChunkSize+aead.Overhead())
+	for counter := uint32(0); ; counter++ {
+		var m int
+		if n == encryptChunkSize {
+			if m, err = readChunk(src, next); err != nil {
+				return err
+			}
+		}
+		var flag byte
+		if m == 0 {
+			flag = chunkLast
+		}
+
+		sealed = aead.Seal(sealed[:0], chunkNonce(prefix, counter, flag), cur[:n], ad)
+		chunkHeader := make([]byte, 5)
+		chunkHeader[0] = flag
+		binary.BigEndian.PutUint32(chunkHeader[1:], uint32(len(sealed)))
+		if _, err := dst.Write(chunkHeader); err != nil {
+			return errors.Wrap(err, "write chunk error")
+		}
+		if _, err := dst.Write(sealed); err != nil {
+			return errors.Wrap(err, "write chunk error")
+		}
+
+		if flag == chunkLast {
+			return nil
+		}
+		if counter == ^uint32(0) {
+			return errors.New("file too large to encrypt")
+		}
+		cur, next, n = next, cur, m
+	}
+}
+
+// readChunk reads until the buffer full or EOF.
+func readChunk(r io.Reader, buf []byte) (int, error) {
+	n, err := io.ReadFull(r, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return n, nil
+	}
+	if err != nil {
+		return n, errors.Wrap(err, "read source error")
+	}
+	return n, nil
+}
+
+// Decrypt decrypts src encrypted by Encrypt, the key is selected from keys
+// by the key ID in the header.
+func Decrypt(dst io.Writer, src io.Reader, keys Keyring) error {
+	r := bufio.NewReader(src)
+
+	fixed := make([]byte, len(encryptMagic)+1+4+1)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return errors.Wrap(err, "read header error")
+	}
+	if string(fixed[:len(encryptMagic)]) != encryptMagic {
+		return errors.New("not an encrypted file")
+	}
+	if version := fixed[len(encryptMagic)]; version != encryptVersion {
+		return errors.Errorf("unsupported version: %d", version)
+	}
+	chunkSize := binary.BigEndian.Uint32(fixed[len(encryptMagic)+1:])
+	idAndPrefix := make([]byte, int(fixed[len(fixed)-1])+noncePrefixSize)
+	if _, err := io.ReadFull(r, idAndPrefix); err != nil {
+		return errors.Wrap(err, "read header error")
+	}
+	keyID := string(idAndPrefix[:len(idAndPrefix)-noncePrefixSize])
+	prefix := idAndPrefix[len(idAndPrefix)-noncePrefixSize:]
+	ad := append(fixed, idAndPrefix...)
+
+	key, ok := keys[keyID]
+	if !ok {
+		return errors.Errorf("key not found: %q", keyID)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+
+	maxSealed := chunkSize + uint32(aead.Overhead())
+	chunkHeader := make([]byte, 5)
+	var sealed, plain []byte
+	for counter := uint32(0); ; counter++ {
+		if _, err := io.ReadFull(r, chunkHeader); err != nil {
+			if err == io.EOF {
+				return errors.New("file truncated")
+			}
+			return errors.Wrap(err, "read chunk error")
+		}
+		flag := chunkHeader[0]
+		size := binary.BigEndian.Uint32(chunkHeader[1:])
+		if size > maxSealed || flag > chunkLast {
+			return errors.New("invalid chunk")
+		}
+
+		if cap(sealed) < int(size) {
+			sealed = make([]byte, size)
+		}
+		sealed = sealed[:size]
+		if _, err := io.ReadFull(r, sealed); err != nil {
+			return errors.Wrap(err, "read chunk error")
+		}
+		if plain, err = aead.Open(plain[:0], chunkNonce(prefix, counter, flag), sealed, ad); err != nil {
+			return errors.Wrapf(err, "decrypt chunk %d error", counter)
+		}
+		if _, err := dst.Write(plain); err != nil {
+			return errors.Wrap(err, "write plain error")
+		}
+
+		if flag == chunkLast {
+			if _, err := r.ReadByte(); err != io.EOF {
+				return errors.New("trailing data after the last chunk")
+			}
+			return nil
+		}
+	}
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != encryptKeySize {
+		return nil, errors.Errorf("key should be %d bytes", encryptKeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "create cipher error")
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "create gcm error")
+	}
+	return aead, nil
+}
+
+func chunkNonce(prefix []byte, counter uint32, flag byte) []byte {
+	nonce := make([]byte, noncePrefixSize+5)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[noncePrefixSize:], counter)
+	nonce[len(nonce)-1] = flag
+	return nonce
+}
+
+// EncryptFile encrypts the source file into the destination file.
+func EncryptFile(sourceFile, destFile, keyID string, key []byte) error {
+	return transformFile(sourceFile, destFile, func(dst io.Writer, src io.Reader) error {
+		return Encrypt(dst, src, keyID, key)
+	})
+}
+
+// DecryptFile decrypts the source file into the destination file,
+// the destination file is removed if failed.
+func DecryptFile(sourceFile, destFile string, keys Keyring) error {
+	return transformFile(sourceFile, destFile, func(dst io.Writer, src io.Reader) error {
+		return Decrypt(dst, src, keys)
+	})
+}
+
+func transformFile(sourceFile, destFile string, transform func(dst io.Writer, src io.Reader) error) error {
+	src, err := os.Open(sourceFile)
+	if err != nil {
+		return errors.Wrap(err, "open source file error")
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(destFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return errors.Wrap(err, "create file error")
+	}
+	w := bufio.NewWriter(dst)
+	err = transform(w, src)
+	if err == nil {
+		err = errors.Wrap(w.Flush(), "flush file error")
+	}
+	if closeErr := dst.Close(); err == nil && closeErr != nil {
+		err = errors.Wrap(closeErr, "close file error")
+	}
+	if err != nil {
+		_ = os.Remove(destFile)
+	}
+	return err
+}
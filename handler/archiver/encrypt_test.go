@@ -0,0 +1,181 @@
+package archiver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func testKey(t *testing.T) []byte {
+	key := make([]byte, encryptKeySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestEncrypt(t *testing.T) {
+	key := testKey(t)
+	sizes := []struct {
+		name string
+		size int
+	}{
+		{"when empty then round trip", 0},
+		{"when smaller than chunk then round trip", 100},
+		{"when exactly one chunk then round trip", encryptChunkSize},
+		{"when one chunk more then round trip", encryptChunkSize + 1},
+		{"when chunks then round trip", 3*encryptChunkSize + 17},
+	}
+	for _, tt := range sizes {
+		t.Run(tt.name, func(t *testing.T) {
+			plain := make([]byte, tt.size)
+			_, _ = rand.Read(plain)
+			var sealed, opened bytes.Buffer
+			if err := Encrypt(&sealed, bytes.NewReader(plain), "k1", key); err != nil {
+				t.Fatalf("encrypt err: %+v", err)
+			}
+			if err := Decrypt(&opened, &sealed, Keyring{"k1": key}); err != nil {
+				t.Fatalf("decrypt err: %+v", err)
+			}
+			if !bytes.Equal(plain, opened.Bytes()) {
+				t.Errorf("decrypted %d bytes, want %d", opened.Len(), len(plain))
+			}
+		})
+	}
+
+	sealed := func(t *testing.T) []byte {
+		var buf bytes.Buffer
+		if err := Encrypt(&buf, bytes.NewReader(make([]byte, 2*encryptChunkSize+10)), "k1", key); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	failures := []struct {
+		name   string
+		keys   Keyring
+		mutate func(b []byte) []byte
+	}{
+		{"when key id unknown then error", Keyring{"k2": key}, func(b []byte) []byte { return b }},
+		{"when wrong key then error", Keyring{"k1": testKey(t)}, func(b []byte) []byte { return b }},
+		{"when truncated at chunk then error", Keyring{"k1": key}, func(b []byte) []byte {
+			return b[:len(b)-(10+5+16)]
+		}},
+		{"when tampered then error", Keyring{"k1": key}, func(b []byte) []byte { b[len(b)/2] ^= 1; return b }},
+		{"when header tampered then error", Keyring{"k1": key}, func(b []byte) []byte { b[8] ^= 1; return b }},
+		{"when trailing data then error", Keyring{"k1": key}, func(b []byte) []byte { return append(b, 0) }},
+		{"when not encrypted then error", Keyring{"k1": key}, func(b []byte) []byte { return []byte("plain text") }},
+	}
+	for _, tt := range failures {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Decrypt(ioutil.Discard, bytes.NewReader(tt.mutate(sealed(t))), tt.keys)
+			if err == nil {
+				t.Errorf("want decrypt error")
+			}
+		})
+	}
+
+	t.Run("when keys rotated then select by key id", func(t *testing.T) {
+		oldKey, newKey := testKey(t), testKey(t)
+		var oldSealed, newSealed bytes.Buffer
+		_ = Encrypt(&oldSealed, bytes.NewReader([]byte("old")), "2021", oldKey)
+		_ = Encrypt(&newSealed, bytes.NewReader([]byte("new")), "2022", newKey)
+
+		keys := Keyring{"2021": oldKey, "2022": newKey}
+		for want, src := range map[string]*bytes.Buffer{"old": &oldSealed, "new": &newSealed} {
+			var opened bytes.Buffer
+			if err := Decrypt(&opened, src, keys); err != nil || opened.String() != want {
+				t.Errorf("Decrypt() = %s, %v, want %s", opened.String(), err, want)
+			}
+		}
+	})
+}
+
+func TestLoadKey(t *testing.T) {
+	key := testKey(t)
+	dir := t.TempDir()
+	encodings := map[string][]byte{
+		"raw":    key,
+		"hex":    []byte(hex.EncodeToString(key) + "\n"),
+		"base64": []byte(base64.StdEncoding.EncodeToString(key)),
+		"short":  []byte("too short"),
+	}
+	for name, content := range encodings {
+		_ = ioutil.WriteFile(path.Join(dir, name), content, 0600)
+	}
+
+	tests := []struct {
+		name    string
+		file    string
+		env     string
+		wantErr bool
+	}{
+		{"when raw file then load", path.Join(dir, "raw"), "", false},
+		{"when hex file then load", path.Join(dir, "hex"), "", false},
+		{"when base64 file then load", path.Join(dir, "base64"), "", false},
+		{"when short key then error", path.Join(dir, "short"), "", true},
+		{"when missing file then error", path.Join(dir, "missing"), "", true},
+		{"when env then load", "", "ETLOG_TEST_KEY", false},
+		{"when env not set then error", "", "ETLOG_TEST_KEY_MISSING", true},
+		{"when no source then error", "", "", true},
+	}
+	_ = os.Setenv("ETLOG_TEST_KEY", hex.EncodeToString(key))
+	defer os.Unsetenv("ETLOG_TEST_KEY")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := LoadKey(tt.file, tt.env)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadKey() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !bytes.Equal(got, key) {
+				t.Errorf("LoadKey() = %x, want %x", got, key)
+			}
+		})
+	}
+}
+
+func TestLogArchiver_Encryption(t *testing.T) {
+	t.Run("when encryption then compress and encrypt", func(t *testing.T) {
+		dir := t.TempDir()
+		key := testKey(t)
+		source := path.Join(dir, "app.2021-01-01.000000.log")
+		_ = ioutil.WriteFile(source, []byte("secret line\n"), 0644)
+
+		la, err := NewLogArchiver(dir, SetFormat(FormatGzip), SetEncryption("k1", key))
+		if err != nil {
+			t.Fatalf("new archiver err: %+v", err)
+		}
+		archiveFile, err := la.archive(source)
+		if err != nil || archiveFile != source+".gz"+EncryptExt {
+			t.Fatalf("archive() = %s, %+v", archiveFile, err)
+		}
+
+		plainFile := path.Join(dir, "plain.gz")
+		if err := DecryptFile(archiveFile, plainFile, Keyring{"k1": key}); err != nil {
+			t.Fatalf("decrypt err: %+v", err)
+		}
+		f, _ := os.Open(plainFile)
+		defer f.Close()
+		r, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatalf("gzip err: %+v", err)
+		}
+		if b, _ := ioutil.ReadAll(r); string(b) != "secret line\n" {
+			t.Errorf("decrypted = %q", b)
+		}
+		if files, _ := ioutil.ReadDir(dir); len(files) != 2 {
+			t.Errorf("temp files left: %v", files)
+		}
+	})
+
+	t.Run("when invalid key then error", func(t *testing.T) {
+		if _, err := NewLogArchiver("", SetEncryption("k1", []byte("short"))); err == nil {
+			t.Errorf("want error")
+		}
+	})
+}
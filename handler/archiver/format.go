@@ -0,0 +1,76 @@
+package archiver
+
+import (
+	"compress/flate"
+	"github.com/edditen/etlog/common/utils"
+	"github.com/pkg/errors"
+	"strings"
+)
+
+// Format the format of archived backup files.
+type Format string
+
+const (
+	FormatZip   Format = "zip"
+	FormatGzip  Format = "gzip"
+	FormatTarGz Format = "tar.gz"
+	// FormatNone keeps the backup files uncompressed.
+	FormatNone Format = "none"
+)
+
+var formats = []Format{FormatZip, FormatGzip, FormatTarGz, FormatNone}
+
+func NewFormat(format string) (Format, error) {
+	f := Format(strings.ToLower(format))
+	switch f {
+	case "":
+		return FormatZip, nil
+	case "gz":
+		return FormatGzip, nil
+	case "tgz":
+		return FormatTarGz, nil
+	case FormatZip, FormatGzip, FormatTarGz, FormatNone:
+		return f, nil
+	}
+	return FormatNone, errors.Errorf("unknown archive format: %s", format)
+}
+
+// Ext returns the extension appended to the backup file name.
+func (f Format) Ext() string {
+	switch f {
+	case FormatZip:
+		return ".zip"
+	case FormatGzip:
+		return ".gz"
+	case FormatTarGz:
+		return ".tar.gz"
+	}
+	return ""
+}
+
+// Compress compresses the source file into the archive file by the level,
+// from 1 (best speed) to 9 (best compression), 0 means the default level.
+func (f Format) Compress(sourceFile, archiveFile string, level int) error {
+	if level == 0 {
+		level = flate.DefaultCompression
+	}
+	switch f {
+	case FormatZip:
+		return utils.ZipCompressLevel(sourceFile, archiveFile, level)
+	case FormatGzip:
+		return utils.GzipCompress(sourceFile, archiveFile, level)
+	case FormatTarGz:
+		return utils.TarGzCompress(sourceFile, archiveFile, level)
+	}
+	return errors.Errorf("format %s can not compress", f)
+}
+
+// Exts returns the extensions of all formats, the uncompressed one is included,
+// such that the backups of any format could be recognized.
+func Exts() []string {
+	exts := make([]string, 0, len(formats))
+	for _, f := range formats {
+		exts = append(exts, f.Ext())
+	}
+	return exts
+}
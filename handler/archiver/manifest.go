@@ -0,0 +1,258 @@
+package archiver
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// ManifestExt the extension of manifest file, which is written next to
+// the archive, such as "app.2021-01-01.000000.log.gz.manifest".
+const ManifestExt = ".manifest"
+
+var entryTimeFormats = []string{
+	"2006-01-02 15:04:05.000000",
+	"2006-01-02 15:04:05",
+}
+
+// Manifest records the original file and the checksum of the archive,
+// to prove the archive was not altered.
+type Manifest struct {
+	// File the original file name
+	File string `json:"file"`
+	// Size the size of the original content
+	Size       int64     `json:"size"`
+	FirstEntry time.Time `json:"first_entry,omitempty"`
+	LastEntry  time.Time `json:"last_entry,omitempty"`
+	// Archive the archive file name, in the same dir of manifest
+	Archive     string    `json:"archive"`
+	ArchiveSize int64     `json:"archive_size"`
+	SHA256      string    `json:"sha256"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// NewManifest scans the entries of the source file, and hashes the
+// compressed file, which will be renamed to archiveName.
+func NewManifest(sourceFile, compressedFile, archiveName string) (*Manifest, error) {
+	m := &Manifest{
+		File:      path.Base(sourceFile),
+		Archive:   archiveName,
+		CreatedAt: time.Now(),
+	}
+
+	if err := m.scanEntries(sourceFile); err != nil {
+		return nil, err
+	}
+
+	checksum, size, err := fileChecksum(compressedFile)
+	if err != nil {
+		return nil, err
+	}
+	m.SHA256 = checksum
+	m.ArchiveSize = size
+	return m, nil
+}
+
+// scanEntries counts the original size, and finds the time of the first
+// and last entries, the gzip file written by stream mode is decompressed.
+func (m *Manifest) scanEntries(sourceFile string) error {
+	f, err := os.Open(sourceFile)
+	if err != nil {
+		return errors.Wrap(err, "open source file error")
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(sourceFile, FormatGzip.Ext()) {
+		gr, err := gzip.NewReader(f)
+		if err != nil {
+			return errors.Wrap(err, "open gzip source error")
+		}
+		defer gr.Close()
+		r = gr
+	}
+
+	br := bufio.NewReader(r)
+	lineStart := true
+	for {
+		line, isPrefix, err := br.ReadLine()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "read source file error")
+		}
+
+		m.Size += int64(len(line))
+		if !isPrefix {
+			m.Size++
+		}
+		if lineStart {
+			if t, ok := entryTime(line); ok {
+				if m.FirstEntry.IsZero() {
+					m.FirstEntry = t
+				}
+				m.LastEntry = t
+			}
+		}
+		lineStart = !isPrefix
+	}
+}
+
+// entryTime parses the time of the entry formatted by the simple, full
+// or json formatter, the continued lines of multi-line message are skipped.
+func entryTime(line []byte) (time.Time, bool) {
+	if len(line) > 0 && line[0] == '{' {
+		var entry struct {
+			Time time.Time `json:"time"`
+		}
+		if err := json.Unmarshal(line, &entry); err != nil || entry.Time.IsZero() {
+			return time.Time{}, false
+		}
+		return entry.Time, true
+	}
+
+	for _, layout := range entryTimeFormats {
+		if len(line) < len(layout) {
+			continue
+		}
+		if t, err := time.ParseInLocation(layout, string(line[:len(layout)]), time.Local); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func fileChecksum(filename string) (string, int64, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", 0, errors.Wrap(err, "open file error")
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, errors.Wrap(err, "hash file error")
+	}
+	return hex.EncodeToString(h.Sum(nil)), size, nil
+}
+
+// WriteFile writes the manifest by a temp file, the partial manifest is
+// never seen.
+func (m *Manifest) WriteFile(filename string) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "marshal manifest error")
+	}
+
+	tmpFile := filename + ".tmp"
+	if err := ioutil.WriteFile(tmpFile, append(b, '\n'), 0644); err != nil {
+		_ = os.Remove(tmpFile)
+		return errors.Wrap(err, "write manifest error")
+	}
+	if err := os.Rename(tmpFile, filename); err != nil {
+		_ = os.Remove(tmpFile)
+		return errors.Wrap(err, "rename manifest error")
+	}
+	return nil
+}
+
+func ReadManifest(filename string) (*Manifest, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "read manifest error")
+	}
+	m := &Manifest{}
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil, errors.Wrap(err, "unmarshal manifest error")
+	}
+	return m, nil
+}
+
+// VerifyReport the result of Verify, the names are of the manifest files,
+// except the unverified archives.
+type VerifyReport struct {
+	Verified []string
+	// Mismatched the archives whose checksum or size differs
+	Mismatched []string
+	// Missing the archives not found
+	Missing []string
+	// Invalid the manifests can not be read
+	Invalid []string
+	// Unverified the archives without the manifest
+	Unverified []string
+}
+
+// OK returns true if all the archives are verified.
+func (vr *VerifyReport) OK() bool {
+	return len(vr.Mismatched) == 0 && len(vr.Missing) == 0 && len(vr.Invalid) == 0 &&
+		len(vr.Unverified) == 0
+}
+
+// isArchive returns true if the name ends with the extension of a compressed
+// format, encrypted or not. The uncompressed archives can not be told from
+// the other files, they are verified by the manifests only.
+func isArchive(name string) bool {
+	name = strings.TrimSuffix(name, EncryptExt)
+	for _, ext := range Exts() {
+		if ext != "" && strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// Verify re-hashes the archives of the manifests in dir, and reports the
+// archives without the manifest as unverified.
+func Verify(dir string) (*VerifyReport, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "read dir error")
+	}
+
+	report := &VerifyReport{}
+	manifests := make(map[string]bool)
+	for _, f := range files {
+		if !f.IsDir() && strings.HasSuffix(f.Name(), ManifestExt) {
+			manifests[f.Name()] = true
+		}
+	}
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		if !strings.HasSuffix(f.Name(), ManifestExt) {
+			if isArchive(f.Name()) && !manifests[f.Name()+ManifestExt] {
+				report.Unverified = append(report.Unverified, f.Name())
+			}
+			continue
+		}
+
+		m, err := ReadManifest(path.Join(dir, f.Name()))
+		if err != nil || m.Archive == "" || m.SHA256 == "" {
+			report.Invalid = append(report.Invalid, f.Name())
+			continue
+		}
+
+		checksum, size, err := fileChecksum(path.Join(dir, m.Archive))
+		switch {
+		case err != nil && os.IsNotExist(errors.Cause(err)):
+			report.Missing = append(report.Missing, f.Name())
+		case err != nil, checksum != m.SHA256, size != m.ArchiveSize:
+			report.Mismatched = append(report.Mismatched, f.Name())
+		default:
+			report.Verified = append(report.Verified, f.Name())
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,120 @@
+package archiver
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEntryTime(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want time.Time
+		ok   bool
+	}{
+		{"when simple then parse", "2021-01-02 03:04:05 [INFO]\tmsg", time.Date(2021, 1, 2, 3, 4, 5, 0, time.Local), true},
+		{"when full then parse", "2021-01-02 03:04:05.123456|INFO|-|-|msg|-|", time.Date(2021, 1, 2, 3, 4, 5, 123456000, time.Local), true},
+		{"when json then parse", `{"time":"2021-01-02T03:04:05Z","msg":"m"}`, time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC), true},
+		{"when continued line then skip", "\tat main.go:10", time.Time{}, false},
+		{"when short line then skip", "2021", time.Time{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := entryTime([]byte(tt.line))
+			if ok != tt.ok {
+				t.Fatalf("entryTime() ok = %v, want %v", ok, tt.ok)
+			}
+			if ok && !got.Equal(tt.want) {
+				t.Errorf("entryTime() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestManifest_Verify(t *testing.T) {
+	archive := func(t *testing.T, dir string) *LogArchiver {
+		source := path.Join(dir, "app.2021-01-01.000000.log")
+		content := "2021-01-01 00:00:01 [INFO]\tfirst\n\tcontinued\n2021-01-01 00:00:09 [INFO]\tlast\n"
+		if err := ioutil.WriteFile(source, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		la, _ := NewLogArchiver(dir, SetFormat(FormatGzip), SetManifest(true))
+		if _, err := la.archive(source); err != nil {
+			t.Fatalf("archive err: %+v", err)
+		}
+		return la
+	}
+	manifestName := "app.2021-01-01.000000.log.gz" + ManifestExt
+
+	t.Run("when archived then manifest written", func(t *testing.T) {
+		dir := t.TempDir()
+		archive(t, dir)
+		m, err := ReadManifest(path.Join(dir, manifestName))
+		if err != nil {
+			t.Fatalf("read manifest err: %+v", err)
+		}
+		if m.File != "app.2021-01-01.000000.log" || m.Size != 76 ||
+			m.FirstEntry.Second() != 1 || m.LastEntry.Second() != 9 ||
+			m.Archive != "app.2021-01-01.000000.log.gz" || len(m.SHA256) != 64 {
+			t.Errorf("manifest = %+v", m)
+		}
+
+		report, err := Verify(dir)
+		if err != nil || !report.OK() || len(report.Verified) != 1 {
+			t.Errorf("Verify() = %+v, %v", report, err)
+		}
+	})
+
+	t.Run("when archive altered then mismatched", func(t *testing.T) {
+		dir := t.TempDir()
+		archive(t, dir)
+		archiveFile := path.Join(dir, "app.2021-01-01.000000.log.gz")
+		b, _ := ioutil.ReadFile(archiveFile)
+		b[len(b)-1] ^= 0xff
+		_ = ioutil.WriteFile(archiveFile, b, 0644)
+
+		report, _ := Verify(dir)
+		if report.OK() || len(report.Mismatched) != 1 {
+			t.Errorf("Verify() = %+v", report)
+		}
+	})
+
+	t.Run("when archive removed then missing", func(t *testing.T) {
+		dir := t.TempDir()
+		archive(t, dir)
+		_ = os.Remove(path.Join(dir, "app.2021-01-01.000000.log.gz"))
+
+		report, _ := Verify(dir)
+		if report.OK() || len(report.Missing) != 1 {
+			t.Errorf("Verify() = %+v", report)
+		}
+	})
+
+	t.Run("when archive without manifest then unverified", func(t *testing.T) {
+		dir := t.TempDir()
+		archive(t, dir)
+		for _, name := range []string{"app.2021-01-02.000000.log.zip", "app.2021-01-03.000000.log.tar.gz.enc", "app.log", "app.log.idx"} {
+			_ = ioutil.WriteFile(path.Join(dir, name), []byte("x"), 0644)
+		}
+
+		report, _ := Verify(dir)
+		want := "app.2021-01-02.000000.log.zip,app.2021-01-03.000000.log.tar.gz.enc"
+		if report.OK() || len(report.Verified) != 1 || strings.Join(report.Unverified, ",") != want {
+			t.Errorf("Verify() = %+v", report)
+		}
+	})
+
+	t.Run("when manifest broken then invalid", func(t *testing.T) {
+		dir := t.TempDir()
+		_ = ioutil.WriteFile(path.Join(dir, manifestName), []byte("{"), 0644)
+
+		report, _ := Verify(dir)
+		if report.OK() || len(report.Invalid) != 1 {
+			t.Errorf("Verify() = %+v", report)
+		}
+	})
+}
@@ -0,0 +1,169 @@
+package archiver
+
+import (
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// UploadedExt the extension of the marker file written after the archive
+// uploaded, the cleaner deletes only the uploaded archives.
+const UploadedExt = ".uploaded"
+
+const (
+	defaultUploadTimeout = 60 * time.Second
+	nameHolder           = "{name}"
+	octetStreamType      = "application/octet-stream"
+)
+
+// Uploader uploads the archive to the remote storage.
+type Uploader interface {
+	Upload(file string) error
+}
+
+// HTTPUploader puts the archive to the url, which is the endpoint with
+// "{name}" replaced by the archive name, or the name appended if no "{name}".
+// The url of each archive could be given by a presign func instead,
+// such as the pre-signed url of S3.
+type HTTPUploader struct {
+	endpoint string
+	headers  map[string]string
+	presign  func(name string) (string, error)
+	client   *http.Client
+}
+
+type HTTPUploaderOpt func(*HTTPUploader)
+
+func NewHTTPUploader(endpoint string, options ...HTTPUploaderOpt) *HTTPUploader {
+	hu := &HTTPUploader{
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: defaultUploadTimeout},
+	}
+	for _, opt := range options {
+		opt(hu)
+	}
+	return hu
+}
+
+func (hu *HTTPUploader) Upload(file string) error {
+	target, err := hu.url(path.Base(file))
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return errors.Wrap(err, "open archive error")
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return errors.Wrap(err, "stat archive error")
+	}
+
+	req, err := http.NewRequest(http.MethodPut, target, f)
+	if err != nil {
+		return errors.Wrap(err, "create upload request error")
+	}
+	req.ContentLength = info.Size()
+	req.Header.Set("Content-Type", octetStreamType)
+	for k, v := range hu.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := hu.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "send upload request error")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return errors.Errorf("upload %s failed, status: %d, body: %s", path.Base(file), resp.StatusCode, body)
+	}
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	return nil
+}
+
+func (hu *HTTPUploader) url(name string) (string, error) {
+	if hu.presign != nil {
+		target, err := hu.presign(name)
+		return target, errors.Wrap(err, "presign url error")
+	}
+	if strings.Contains(hu.endpoint, nameHolder) {
+		return strings.Replace(hu.endpoint, nameHolder, url.PathEscape(name), -1), nil
+	}
+	return strings.TrimRight(hu.endpoint, "/") + "/" + url.PathEscape(name), nil
+}
+
+// SetHeaders sets the headers of the upload request, such as authorization.
+func SetHeaders(headers map[string]string) HTTPUploaderOpt {
+	return func(hu *HTTPUploader) {
+		hu.headers = headers
+	}
+}
+
+// SetTimeout sets the timeout of each upload request.
+func SetTimeout(timeout time.Duration) HTTPUploaderOpt {
+	return func(hu *HTTPUploader) {
+		hu.client.Timeout = timeout
+	}
+}
+
+// SetPresign gives the url of each archive, the endpoint is ignored.
+func SetPresign(presign func(name string) (string, error)) HTTPUploaderOpt {
+	return func(hu *HTTPUploader) {
+		hu.presign = presign
+	}
+}
+
+// DirUploader copies the archive into the dir, such as a NFS mount.
+type DirUploader struct {
+	dir string
+}
+
+func NewDirUploader(dir string) *DirUploader {
+	return &DirUploader{dir: dir}
+}
+
+// Upload copies into a temp file then renames, so a partial copy is never
+// seen in the dir.
+func (du *DirUploader) Upload(file string) error {
+	if err := os.MkdirAll(du.dir, os.ModePerm); err != nil {
+		return errors.Wrap(err, "create upload dir error")
+	}
+
+	src, err := os.Open(file)
+	if err != nil {
+		return errors.Wrap(err, "open archive error")
+	}
+	defer src.Close()
+
+	target := path.Join(du.dir, path.Base(file))
+	tmpFile := target + ".tmp"
+	dst, err := os.OpenFile(tmpFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return errors.Wrap(err, "create upload file error")
+	}
+	_, err = io.Copy(dst, src)
+	if err == nil {
+		err = dst.Sync()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpFile, target)
+	}
+	if err != nil {
+		_ = os.Remove(tmpFile)
+		return errors.Wrap(err, "copy archive error")
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package archiver
+
+import (
+	"github.com/edditen/etlog/handler/naming"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeStore the httptest stand-in of S3 compatible storage.
+type fakeStore struct {
+	mutex   sync.Mutex
+	objects map[string][]byte
+	headers http.Header
+	fails   int
+}
+
+func (fs *fakeStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	if r.Method != http.MethodPut {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if fs.fails > 0 {
+		fs.fails--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	fs.objects[r.URL.Path] = body
+	fs.headers = r.Header
+	w.WriteHeader(http.StatusOK)
+}
+
+func (fs *fakeStore) object(key string) ([]byte, bool) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	b, ok := fs.objects[key]
+	return b, ok
+}
+
+func newFakeStore(t *testing.T, fails int) (*fakeStore, string) {
+	store := &fakeStore{objects: make(map[string][]byte), fails: fails}
+	server := httptest.NewServer(store)
+	t.Cleanup(server.Close)
+	return store, server.URL
+}
+
+func TestHTTPUploader_Upload(t *testing.T) {
+	file := path.Join(t.TempDir(), "app.2021-01-01.000000.log.gz")
+	_ = ioutil.WriteFile(file, []byte("archive"), 0644)
+
+	t.Run("when endpoint then append name", func(t *testing.T) {
+		store, url := newFakeStore(t, 0)
+		u := NewHTTPUploader(url+"/bucket/", SetHeaders(map[string]string{"X-Token": "t"}))
+		if err := u.Upload(file); err != nil {
+			t.Fatalf("upload err: %+v", err)
+		}
+		if b, ok := store.object("/bucket/app.2021-01-01.000000.log.gz"); !ok || string(b) != "archive" {
+			t.Errorf("object = %s, %v", b, ok)
+		}
+		if store.headers.Get("X-Token") != "t" {
+			t.Errorf("headers = %v", store.headers)
+		}
+	})
+
+	t.Run("when name holder then replace", func(t *testing.T) {
+		store, url := newFakeStore(t, 0)
+		u := NewHTTPUploader(url + "/logs/{name}?X-Amz-Signature=s")
+		if err := u.Upload(file); err != nil {
+			t.Fatalf("upload err: %+v", err)
+		}
+		if _, ok := store.object("/logs/app.2021-01-01.000000.log.gz"); !ok {
+			t.Errorf("object not uploaded")
+		}
+	})
+
+	t.Run("when presign then put to signed url", func(t *testing.T) {
+		store, url := newFakeStore(t, 0)
+		u := NewHTTPUploader("", SetPresign(func(name string) (string, error) {
+			return url + "/signed/" + name, nil
+		}))
+		if err := u.Upload(file); err != nil {
+			t.Fatalf("upload err: %+v", err)
+		}
+		if _, ok := store.object("/signed/app.2021-01-01.000000.log.gz"); !ok {
+			t.Errorf("object not uploaded")
+		}
+	})
+
+	t.Run("when server error then error", func(t *testing.T) {
+		_, url := newFakeStore(t, 1)
+		if err := NewHTTPUploader(url).Upload(file); err == nil {
+			t.Errorf("want upload error")
+		}
+	})
+}
+
+func TestDirUploader_Upload(t *testing.T) {
+	file := path.Join(t.TempDir(), "app.2021-01-01.000000.log.gz")
+	_ = ioutil.WriteFile(file, []byte("archive"), 0644)
+	dir := path.Join(t.TempDir(), "nfs", "logs")
+
+	if err := NewDirUploader(dir).Upload(file); err != nil {
+		t.Fatalf("upload err: %+v", err)
+	}
+	b, err := ioutil.ReadFile(path.Join(dir, path.Base(file)))
+	if err != nil || string(b) != "archive" {
+		t.Errorf("copied = %s, %v", b, err)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("temp files left: %v", files)
+	}
+}
+
+func TestLogArchiver_Upload(t *testing.T) {
+	t.Run("when upload failed then retry and mark", func(t *testing.T) {
+		dir := t.TempDir()
+		source := path.Join(dir, "app.2021-01-01.000000.log")
+		_ = ioutil.WriteFile(source, []byte("line\n"), 0644)
+		store, url := newFakeStore(t, 1)
+
+		la, _ := NewLogArchiver(dir, SetFormat(FormatGzip), SetManifest(true),
+			SetBackupDelay(time.Millisecond), SetRetry(3, 5*time.Millisecond, 10*time.Millisecond),
+			SetUploader(NewHTTPUploader(url)))
+		if err := la.Init(); err != nil {
+			t.Fatalf("init err: %+v", err)
+		}
+		defer la.Shutdown()
+		_ = la.Archive(source)
+
+		stats := waitStats(t, la, func(s Stats) bool { return s.Uploaded == 1 })
+		if stats.Archived != 1 || stats.Retries != 1 || stats.Pending != 0 {
+			t.Errorf("Stats() = %+v", stats)
+		}
+		for _, key := range []string{"/app.2021-01-01.000000.log.gz", "/app.2021-01-01.000000.log.gz" + ManifestExt} {
+			if _, ok := store.object(key); !ok {
+				t.Errorf("%s not uploaded", key)
+			}
+		}
+		if _, err := os.Stat(source + ".gz" + UploadedExt); err != nil {
+			t.Errorf("uploaded mark not found: %v", err)
+		}
+	})
+
+	t.Run("when archives not uploaded then resume on init", func(t *testing.T) {
+		dir, target := t.TempDir(), t.TempDir()
+		for _, name := range []string{"app.2021-01-01.000000.log.gz", "app.2021-01-02.000000.log.gz"} {
+			_ = ioutil.WriteFile(path.Join(dir, name), []byte("archive"), 0644)
+		}
+		_ = ioutil.WriteFile(path.Join(dir, "app.2021-01-01.000000.log.gz"+UploadedExt), nil, 0644)
+
+		tmpl, _ := naming.ParseTemplate(naming.DefaultTemplate)
+		matcher, _ := tmpl.Matcher("app", ".log", "")
+		la, _ := NewLogArchiver(dir, SetFormat(FormatGzip), SetResume(dir, matcher),
+			SetUploader(NewDirUploader(target)))
+		if err := la.Init(); err != nil {
+			t.Fatalf("init err: %+v", err)
+		}
+		defer la.Shutdown()
+
+		waitStats(t, la, func(s Stats) bool { return s.Uploaded == 1 && s.Pending == 0 })
+		if files, _ := ioutil.ReadDir(target); len(files) != 1 || files[0].Name() != "app.2021-01-02.000000.log.gz" {
+			t.Errorf("uploaded files: %v", files)
+		}
+	})
+}
@@ -0,0 +1,509 @@
+package handler
+
+import (
+	"fmt"
+	"github.com/edditen/etlog/common/queue"
+	"github.com/edditen/etlog/common/utils"
+	"github.com/edditen/etlog/config"
+	"github.com/edditen/etlog/core"
+	"github.com/edditen/etlog/opt"
+	"github.com/pkg/errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultQueueSize     = 8192
+	defaultFlushInterval = 100
+	defaultFlushSize     = 256
+	defaultOverflow      = OverflowSync
+	defaultDropLevel     = "warn"
+)
+
+// errAsyncDown the background writer is gone, the entry should be written directly.
+var errAsyncDown = errors.New("async handler is shut down")
+
+// OverflowPolicy decides what to do when the queue of async handler is full.
+type OverflowPolicy int
+
+const (
+	// OverflowSync writes the entry synchronously
+	OverflowSync OverflowPolicy = iota
+	// OverflowBlock blocks the caller until the queue has room
+	OverflowBlock
+	// OverflowDropNewest drops the entry being handled
+	OverflowDropNewest
+	// OverflowDropOldest drops the oldest entry in queue
+	OverflowDropOldest
+	// OverflowDropBelowLevel drops the entry below the drop level, and blocks the others
+	OverflowDropBelowLevel
+	// OverflowPriority evicts the queued entries below the drop level to make room
+	// for the higher level entry, drops the entry below the drop level and blocks the others
+	OverflowPriority
+)
+
+func NewOverflowPolicy(policy string) OverflowPolicy {
+	switch strings.ToUpper(policy) {
+	case "SYNC":
+		return OverflowSync
+	case "BLOCK":
+		return OverflowBlock
+	case "DROP_NEWEST":
+		return OverflowDropNewest
+	case "DROP_OLDEST":
+		return OverflowDropOldest
+	case "DROP_BELOW_LEVEL":
+		return OverflowDropBelowLevel
+	case "PRIORITY":
+		return OverflowPriority
+	}
+	return defaultOverflow
+}
+
+func (op OverflowPolicy) String() string {
+	switch op {
+	case OverflowSync:
+		return "SYNC"
+	case OverflowBlock:
+		return "BLOCK"
+	case OverflowDropNewest:
+		return "DROP_NEWEST"
+	case OverflowDropOldest:
+		return "DROP_OLDEST"
+	case OverflowDropBelowLevel:
+		return "DROP_BELOW_LEVEL"
+	case OverflowPriority:
+		return "PRIORITY"
+	}
+	return ""
+}
+
+// BatchHandler is implemented by the handlers which could write
+// a batch of entries at once.
+type BatchHandler interface {
+	HandleBatch(entries LogEntries) error
+}
+
+// AsyncStats the counters of async handler, the evicted entries
+// are counted in dropped too. The buffered entries include the queued
+// ones and the ones of the batch being written.
+type AsyncStats struct {
+	Queued         int
+	QueuedBytes    int64
+	Buffered       int
+	BufferedBytes  int64
+	MaxBufferBytes int64
+	Handled        uint64
+	Dropped        uint64
+	Synced         uint64
+	Evicted        map[string]uint64
+}
+
+// AsyncHandler makes the wrapped handler asynchronous, the entries are queued
+// and written by batch in background, the overflow policy decides what to do
+// when the queue is full.
+type AsyncHandler struct {
+	*BaseHandler
+	handler       Handler
+	overflow      OverflowPolicy
+	dropLevel     core.Level
+	flushInterval time.Duration
+	flushSize     int
+	maxBytes      int64
+	durable       durableFilter
+	entryQ        *queue.EvictingQueue
+	entryBuf      LogEntries
+	bufBytes      int64
+	bufLen        int64
+	queueFull     chan bool
+	handled       uint64
+	dropped       uint64
+	synced        uint64
+	evicted       []uint64
+	reported      []uint64
+	once          *sync.Once
+	downLock      *sync.RWMutex
+	exitC         chan interface{}
+	doneC         chan interface{}
+}
+
+func NewAsyncHandler(conf *config.HandlerConfig, h Handler) *AsyncHandler {
+	return &AsyncHandler{
+		BaseHandler: NewBaseHandler(conf),
+		handler:     h,
+		queueFull:   make(chan bool),
+		evicted:     make([]uint64, core.FATAL+1),
+		reported:    make([]uint64, core.FATAL+1),
+		once:        new(sync.Once),
+		downLock:    new(sync.RWMutex),
+		exitC:       make(chan interface{}),
+		doneC:       make(chan interface{}),
+	}
+}
+
+func (ah *AsyncHandler) Init() error {
+	if err := ah.handler.Init(); err != nil {
+		return err
+	}
+	if err := ah.BaseHandler.Init(); err != nil {
+		return err
+	}
+	if err := ah.settingSync(); err != nil {
+		return err
+	}
+
+	go ah.run()
+	return nil
+}
+
+func (ah *AsyncHandler) settingSync() error {
+	syncConf := ah.BaseHandler.handlerConfig.Sync
+	if syncConf.QueueSize <= 0 {
+		syncConf.QueueSize = defaultQueueSize
+	}
+	if syncConf.FlushInterval <= 0 {
+		syncConf.FlushInterval = defaultFlushInterval
+	}
+	if syncConf.FlushSize <= 0 {
+		syncConf.FlushSize = defaultFlushSize
+	}
+	if syncConf.Overflow == "" {
+		syncConf.Overflow = defaultOverflow.String()
+	}
+	if syncConf.DropLevel == "" {
+		syncConf.DropLevel = defaultDropLevel
+	}
+
+	ah.overflow = NewOverflowPolicy(syncConf.Overflow)
+	if !strings.EqualFold(ah.overflow.String(), syncConf.Overflow) {
+		return errors.Errorf("unknown overflow policy: %s", syncConf.Overflow)
+	}
+	if syncConf.MaxBufferBytes != "" {
+		maxBytes, err := utils.ParseSize(syncConf.MaxBufferBytes)
+		if err != nil {
+			return errors.Wrap(err, "parse max buffer bytes error")
+		}
+		ah.maxBytes = int64(maxBytes)
+	}
+
+	var err error
+	if ah.durable, err = newDurableFilter(syncConf.DurableLevel); err != nil {
+		return err
+	}
+	ah.dropLevel = core.NewLevel(syncConf.DropLevel)
+	ah.flushInterval = time.Duration(syncConf.FlushInterval) * time.Millisecond
+	ah.flushSize = syncConf.FlushSize
+	// the max bytes are split between the queue and the batch being written
+	ah.entryQ = queue.NewWeightedEvictingQueue(syncConf.QueueSize, ah.maxBytes/2)
+	ah.entryBuf = make(LogEntries, 0, ah.flushSize)
+	return nil
+}
+
+func (ah *AsyncHandler) Handle(entry *core.LogEntry) error {
+	if !ah.BaseHandler.MarkerMatched(entry.Marker) {
+		return nil
+	}
+	if !ah.BaseHandler.Contains(entry.Level) {
+		return nil
+	}
+	if ah.durable.match(entry) {
+		// the durable entry is written and synced by the wrapped handler
+		// before returning, it may be ahead of the entries still queued
+		return ah.syncHandle(entry)
+	}
+
+	err := ah.offer(entry)
+	if err == nil {
+		return nil
+	}
+	if err == errAsyncDown {
+		// the background writer is gone, keep the entry by writing it directly
+		return ah.syncHandle(entry)
+	}
+
+	return ah.handleOverflow(entry)
+}
+
+// whileUp runs fn unless shut down. Shutdown waits for fn, so the entry
+// queued by fn is always pulled by the final flush of the background writer.
+func (ah *AsyncHandler) whileUp(fn func() error) error {
+	ah.downLock.RLock()
+	defer ah.downLock.RUnlock()
+	if ah.isDown() {
+		return errAsyncDown
+	}
+	return fn()
+}
+
+func (ah *AsyncHandler) offer(entry *core.LogEntry) error {
+	return ah.whileUp(func() error {
+		return ah.entryQ.Offer(entry, int(entry.Level), ah.weight(entry))
+	})
+}
+
+func (ah *AsyncHandler) handleOverflow(entry *core.LogEntry) error {
+	switch ah.overflow {
+	case OverflowBlock:
+		return ah.blockingOffer(entry)
+	case OverflowDropNewest:
+		ah.drop(1)
+		return nil
+	case OverflowDropOldest:
+		return ah.offerDropOldest(entry)
+	case OverflowDropBelowLevel:
+		if entry.Level < ah.dropLevel {
+			ah.drop(1)
+			return nil
+		}
+		return ah.blockingOffer(entry)
+	case OverflowPriority:
+		return ah.offerEvict(entry)
+	}
+
+	err := ah.syncHandle(entry)
+	ah.notifyFull()
+	return err
+}
+
+func (ah *AsyncHandler) blockingOffer(entry *core.LogEntry) error {
+	for {
+		err := ah.offer(entry)
+		if err == nil {
+			// wake up the other waiters if there is still room
+			ah.entryQ.Signal()
+			return nil
+		}
+		if err == errAsyncDown {
+			return ah.syncHandle(entry)
+		}
+
+		select {
+		case <-ah.entryQ.NotFull():
+		case <-time.After(ah.flushInterval):
+		case <-ah.exitC:
+			return ah.syncHandle(entry)
+		}
+	}
+}
+
+func (ah *AsyncHandler) offerDropOldest(entry *core.LogEntry) error {
+	for {
+		err := ah.offer(entry)
+		if err == nil {
+			return nil
+		}
+		if err == errAsyncDown {
+			return ah.syncHandle(entry)
+		}
+		if _, ok := ah.entryQ.Poll(); ok {
+			ah.drop(1)
+		}
+	}
+}
+
+func (ah *AsyncHandler) offerEvict(entry *core.LogEntry) error {
+	var evicted []interface{}
+	err := ah.whileUp(func() (err error) {
+		evicted, err = ah.entryQ.OfferEvict(entry, int(entry.Level), int(ah.dropLevel), ah.weight(entry))
+		return err
+	})
+	if err == errAsyncDown {
+		return ah.syncHandle(entry)
+	}
+	if err == nil {
+		for _, val := range evicted {
+			atomic.AddUint64(&ah.evicted[val.(*core.LogEntry).Level], 1)
+		}
+		ah.drop(len(evicted))
+		return nil
+	}
+
+	if entry.Level < ah.dropLevel {
+		ah.drop(1)
+		return nil
+	}
+	return ah.blockingOffer(entry)
+}
+
+// weight the estimated bytes of entry.
+func (ah *AsyncHandler) weight(entry *core.LogEntry) int64 {
+	return int64(entry.EstimateSize())
+}
+
+func (ah *AsyncHandler) syncHandle(entry *core.LogEntry) error {
+	atomic.AddUint64(&ah.synced, 1)
+	return ah.handler.Handle(entry)
+}
+
+func (ah *AsyncHandler) drop(n int) {
+	atomic.AddUint64(&ah.dropped, uint64(n))
+}
+
+func (ah *AsyncHandler) notifyFull() {
+	select {
+	case ah.queueFull <- true:
+	default:
+	}
+}
+
+func (ah *AsyncHandler) run() {
+	defer close(ah.doneC)
+
+	ticker := time.NewTicker(ah.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ah.entryQ.NotEmpty():
+			ah.pull()
+		case <-ticker.C:
+			ah.pull()
+			ah.flush()
+			ah.reportEvicted()
+		case <-ah.queueFull:
+			ah.flush()
+		case <-ah.exitC:
+			ah.pull()
+			ah.flush()
+			ah.reportEvicted()
+			return
+		}
+	}
+}
+
+// pull moves the queued entries into buffer, and flushes the buffer when it is full.
+func (ah *AsyncHandler) pull() {
+	for {
+		if ah.bufferFull() {
+			ah.flush()
+		}
+		val, ok := ah.entryQ.Poll()
+		if !ok {
+			return
+		}
+		entry := val.(*core.LogEntry)
+		ah.entryBuf = append(ah.entryBuf, entry)
+		atomic.AddInt64(&ah.bufLen, 1)
+		atomic.AddInt64(&ah.bufBytes, ah.weight(entry))
+	}
+}
+
+func (ah *AsyncHandler) bufferFull() bool {
+	if len(ah.entryBuf) >= ah.flushSize {
+		return true
+	}
+	return ah.maxBytes > 0 && atomic.LoadInt64(&ah.bufBytes) >= ah.maxBytes/2
+}
+
+func (ah *AsyncHandler) reportEvicted() {
+	report := ""
+	for level := range ah.evicted {
+		evicted := atomic.LoadUint64(&ah.evicted[level])
+		if evicted == ah.reported[level] {
+			continue
+		}
+		report += fmt.Sprintf(" %s:%d", core.Level(level), evicted-ah.reported[level])
+		ah.reported[level] = evicted
+	}
+	if report != "" {
+		opt.GetErrLog().Printf("async queue full, evicted entries:%s\n", report)
+	}
+}
+
+func (ah *AsyncHandler) flush() {
+	if len(ah.entryBuf) == 0 {
+		return
+	}
+
+	if bh, ok := ah.handler.(BatchHandler); ok {
+		if err := bh.HandleBatch(ah.entryBuf); err != nil {
+			opt.GetErrLog().Printf("async handle batch err: %+v\n", err)
+		}
+	} else {
+		for _, entry := range ah.entryBuf {
+			if err := ah.handler.Handle(entry); err != nil {
+				opt.GetErrLog().Printf("async handle log err: %+v\n", err)
+			}
+		}
+	}
+
+	atomic.AddUint64(&ah.handled, uint64(len(ah.entryBuf)))
+	ah.entryBuf = ah.entryBuf[:0]
+	atomic.StoreInt64(&ah.bufLen, 0)
+	atomic.StoreInt64(&ah.bufBytes, 0)
+}
+
+func (ah *AsyncHandler) isDown() bool {
+	select {
+	case <-ah.exitC:
+		return true
+	default:
+		return false
+	}
+}
+
+// Shutdown flushes all the queued entries, then shutdown the wrapped handler.
+func (ah *AsyncHandler) Shutdown() {
+	ah.once.Do(func() {
+		ah.downLock.Lock()
+		close(ah.exitC)
+		ah.downLock.Unlock()
+		<-ah.doneC
+		ah.handler.Shutdown()
+	})
+}
+
+func (ah *AsyncHandler) Stats() AsyncStats {
+	evicted := make(map[string]uint64)
+	for level := range ah.evicted {
+		if n := atomic.LoadUint64(&ah.evicted[level]); n > 0 {
+			evicted[core.Level(level).String()] = n
+		}
+	}
+	queued := ah.entryQ.Len()
+	queuedBytes := ah.entryQ.Weight()
+	return AsyncStats{
+		Queued:         queued,
+		QueuedBytes:    queuedBytes,
+		Buffered:       queued + int(atomic.LoadInt64(&ah.bufLen)),
+		BufferedBytes:  queuedBytes + atomic.LoadInt64(&ah.bufBytes),
+		MaxBufferBytes: ah.maxBytes,
+		Handled:        atomic.LoadUint64(&ah.handled),
+		Dropped:        atomic.LoadUint64(&ah.dropped),
+		Synced:         atomic.LoadUint64(&ah.synced),
+		Evicted:        evicted,
+	}
+}
+
+// StatsReporter is implemented by the handlers which report the counters.
+type StatsReporter interface {
+	Stats() AsyncStats
+}
+
+// Unwrap returns the wrapped handler.
+func (ah *AsyncHandler) Unwrap() Handler {
+	return ah.handler
+}
+
+func (ah *AsyncHandler) IgnoreLevel() bool {
+	if li, ok := ah.handler.(LevelIgnorer); ok {
+		return li.IgnoreLevel()
+	}
+	return false
+}
+
+func (ah *AsyncHandler) Dump() error {
+	if d, ok := ah.handler.(Dumper); ok {
+		return d.Dump()
+	}
+	return nil
+}
+
+func (ah *AsyncHandler) Reopen() error {
+	if r, ok := ah.handler.(Reopener); ok {
+		return r.Reopen()
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+package handler
+
+import (
+	"github.com/edditen/etlog/config"
+	"github.com/edditen/etlog/core"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockingHandler blocks the writing until released
+type blockingHandler struct {
+	captureHandler
+	release chan bool
+	once    sync.Once
+}
+
+func (bh *blockingHandler) Init() error {
+	return nil
+}
+
+func (bh *blockingHandler) Handle(entry *core.LogEntry) error {
+	<-bh.release
+	return bh.captureHandler.Handle(entry)
+}
+
+func (bh *blockingHandler) Release() {
+	bh.once.Do(func() {
+		close(bh.release)
+	})
+}
+
+func TestAsyncHandler_Handle(t *testing.T) {
+	newHandler := func(t *testing.T, overflow string) (*AsyncHandler, *blockingHandler) {
+		conf := config.NewHandlerConfig()
+		conf.Levels = allLevels
+		conf.Sync.AsyncWrite = true
+		conf.Sync.QueueSize = 2
+		conf.Sync.FlushSize = 1
+		conf.Sync.FlushInterval = 10
+		conf.Sync.Overflow = overflow
+		inner := &blockingHandler{release: make(chan bool)}
+		h := NewAsyncHandler(conf, inner)
+		if err := h.Init(); err != nil {
+			t.Fatalf("init err: %+v", err)
+		}
+		return h, inner
+	}
+	entry := func(level core.Level, msg string) *core.LogEntry {
+		return &core.LogEntry{Time: time.Now(), Level: level, Msg: msg}
+	}
+	// fill makes the writer busy on the first entry, and the queue full
+	fill := func(h *AsyncHandler) {
+		_ = h.Handle(entry(core.DEBUG, "busy"))
+		for h.entryQ.Len() > 0 {
+			time.Sleep(time.Millisecond)
+		}
+		_ = h.Handle(entry(core.DEBUG, "q1"))
+		_ = h.Handle(entry(core.DEBUG, "q2"))
+	}
+
+	t.Run("when drop newest then count dropped", func(t *testing.T) {
+		h, inner := newHandler(t, "drop_newest")
+		fill(h)
+		_ = h.Handle(entry(core.ERROR, "new"))
+		inner.Release()
+		h.Shutdown()
+
+		if got := h.Stats().Dropped; got != 1 {
+			t.Errorf("expect 1 dropped, got: %d", got)
+		}
+		if got := inner.msgs(); len(got) != 3 || got[2] != "q2" {
+			t.Errorf("unexpected written: %v", got)
+		}
+	})
+
+	t.Run("when drop oldest then keep newest", func(t *testing.T) {
+		h, inner := newHandler(t, "drop_oldest")
+		fill(h)
+		_ = h.Handle(entry(core.ERROR, "new"))
+		inner.Release()
+		h.Shutdown()
+
+		if got := inner.msgs(); len(got) != 3 || got[1] != "q2" || got[2] != "new" {
+			t.Errorf("unexpected written: %v", got)
+		}
+	})
+
+	t.Run("when drop below level then drop debug only", func(t *testing.T) {
+		h, inner := newHandler(t, "drop_below_level")
+		fill(h)
+		_ = h.Handle(entry(core.DEBUG, "debug"))
+		go inner.Release()
+		_ = h.Handle(entry(core.ERROR, "error"))
+		h.Shutdown()
+
+		got := inner.msgs()
+		if len(got) != 4 || got[3] != "error" || h.Stats().Dropped != 1 {
+			t.Errorf("unexpected written: %v, dropped: %d", got, h.Stats().Dropped)
+		}
+	})
+
+	t.Run("when priority then evict debug for error", func(t *testing.T) {
+		h, inner := newHandler(t, "priority")
+		fill(h)
+		_ = h.Handle(entry(core.ERROR, "error"))
+		_ = h.Handle(entry(core.DEBUG, "debug"))
+		inner.Release()
+		h.Shutdown()
+
+		got := inner.msgs()
+		if len(got) != 3 || got[1] != "q2" || got[2] != "error" {
+			t.Errorf("unexpected written: %v", got)
+		}
+		stats := h.Stats()
+		if stats.Dropped != 2 || stats.Evicted["DEBUG"] != 1 {
+			t.Errorf("unexpected stats: %+v", stats)
+		}
+	})
+
+	t.Run("when max buffer bytes then bounded by bytes", func(t *testing.T) {
+		conf := config.NewHandlerConfig()
+		conf.Levels = allLevels
+		conf.Sync.QueueSize = 100
+		conf.Sync.FlushSize = 1
+		conf.Sync.Overflow = "drop_newest"
+		conf.Sync.MaxBufferBytes = "2k"
+		inner := &blockingHandler{release: make(chan bool)}
+		h := NewAsyncHandler(conf, inner)
+		if err := h.Init(); err != nil {
+			t.Fatalf("init err: %+v", err)
+		}
+		_ = h.Handle(entry(core.DEBUG, "busy"))
+		for h.entryQ.Len() > 0 {
+			time.Sleep(time.Millisecond)
+		}
+
+		payload := string(make([]byte, 400))
+		for i := 0; i < 5; i++ {
+			_ = h.Handle(entry(core.INFO, payload))
+		}
+		stats := h.Stats()
+		if stats.Queued != 2 || stats.Dropped != 3 {
+			t.Errorf("expect 2 queued and 3 dropped, got: %+v", stats)
+		}
+		if stats.QueuedBytes > 1024 || stats.BufferedBytes <= stats.QueuedBytes {
+			t.Errorf("unexpected bytes: %+v", stats)
+		}
+		inner.Release()
+		h.Shutdown()
+	})
+
+	t.Run("when shutdown then flush all queued", func(t *testing.T) {
+		h, inner := newHandler(t, "block")
+		inner.Release()
+		for i := 0; i < 10; i++ {
+			_ = h.Handle(entry(core.INFO, "msg"))
+		}
+		h.Shutdown()
+		if got := inner.msgs(); len(got) != 10 {
+			t.Errorf("expect 10 written, got: %d", len(got))
+		}
+	})
+
+	t.Run("when handled concurrently with shutdown then none lost", func(t *testing.T) {
+		h, inner := newHandler(t, "block")
+		inner.Release()
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 200; j++ {
+					_ = h.Handle(entry(core.INFO, "msg"))
+				}
+			}()
+		}
+		time.Sleep(5 * time.Millisecond)
+		h.Shutdown()
+		wg.Wait()
+		if got := inner.msgs(); len(got) != 8*200 {
+			t.Errorf("expect %d written, got: %d", 8*200, len(got))
+		}
+	})
+
+	t.Run("when offer in flight then shutdown waits and flushes it", func(t *testing.T) {
+		h, inner := newHandler(t, "block")
+		inner.Release()
+		// hold the lock as an offer between the down check and the queue
+		h.downLock.RLock()
+		done := make(chan bool)
+		go func() {
+			h.Shutdown()
+			close(done)
+		}()
+		time.Sleep(20 * time.Millisecond)
+		if h.isDown() {
+			t.Errorf("shutdown should wait for the offer in flight")
+		}
+		_ = h.entryQ.Offer(entry(core.INFO, "in flight"), int(core.INFO), 0)
+		h.downLock.RUnlock()
+		<-done
+		if got := inner.msgs(); len(got) != 1 || got[0] != "in flight" {
+			t.Errorf("unexpected written: %v", got)
+		}
+	})
+
+	t.Run("when unknown overflow then init error", func(t *testing.T) {
+		conf := config.NewHandlerConfig()
+		conf.Sync.Overflow = "unknown"
+		if err := NewAsyncHandler(conf, &blockingHandler{}).Init(); err == nil {
+			t.Errorf("expect init error")
+		}
+	})
+}
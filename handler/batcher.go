@@ -0,0 +1,218 @@
+package handler
+
+import (
+	"github.com/edditen/etlog/common/utils"
+	"github.com/edditen/etlog/config"
+	"github.com/edditen/etlog/core"
+	"github.com/edditen/etlog/opt"
+	"github.com/pkg/errors"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBatchSize     = 500
+	defaultBatchBytes    = "5M"
+	defaultBatchInterval = 1000
+	defaultRemoteQueue   = 8192
+	defaultRemoteTimeout = 5000
+	defaultMaxRetries    = 3
+	defaultRetryBackoff  = 200
+)
+
+var (
+	errBatcherDown = errors.New("batcher already shutdown")
+	errQueueFull   = errors.New("queue is full, entry dropped")
+)
+
+// batchSender sends a batch of entries, when it fails the entries
+// should be retried are returned with the error.
+type batchSender func(entries LogEntries) (LogEntries, error)
+
+// batcher collects entries and sends them in batches by the count, the
+// estimated bytes and time, the failed entries will be retried with
+// exponential backoff. The retries block the collecting, so the entries
+// offered meanwhile are dropped once the queue is full.
+type batcher struct {
+	entryC       chan *core.LogEntry
+	entryBuf     LogEntries
+	bufBytes     int
+	batchSize    int
+	batchBytes   int
+	interval     time.Duration
+	maxRetries   int
+	retryBackoff time.Duration
+	send         batchSender
+	ticker       *time.Ticker
+	once         *sync.Once
+	exitC        chan interface{}
+	doneC        chan interface{}
+}
+
+func newBatcher(conf *config.RemoteConfig, send batchSender) (*batcher, error) {
+	conf = settingRemote(conf)
+	batchBytes, err := utils.ParseSize(conf.BatchBytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse batch bytes error")
+	}
+	return &batcher{
+		entryC:       make(chan *core.LogEntry, conf.QueueSize),
+		entryBuf:     make(LogEntries, 0, conf.BatchSize),
+		batchSize:    conf.BatchSize,
+		batchBytes:   batchBytes,
+		interval:     time.Duration(conf.BatchInterval) * time.Millisecond,
+		maxRetries:   *conf.MaxRetries,
+		retryBackoff: time.Duration(conf.RetryBackoff) * time.Millisecond,
+		send:         send,
+		once:         new(sync.Once),
+		exitC:        make(chan interface{}),
+		doneC:        make(chan interface{}),
+	}, nil
+}
+
+// settingRemote returns the copy of conf with the defaults, the conf of
+// the caller is kept as it is.
+func settingRemote(remote *config.RemoteConfig) *config.RemoteConfig {
+	conf := *remote
+	if conf.BatchSize <= 0 {
+		conf.BatchSize = defaultBatchSize
+	}
+	if conf.BatchBytes == "" {
+		conf.BatchBytes = defaultBatchBytes
+	}
+	if conf.BatchInterval <= 0 {
+		conf.BatchInterval = defaultBatchInterval
+	}
+	if conf.QueueSize <= 0 {
+		conf.QueueSize = defaultRemoteQueue
+	}
+	if conf.Timeout <= 0 {
+		conf.Timeout = defaultRemoteTimeout
+	}
+	if conf.MaxRetries == nil {
+		maxRetries := defaultMaxRetries
+		conf.MaxRetries = &maxRetries
+	} else if *conf.MaxRetries < 0 {
+		maxRetries := 0
+		conf.MaxRetries = &maxRetries
+	}
+	if conf.RetryBackoff <= 0 {
+		conf.RetryBackoff = defaultRetryBackoff
+	}
+	return &conf
+}
+
+func (b *batcher) Init() error {
+	b.ticker = time.NewTicker(b.interval)
+	go b.Run()
+	return nil
+}
+
+func (b *batcher) Run() error {
+	defer close(b.doneC)
+	defer b.ticker.Stop()
+
+	for {
+		select {
+		case entry := <-b.entryC:
+			b.add(entry)
+		case <-b.ticker.C:
+			b.flush()
+		case <-b.exitC:
+			b.drain()
+			return nil
+		}
+	}
+}
+
+// Shutdown stops accepting entries and waits util the queued entries sent.
+func (b *batcher) Shutdown() {
+	b.once.Do(func() {
+		close(b.exitC)
+	})
+	<-b.doneC
+}
+
+func (b *batcher) isDown() bool {
+	select {
+	case <-b.exitC:
+		return true
+	default:
+		return false
+	}
+}
+
+func (b *batcher) Offer(entry *core.LogEntry) error {
+	if b.isDown() {
+		return errBatcherDown
+	}
+
+	select {
+	case b.entryC <- entry:
+		return nil
+	default:
+		return errQueueFull
+	}
+}
+
+func (b *batcher) drain() {
+	for {
+		select {
+		case entry := <-b.entryC:
+			b.add(entry)
+		default:
+			b.flush()
+			return
+		}
+	}
+}
+
+// add buffers the entry, the batch is sent before it exceeds the bytes,
+// the entry larger than the bytes is sent alone.
+func (b *batcher) add(entry *core.LogEntry) {
+	size := entry.EstimateSize()
+	if len(b.entryBuf) > 0 && b.bufBytes+size > b.batchBytes {
+		b.flush()
+	}
+	b.entryBuf = append(b.entryBuf, entry)
+	b.bufBytes += size
+	if len(b.entryBuf) >= b.batchSize || b.bufBytes >= b.batchBytes {
+		b.flush()
+	}
+}
+
+func (b *batcher) flush() {
+	if len(b.entryBuf) == 0 {
+		return
+	}
+
+	entries := make(LogEntries, len(b.entryBuf))
+	copy(entries, b.entryBuf)
+	b.entryBuf = b.entryBuf[:0]
+	b.bufBytes = 0
+
+	b.sendWithRetry(entries)
+}
+
+func (b *batcher) sendWithRetry(entries LogEntries) {
+	backoff := b.retryBackoff
+	for i := 0; ; i++ {
+		failed, err := b.send(entries)
+		if err == nil {
+			return
+		}
+		if len(failed) == 0 {
+			opt.GetErrLog().Printf("send batch err: %+v\n", err)
+			return
+		}
+		if i >= b.maxRetries {
+			opt.GetErrLog().Printf("send batch err after %d retries, %d entries dropped: %+v\n",
+				b.maxRetries, len(failed), err)
+			return
+		}
+
+		entries = failed
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
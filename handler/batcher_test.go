@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"fmt"
+	"github.com/edditen/etlog/config"
+	"github.com/edditen/etlog/core"
+	"github.com/pkg/errors"
+	"strings"
+	"testing"
+)
+
+func TestBatcher_Retry(t *testing.T) {
+	retries := func(n int) *int {
+		return &n
+	}
+	tests := []struct {
+		name       string
+		maxRetries *int
+		wantSends  int
+	}{
+		{"when max retries not set then retried by default", nil, defaultMaxRetries + 1},
+		{"when max retries zero then never retried", retries(0), 1},
+		{"when max retries given then retried", retries(1), 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := config.NewRemoteConfig()
+			conf.MaxRetries = tt.maxRetries
+			conf.RetryBackoff = 1
+			sends := 0
+			b, err := newBatcher(conf, func(entries LogEntries) (LogEntries, error) {
+				sends++
+				return entries, errors.New("unavailable")
+			})
+			if err != nil {
+				t.Fatalf("new batcher err: %+v", err)
+			}
+			if err := b.Init(); err != nil {
+				t.Fatalf("init err: %+v", err)
+			}
+			if err := b.Offer(&core.LogEntry{Level: core.INFO, Msg: "a"}); err != nil {
+				t.Fatalf("offer err: %+v", err)
+			}
+			b.Shutdown()
+
+			if sends != tt.wantSends {
+				t.Errorf("sends = %d, want %d", sends, tt.wantSends)
+			}
+			// the defaults are not written back
+			if conf.MaxRetries != tt.maxRetries || conf.BatchSize != 0 || conf.RetryBackoff != 1 {
+				t.Errorf("conf = %+v, want unchanged", conf)
+			}
+		})
+	}
+}
+
+func TestBatcher_BatchBytes(t *testing.T) {
+	entry := func(size int) *core.LogEntry {
+		return &core.LogEntry{Level: core.INFO, Msg: strings.Repeat("x", size)}
+	}
+
+	t.Run("when batch bytes reached then sent before exceeded", func(t *testing.T) {
+		conf := config.NewRemoteConfig()
+		conf.BatchBytes = "1K"
+		conf.BatchInterval = 60 * 1000
+		var batches []int
+		b, err := newBatcher(conf, func(entries LogEntries) (LogEntries, error) {
+			batches = append(batches, len(entries))
+			return nil, nil
+		})
+		if err != nil {
+			t.Fatalf("new batcher err: %+v", err)
+		}
+		if err := b.Init(); err != nil {
+			t.Fatalf("init err: %+v", err)
+		}
+		// two entries of 400 bytes fit in a batch, the one of 2k is sent alone
+		for _, size := range []int{400, 400, 400, 2048, 400} {
+			if err := b.Offer(entry(size)); err != nil {
+				t.Fatalf("offer err: %+v", err)
+			}
+		}
+		b.Shutdown()
+
+		if fmt.Sprint(batches) != "[2 1 1 1]" {
+			t.Errorf("batches = %v, want [2 1 1 1]", batches)
+		}
+	})
+
+	t.Run("when batch bytes invalid then error", func(t *testing.T) {
+		conf := config.NewRemoteConfig()
+		conf.BatchBytes = "many"
+		if _, err := newBatcher(conf, nil); err == nil {
+			t.Errorf("want error")
+		}
+	})
+}
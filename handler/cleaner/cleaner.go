@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/edditen/etlog/common/runnable"
 	"github.com/edditen/etlog/common/utils"
+	"github.com/edditen/etlog/handler/naming"
 	"github.com/edditen/etlog/opt"
 	"github.com/pkg/errors"
 	"math"
@@ -30,16 +31,20 @@ type FileInfo struct {
 	FileDir    string
 	Filename   string
 	BackupTime time.Time
+	Seq        int
+	Size       int64
 }
 
 func (fi FileInfo) String() string {
-	return fmt.Sprintf("{dir:%s, name:%s, time:%v}",
-		fi.FileDir, fi.Filename, fi.BackupTime)
+	return fmt.Sprintf("{dir:%s, name:%s, time:%v, seq:%d, size:%d}",
+		fi.FileDir, fi.Filename, fi.BackupTime, fi.Seq, fi.Size)
 }
 
 type Cleaner interface {
 	runnable.Runnable
-	Clean() error
+	Clean() (*CleanReport, error)
+	// RemoveOldest removes the oldest backup file, returns false if no backup.
+	RemoveOldest() (FileInfo, bool, error)
 }
 
 type Option func(*LogCleaner) error
@@ -48,9 +53,20 @@ type LogCleaner struct {
 	backupDir      string
 	backupBaseName string
 	backupExt      string
+	backupExts     []string
+	archiveDir     string
+	sidecarExts    []string
+	removableMark  string
+	tiers          []Tier
+	dryRun         bool
 	backupCount    int
 	backupDuration time.Duration
 	checkInterval  time.Duration
+	maxTotalSize   int64
+	activeFile     string
+	naming         *naming.Template
+	fileExt        string
+	matchers       []*naming.Matcher
 	mutex          *sync.Mutex
 	ticker         *time.Ticker
 	exitC          chan interface{}
@@ -74,6 +90,19 @@ func NewLogCleaner(backupDir, backupBaseName string, options ...Option) (*LogCle
 		}
 	}
 
+	if fc.naming != nil {
+		if len(fc.backupExts) == 0 {
+			fc.backupExts = []string{fc.backupExt}
+		}
+		for _, ext := range fc.backupExts {
+			matcher, err := fc.naming.Matcher(backupBaseName, fc.fileExt, ext)
+			if err != nil {
+				return nil, errors.Wrap(err, "create backup name matcher error")
+			}
+			fc.matchers = append(fc.matchers, matcher)
+		}
+	}
+
 	return fc, nil
 
 }
@@ -89,96 +118,153 @@ func (lc *LogCleaner) Run() error {
 	for {
 		select {
 		case <-lc.ticker.C:
-			_ = lc.Clean()
+			if _, err := lc.Clean(); err != nil {
+				opt.GetErrLog().Printf("clean backup files err: %+v\n", err)
+			}
 		case <-lc.exitC:
-			break
+			return nil
 		}
 	}
-	return nil
 }
 
 func (lc *LogCleaner) Shutdown() {
 	close(lc.exitC)
 }
 
-func (lc *LogCleaner) Clean() error {
+// Clean removes the backups by the retention, and reports the removed.
+func (lc *LogCleaner) Clean() (*CleanReport, error) {
 	lc.mutex.Lock()
 	defer lc.mutex.Unlock()
 
-	cleanFiles, required := lc.shouldClean()
-	if !required {
-		return nil
+	report := &CleanReport{DryRun: lc.dryRun}
+	removals, kept := lc.shouldClean()
+	report.Kept = kept
+	if len(removals) == 0 {
+		return report, nil
 	}
 
-	if err := lc.removeFiles(cleanFiles); err != nil {
-		return errors.Wrap(err, "remove file error")
+	if lc.dryRun {
+		report.Removed = removals
+		for _, r := range removals {
+			opt.GetErrLog().Printf("[DryRun] would remove %s\n", r)
+		}
+		return report, nil
 	}
-	return nil
+
+	for _, r := range removals {
+		if err := lc.removeFiles([]FileInfo{r.FileInfo}); err != nil {
+			report.Kept += len(removals) - len(report.Removed)
+			return report, errors.Wrap(err, "remove file error")
+		}
+		report.Removed = append(report.Removed, r)
+	}
+	return report, nil
 }
 
 func (lc *LogCleaner) removeFiles(files []FileInfo) error {
 	for _, f := range files {
-		if err := os.Remove(path.Join(f.FileDir, f.Filename)); err != nil {
+		filePath := path.Join(f.FileDir, f.Filename)
+		if err := os.Remove(filePath); err != nil {
 			return err
 		}
+		for _, ext := range lc.sidecarExts {
+			if err := os.Remove(filePath + ext); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
 	}
 
 	return nil
 }
 
-func (lc *LogCleaner) shouldClean() (files []FileInfo, required bool) {
-	files = make([]FileInfo, 0)
-	required = false
+// shouldClean returns the backups to remove with the reasons,
+// and the count of backups kept.
+func (lc *LogCleaner) shouldClean() ([]Removal, int) {
+	removals := make([]Removal, 0)
 
 	matchedFiles := lc.listBackupFiles()
 	if len(matchedFiles) == 0 {
-		return
+		return removals, 0
 	}
 
 	expiredFiles := lc.expiredFiles(matchedFiles)
-	if len(expiredFiles) > 0 {
-		files = append(files, expiredFiles...)
-		required = true
-	}
-
+	removals = append(removals, withReason(expiredFiles, "older than backup time")...)
 	remains := lc.deduplicateByFilename(matchedFiles, expiredFiles)
 
+	tierRemovals := lc.outOfTiers(remains, time.Now())
+	removals = append(removals, tierRemovals...)
+	remains = lc.deduplicateByFilename(remains, fileInfos(tierRemovals))
+
 	cleanFiles := lc.gtBackupLimit(remains)
+	removals = append(removals, withReason(cleanFiles, "over backup count")...)
+	remains = lc.deduplicateByFilename(remains, cleanFiles)
 
-	if len(cleanFiles) > 0 {
-		files = append(files, cleanFiles...)
-		required = true
+	oversizeFiles := lc.gtTotalSize(remains, lc.activeFileSize())
+	removals = append(removals, withReason(oversizeFiles, "over max total size")...)
+
+	removals = lc.removable(removals)
+	return removals, len(matchedFiles) - len(removals)
+}
+
+// removable filters the backups with the removable mark, all the backups
+// are removable if no mark required.
+func (lc *LogCleaner) removable(removals []Removal) []Removal {
+	if lc.removableMark == "" {
+		return removals
 	}
+	filtered := make([]Removal, 0, len(removals))
+	for _, r := range removals {
+		if _, err := os.Stat(path.Join(r.FileDir, r.Filename) + lc.removableMark); err == nil {
+			filtered = append(filtered, r)
+		}
+	}
+	return filtered
+}
+
+// RemoveOldest removes the oldest backup file.
+func (lc *LogCleaner) RemoveOldest() (FileInfo, bool, error) {
+	lc.mutex.Lock()
+	defer lc.mutex.Unlock()
 
-	return files, required
+	files := lc.listBackupFiles()
+	sortByBackupTime(files)
+	files = fileInfos(lc.removable(withReason(files, "")))
+	if len(files) == 0 {
+		return FileInfo{}, false, nil
+	}
+	if err := lc.removeFiles(files[:1]); err != nil {
+		return files[0], false, errors.Wrap(err, "remove file error")
+	}
+	return files[0], true, nil
 }
 
 func (lc *LogCleaner) listBackupFiles() []FileInfo {
-	matchedFiles := make([]FileInfo, 0)
-	err := filepath.Walk(lc.backupDir,
+	matchedFiles := lc.walkBackupFiles(lc.backupDir, make([]FileInfo, 0))
+	if lc.archiveDir != "" && !isSubDir(lc.backupDir, lc.archiveDir) {
+		matchedFiles = lc.walkBackupFiles(lc.archiveDir, matchedFiles)
+	}
+	return matchedFiles
+}
+
+func isSubDir(dir, sub string) bool {
+	rel, err := filepath.Rel(dir, sub)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func (lc *LogCleaner) walkBackupFiles(dir string, matchedFiles []FileInfo) []FileInfo {
+	err := filepath.Walk(dir,
 		func(filePath string, info os.FileInfo, err error) error {
 			if err != nil {
 				return err
 			}
 
 			filename := info.Name()
-			if filename == lc.backupBaseName {
-				return nil
-			}
-
-			if !strings.HasPrefix(filename, lc.backupBaseName) ||
-				!strings.HasSuffix(filename, lc.backupExt) {
-				return nil
-			}
-
-			var ts string
-			var matched bool
-			if ts, matched = utils.GetFirstMatchedString(defaultTimePattern, filename); !matched {
+			if info.IsDir() || filename == lc.backupBaseName {
 				return nil
 			}
 
-			var backupTime time.Time
-			if backupTime, err = time.Parse(defaultTimeFormat, ts); err != nil {
+			backupTime, seq, matched := lc.matchBackupFile(filename)
+			if !matched {
 				return nil
 			}
 
@@ -186,6 +272,8 @@ func (lc *LogCleaner) listBackupFiles() []FileInfo {
 				FileDir:    path.Dir(filePath),
 				Filename:   filename,
 				BackupTime: backupTime,
+				Seq:        seq,
+				Size:       info.Size(),
 			})
 
 			return nil
@@ -197,6 +285,35 @@ func (lc *LogCleaner) listBackupFiles() []FileInfo {
 	return matchedFiles
 }
 
+// matchBackupFile returns the backup time and sequence of the file,
+// by the naming template if set, or the default time pattern.
+func (lc *LogCleaner) matchBackupFile(filename string) (time.Time, int, bool) {
+	if len(lc.matchers) > 0 {
+		for _, matcher := range lc.matchers {
+			if backupTime, seq, ok := matcher.Match(filename); ok {
+				return backupTime, seq, true
+			}
+		}
+		return time.Time{}, 0, false
+	}
+
+	if !strings.HasPrefix(filename, lc.backupBaseName) ||
+		!strings.HasSuffix(filename, lc.backupExt) {
+		return time.Time{}, 0, false
+	}
+
+	ts, matched := utils.GetFirstMatchedString(defaultTimePattern, filename)
+	if !matched {
+		return time.Time{}, 0, false
+	}
+
+	backupTime, err := time.Parse(defaultTimeFormat, ts)
+	if err != nil {
+		return time.Time{}, 0, false
+	}
+	return backupTime, 0, true
+}
+
 func (lc *LogCleaner) expiredFiles(files []FileInfo) []FileInfo {
 	expired := make([]FileInfo, 0)
 	now := time.Now()
@@ -215,14 +332,55 @@ func (lc *LogCleaner) gtBackupLimit(files []FileInfo) []FileInfo {
 		return make([]FileInfo, 0)
 	}
 
-	sort.Slice(files, func(i, j int) bool {
-		return files[i].BackupTime.Before(files[j].BackupTime)
-	})
+	sortByBackupTime(files)
 
 	removeCount := len(files) - lc.backupCount
 	return files[:removeCount]
 }
 
+// gtTotalSize greater than the max total size of the active and backup files,
+// then the oldest files exceeded will be returned
+func (lc *LogCleaner) gtTotalSize(files []FileInfo, activeSize int64) []FileInfo {
+	if lc.maxTotalSize <= 0 {
+		return make([]FileInfo, 0)
+	}
+
+	total := activeSize
+	for _, f := range files {
+		total += f.Size
+	}
+
+	sortByBackupTime(files)
+
+	removeCount := 0
+	for removeCount < len(files) && total > lc.maxTotalSize {
+		total -= files[removeCount].Size
+		removeCount++
+	}
+	return files[:removeCount]
+}
+
+func (lc *LogCleaner) activeFileSize() int64 {
+	if lc.activeFile == "" {
+		return 0
+	}
+	info, err := os.Stat(lc.activeFile)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// sortByBackupTime sorts from the oldest
+func sortByBackupTime(files []FileInfo) {
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].BackupTime.Equal(files[j].BackupTime) {
+			return files[i].Seq < files[j].Seq
+		}
+		return files[i].BackupTime.Before(files[j].BackupTime)
+	})
+}
+
 func (lc *LogCleaner) deduplicateByFilename(fullList, sublist []FileInfo) []FileInfo {
 	if len(fullList) == 0 || len(sublist) == 0 {
 		return fullList
@@ -256,6 +414,41 @@ func SetBackupExt(backupExt string) Option {
 	}
 }
 
+// SetBackupExts recognizes the backup files with any of the extensions,
+// such as the extensions of all archive formats, it works with SetNaming.
+func SetBackupExts(backupExts ...string) Option {
+	return func(cleaner *LogCleaner) error {
+		cleaner.backupExts = backupExts
+		return nil
+	}
+}
+
+// SetArchiveDir cleans the archive dir too, if it is not in the backup dir.
+func SetArchiveDir(archiveDir string) Option {
+	return func(cleaner *LogCleaner) error {
+		cleaner.archiveDir = archiveDir
+		return nil
+	}
+}
+
+// SetSidecarExts removes the sidecar files together with the backup,
+// such as the manifest of archive.
+func SetSidecarExts(exts ...string) Option {
+	return func(cleaner *LogCleaner) error {
+		cleaner.sidecarExts = exts
+		return nil
+	}
+}
+
+// SetRemovableMark removes only the backups with the mark file of the
+// extension, such as the uploaded mark.
+func SetRemovableMark(ext string) Option {
+	return func(cleaner *LogCleaner) error {
+		cleaner.removableMark = ext
+		return nil
+	}
+}
+
 func SetCheckInterval(interval time.Duration) Option {
 	return func(cleaner *LogCleaner) error {
 		cleaner.checkInterval = interval
@@ -269,3 +462,23 @@ func SetBackupDuration(duration time.Duration) Option {
 		return nil
 	}
 }
+
+// SetMaxTotalSize limits the total size of the active file and backup files,
+// activeFile is the path of the file being written.
+func SetMaxTotalSize(maxTotalSize int64, activeFile string) Option {
+	return func(cleaner *LogCleaner) error {
+		cleaner.maxTotalSize = maxTotalSize
+		cleaner.activeFile = activeFile
+		return nil
+	}
+}
+
+// SetNaming matches the backup files by the naming template,
+// fileExt is the extension of log file, such as ".log".
+func SetNaming(template *naming.Template, fileExt string) Option {
+	return func(cleaner *LogCleaner) error {
+		cleaner.naming = template
+		cleaner.fileExt = fileExt
+		return nil
+	}
+}
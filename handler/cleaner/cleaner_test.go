@@ -1,6 +1,9 @@
 package cleaner
 
 import (
+	"github.com/edditen/etlog/handler/naming"
+	"os"
+	"path"
 	"reflect"
 	"sync"
 	"testing"
@@ -295,3 +298,150 @@ func TestBaseName(t *testing.T) {
 		}
 	})
 }
+
+func TestLogCleaner_listBackupFiles(t *testing.T) {
+	t.Run("when naming template then match by template", func(t *testing.T) {
+		dir := t.TempDir()
+		for _, name := range []string{
+			"info.log",
+			"info-2021-06-22-0.log.zip",
+			"info-2021-06-22-1.log.zip",
+			"info-2021-06-23-0.log",
+			"info.2021-06-22.223730.log.zip",
+			"error-2021-06-22-0.log.zip",
+		} {
+			if err := os.WriteFile(path.Join(dir, name), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		tmpl, err := naming.ParseTemplate("{name}-{date:2006-01-02}-{seq}{ext}")
+		if err != nil {
+			t.Fatal(err)
+		}
+		lc, err := NewLogCleaner(dir, "info", SetNaming(tmpl, ".log"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		day := time.Date(2021, 6, 22, 0, 0, 0, 0, time.Local)
+		want := []FileInfo{
+			{FileDir: dir, Filename: "info-2021-06-22-0.log.zip", BackupTime: day, Seq: 0},
+			{FileDir: dir, Filename: "info-2021-06-22-1.log.zip", BackupTime: day, Seq: 1},
+		}
+		if got := lc.listBackupFiles(); !reflect.DeepEqual(got, want) {
+			t.Errorf("listBackupFiles() = %v, want %v", got, want)
+		}
+	})
+}
+
+func TestFileCleaner_gtTotalSize(t *testing.T) {
+	now := time.Now()
+	files := func() []FileInfo {
+		return []FileInfo{
+			{BackupTime: now, Size: 10},
+			{BackupTime: now.Add(-10 * time.Second), Size: 10},
+			{BackupTime: now.Add(-20 * time.Second), Size: 10},
+		}
+	}
+	tests := []struct {
+		name         string
+		maxTotalSize int64
+		activeSize   int64
+		want         []FileInfo
+	}{
+		{
+			name:         "when no limit then return empty",
+			maxTotalSize: 0,
+			activeSize:   100,
+			want:         []FileInfo{},
+		},
+		{
+			name:         "when not greater than limit then return empty",
+			maxTotalSize: 40,
+			activeSize:   10,
+			want:         []FileInfo{},
+		},
+		{
+			name:         "when greater than limit then return oldest files",
+			maxTotalSize: 30,
+			activeSize:   15,
+			want: []FileInfo{
+				{BackupTime: now.Add(-20 * time.Second), Size: 10},
+				{BackupTime: now.Add(-10 * time.Second), Size: 10},
+			},
+		},
+		{
+			name:         "when active file exceeds limit then return all",
+			maxTotalSize: 30,
+			activeSize:   50,
+			want:         files()[:3],
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := &LogCleaner{maxTotalSize: tt.maxTotalSize}
+			got := fc.gtTotalSize(files(), tt.activeSize)
+			sortByBackupTime(tt.want)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("gtTotalSize() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogCleaner_RemoveOldest(t *testing.T) {
+	t.Run("when backups then remove the oldest", func(t *testing.T) {
+		dir := t.TempDir()
+		for _, name := range []string{"info.2021-06-22.223730.log.zip", "info.2021-06-21.223730.log.zip"} {
+			if err := os.WriteFile(path.Join(dir, name), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		lc, _ := NewLogCleaner(dir, "info")
+
+		removed, ok, err := lc.RemoveOldest()
+		if err != nil || !ok || removed.Filename != "info.2021-06-21.223730.log.zip" {
+			t.Errorf("RemoveOldest() = %v, %v, %v", removed, ok, err)
+		}
+		_, _, _ = lc.RemoveOldest()
+		if _, ok, _ := lc.RemoveOldest(); ok {
+			t.Errorf("RemoveOldest() want no backup")
+		}
+	})
+	t.Run("when removable mark then skip the unmarked", func(t *testing.T) {
+		dir := t.TempDir()
+		for _, name := range []string{"info.2021-06-21.223730.log.zip", "info.2021-06-22.223730.log.zip",
+			"info.2021-06-22.223730.log.zip.uploaded"} {
+			if err := os.WriteFile(path.Join(dir, name), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		lc, _ := NewLogCleaner(dir, "info", SetRemovableMark(".uploaded"), SetBackupCount(0))
+
+		removed, ok, err := lc.RemoveOldest()
+		if err != nil || !ok || removed.Filename != "info.2021-06-22.223730.log.zip" {
+			t.Errorf("RemoveOldest() = %v, %v, %v", removed, ok, err)
+		}
+		if removals, kept := lc.shouldClean(); len(removals) != 0 || kept != 1 {
+			t.Errorf("shouldClean() = %v, %d, want not uploaded kept", removals, kept)
+		}
+	})
+
+	t.Run("when sidecar exts then remove together", func(t *testing.T) {
+		dir := t.TempDir()
+		for _, name := range []string{"info.2021-06-21.223730.log.zip", "info.2021-06-21.223730.log.zip.manifest"} {
+			if err := os.WriteFile(path.Join(dir, name), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		lc, _ := NewLogCleaner(dir, "info", SetSidecarExts(".manifest"))
+
+		if _, ok, err := lc.RemoveOldest(); err != nil || !ok {
+			t.Fatalf("RemoveOldest() = %v, %v", ok, err)
+		}
+		if files, _ := os.ReadDir(dir); len(files) != 0 {
+			t.Errorf("files left: %v", files)
+		}
+	})
+}
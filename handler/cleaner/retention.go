@@ -0,0 +1,127 @@
+package cleaner
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"sort"
+	"time"
+)
+
+// Tier keeps one backup per Every for the backups younger than Keep,
+// all the backups are kept if Every is 0. Such as the tiers
+// {0, 1d}, {1h, 7d}, {1d, 90d} keep all backups for 1 day, one per hour
+// for 7 days, one per day for 90 days, and delete the older.
+type Tier struct {
+	Every time.Duration
+	Keep  time.Duration
+}
+
+func (t Tier) String() string {
+	if t.Every <= 0 {
+		return fmt.Sprintf("all for %v", t.Keep)
+	}
+	return fmt.Sprintf("one per %v for %v", t.Every, t.Keep)
+}
+
+// Removal the backup removed and the reason.
+type Removal struct {
+	FileInfo
+	Reason string
+}
+
+func (r Removal) String() string {
+	return fmt.Sprintf("%s: %s", r.Filename, r.Reason)
+}
+
+// CleanReport the backups removed by Clean, or to be removed in dry run.
+type CleanReport struct {
+	DryRun  bool
+	Removed []Removal
+	// Kept the count of backups kept
+	Kept int
+}
+
+// outOfTiers returns the backups not kept by any tier, the oldest backup
+// of each period is kept, so a kept backup stays kept when it ages into
+// the next tier of the longer period.
+func (lc *LogCleaner) outOfTiers(files []FileInfo, now time.Time) []Removal {
+	removals := make([]Removal, 0)
+	if len(lc.tiers) == 0 {
+		return removals
+	}
+
+	sortByBackupTime(files)
+	last := lc.tiers[len(lc.tiers)-1]
+	kept := make(map[int]map[int64]bool, len(lc.tiers))
+	for _, f := range files {
+		age := now.Sub(f.BackupTime)
+		i := sort.Search(len(lc.tiers), func(i int) bool { return age <= lc.tiers[i].Keep })
+		if i == len(lc.tiers) {
+			removals = append(removals, Removal{f, fmt.Sprintf("older than retention %v", last.Keep)})
+			continue
+		}
+
+		tier := lc.tiers[i]
+		if tier.Every <= 0 {
+			continue
+		}
+		if kept[i] == nil {
+			kept[i] = make(map[int64]bool)
+		}
+		period := periodOf(f.BackupTime, tier.Every)
+		if kept[i][period] {
+			removals = append(removals, Removal{f, fmt.Sprintf("thinned by retention %v", tier)})
+			continue
+		}
+		kept[i][period] = true
+	}
+	return removals
+}
+
+// periodOf the index of the period in local time, so the daily periods
+// start from the local midnight.
+func periodOf(t time.Time, every time.Duration) int64 {
+	_, offset := t.Zone()
+	return (t.Unix() + int64(offset)) / int64(every/time.Second)
+}
+
+func withReason(files []FileInfo, reason string) []Removal {
+	removals := make([]Removal, 0, len(files))
+	for _, f := range files {
+		removals = append(removals, Removal{f, reason})
+	}
+	return removals
+}
+
+func fileInfos(removals []Removal) []FileInfo {
+	files := make([]FileInfo, 0, len(removals))
+	for _, r := range removals {
+		files = append(files, r.FileInfo)
+	}
+	return files
+}
+
+// SetTiers sets the retention tiers, the backups older than the longest
+// tier are deleted.
+func SetTiers(tiers ...Tier) Option {
+	return func(cleaner *LogCleaner) error {
+		sorted := append([]Tier(nil), tiers...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i].Keep < sorted[j].Keep })
+		for _, t := range sorted {
+			if t.Keep <= 0 || t.Every < 0 || (t.Every > 0 && t.Every < time.Second) {
+				return errors.Errorf("invalid retention tier: %v", t)
+			}
+		}
+		cleaner.tiers = sorted
+		return nil
+	}
+}
+
+// SetDryRun lists the backups to be removed by Clean without removing,
+// the oldest backup is still removed by RemoveOldest.
+func SetDryRun(dryRun bool) Option {
+	return func(cleaner *LogCleaner) error {
+		cleaner.dryRun = dryRun
+		return nil
+	}
+}
@@ -0,0 +1,117 @@
+package cleaner
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogCleaner_outOfTiers(t *testing.T) {
+	now := time.Date(2021, 6, 30, 12, 30, 0, 0, time.Local)
+	ago := func(d time.Duration) FileInfo {
+		bt := now.Add(-d)
+		return FileInfo{Filename: bt.Format("2006-01-02.150405"), BackupTime: bt}
+	}
+	tiers := []Tier{
+		{Every: time.Hour, Keep: 7 * 24 * time.Hour},
+		{Every: 0, Keep: 24 * time.Hour},
+		{Every: 24 * time.Hour, Keep: 90 * 24 * time.Hour},
+	}
+
+	tests := []struct {
+		name        string
+		files       []FileInfo
+		wantRemoved []string
+		wantReason  string
+	}{
+		{
+			name:  "when within keep all tier then keep all",
+			files: []FileInfo{ago(time.Minute), ago(2 * time.Minute), ago(3 * time.Minute)},
+		},
+		{
+			name: "when within hourly tier then keep the oldest of each hour",
+			// 2 days ago at 12:10, 12:20, 12:25, and 13:10
+			files: []FileInfo{ago(48*time.Hour + 20*time.Minute), ago(48*time.Hour + 10*time.Minute),
+				ago(48*time.Hour + 5*time.Minute), ago(47*time.Hour + 20*time.Minute)},
+			wantRemoved: []string{ago(48*time.Hour + 10*time.Minute).Filename, ago(48*time.Hour + 5*time.Minute).Filename},
+			wantReason:  "thinned by retention one per 1h0m0s",
+		},
+		{
+			name:        "when within daily tier then keep the oldest of each day",
+			files:       []FileInfo{ago(30*24*time.Hour + 2*time.Hour), ago(30*24*time.Hour + time.Hour)},
+			wantRemoved: []string{ago(30*24*time.Hour + time.Hour).Filename},
+			wantReason:  "thinned by retention one per 24h0m0s",
+		},
+		{
+			name:        "when older than the last tier then remove",
+			files:       []FileInfo{ago(91 * 24 * time.Hour)},
+			wantRemoved: []string{ago(91 * 24 * time.Hour).Filename},
+			wantReason:  "older than retention",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lc, err := NewLogCleaner("", "info", SetTiers(tiers...))
+			if err != nil {
+				t.Fatalf("new cleaner err: %+v", err)
+			}
+			removals := lc.outOfTiers(tt.files, now)
+			if len(removals) != len(tt.wantRemoved) {
+				t.Fatalf("outOfTiers() = %v, want %v", removals, tt.wantRemoved)
+			}
+			for i, r := range removals {
+				if r.Filename != tt.wantRemoved[i] || !strings.HasPrefix(r.Reason, tt.wantReason) {
+					t.Errorf("outOfTiers()[%d] = %v, want %s: %s", i, r, tt.wantRemoved[i], tt.wantReason)
+				}
+			}
+		})
+	}
+
+	t.Run("when invalid tier then error", func(t *testing.T) {
+		if _, err := NewLogCleaner("", "info", SetTiers(Tier{Every: time.Hour})); err == nil {
+			t.Errorf("want error")
+		}
+	})
+}
+
+func TestLogCleaner_Clean(t *testing.T) {
+	newBackups := func(t *testing.T) string {
+		dir := t.TempDir()
+		for _, name := range []string{"info.2021-06-20.223730.log.zip", "info.2021-06-21.223730.log.zip",
+			"info.2021-06-22.223730.log.zip"} {
+			if err := os.WriteFile(path.Join(dir, name), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return dir
+	}
+
+	t.Run("when clean then report removed", func(t *testing.T) {
+		dir := newBackups(t)
+		lc, _ := NewLogCleaner(dir, "info", SetBackupCount(1))
+		report, err := lc.Clean()
+		if err != nil || report.DryRun || len(report.Removed) != 2 || report.Kept != 1 {
+			t.Fatalf("Clean() = %+v, %v", report, err)
+		}
+		if report.Removed[0].Reason != "over backup count" {
+			t.Errorf("reason = %s", report.Removed[0].Reason)
+		}
+		if files, _ := os.ReadDir(dir); len(files) != 1 {
+			t.Errorf("files left: %v", files)
+		}
+	})
+
+	t.Run("when dry run then report without removing", func(t *testing.T) {
+		dir := newBackups(t)
+		lc, _ := NewLogCleaner(dir, "info", SetBackupCount(1), SetDryRun(true))
+		report, err := lc.Clean()
+		if err != nil || !report.DryRun || len(report.Removed) != 2 || report.Kept != 1 {
+			t.Fatalf("Clean() = %+v, %v", report, err)
+		}
+		if files, _ := os.ReadDir(dir); len(files) != 3 {
+			t.Errorf("files removed in dry run: %v", files)
+		}
+	})
+}
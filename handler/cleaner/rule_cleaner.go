@@ -0,0 +1,260 @@
+package cleaner
+
+import (
+	"fmt"
+	"github.com/edditen/etlog/opt"
+	"github.com/pkg/errors"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// Rule selects a family of files by the glob pattern, the time of file is
+// extracted by TimeRegex and TimeLayout, or the modified time if no
+// TimeRegex. The limits of 0 mean no limit.
+type Rule struct {
+	Name    string
+	Pattern string
+	// TimeRegex the first submatch, or the whole match if no group, is parsed
+	// by TimeLayout in local time, the files not matched are skipped
+	TimeRegex  *regexp.Regexp
+	TimeLayout string
+	MaxAge     time.Duration
+	MaxCount   int
+	MaxSize    int64
+}
+
+func (r *Rule) String() string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return r.Pattern
+}
+
+// files lists the files matched by the rule, with the time and size.
+func (r *Rule) files() ([]FileInfo, error) {
+	matches, err := filepath.Glob(r.Pattern)
+	if err != nil {
+		return nil, errors.Wrapf(err, "glob %s error", r.Pattern)
+	}
+
+	files := make([]FileInfo, 0, len(matches))
+	for _, match := range matches {
+		info, err := os.Lstat(match)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		fileTime, ok := r.fileTime(info)
+		if !ok {
+			continue
+		}
+		files = append(files, FileInfo{
+			FileDir:    path.Dir(match),
+			Filename:   info.Name(),
+			BackupTime: fileTime,
+			Size:       info.Size(),
+		})
+	}
+	return files, nil
+}
+
+func (r *Rule) fileTime(info os.FileInfo) (time.Time, bool) {
+	if r.TimeRegex == nil {
+		return info.ModTime(), true
+	}
+
+	match := r.TimeRegex.FindStringSubmatch(info.Name())
+	if match == nil {
+		return time.Time{}, false
+	}
+	ts := match[0]
+	if len(match) > 1 {
+		ts = match[1]
+	}
+	fileTime, err := time.ParseInLocation(r.TimeLayout, ts, time.Local)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return fileTime, true
+}
+
+// removals returns the files to remove by max age, then max count and
+// max size keeping the newest.
+func (r *Rule) removals(files []FileInfo, now time.Time) []Removal {
+	removals := make([]Removal, 0)
+	sortByBackupTime(files)
+
+	remains := files[:0:0]
+	for _, f := range files {
+		if r.MaxAge > 0 && now.Sub(f.BackupTime) > r.MaxAge {
+			removals = append(removals, Removal{f, fmt.Sprintf("rule %s, older than %v", r, r.MaxAge)})
+			continue
+		}
+		remains = append(remains, f)
+	}
+
+	if r.MaxCount > 0 && len(remains) > r.MaxCount {
+		over := len(remains) - r.MaxCount
+		removals = append(removals, withReason(remains[:over], fmt.Sprintf("rule %s, over count %d", r, r.MaxCount))...)
+		remains = remains[over:]
+	}
+
+	if r.MaxSize > 0 {
+		var total int64
+		for _, f := range remains {
+			total += f.Size
+		}
+		over := 0
+		for over < len(remains) && total > r.MaxSize {
+			total -= remains[over].Size
+			over++
+		}
+		removals = append(removals, withReason(remains[:over], fmt.Sprintf("rule %s, over size %d", r, r.MaxSize))...)
+	}
+	return removals
+}
+
+type RuleOption func(*RuleCleaner) error
+
+// RuleCleaner cleans the files of any tools by the rules, such as heap dumps,
+// core files and the logs rotated by other tools.
+type RuleCleaner struct {
+	rules         []*Rule
+	checkInterval time.Duration
+	dryRun        bool
+	mutex         *sync.Mutex
+	ticker        *time.Ticker
+	exitC         chan interface{}
+}
+
+func NewRuleCleaner(rules []*Rule, options ...RuleOption) (*RuleCleaner, error) {
+	rc := &RuleCleaner{
+		rules:         rules,
+		checkInterval: defaultCheckInterval,
+		mutex:         new(sync.Mutex),
+		exitC:         make(chan interface{}),
+	}
+
+	for _, rule := range rules {
+		if rule.Pattern == "" {
+			return nil, errors.Errorf("pattern of rule %s is empty", rule)
+		}
+		if _, err := filepath.Match(rule.Pattern, ""); err != nil {
+			return nil, errors.Wrapf(err, "invalid pattern of rule %s", rule)
+		}
+		if rule.TimeRegex != nil && rule.TimeLayout == "" {
+			return nil, errors.Errorf("time layout of rule %s is empty", rule)
+		}
+	}
+
+	for _, option := range options {
+		if err := option(rc); err != nil {
+			return nil, err
+		}
+	}
+	return rc, nil
+}
+
+func (rc *RuleCleaner) Init() error {
+	rc.ticker = time.NewTicker(rc.checkInterval)
+	go rc.Run()
+	return nil
+}
+
+func (rc *RuleCleaner) Run() error {
+	defer rc.ticker.Stop()
+	for {
+		select {
+		case <-rc.ticker.C:
+			if _, err := rc.Clean(); err != nil {
+				opt.GetErrLog().Printf("clean files err: %+v\n", err)
+			}
+		case <-rc.exitC:
+			return nil
+		}
+	}
+}
+
+func (rc *RuleCleaner) Shutdown() {
+	close(rc.exitC)
+}
+
+// Clean removes the files by all the rules, the file matched by several
+// rules is removed once.
+func (rc *RuleCleaner) Clean() (*CleanReport, error) {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+
+	report := &CleanReport{DryRun: rc.dryRun}
+	seen := make(map[string]bool)
+	now := time.Now()
+	for _, rule := range rc.rules {
+		files, err := rule.files()
+		if err != nil {
+			return report, err
+		}
+
+		removals := rule.removals(files, now)
+		report.Kept += len(files) - len(removals)
+		for _, r := range removals {
+			filePath := path.Join(r.FileDir, r.Filename)
+			if seen[filePath] {
+				continue
+			}
+			seen[filePath] = true
+
+			if rc.dryRun {
+				opt.GetErrLog().Printf("[DryRun] would remove %s\n", r)
+			} else if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+				return report, errors.Wrap(err, "remove file error")
+			}
+			report.Removed = append(report.Removed, r)
+		}
+	}
+	return report, nil
+}
+
+// RemoveOldest removes the oldest file of all the rules.
+func (rc *RuleCleaner) RemoveOldest() (FileInfo, bool, error) {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+
+	all := make([]FileInfo, 0)
+	for _, rule := range rc.rules {
+		files, err := rule.files()
+		if err != nil {
+			return FileInfo{}, false, err
+		}
+		all = append(all, files...)
+	}
+	if len(all) == 0 {
+		return FileInfo{}, false, nil
+	}
+
+	sortByBackupTime(all)
+	if err := os.Remove(path.Join(all[0].FileDir, all[0].Filename)); err != nil {
+		return all[0], false, errors.Wrap(err, "remove file error")
+	}
+	return all[0], true, nil
+}
+
+func SetRuleCheckInterval(interval time.Duration) RuleOption {
+	return func(cleaner *RuleCleaner) error {
+		if interval <= 0 {
+			return errors.Errorf("invalid check interval: %v", interval)
+		}
+		cleaner.checkInterval = interval
+		return nil
+	}
+}
+
+// SetRuleDryRun lists the files to be removed by Clean without removing.
+func SetRuleDryRun(dryRun bool) RuleOption {
+	return func(cleaner *RuleCleaner) error {
+		cleaner.dryRun = dryRun
+		return nil
+	}
+}
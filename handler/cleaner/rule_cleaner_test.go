@@ -0,0 +1,134 @@
+package cleaner
+
+import (
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestRuleCleaner_Clean(t *testing.T) {
+	newFiles := func(t *testing.T, files map[string]time.Duration) string {
+		dir := t.TempDir()
+		for name, age := range files {
+			filePath := path.Join(dir, name)
+			if err := os.WriteFile(filePath, make([]byte, 10), 0644); err != nil {
+				t.Fatal(err)
+			}
+			mtime := time.Now().Add(-age)
+			_ = os.Chtimes(filePath, mtime, mtime)
+		}
+		return dir
+	}
+	remains := func(dir string) []string {
+		files, _ := os.ReadDir(dir)
+		names := make([]string, 0, len(files))
+		for _, f := range files {
+			names = append(names, f.Name())
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	tests := []struct {
+		name    string
+		files   map[string]time.Duration
+		rule    func(dir string) *Rule
+		want    []string
+		removed int
+	}{
+		{
+			name:  "when max age by mtime then remove the old",
+			files: map[string]time.Duration{"core.1": 48 * time.Hour, "core.2": time.Hour, "keep.txt": 48 * time.Hour},
+			rule: func(dir string) *Rule {
+				return &Rule{Pattern: path.Join(dir, "core.*"), MaxAge: 24 * time.Hour}
+			},
+			want:    []string{"core.2", "keep.txt"},
+			removed: 1,
+		},
+		{
+			name: "when time regex then order by the name",
+			files: map[string]time.Duration{"heap-20210103.hprof": 0, "heap-20210101.hprof": 0,
+				"heap-20210102.hprof": 0, "heap-latest.hprof": 0},
+			rule: func(dir string) *Rule {
+				return &Rule{Pattern: path.Join(dir, "heap-*.hprof"), MaxCount: 1,
+					TimeRegex: regexp.MustCompile(`heap-(\d{8})`), TimeLayout: "20060102"}
+			},
+			want:    []string{"heap-20210103.hprof", "heap-latest.hprof"},
+			removed: 2,
+		},
+		{
+			name:  "when max size then keep the newest",
+			files: map[string]time.Duration{"a.log.1": 3 * time.Hour, "a.log.2": 2 * time.Hour, "a.log.3": time.Hour},
+			rule: func(dir string) *Rule {
+				return &Rule{Pattern: path.Join(dir, "a.log.*"), MaxSize: 25}
+			},
+			want:    []string{"a.log.2", "a.log.3"},
+			removed: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newFiles(t, tt.files)
+			rc, err := NewRuleCleaner([]*Rule{tt.rule(dir)})
+			if err != nil {
+				t.Fatalf("new cleaner err: %+v", err)
+			}
+			report, err := rc.Clean()
+			if err != nil || len(report.Removed) != tt.removed {
+				t.Errorf("Clean() = %+v, %v", report, err)
+			}
+			if got := remains(dir); !equalStrings(got, tt.want) {
+				t.Errorf("remains = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("when dry run then keep the files", func(t *testing.T) {
+		dir := newFiles(t, map[string]time.Duration{"core.1": 48 * time.Hour})
+		rc, _ := NewRuleCleaner([]*Rule{{Name: "cores", Pattern: path.Join(dir, "core.*"), MaxAge: time.Hour}},
+			SetRuleDryRun(true))
+		report, err := rc.Clean()
+		if err != nil || !report.DryRun || len(report.Removed) != 1 || report.Removed[0].Reason != "rule cores, older than 1h0m0s" {
+			t.Errorf("Clean() = %+v, %v", report, err)
+		}
+		if got := remains(dir); len(got) != 1 {
+			t.Errorf("remains = %v", got)
+		}
+	})
+
+	t.Run("when remove oldest then across the rules", func(t *testing.T) {
+		dir := newFiles(t, map[string]time.Duration{"core.1": time.Hour, "heap.1": 2 * time.Hour})
+		rc, _ := NewRuleCleaner([]*Rule{{Pattern: path.Join(dir, "core.*")}, {Pattern: path.Join(dir, "heap.*")}})
+		if removed, ok, err := rc.RemoveOldest(); err != nil || !ok || removed.Filename != "heap.1" {
+			t.Errorf("RemoveOldest() = %v, %v, %v", removed, ok, err)
+		}
+	})
+
+	t.Run("when invalid rule then error", func(t *testing.T) {
+		invalid := []*Rule{
+			{},
+			{Pattern: "[", MaxAge: time.Hour},
+			{Pattern: "*.log", TimeRegex: regexp.MustCompile(`\d+`)},
+		}
+		for _, rule := range invalid {
+			if _, err := NewRuleCleaner([]*Rule{rule}); err == nil {
+				t.Errorf("NewRuleCleaner(%+v) want error", rule)
+			}
+		}
+	})
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
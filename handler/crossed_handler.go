@@ -0,0 +1,270 @@
+package handler
+
+import (
+	"container/list"
+	"fmt"
+	"github.com/edditen/etlog/common/utils"
+	"github.com/edditen/etlog/config"
+	"github.com/edditen/etlog/core"
+	"github.com/pkg/errors"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTriggerLevel = "error"
+	defaultScopeBuffer  = 1000
+	defaultMaxScopes    = 10000
+	defaultScopeTTL     = "10m"
+)
+
+type scopeBuffer struct {
+	key       string
+	entries   LogEntries
+	triggered bool
+	lastSeen  time.Time
+	// elem the element in the probation list, or the active list if promoted
+	elem     *list.Element
+	promoted bool
+}
+
+// CrossedHandler is the "fingers crossed" handler, which buffers the entries
+// per scope and throws them away unless an entry at or above the trigger level
+// arrived in the same scope, then the whole buffered context will be written
+// into the wrapped handler, and the following entries of the scope pass through.
+//
+// The scope is the value of the scope field, or the logger derived through With*,
+// the entries without scope pass through directly.
+//
+// A new scope is on probation until its second entry, so the loggers used once,
+// such as Log.WithField(...).Info(...), are evicted first on max scopes, and
+// never push out the scopes in use. Both lists are ordered by the last seen.
+type CrossedHandler struct {
+	*BaseHandler
+	handler      Handler
+	triggerLevel core.Level
+	scopeField   string
+	bufferSize   int
+	maxScopes    int
+	scopeTTL     time.Duration
+	scopes       map[string]*scopeBuffer
+	probation    *list.List
+	active       *list.List
+	mutex        *sync.Mutex
+	ticker       *time.Ticker
+	exitC        chan interface{}
+}
+
+func NewCrossedHandler(conf *config.HandlerConfig) *CrossedHandler {
+	return &CrossedHandler{
+		BaseHandler: NewBaseHandler(conf),
+		scopes:      make(map[string]*scopeBuffer),
+		probation:   list.New(),
+		active:      list.New(),
+		mutex:       new(sync.Mutex),
+		exitC:       make(chan interface{}),
+	}
+}
+
+func (ch *CrossedHandler) Init() error {
+	ch.BaseHandler.DefaultSetting()
+	if len(ch.BaseHandler.handlerConfig.Levels) == 0 {
+		ch.BaseHandler.handlerConfig.Levels = allLevels
+	}
+	if err := ch.BaseHandler.Init(); err != nil {
+		return err
+	}
+
+	if err := ch.settingCrossed(); err != nil {
+		return err
+	}
+
+	if err := ch.settingHandler(); err != nil {
+		return err
+	}
+
+	ch.ticker = time.NewTicker(ch.scopeTTL / 2)
+	go ch.run()
+	return nil
+}
+
+func (ch *CrossedHandler) settingCrossed() error {
+	crossed := ch.BaseHandler.handlerConfig.Crossed
+	if crossed.TriggerLevel == "" {
+		crossed.TriggerLevel = defaultTriggerLevel
+	}
+	if crossed.BufferSize <= 0 {
+		crossed.BufferSize = defaultScopeBuffer
+	}
+	if crossed.MaxScopes <= 0 {
+		crossed.MaxScopes = defaultMaxScopes
+	}
+	if crossed.ScopeTTL == "" {
+		crossed.ScopeTTL = defaultScopeTTL
+	}
+
+	ttl, err := utils.ParseSeconds(crossed.ScopeTTL)
+	if err != nil {
+		return errors.Wrap(err, "parse scope ttl error")
+	}
+
+	ch.triggerLevel = core.NewLevel(crossed.TriggerLevel)
+	ch.scopeField = crossed.ScopeField
+	ch.bufferSize = crossed.BufferSize
+	ch.maxScopes = crossed.MaxScopes
+	ch.scopeTTL = time.Duration(ttl) * time.Second
+	return nil
+}
+
+func (ch *CrossedHandler) settingHandler() error {
+	conf := ch.BaseHandler.handlerConfig.Crossed.Handler
+	if conf == nil {
+		return errors.New("fingers crossed wrapped handler is empty")
+	}
+	conf.Marker = ch.BaseHandler.marker
+	if len(conf.Levels) == 0 {
+		conf.Levels = allLevels
+	}
+
+	ch.handler = HandlerFactory(conf)
+	if err := ch.handler.Init(); err != nil {
+		return errors.Wrap(err, "init fingers crossed wrapped handler error")
+	}
+	return nil
+}
+
+func (ch *CrossedHandler) Handle(entry *core.LogEntry) error {
+	if !ch.BaseHandler.MarkerMatched(entry.Marker) {
+		return nil
+	}
+	if !ch.BaseHandler.Contains(entry.Level) {
+		return nil
+	}
+
+	key := ch.scopeKey(entry)
+	if key == "" {
+		return ch.handler.Handle(entry)
+	}
+
+	entries := ch.buffer(key, entry)
+	for _, e := range entries {
+		if err := ch.handler.Handle(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// buffer buffers the entry into the scope, and returns the entries
+// should be written.
+func (ch *CrossedHandler) buffer(key string, entry *core.LogEntry) LogEntries {
+	ch.mutex.Lock()
+	defer ch.mutex.Unlock()
+
+	scope, ok := ch.scopes[key]
+	if !ok {
+		if len(ch.scopes) >= ch.maxScopes {
+			ch.evictOldest()
+		}
+		scope = &scopeBuffer{key: key, entries: make(LogEntries, 0, 1)}
+		scope.elem = ch.probation.PushBack(scope)
+		ch.scopes[key] = scope
+	} else if !scope.promoted {
+		// the second entry of the scope
+		ch.probation.Remove(scope.elem)
+		scope.elem = ch.active.PushBack(scope)
+		scope.promoted = true
+	} else {
+		ch.active.MoveToBack(scope.elem)
+	}
+	scope.lastSeen = time.Now()
+
+	if scope.triggered {
+		return LogEntries{entry}
+	}
+
+	if len(scope.entries) >= ch.bufferSize {
+		scope.entries = scope.entries[1:]
+	}
+	scope.entries = append(scope.entries, entry)
+
+	if entry.Level < ch.triggerLevel {
+		return nil
+	}
+
+	entries := scope.entries
+	scope.entries = nil
+	scope.triggered = true
+	return entries
+}
+
+func (ch *CrossedHandler) scopeKey(entry *core.LogEntry) string {
+	if ch.scopeField != "" && entry.Fields != nil {
+		if v, ok := entry.Fields[ch.scopeField]; ok {
+			return fmt.Sprintf("f:%v", v)
+		}
+	}
+	if entry.Scope != 0 {
+		return fmt.Sprintf("s:%d", entry.Scope)
+	}
+	return ""
+}
+
+// evictOldest evicts the oldest scope on probation, or the oldest scope in use
+// if none on probation.
+func (ch *CrossedHandler) evictOldest() {
+	oldest := ch.probation.Front()
+	if oldest == nil {
+		oldest = ch.active.Front()
+	}
+	if oldest != nil {
+		ch.remove(oldest.Value.(*scopeBuffer))
+	}
+}
+
+func (ch *CrossedHandler) remove(scope *scopeBuffer) {
+	if scope.promoted {
+		ch.active.Remove(scope.elem)
+	} else {
+		ch.probation.Remove(scope.elem)
+	}
+	delete(ch.scopes, scope.key)
+}
+
+func (ch *CrossedHandler) run() {
+	defer ch.ticker.Stop()
+	for {
+		select {
+		case <-ch.ticker.C:
+			ch.expire()
+		case <-ch.exitC:
+			return
+		}
+	}
+}
+
+func (ch *CrossedHandler) expire() {
+	ch.mutex.Lock()
+	defer ch.mutex.Unlock()
+
+	now := time.Now()
+	for _, scopes := range []*list.List{ch.probation, ch.active} {
+		for elem := scopes.Front(); elem != nil; elem = scopes.Front() {
+			scope := elem.Value.(*scopeBuffer)
+			if now.Sub(scope.lastSeen) <= ch.scopeTTL {
+				break
+			}
+			ch.remove(scope)
+		}
+	}
+}
+
+// IgnoreLevel the buffered context includes the entries below the level of logger.
+func (ch *CrossedHandler) IgnoreLevel() bool {
+	return true
+}
+
+func (ch *CrossedHandler) Shutdown() {
+	close(ch.exitC)
+	ch.handler.Shutdown()
+}
@@ -0,0 +1,152 @@
+package handler
+
+import (
+	"fmt"
+	"github.com/edditen/etlog/config"
+	"github.com/edditen/etlog/core"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type captureHandler struct {
+	*BaseHandler
+	mutex   sync.Mutex
+	entries LogEntries
+}
+
+func (c *captureHandler) Handle(entry *core.LogEntry) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.entries = append(c.entries, entry)
+	return nil
+}
+
+func (c *captureHandler) msgs() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	msgs := make([]string, 0)
+	for _, e := range c.entries {
+		msgs = append(msgs, e.Msg)
+	}
+	return msgs
+}
+
+func TestCrossedHandler_Handle(t *testing.T) {
+	newHandler := func(t *testing.T) (*CrossedHandler, *captureHandler) {
+		conf := config.NewHandlerConfig()
+		conf.Crossed.ScopeField = "request_id"
+		conf.Crossed.BufferSize = 3
+		conf.Crossed.Handler = config.NewHandlerConfig()
+		h := NewCrossedHandler(conf)
+		if err := h.Init(); err != nil {
+			t.Fatalf("init err: %+v", err)
+		}
+		capture := &captureHandler{}
+		h.handler = capture
+		return h, capture
+	}
+	entry := func(level core.Level, msg string, requestID string, scope uint64) *core.LogEntry {
+		e := &core.LogEntry{Time: time.Now(), Level: level, Msg: msg, Scope: scope}
+		if requestID != "" {
+			e.Fields = core.Fields{"request_id": requestID}
+		}
+		return e
+	}
+
+	t.Run("when no error in scope then discard", func(t *testing.T) {
+		h, capture := newHandler(t)
+		_ = h.Handle(entry(core.DEBUG, "a", "r1", 0))
+		_ = h.Handle(entry(core.INFO, "b", "r1", 0))
+		if len(capture.msgs()) != 0 {
+			t.Errorf("expect nothing written, got: %v", capture.msgs())
+		}
+	})
+
+	t.Run("when error in scope then flush the context of the scope", func(t *testing.T) {
+		h, capture := newHandler(t)
+		_ = h.Handle(entry(core.DEBUG, "a", "r1", 0))
+		_ = h.Handle(entry(core.DEBUG, "other", "r2", 0))
+		_ = h.Handle(entry(core.INFO, "b", "r1", 0))
+		_ = h.Handle(entry(core.ERROR, "c", "r1", 0))
+		_ = h.Handle(entry(core.DEBUG, "d", "r1", 0))
+
+		got := capture.msgs()
+		want := []string{"a", "b", "c", "d"}
+		if len(got) != len(want) {
+			t.Fatalf("got: %v, want: %v", got, want)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("got: %v, want: %v", got, want)
+			}
+		}
+	})
+
+	t.Run("when buffer full then keep the newest", func(t *testing.T) {
+		h, capture := newHandler(t)
+		for _, msg := range []string{"a", "b", "c", "d"} {
+			_ = h.Handle(entry(core.DEBUG, msg, "", 7))
+		}
+		_ = h.Handle(entry(core.ERROR, "e", "", 7))
+		got := capture.msgs()
+		if len(got) != 3 || got[0] != "c" || got[2] != "e" {
+			t.Errorf("unexpected flushed: %v", got)
+		}
+	})
+
+	t.Run("when max scopes then evict the scopes used once first", func(t *testing.T) {
+		h, capture := newHandler(t)
+		h.maxScopes = 3
+		_ = h.Handle(entry(core.DEBUG, "a1", "", 1))
+		_ = h.Handle(entry(core.DEBUG, "a2", "", 1))
+		for scope := uint64(2); scope < 10; scope++ {
+			_ = h.Handle(entry(core.INFO, "once", "", scope))
+		}
+		_ = h.Handle(entry(core.ERROR, "a3", "", 1))
+		if got := capture.msgs(); strings.Join(got, ",") != "a1,a2,a3" {
+			t.Errorf("unexpected flushed: %v", got)
+		}
+		if len(h.scopes) != 3 || h.probation.Len() != 2 || h.active.Len() != 1 {
+			t.Errorf("scopes: %d, probation: %d, active: %d", len(h.scopes), h.probation.Len(), h.active.Len())
+		}
+	})
+
+	t.Run("when max scopes then evict the least recently seen", func(t *testing.T) {
+		h, capture := newHandler(t)
+		h.maxScopes = 2
+		for _, scope := range []uint64{1, 2, 2, 1} {
+			_ = h.Handle(entry(core.DEBUG, fmt.Sprint(scope), "", scope))
+		}
+		// scope 2 is the least recently seen
+		_ = h.Handle(entry(core.DEBUG, "3", "", 3))
+		_ = h.Handle(entry(core.ERROR, "e1", "", 1))
+		_ = h.Handle(entry(core.ERROR, "e2", "", 2))
+		if got := capture.msgs(); strings.Join(got, ",") != "1,1,e1,e2" {
+			t.Errorf("unexpected flushed: %v", got)
+		}
+	})
+
+	t.Run("when ttl passed then expire", func(t *testing.T) {
+		h, _ := newHandler(t)
+		_ = h.Handle(entry(core.DEBUG, "a", "", 1))
+		_ = h.Handle(entry(core.DEBUG, "a", "", 1))
+		_ = h.Handle(entry(core.DEBUG, "b", "", 2))
+		h.scopeTTL = 10 * time.Millisecond
+		time.Sleep(20 * time.Millisecond)
+		_ = h.Handle(entry(core.DEBUG, "c", "", 3))
+		h.expire()
+		if _, ok := h.scopes["s:3"]; !ok || len(h.scopes) != 1 || h.probation.Len() != 1 || h.active.Len() != 0 {
+			t.Errorf("scopes: %d, probation: %d, active: %d", len(h.scopes), h.probation.Len(), h.active.Len())
+		}
+	})
+
+	t.Run("when no scope then pass through", func(t *testing.T) {
+		h, capture := newHandler(t)
+		_ = h.Handle(entry(core.INFO, "a", "", 0))
+		if got := capture.msgs(); len(got) != 1 {
+			t.Errorf("expect pass through, got: %v", got)
+		}
+	})
+}
@@ -0,0 +1,185 @@
+package handler
+
+import (
+	"github.com/edditen/etlog/common/diskusage"
+	"github.com/edditen/etlog/common/utils"
+	"github.com/edditen/etlog/core"
+	"github.com/edditen/etlog/handler/cleaner"
+	"github.com/edditen/etlog/opt"
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultDiskCheckInterval = 5000
+	defaultDiskStopWater     = "1%"
+)
+
+const (
+	guardNormal int32 = iota
+	// guardDropLow drops the entries below WARN
+	guardDropLow
+	// guardStopped stops writing
+	guardStopped
+)
+
+// waterMark the free space in bytes, or in percent of the total space.
+type waterMark struct {
+	size    uint64
+	percent float64
+}
+
+func parseWaterMark(mark string) (waterMark, error) {
+	if strings.HasSuffix(mark, "%") {
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(mark, "%"), 64)
+		if err != nil || percent < 0 || percent > 100 {
+			return waterMark{}, errors.Errorf("invalid water mark: %s", mark)
+		}
+		return waterMark{percent: percent}, nil
+	}
+
+	size, err := utils.ParseSize(mark)
+	if err != nil {
+		return waterMark{}, errors.Wrap(err, "parse water mark error")
+	}
+	return waterMark{size: uint64(size)}, nil
+}
+
+func (wm waterMark) bytes(total uint64) uint64 {
+	if wm.percent > 0 {
+		return uint64(float64(total) * wm.percent / 100)
+	}
+	return wm.size
+}
+
+// diskGuard watches the free space of the disk, under the low water mark,
+// it deletes the oldest backups first, then drops the entries below WARN,
+// and stops writing under the stop water mark.
+type diskGuard struct {
+	dir       string
+	lowWater  waterMark
+	stopWater waterMark
+	cleaner   cleaner.Cleaner
+	state     int32
+	dropped   uint64
+	interval  time.Duration
+	usage     func(dir string) (diskusage.Usage, error)
+	exitC     chan interface{}
+}
+
+func newDiskGuard(dir string, lowWater, stopWater waterMark, c cleaner.Cleaner,
+	interval time.Duration, exitC chan interface{}) *diskGuard {
+	return &diskGuard{
+		dir:       dir,
+		lowWater:  lowWater,
+		stopWater: stopWater,
+		cleaner:   c,
+		interval:  interval,
+		usage:     diskusage.Get,
+		exitC:     exitC,
+	}
+}
+
+func (dg *diskGuard) Init() error {
+	if _, err := dg.usage(dg.dir); err != nil {
+		return err
+	}
+	dg.check()
+	go dg.run()
+	return nil
+}
+
+func (dg *diskGuard) run() {
+	ticker := time.NewTicker(dg.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			dg.check()
+		case <-dg.exitC:
+			return
+		}
+	}
+}
+
+// allow returns whether the entry of level could be written, it is safe
+// to be called on nil guard.
+func (dg *diskGuard) allow(level core.Level) bool {
+	if dg == nil {
+		return true
+	}
+	switch atomic.LoadInt32(&dg.state) {
+	case guardDropLow:
+		if level >= core.WARN {
+			return true
+		}
+	case guardStopped:
+	default:
+		return true
+	}
+	atomic.AddUint64(&dg.dropped, 1)
+	return false
+}
+
+func (dg *diskGuard) check() {
+	usage, err := dg.usage(dg.dir)
+	if err != nil {
+		opt.GetErrLog().Printf("[DiskGuard] check disk usage of %s err: %+v\n", dg.dir, err)
+		return
+	}
+
+	low := dg.lowWater.bytes(usage.Total)
+	stop := dg.stopWater.bytes(usage.Total)
+	if stop > low {
+		stop = low
+	}
+
+	// delete the oldest backups first
+	for usage.Free < low {
+		removed, ok, err := dg.cleaner.RemoveOldest()
+		if err != nil {
+			opt.GetErrLog().Printf("[DiskGuard] remove oldest backup err: %+v\n", err)
+			break
+		}
+		if !ok {
+			break
+		}
+		opt.GetErrLog().Printf("[DiskGuard] free space %d under low water %d of %s, removed backup %s\n",
+			usage.Free, low, dg.dir, removed.Filename)
+		if usage, err = dg.usage(dg.dir); err != nil {
+			opt.GetErrLog().Printf("[DiskGuard] check disk usage of %s err: %+v\n", dg.dir, err)
+			return
+		}
+	}
+
+	switch {
+	case usage.Free >= low:
+		dg.transit(guardNormal, usage.Free, low)
+	case usage.Free >= stop:
+		dg.transit(guardDropLow, usage.Free, low)
+	default:
+		dg.transit(guardStopped, usage.Free, stop)
+	}
+}
+
+func (dg *diskGuard) transit(state int32, free, water uint64) {
+	prev := atomic.SwapInt32(&dg.state, state)
+	if prev == state {
+		return
+	}
+
+	switch state {
+	case guardDropLow:
+		opt.GetErrLog().Printf("[DiskGuard] free space %d under low water %d of %s, drop entries below WARN\n",
+			free, water, dg.dir)
+	case guardStopped:
+		opt.GetErrLog().Printf("[DiskGuard] free space %d under stop water %d of %s, stop writing\n",
+			free, water, dg.dir)
+	default:
+		opt.GetErrLog().Printf("[DiskGuard] free space %d recovered above low water %d of %s, %d entries dropped\n",
+			free, water, dg.dir, atomic.SwapUint64(&dg.dropped, 0))
+	}
+}
@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"github.com/edditen/etlog/common/diskusage"
+	"github.com/edditen/etlog/core"
+	"github.com/edditen/etlog/handler/cleaner"
+	"testing"
+)
+
+// fakeCleaner frees the space of a backup when removing it
+type fakeCleaner struct {
+	backups int
+	free    *uint64
+	size    uint64
+}
+
+func (fc *fakeCleaner) Init() error                          { return nil }
+func (fc *fakeCleaner) Run() error                           { return nil }
+func (fc *fakeCleaner) Shutdown()                            {}
+func (fc *fakeCleaner) Clean() (*cleaner.CleanReport, error) { return &cleaner.CleanReport{}, nil }
+
+func (fc *fakeCleaner) RemoveOldest() (cleaner.FileInfo, bool, error) {
+	if fc.backups == 0 {
+		return cleaner.FileInfo{}, false, nil
+	}
+	fc.backups--
+	*fc.free += fc.size
+	return cleaner.FileInfo{Filename: "backup"}, true, nil
+}
+
+func TestDiskGuard_check(t *testing.T) {
+	tests := []struct {
+		name        string
+		free        uint64
+		backups     int
+		wantState   int32
+		wantBackups int
+	}{
+		{"when enough space then normal", 500, 2, guardNormal, 2},
+		{"when under low water then remove backups first", 80, 3, guardNormal, 1},
+		{"when no backups left then drop below warn", 80, 1, guardDropLow, 0},
+		{"when under stop water then stop writing", 5, 0, guardStopped, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			free := tt.free
+			fc := &fakeCleaner{backups: tt.backups, free: &free, size: 15}
+			dg := newDiskGuard("log", waterMark{percent: 10}, waterMark{size: 10}, fc, 0, nil)
+			dg.usage = func(string) (diskusage.Usage, error) {
+				return diskusage.Usage{Free: free, Total: 1000}, nil
+			}
+
+			dg.check()
+			if dg.state != tt.wantState || fc.backups != tt.wantBackups {
+				t.Errorf("state = %d, backups = %d", dg.state, fc.backups)
+			}
+		})
+	}
+}
+
+func TestDiskGuard_allow(t *testing.T) {
+	tests := []struct {
+		name  string
+		state int32
+		level core.Level
+		want  bool
+	}{
+		{"when normal then allow", guardNormal, core.DEBUG, true},
+		{"when drop low then drop info", guardDropLow, core.INFO, false},
+		{"when drop low then allow warn", guardDropLow, core.WARN, true},
+		{"when stopped then drop error", guardStopped, core.ERROR, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dg := &diskGuard{state: tt.state}
+			if got := dg.allow(tt.level); got != tt.want {
+				t.Errorf("allow() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("when nil guard then allow", func(t *testing.T) {
+		var dg *diskGuard
+		if !dg.allow(core.DEBUG) {
+			t.Errorf("allow() = false")
+		}
+	})
+}
+
+func TestParseWaterMark(t *testing.T) {
+	tests := []struct {
+		mark    string
+		want    uint64
+		wantErr bool
+	}{
+		{"10%", 100, false},
+		{"1k", 1024, false},
+		{"101%", 0, true},
+		{"abc", 0, true},
+	}
+	for _, tt := range tests {
+		wm, err := parseWaterMark(tt.mark)
+		if (err != nil) != tt.wantErr || (err == nil && wm.bytes(1000) != tt.want) {
+			t.Errorf("parseWaterMark(%s) = %+v, %v", tt.mark, wm, err)
+		}
+	}
+}
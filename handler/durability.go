@@ -0,0 +1,146 @@
+package handler
+
+import (
+	"github.com/edditen/etlog/core"
+	"github.com/pkg/errors"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const defaultFsyncInterval = 1000
+
+// Durability decides when the written data is synced to the disk.
+type Durability int
+
+const (
+	// DurabilityNone never syncs, the data is flushed by the OS.
+	DurabilityNone Durability = iota
+	// DurabilityInterval syncs every fsync interval if anything written.
+	DurabilityInterval
+	// DurabilityBatch syncs after each batch of the async path,
+	// and after each entry of the sync path.
+	DurabilityBatch
+	// DurabilityEveryWrite syncs after every write.
+	DurabilityEveryWrite
+)
+
+func NewDurability(durability string) (Durability, error) {
+	switch strings.ToLower(durability) {
+	case "", "none":
+		return DurabilityNone, nil
+	case "interval":
+		return DurabilityInterval, nil
+	case "batch":
+		return DurabilityBatch, nil
+	case "every_write":
+		return DurabilityEveryWrite, nil
+	}
+	return DurabilityNone, errors.Errorf("unknown durability: %s", durability)
+}
+
+func (d Durability) String() string {
+	switch d {
+	case DurabilityInterval:
+		return "interval"
+	case DurabilityBatch:
+		return "batch"
+	case DurabilityEveryWrite:
+		return "every_write"
+	}
+	return "none"
+}
+
+// durableFilter selects the entries synced to the disk before the handling
+// returns, the ones logged by etlog.Durable and the ones at or above the
+// durable level of the handler.
+type durableFilter struct {
+	enabled bool
+	level   core.Level
+}
+
+func newDurableFilter(durableLevel string) (durableFilter, error) {
+	if durableLevel == "" {
+		return durableFilter{}, nil
+	}
+	level, err := core.ParseLevel(durableLevel)
+	if err != nil {
+		return durableFilter{}, errors.Wrap(err, "parse durable level error")
+	}
+	return durableFilter{enabled: true, level: level}, nil
+}
+
+func (df durableFilter) match(entry *core.LogEntry) bool {
+	return entry.Durable || df.enabled && entry.Level >= df.level
+}
+
+// FsyncStats the counters and latency of fsync.
+type FsyncStats struct {
+	Durability   string
+	Syncs        uint64
+	Errors       uint64
+	TotalLatency time.Duration
+	MaxLatency   time.Duration
+	LastLatency  time.Duration
+}
+
+// FsyncReporter is implemented by the handlers which sync files.
+type FsyncReporter interface {
+	FsyncStats() FsyncStats
+}
+
+// fsyncer syncs the file and records the latency.
+type fsyncer struct {
+	durability   Durability
+	dirty        int32
+	syncs        uint64
+	errors       uint64
+	totalLatency int64
+	maxLatency   int64
+	lastLatency  int64
+}
+
+func (fy *fsyncer) written() {
+	atomic.StoreInt32(&fy.dirty, 1)
+}
+
+func (fy *fsyncer) isDirty() bool {
+	return atomic.LoadInt32(&fy.dirty) == 1
+}
+
+func (fy *fsyncer) sync(file *os.File) error {
+	atomic.StoreInt32(&fy.dirty, 0)
+
+	start := time.Now()
+	err := file.Sync()
+	latency := int64(time.Since(start))
+
+	atomic.AddUint64(&fy.syncs, 1)
+	atomic.AddInt64(&fy.totalLatency, latency)
+	atomic.StoreInt64(&fy.lastLatency, latency)
+	for {
+		max := atomic.LoadInt64(&fy.maxLatency)
+		if latency <= max || atomic.CompareAndSwapInt64(&fy.maxLatency, max, latency) {
+			break
+		}
+	}
+
+	if err != nil {
+		atomic.AddUint64(&fy.errors, 1)
+		atomic.StoreInt32(&fy.dirty, 1)
+		return errors.Wrap(err, "fsync file error")
+	}
+	return nil
+}
+
+func (fy *fsyncer) stats() FsyncStats {
+	return FsyncStats{
+		Durability:   fy.durability.String(),
+		Syncs:        atomic.LoadUint64(&fy.syncs),
+		Errors:       atomic.LoadUint64(&fy.errors),
+		TotalLatency: time.Duration(atomic.LoadInt64(&fy.totalLatency)),
+		MaxLatency:   time.Duration(atomic.LoadInt64(&fy.maxLatency)),
+		LastLatency:  time.Duration(atomic.LoadInt64(&fy.lastLatency)),
+	}
+}
@@ -0,0 +1,214 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/edditen/etlog/config"
+	"github.com/edditen/etlog/core"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	defaultIndexTemplate = "etlog-%Y.%m.%d"
+	bulkPath             = "/_bulk"
+	ndjsonContentType    = "application/x-ndjson"
+	timestampKey         = "@timestamp"
+)
+
+// ElasticHandler writes entries into elasticsearch or opensearch
+// through the _bulk api.
+type ElasticHandler struct {
+	*BaseHandler
+	endpoint  string
+	index     string
+	headers   map[string]string
+	client    *http.Client
+	docFormat core.Formatter
+	batcher   *batcher
+}
+
+func NewElasticHandler(conf *config.HandlerConfig) *ElasticHandler {
+	return &ElasticHandler{
+		BaseHandler: NewBaseHandler(conf),
+		docFormat:   core.NewJSONFormatter(),
+	}
+}
+
+func (eh *ElasticHandler) Init() error {
+	if err := eh.BaseHandler.Init(); err != nil {
+		return err
+	}
+
+	remote := settingRemote(eh.BaseHandler.handlerConfig.Remote)
+	if remote.Endpoint == "" {
+		return errors.New("elasticsearch endpoint is empty")
+	}
+	if remote.Index == "" {
+		remote.Index = defaultIndexTemplate
+	}
+
+	eh.endpoint = strings.TrimRight(remote.Endpoint, "/") + bulkPath
+	eh.index = remote.Index
+	eh.headers = remote.Headers
+	var err error
+	if eh.batcher, err = newBatcher(remote, eh.bulk); err != nil {
+		return err
+	}
+	eh.client = &http.Client{Timeout: time.Duration(remote.Timeout) * time.Millisecond}
+
+	return eh.batcher.Init()
+}
+
+func (eh *ElasticHandler) Handle(entry *core.LogEntry) error {
+	if !eh.BaseHandler.MarkerMatched(entry.Marker) {
+		return nil
+	}
+	if !eh.BaseHandler.Contains(entry.Level) {
+		return nil
+	}
+	return eh.batcher.Offer(entry)
+}
+
+func (eh *ElasticHandler) Shutdown() {
+	eh.batcher.Shutdown()
+}
+
+func (eh *ElasticHandler) bulk(entries LogEntries) (LogEntries, error) {
+	body := eh.encode(entries)
+
+	req, err := http.NewRequest(http.MethodPost, eh.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Wrap(err, "create bulk request error")
+	}
+	req.Header.Set("Content-Type", ndjsonContentType)
+	for k, v := range eh.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := eh.client.Do(req)
+	if err != nil {
+		return entries, errors.Wrap(err, "send bulk request error")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		return entries, errors.Errorf("bulk request failed, status: %d", resp.StatusCode)
+	}
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return entries, errors.Wrap(err, "read bulk response error")
+	}
+	if resp.StatusCode >= 300 {
+		return nil, errors.Errorf("bulk request rejected, status: %d, body: %s",
+			resp.StatusCode, respBody)
+	}
+
+	return parseBulkResponse(respBody, entries)
+}
+
+func (eh *ElasticHandler) encode(entries LogEntries) []byte {
+	body := &bytes.Buffer{}
+	for _, entry := range entries {
+		body.WriteString(`{"index":{"_index":`)
+		index, _ := json.Marshal(FormatIndex(eh.index, entry.Time))
+		body.Write(index)
+		body.WriteString("}}\n")
+		body.Write(eh.document(entry))
+		body.WriteByte('\n')
+	}
+	return body.Bytes()
+}
+
+// document formats the entry same as the json formatter, with @timestamp added.
+func (eh *ElasticHandler) document(entry *core.LogEntry) []byte {
+	buf := eh.docFormat.Format(entry)
+	defer buf.Free()
+
+	doc := bytes.TrimSpace(buf.Bytes())
+	if len(doc) < 2 || doc[0] != '{' {
+		return []byte("{}")
+	}
+
+	result := &bytes.Buffer{}
+	result.WriteString(fmt.Sprintf(`{"%s":"%s"`, timestampKey,
+		entry.Time.Format(time.RFC3339Nano)))
+	if len(doc) > 2 {
+		result.WriteByte(',')
+	}
+	result.Write(doc[1:])
+	return result.Bytes()
+}
+
+type bulkResponse struct {
+	Errors bool                          `json:"errors"`
+	Items  []map[string]bulkResponseItem `json:"items"`
+}
+
+type bulkResponseItem struct {
+	Index  string          `json:"_index"`
+	Status int             `json:"status"`
+	Error  json.RawMessage `json:"error,omitempty"`
+}
+
+// parseBulkResponse returns the entries failed with a retryable status,
+// the items rejected by other reasons will be reported and dropped.
+func parseBulkResponse(body []byte, entries LogEntries) (LogEntries, error) {
+	resp := &bulkResponse{}
+	if err := json.Unmarshal(body, resp); err != nil {
+		return nil, errors.Wrap(err, "unmarshal bulk response error")
+	}
+	if !resp.Errors {
+		return nil, nil
+	}
+
+	failed := make(LogEntries, 0)
+	rejected := 0
+	var firstErr string
+	for i, item := range resp.Items {
+		if i >= len(entries) {
+			break
+		}
+		for _, result := range item {
+			if result.Status < 300 {
+				continue
+			}
+			if firstErr == "" {
+				firstErr = string(result.Error)
+			}
+			if result.Status == http.StatusTooManyRequests || result.Status >= 500 {
+				failed = append(failed, entries[i])
+			} else {
+				rejected++
+			}
+		}
+	}
+
+	if len(failed) == 0 && rejected == 0 {
+		return nil, nil
+	}
+	return failed, errors.Errorf("bulk items failed, retryable: %d, rejected: %d, error: %s",
+		len(failed), rejected, firstErr)
+}
+
+// FormatIndex replaces the date directives %Y %m %d %H in index template.
+func FormatIndex(template string, t time.Time) string {
+	if !strings.Contains(template, "%") {
+		return template
+	}
+	t = t.UTC()
+	replacer := strings.NewReplacer(
+		"%Y", fmt.Sprintf("%04d", t.Year()),
+		"%m", fmt.Sprintf("%02d", int(t.Month())),
+		"%d", fmt.Sprintf("%02d", t.Day()),
+		"%H", fmt.Sprintf("%02d", t.Hour()),
+	)
+	return replacer.Replace(template)
+}
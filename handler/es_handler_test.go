@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"github.com/edditen/etlog/config"
+	"github.com/edditen/etlog/core"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFormatIndex(t *testing.T) {
+	ts := time.Date(2021, 6, 5, 8, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		template string
+		want     string
+	}{
+		{name: "when daily template then replace date", template: "app-logs-%Y.%m.%d", want: "app-logs-2021.06.05"},
+		{name: "when hourly template then replace hour", template: "app-%Y%m%d%H", want: "app-2021060508"},
+		{name: "when plain name then keep it", template: "app-logs", want: "app-logs"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FormatIndex(tt.template, ts); got != tt.want {
+				t.Errorf("FormatIndex() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestElasticHandler_Handle(t *testing.T) {
+	t.Run("when bulk items failed then retry only failed items", func(t *testing.T) {
+		mu := new(sync.Mutex)
+		requests := make([][]string, 0)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			lines := make([]string, 0)
+			scanner := bufio.NewScanner(bytes.NewReader(body))
+			for scanner.Scan() {
+				lines = append(lines, scanner.Text())
+			}
+
+			mu.Lock()
+			requests = append(requests, lines)
+			first := len(requests) == 1
+			mu.Unlock()
+
+			if first {
+				_, _ = w.Write([]byte(`{"errors":true,"items":[` +
+					`{"index":{"status":201}},` +
+					`{"index":{"status":429,"error":{"type":"es_rejected_execution_exception"}}},` +
+					`{"index":{"status":400,"error":{"type":"mapper_parsing_exception"}}}]}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"errors":false,"items":[{"index":{"status":201}}]}`))
+		}))
+		defer server.Close()
+
+		conf := config.NewHandlerConfig()
+		conf.Levels = []string{"info"}
+		conf.Remote.Endpoint = server.URL
+		conf.Remote.Index = "app-logs-%Y.%m.%d"
+		conf.Remote.BatchSize = 3
+		conf.Remote.RetryBackoff = 1
+
+		h := NewElasticHandler(conf)
+		if err := h.Init(); err != nil {
+			t.Fatalf("init err: %+v", err)
+		}
+		ts := time.Date(2021, 6, 5, 8, 0, 0, 0, time.UTC)
+		for _, msg := range []string{"a", "b", "c"} {
+			if err := h.Handle(&core.LogEntry{Time: ts, Level: core.INFO, Msg: msg}); err != nil {
+				t.Fatalf("handle err: %+v", err)
+			}
+		}
+		h.Shutdown()
+
+		if len(requests) != 2 {
+			t.Fatalf("expect 2 requests, got: %d", len(requests))
+		}
+		if len(requests[0]) != 6 || len(requests[1]) != 2 {
+			t.Fatalf("expect 6 and 2 lines, got: %d and %d", len(requests[0]), len(requests[1]))
+		}
+		if requests[0][0] != `{"index":{"_index":"app-logs-2021.06.05"}}` {
+			t.Errorf("unexpected action line: %s", requests[0][0])
+		}
+
+		doc := make(map[string]interface{})
+		if err := json.Unmarshal([]byte(requests[1][1]), &doc); err != nil {
+			t.Fatalf("unmarshal doc err: %+v", err)
+		}
+		if doc["msg"] != "b" || doc[timestampKey] != "2021-06-05T08:00:00Z" {
+			t.Errorf("unexpected retried doc: %s", requests[1][1])
+		}
+	})
+}
@@ -1,15 +1,23 @@
 package handler
 
 import (
+	"bytes"
+	"compress/gzip"
 	"fmt"
 	"github.com/edditen/etlog/common/bufferpool"
+	"github.com/edditen/etlog/common/flock"
+	"github.com/edditen/etlog/common/schedule"
 	"github.com/edditen/etlog/handler/archiver"
 	"github.com/edditen/etlog/handler/cleaner"
+	"github.com/edditen/etlog/handler/index"
+	"github.com/edditen/etlog/handler/naming"
 	"github.com/edditen/etlog/opt"
+	"io"
 	"io/fs"
 	"math"
 	"os"
 	"path"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -21,46 +29,67 @@ import (
 )
 
 const (
-	fileFlag                         = os.O_APPEND | os.O_CREATE | os.O_WRONLY
-	fileMode             fs.FileMode = 0644
-	backupTimeFormat                 = "2006-01-02.150405"
-	defaultLogSize                   = "10G"
-	defaultRolloverTime              = "1d"
-	defaultBackupTime                = "365d"
-	defaultBackupCount               = math.MaxInt32
-	defaultQueueSize                 = 8192
-	defaultFlushInterval             = 100
-	defaultFlushSize                 = 256
+	fileFlag                                 = os.O_APPEND | os.O_CREATE | os.O_WRONLY
+	fileMode                     fs.FileMode = 0644
+	streamExt                                = ".gz"
+	defaultLogSize                           = "10G"
+	defaultRolloverTime                      = "1d"
+	defaultRolloverWeekday                   = "monday"
+	defaultBackupTime                        = "365d"
+	defaultBackupCount                       = math.MaxInt32
+	defaultExternalCheckInterval             = 1000
+	lockFileExt                              = ".lock"
+	// atomicWriteSize the size of chunk written by one call in shared mode,
+	// like PIPE_BUF, the appending is not interleaved with other processes.
+	atomicWriteSize = 4096
+)
+
+const (
+	externalRotateNone         = ""
+	externalRotateReopen       = "reopen"
+	externalRotateCopyTruncate = "copytruncate"
 )
 
 type LogEntries = []*core.LogEntry
 
 type FileHandler struct {
 	*BaseHandler
-	fileWriter     *os.File
-	filePath       string
-	fileDir        string
-	fileExt        string
-	fileName       string
-	rotateSize     int
-	rotateInterval int
-	backupTime     int
-	backupCount    int
-	writtenSize    int64
-	rotateAt       time.Time
-	rotateLock     *sync.RWMutex
-	flushLock      *sync.Mutex
-	asyncWrite     bool
-	queueSize      int
-	flushInterval  int
-	flushSize      int
-	entryC         chan *core.LogEntry
-	entryBuf       []*core.LogEntry
-	ticker         *time.Ticker
-	asyncMutex     *sync.RWMutex
-	queueFull      chan bool
-	cleaner        cleaner.Cleaner
-	archiver       archiver.Archiver
+	fileWriter  *os.File
+	filePath    string
+	fileDir     string
+	fileExt     string
+	fileName    string
+	currentLink string
+	naming      *naming.Template
+	rotateSize  int
+	rotateSched schedule.Schedule
+	calendar    bool
+	backupTime  int
+	backupCount int
+	writtenSize int64
+	rotateAt    time.Time
+	rotateLock  *sync.RWMutex
+	flushLock   *sync.Mutex
+	cleaner     cleaner.Cleaner
+	archiver    archiver.Archiver
+	external    string
+	shared      bool
+	flock       *flock.Flock
+	openedInfo  atomic.Value
+	fsyncer     *fsyncer
+	durable     durableFilter
+	guard       *diskGuard
+	archiveConf *config.ArchiveConfig
+	archiveFmt  archiver.Format
+	encryptKey  []byte
+	uploader    archiver.Uploader
+	archiveDir  string
+	stream      bool
+	gzWriter    *gzip.Writer
+	index       *fileIndex
+	ticker      *time.Ticker
+	once        *sync.Once
+	exitC       chan interface{}
 }
 
 func NewFileHandler(conf *config.HandlerConfig) *FileHandler {
@@ -68,6 +97,9 @@ func NewFileHandler(conf *config.HandlerConfig) *FileHandler {
 		BaseHandler: NewBaseHandler(conf),
 		rotateLock:  new(sync.RWMutex),
 		flushLock:   new(sync.Mutex),
+		fsyncer:     new(fsyncer),
+		once:        new(sync.Once),
+		exitC:       make(chan interface{}),
 	}
 }
 
@@ -80,6 +112,22 @@ func (fh *FileHandler) Init() error {
 		return err
 	}
 
+	if err := fh.settingArchive(); err != nil {
+		return err
+	}
+
+	if err := fh.settingShared(); err != nil {
+		return err
+	}
+
+	if err := fh.settingFileIndex(); err != nil {
+		return err
+	}
+
+	if err := fh.settingNaming(); err != nil {
+		return err
+	}
+
 	if err := fh.settingRolloverSize(); err != nil {
 		return err
 	}
@@ -96,56 +144,124 @@ func (fh *FileHandler) Init() error {
 		return err
 	}
 
-	if err := fh.settingSync(); err != nil {
+	if err := fh.settingCleaner(); err != nil {
 		return err
 	}
 
-	if err := fh.settingChan(); err != nil {
+	if err := fh.settingArchiver(); err != nil {
 		return err
 	}
 
-	if err := fh.settingCleaner(); err != nil {
+	if err := fh.settingDiskGuard(); err != nil {
 		return err
 	}
 
-	if err := fh.settingArchiver(); err != nil {
+	if err := fh.settingExternalRotate(); err != nil {
+		return err
+	}
+
+	if err := fh.settingDurability(); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (fh *FileHandler) Handle(entry *core.LogEntry) (err error) {
+func (fh *FileHandler) Handle(entry *core.LogEntry) error {
 	if !fh.BaseHandler.MarkerMatched(entry.Marker) {
 		return nil
 	}
 	if !fh.BaseHandler.Contains(entry.Level) {
 		return nil
 	}
+	if !fh.guard.allow(entry.Level) {
+		return nil
+	}
 
-	if fh.asyncWrite {
-		select {
-		case fh.entryC <- entry:
-		default:
-			err = fh.syncHandle(entry)
-			fh.notifyFull()
+	return fh.syncHandle(entry)
+}
+
+// HandleBatch writes the entries by blocks of flush size.
+func (fh *FileHandler) HandleBatch(entries LogEntries) error {
+	flushSize := fh.BaseHandler.handlerConfig.Sync.FlushSize
+	if flushSize <= 0 {
+		flushSize = len(entries)
+	}
+
+	durable := false
+	blocks := utils.CalculateBlocks(len(entries), flushSize)
+	for i := 0; i < blocks; i++ {
+		buf := bufferpool.Borrow()
+		entriesWritten := make([]written, 0, flushSize)
+
+		for j := i * flushSize; j < (i+1)*flushSize && j < len(entries); j++ {
+			entry := entries[j]
+			if !fh.BaseHandler.MarkerMatched(entry.Marker) || !fh.BaseHandler.Contains(entry.Level) {
+				continue
+			}
+			if !fh.guard.allow(entry.Level) {
+				continue
+			}
+			b := fh.formatter.Format(entry)
+			buf.AppendBytes(b.Bytes())
+			entriesWritten = append(entriesWritten, written{entry: entry, size: b.Len()})
+			b.Free()
+			durable = durable || fh.durable.match(entry)
 		}
-		return err
+
+		if buf.Len() > 0 {
+			if err := fh.syncFlush(buf.Bytes(), entriesWritten); err != nil {
+				buf.Free()
+				return err
+			}
+		}
+		buf.Free()
 	}
-	return fh.syncHandle(entry)
+	if fh.fsyncer.durability == DurabilityBatch || durable {
+		return fh.syncFile()
+	}
+	return nil
+}
+
+// Shutdown closes the file, and stops the archiver and cleaner, only the
+// first call takes effect.
+func (fh *FileHandler) Shutdown() {
+	fh.once.Do(func() {
+		close(fh.exitC)
+
+		fh.rotateLock.Lock()
+		if fh.fileWriter != nil {
+			fh.syncBeforeClose()
+			fh.closeFileWriter()
+		}
+		fh.rotateLock.Unlock()
+
+		if fh.flock != nil {
+			_ = fh.flock.Close()
+		}
+		if fh.archiver != nil {
+			fh.archiver.Shutdown()
+		}
+		if fh.cleaner != nil {
+			fh.cleaner.Shutdown()
+		}
+	})
 }
 
 func (fh *FileHandler) syncHandle(entry *core.LogEntry) error {
 	buf := fh.BaseHandler.formatter.Format(entry)
 	defer buf.Free()
 
-	if err := fh.syncFlush(buf.Bytes()); err != nil {
+	if err := fh.syncFlush(buf.Bytes(), []written{{entry: entry, size: buf.Len()}}); err != nil {
 		return err
 	}
+	if fh.fsyncer.durability == DurabilityBatch || fh.durable.match(entry) {
+		return fh.syncFile()
+	}
 	return nil
 }
 
-func (fh *FileHandler) syncFlush(bs []byte) error {
+func (fh *FileHandler) syncFlush(bs []byte, entries []written) error {
 	if fh.shouldCreateFile() {
 		if err := fh.createFileWithLock(); err != nil {
 			return err
@@ -158,7 +274,7 @@ func (fh *FileHandler) syncFlush(bs []byte) error {
 		}
 	}
 
-	if err := fh.Flush(bs); err != nil {
+	if err := fh.flush(bs, entries); err != nil {
 		return err
 	}
 	return nil
@@ -189,29 +305,169 @@ func (fh *FileHandler) createFile() error {
 		return err
 	}
 
-	fh.rotateAt = fh.nextTimeRotate(fh.rotateInterval)
+	if fh.index != nil {
+		// the index is optional, keep writing without it
+		if err := fh.index.open(fh.filePath, atomic.LoadInt64(&fh.writtenSize)); err != nil {
+			opt.GetErrLog().Printf("open file index err: %+v\n", err)
+		}
+	}
+
+	if fileInfo, err := fh.fileWriter.Stat(); err == nil {
+		fh.openedInfo.Store(fileInfo)
+	}
+	fh.settingRotateAt()
+	fh.linkCurrent()
 	return nil
 }
 
+// settingRotateAt the calendar based rotation counts from the last modified time
+// of the existing file, so a file left over from an earlier period is rotated
+// on the first write.
+func (fh *FileHandler) settingRotateAt() {
+	from := time.Now()
+	if fh.calendar && fh.writtenSize > 0 {
+		if fileInfo, err := fh.fileWriter.Stat(); err == nil {
+			from = fileInfo.ModTime()
+		}
+	}
+	fh.rotateAt = fh.rotateSched.Next(from)
+}
+
 func (fh *FileHandler) Flush(bs []byte) error {
+	return fh.flush(bs, nil)
+}
+
+// flush writes the bytes of the entries, which are indexed in order.
+func (fh *FileHandler) flush(bs []byte, entries []written) error {
 	fh.rotateLock.RLock()
 	defer fh.rotateLock.RUnlock()
 
 	fh.flushLock.Lock()
 	defer fh.flushLock.Unlock()
 
-	if _, err := fh.fileWriter.Write(bs); err != nil {
-		return errors.Wrap(err, "write file error")
+	switch {
+	case fh.stream:
+		// the compressed size is counted by the writer under gzip
+		if _, err := fh.gzWriter.Write(bs); err != nil {
+			return errors.Wrap(err, "write gzip error")
+		}
+		if err := fh.gzWriter.Flush(); err != nil {
+			return errors.Wrap(err, "flush gzip error")
+		}
+	case fh.shared:
+		if err := writeChunks(fh.fileWriter, bs, atomicWriteSize); err != nil {
+			return errors.Wrap(err, "write file error")
+		}
+		atomic.AddInt64(&fh.writtenSize, int64(len(bs)))
+	default:
+		if _, err := fh.fileWriter.Write(bs); err != nil {
+			return errors.Wrap(err, "write file error")
+		}
+		atomic.AddInt64(&fh.writtenSize, int64(len(bs)))
+	}
+	if fh.index != nil {
+		fh.index.add(entries)
+	}
+
+	fh.fsyncer.written()
+	if fh.fsyncer.durability == DurabilityEveryWrite {
+		return fh.fsyncer.sync(fh.fileWriter)
+	}
+	return nil
+}
+
+// syncFile syncs the file to the disk.
+func (fh *FileHandler) syncFile() error {
+	fh.rotateLock.RLock()
+	defer fh.rotateLock.RUnlock()
+
+	if fh.fileWriter == nil || !fh.fsyncer.isDirty() {
+		return nil
+	}
+	return fh.fsyncer.sync(fh.fileWriter)
+}
+
+// syncBeforeClose syncs the file unless no durability required,
+// it should be called with the rotate lock.
+func (fh *FileHandler) syncBeforeClose() {
+	if fh.fsyncer.durability == DurabilityNone || !fh.fsyncer.isDirty() {
+		return
+	}
+	if err := fh.fsyncer.sync(fh.fileWriter); err != nil {
+		opt.GetErrLog().Printf("fsync before close err: %+v\n", err)
+	}
+}
+
+func (fh *FileHandler) runFsync(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := fh.syncFile(); err != nil {
+				opt.GetErrLog().Printf("fsync file err: %+v\n", err)
+			}
+		case <-fh.exitC:
+			return
+		}
 	}
+}
 
-	atomic.AddInt64(&fh.writtenSize, int64(len(bs)))
+// ArchiveStats returns the counters of the archiver.
+func (fh *FileHandler) ArchiveStats() archiver.Stats {
+	return fh.archiver.Stats()
+}
+
+func (fh *FileHandler) FsyncStats() FsyncStats {
+	return fh.fsyncer.stats()
+}
+
+// writeChunks writes the lines by chunks no larger than the limit, each chunk
+// is written by one call, a line larger than the limit is written by itself.
+func writeChunks(w io.Writer, bs []byte, limit int) error {
+	for len(bs) > 0 {
+		n := chunkSize(bs, limit)
+		if _, err := w.Write(bs[:n]); err != nil {
+			return err
+		}
+		bs = bs[n:]
+	}
 	return nil
 }
 
+func chunkSize(bs []byte, limit int) int {
+	if len(bs) <= limit {
+		return len(bs)
+	}
+	if i := bytes.LastIndexByte(bs[:limit], '\n'); i >= 0 {
+		return i + 1
+	}
+	if i := bytes.IndexByte(bs[limit:], '\n'); i >= 0 {
+		return limit + i + 1
+	}
+	return len(bs)
+}
+
 func (fh *FileHandler) Rotate() error {
 	fh.rotateLock.Lock()
 	defer fh.rotateLock.Unlock()
 
+	if fh.shared {
+		if err := fh.flock.Lock(); err != nil {
+			return err
+		}
+		defer func() {
+			if err := fh.flock.Unlock(); err != nil {
+				opt.GetErrLog().Printf("unlock rotate lock err: %+v\n", err)
+			}
+		}()
+
+		// another process rotated the file while waiting for the lock
+		if fh.rotatedByOthers() {
+			return fh.reopen()
+		}
+	}
+
 	if !fh.shouldRotate() {
 		return nil
 	}
@@ -231,12 +487,19 @@ func (fh *FileHandler) Rotate() error {
 }
 
 func (fh *FileHandler) backup() (string, error) {
+	fh.syncBeforeClose()
 	fh.closeFileWriter()
 
 	backupName := fh.genBackupFileName()
+	if fh.stream {
+		backupName += streamExt
+	}
 	if err := os.Rename(fh.filePath, backupName); err != nil {
 		return "", errors.Wrap(err, "rotate file error")
 	}
+	if fh.index != nil {
+		fh.index.rename(fh.filePath, backupName)
+	}
 	return backupName, nil
 }
 
@@ -247,32 +510,183 @@ func (fh *FileHandler) postRotate(backupName string) {
 	}
 
 	//clean
-	if err := fh.cleaner.Clean(); err != nil {
+	if _, err := fh.cleaner.Clean(); err != nil {
 		opt.GetErrLog().Printf("clean backup files err: %+v\n", err)
 	}
 
 }
 
 func (fh *FileHandler) shouldRotate() bool {
+	if fh.shared && fh.rotatedByOthers() {
+		return true
+	}
 	if time.Now().After(fh.rotateAt) {
 		return true
 	}
-	if fh.writtenSize > int64(fh.rotateSize) {
+	if atomic.LoadInt64(&fh.writtenSize) > int64(fh.rotateSize) {
+		return true
+	}
+	return false
+}
+
+// rotatedByOthers re-reads the real size of the file written by all processes,
+// and returns true if the file path is not the opened file any more.
+func (fh *FileHandler) rotatedByOthers() bool {
+	openedInfo, ok := fh.openedInfo.Load().(os.FileInfo)
+	if !ok {
+		return false
+	}
+	pathInfo, err := os.Stat(fh.filePath)
+	if err != nil || !os.SameFile(pathInfo, openedInfo) {
 		return true
 	}
+	atomic.StoreInt64(&fh.writtenSize, pathInfo.Size())
 	return false
 }
 
+// genBackupFileName increases the sequence until neither the backup file
+// nor its archive exists. The calendar based rotation names the backup by
+// the start of the period it covers, rather than the rotation time, which
+// is in the next period, or even later for the file left over.
 func (fh *FileHandler) genBackupFileName() string {
-	filename := fh.fileName[:len(fh.fileName)-len(fh.fileExt)]
-	t := time.Now().Format(backupTimeFormat)
-	filename = fmt.Sprintf("%s.%s%s", filename, t, fh.fileExt)
-	return path.Join(fh.fileDir, filename)
+	baseName := fh.fileName[:len(fh.fileName)-len(fh.fileExt)]
+	sourceExt := ""
+	if fh.stream {
+		sourceExt = streamExt
+	}
+	archiveExt := sourceExt + fh.archiveFmt.Ext()
+	if fh.encryptKey != nil {
+		archiveExt += archiver.EncryptExt
+	}
+
+	stamp := time.Now()
+	if fh.calendar {
+		if start := schedule.Prev(fh.rotateSched, fh.rotateAt); !start.IsZero() {
+			stamp = start
+		}
+	}
+	for seq := 0; ; seq++ {
+		filename := fh.naming.Format(baseName, fh.fileExt, stamp, seq)
+		if !fileExists(path.Join(fh.fileDir, filename)) &&
+			!fileExists(path.Join(fh.fileDir, filename+sourceExt)) &&
+			!fileExists(path.Join(fh.archiveDir, filename+archiveExt)) {
+			return path.Join(fh.fileDir, filename)
+		}
+	}
+}
+
+// linkCurrent points the current link to the active file, the link is
+// replaced by renaming, so it is always valid for readers.
+func (fh *FileHandler) linkCurrent() {
+	if fh.currentLink == "" {
+		return
+	}
+
+	target := fh.filePath
+	if path.Dir(fh.currentLink) == fh.fileDir {
+		target = path.Base(fh.filePath)
+	}
+
+	tmpLink := fmt.Sprintf("%s.%d.tmp", fh.currentLink, os.Getpid())
+	_ = os.Remove(tmpLink)
+	if err := os.Symlink(target, tmpLink); err != nil {
+		opt.GetErrLog().Printf("create current link err: %+v\n", err)
+		return
+	}
+	if err := os.Rename(tmpLink, fh.currentLink); err != nil {
+		_ = os.Remove(tmpLink)
+		opt.GetErrLog().Printf("replace current link err: %+v\n", err)
+	}
+}
+
+func fileExists(name string) bool {
+	_, err := os.Lstat(name)
+	return err == nil
+}
+
+// Reopen closes the file and opens the file path again, it should be called
+// after the file was moved by other tools, such as on SIGHUP of logrotate.
+func (fh *FileHandler) Reopen() error {
+	fh.rotateLock.Lock()
+	defer fh.rotateLock.Unlock()
+
+	return fh.reopen()
+}
+
+func (fh *FileHandler) reopen() error {
+	if fh.fileWriter != nil {
+		fh.syncBeforeClose()
+		fh.closeFileWriter()
+	}
+	if err := fh.createFile(); err != nil {
+		return errors.Wrap(err, "reopen file error")
+	}
+	return nil
+}
+
+func (fh *FileHandler) runExternalCheck() {
+	defer fh.ticker.Stop()
+	for {
+		select {
+		case <-fh.ticker.C:
+			if err := fh.checkExternalRotate(); err != nil {
+				opt.GetErrLog().Printf("check external rotate err: %+v\n", err)
+			}
+		case <-fh.exitC:
+			return
+		}
+	}
+}
+
+// checkExternalRotate reopens the file path if the opened file was moved or
+// deleted, and resets the written size if the file was truncated.
+func (fh *FileHandler) checkExternalRotate() error {
+	fh.rotateLock.Lock()
+	defer fh.rotateLock.Unlock()
+
+	if fh.fileWriter == nil {
+		return nil
+	}
+
+	openedInfo, err := fh.fileWriter.Stat()
+	if err != nil {
+		return errors.Wrap(err, "opened file stat error")
+	}
+
+	if fh.external == externalRotateReopen {
+		pathInfo, err := os.Stat(fh.filePath)
+		if os.IsNotExist(err) {
+			return fh.reopen()
+		}
+		if err != nil {
+			return errors.Wrap(err, "file stat error")
+		}
+		if !os.SameFile(pathInfo, openedInfo) {
+			return fh.reopen()
+		}
+	}
+
+	if openedInfo.Size() < atomic.LoadInt64(&fh.writtenSize) {
+		atomic.StoreInt64(&fh.writtenSize, openedInfo.Size())
+		if fh.index != nil {
+			fh.index.reset(fh.filePath, openedInfo.Size())
+		}
+	}
+	return nil
 }
 
 func (fh *FileHandler) closeFileWriter() {
+	if fh.gzWriter != nil {
+		if err := fh.gzWriter.Close(); err != nil {
+			opt.GetErrLog().Printf("close gzip writer err: %+v\n", err)
+		}
+		fh.gzWriter = nil
+	}
 	_ = fh.fileWriter.Close()
 	fh.fileWriter = nil
+	if fh.index != nil {
+		fh.index.close()
+	}
 }
 
 func (fh *FileHandler) settingFileInfo() (err error) {
@@ -288,6 +702,70 @@ func (fh *FileHandler) settingFileInfo() (err error) {
 	return nil
 }
 
+func (fh *FileHandler) settingShared() error {
+	if !fh.BaseHandler.handlerConfig.Shared {
+		return nil
+	}
+	if !flock.Supported {
+		return errors.New("shared mode is not supported on this platform")
+	}
+	fh.shared = true
+	fh.flock = flock.New(fh.filePath + lockFileExt)
+	return nil
+}
+
+// settingFileIndex the offsets of the time index are of the plain file
+// written by this process only, so it works in neither stream nor shared
+// mode. The bloom filter has all the values only if written by this
+// process, so it works in stream mode but not shared mode, and it is not
+// encrypted, so it does not work with the encrypted archives either.
+func (fh *FileHandler) settingFileIndex() (err error) {
+	timeConf := fh.BaseHandler.handlerConfig.TimeIndex
+	bloomConf := fh.BaseHandler.handlerConfig.Bloom
+	if timeConf == nil && bloomConf == nil {
+		return nil
+	}
+	if timeConf != nil && (fh.stream || fh.shared) {
+		return errors.New("time index can not work in stream or shared mode")
+	}
+	if bloomConf != nil && fh.shared {
+		return errors.New("bloom can not work in shared mode")
+	}
+	if bloomConf != nil && fh.encryptKey != nil {
+		// the plain filter next to the archive tells whether it has a value
+		return errors.New("bloom can not work with encrypted archives")
+	}
+	fh.index, err = newFileIndex(timeConf, bloomConf)
+	return err
+}
+
+func (fh *FileHandler) settingNaming() (err error) {
+	rollover := fh.BaseHandler.handlerConfig.Rollover
+	if rollover.BackupNaming == "" {
+		rollover.BackupNaming = naming.DefaultTemplate
+	}
+	if fh.naming, err = naming.ParseTemplate(rollover.BackupNaming); err != nil {
+		return errors.Wrap(err, "parse backup naming error")
+	}
+	// the backups are named and matched in the timezone of the schedule
+	loc, err := rotateLocation(rollover.Timezone)
+	if err != nil {
+		return err
+	}
+	fh.naming = fh.naming.In(loc)
+
+	if rollover.CurrentLink != "" {
+		fh.currentLink = rollover.CurrentLink
+		if !path.IsAbs(fh.currentLink) {
+			fh.currentLink = path.Join(fh.fileDir, fh.currentLink)
+		}
+		if fh.currentLink == fh.filePath {
+			return errors.New("current link should not be the log file")
+		}
+	}
+	return nil
+}
+
 func (fh *FileHandler) settingRolloverSize() (err error) {
 	if fh.BaseHandler.handlerConfig.Rollover.RolloverSize == "" {
 		fh.BaseHandler.handlerConfig.Rollover.RolloverSize = defaultLogSize
@@ -301,19 +779,59 @@ func (fh *FileHandler) settingRolloverSize() (err error) {
 }
 
 func (fh *FileHandler) settingRolloverInterval() (err error) {
-	if fh.BaseHandler.handlerConfig.Rollover.RolloverInterval == "" {
-		fh.BaseHandler.handlerConfig.Rollover.RolloverInterval = defaultRolloverTime
+	rollover := fh.BaseHandler.handlerConfig.Rollover
+	if rollover.RolloverInterval == "" {
+		rollover.RolloverInterval = defaultRolloverTime
+	}
+	if rollover.RolloverWeekday == "" {
+		rollover.RolloverWeekday = defaultRolloverWeekday
+	}
+
+	loc, err := rotateLocation(rollover.Timezone)
+	if err != nil {
+		return err
+	}
+
+	if rollover.RolloverAt != "" {
+		if fh.rotateSched, err = schedule.ParseCron(rollover.RolloverAt, loc); err != nil {
+			return errors.Wrap(err, "parse rollover at error")
+		}
+		fh.calendar = true
+		return nil
 	}
-	fh.rotateInterval, err = utils.ParseSeconds(fh.BaseHandler.handlerConfig.Rollover.RolloverInterval)
+
+	interval, err := utils.ParseSeconds(rollover.RolloverInterval)
 	if err != nil {
 		return errors.Wrap(err, "parse rotate interval error")
 	}
+	duration := time.Duration(interval) * time.Second
+
+	if !rollover.RolloverAlign {
+		fh.rotateSched = schedule.NewIntervalSchedule(duration)
+		return nil
+	}
 
+	weekday, err := schedule.ParseWeekday(rollover.RolloverWeekday)
+	if err != nil {
+		return errors.Wrap(err, "parse rotate weekday error")
+	}
+	if fh.rotateSched, err = schedule.NewAlignedSchedule(duration, loc, weekday); err != nil {
+		return errors.Wrap(err, "create aligned rotate schedule error")
+	}
+	fh.calendar = true
 	return nil
 }
 
-func (fh *FileHandler) nextTimeRotate(interval int) time.Time {
-	return time.Now().Add(time.Duration(interval) * time.Second)
+// rotateLocation loads the timezone of the rotation, the local by default.
+func rotateLocation(timezone string) (*time.Location, error) {
+	if timezone == "" {
+		return time.Local, nil
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, errors.Wrap(err, "load rotate timezone error")
+	}
+	return loc, nil
 }
 
 func (fh *FileHandler) settingBackupTime() (err error) {
@@ -328,6 +846,26 @@ func (fh *FileHandler) settingBackupTime() (err error) {
 
 }
 
+func (fh *FileHandler) retentionTiers() ([]cleaner.Tier, error) {
+	tiers := make([]cleaner.Tier, 0)
+	for _, rt := range fh.BaseHandler.handlerConfig.Rollover.Retention {
+		keep, err := utils.ParseSeconds(rt.For)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse retention for %q error", rt.For)
+		}
+		tier := cleaner.Tier{Keep: time.Duration(keep) * time.Second}
+		if every := strings.ToLower(rt.Every); every != "" && every != "all" {
+			period, err := utils.ParseSeconds(every)
+			if err != nil {
+				return nil, errors.Wrapf(err, "parse retention every %q error", rt.Every)
+			}
+			tier.Every = time.Duration(period) * time.Second
+		}
+		tiers = append(tiers, tier)
+	}
+	return tiers, nil
+}
+
 func (fh *FileHandler) settingBackupCount() error {
 	if fh.BaseHandler.handlerConfig.Rollover.BackupCount <= 0 {
 		fh.BaseHandler.handlerConfig.Rollover.BackupCount = defaultBackupCount
@@ -345,52 +883,82 @@ func (fh *FileHandler) settingFileWriter() (err error) {
 	if err != nil {
 		return errors.Wrap(err, "open file error")
 	}
+
+	if fh.stream {
+		// appending to an existing file starts a new gzip member, which is
+		// still a valid gzip file
+		counter := &countingWriter{writer: fh.fileWriter, count: &fh.writtenSize}
+		if fh.gzWriter, err = gzip.NewWriterLevel(counter, gzipLevel(fh.archiveConf.Level)); err != nil {
+			return errors.Wrap(err, "create gzip writer error")
+		}
+	}
 	return nil
 }
 
+// countingWriter counts the bytes written into the file under gzip.
+type countingWriter struct {
+	writer io.Writer
+	count  *int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.writer.Write(p)
+	atomic.AddInt64(cw.count, int64(n))
+	return n, err
+}
+
+func gzipLevel(level int) int {
+	if level == 0 {
+		return gzip.DefaultCompression
+	}
+	return level
+}
+
 func (fh *FileHandler) settingWrittenSize() error {
 	if fileInfo, err := os.Stat(fh.filePath); err != nil {
 		return errors.Wrap(err, "file stat error")
 	} else {
-		fh.writtenSize = fileInfo.Size()
+		atomic.StoreInt64(&fh.writtenSize, fileInfo.Size())
 	}
 
 	return nil
 }
 
-func (fh *FileHandler) settingSync() error {
-
-	if fh.BaseHandler.handlerConfig.Sync.QueueSize <= 0 {
-		fh.BaseHandler.handlerConfig.Sync.QueueSize = defaultQueueSize
-	}
-	if fh.BaseHandler.handlerConfig.Sync.FlushInterval <= 0 {
-		fh.BaseHandler.handlerConfig.Sync.FlushInterval = defaultFlushInterval
+func (fh *FileHandler) settingExternalRotate() error {
+	rollover := fh.BaseHandler.handlerConfig.Rollover
+	switch rollover.ExternalRotate {
+	case externalRotateNone:
+		return nil
+	case externalRotateReopen, externalRotateCopyTruncate:
+	default:
+		return errors.Errorf("unknown external rotate mode: %s", rollover.ExternalRotate)
 	}
-	if fh.BaseHandler.handlerConfig.Sync.FlushSize <= 0 {
-		fh.BaseHandler.handlerConfig.Sync.FlushSize = defaultFlushSize
+	if rollover.ExternalCheckInterval <= 0 {
+		rollover.ExternalCheckInterval = defaultExternalCheckInterval
 	}
 
-	fh.queueSize = fh.BaseHandler.handlerConfig.Sync.QueueSize
-	fh.flushInterval = fh.BaseHandler.handlerConfig.Sync.FlushInterval
-	fh.flushSize = fh.BaseHandler.handlerConfig.Sync.FlushSize
-	fh.asyncWrite = fh.BaseHandler.handlerConfig.Sync.AsyncWrite
-
+	fh.external = rollover.ExternalRotate
+	fh.ticker = time.NewTicker(time.Duration(rollover.ExternalCheckInterval) * time.Millisecond)
+	go fh.runExternalCheck()
 	return nil
 }
 
-func (fh *FileHandler) settingChan() error {
-	if !fh.asyncWrite {
+func (fh *FileHandler) settingDurability() (err error) {
+	syncConf := fh.BaseHandler.handlerConfig.Sync
+	if fh.fsyncer.durability, err = NewDurability(syncConf.Durability); err != nil {
+		return err
+	}
+	if fh.durable, err = newDurableFilter(syncConf.DurableLevel); err != nil {
+		return err
+	}
+	if fh.fsyncer.durability != DurabilityInterval {
 		return nil
 	}
 
-	fh.asyncMutex = new(sync.RWMutex)
-	fh.queueFull = make(chan bool)
-	fh.entryC = make(chan *core.LogEntry, fh.queueSize)
-	fh.entryBuf = make([]*core.LogEntry, 0)
-	fh.ticker = time.NewTicker(time.Duration(fh.flushInterval) * time.Millisecond)
-
-	go fh.runChan()
-
+	if syncConf.FsyncInterval <= 0 {
+		syncConf.FsyncInterval = defaultFsyncInterval
+	}
+	go fh.runFsync(time.Duration(syncConf.FsyncInterval) * time.Millisecond)
 	return nil
 }
 
@@ -398,11 +966,37 @@ func (fh *FileHandler) settingCleaner() (err error) {
 	duration := time.Duration(fh.backupTime) * time.Second
 	baseName := fh.fileName[:len(fh.fileName)-len(fh.fileExt)]
 
-	fh.cleaner, err = cleaner.NewLogCleaner(
-		fh.fileDir, baseName,
+	var maxTotalSize int
+	if fh.BaseHandler.handlerConfig.Rollover.MaxTotalSize != "" {
+		maxTotalSize, err = utils.ParseSize(fh.BaseHandler.handlerConfig.Rollover.MaxTotalSize)
+		if err != nil {
+			return errors.Wrap(err, "parse max total size error")
+		}
+	}
+
+	options := []cleaner.Option{
 		cleaner.SetBackupCount(fh.backupCount),
 		cleaner.SetBackupDuration(duration),
-	)
+		cleaner.SetBackupExts(fh.backupExts()...),
+		cleaner.SetArchiveDir(fh.archiveDir),
+		cleaner.SetSidecarExts(archiver.ManifestExt, archiver.UploadedExt, index.Ext, index.BloomExt),
+		cleaner.SetNaming(fh.naming, fh.fileExt),
+		cleaner.SetMaxTotalSize(int64(maxTotalSize), fh.filePath),
+	}
+	if tiers, err := fh.retentionTiers(); err != nil {
+		return err
+	} else if len(tiers) > 0 {
+		options = append(options, cleaner.SetTiers(tiers...))
+	}
+	if fh.BaseHandler.handlerConfig.Rollover.CleanDryRun {
+		options = append(options, cleaner.SetDryRun(true))
+	}
+	if fh.uploader != nil {
+		// the local copy is deleted only once uploaded
+		options = append(options, cleaner.SetRemovableMark(archiver.UploadedExt))
+	}
+
+	fh.cleaner, err = cleaner.NewLogCleaner(fh.fileDir, baseName, options...)
 	if err != nil {
 		return errors.Wrap(err, "create log cleaner error")
 	}
@@ -414,71 +1008,156 @@ func (fh *FileHandler) settingCleaner() (err error) {
 	return nil
 }
 
-func (fh *FileHandler) settingArchiver() (err error) {
+func (fh *FileHandler) settingDiskGuard() error {
+	rollover := fh.BaseHandler.handlerConfig.Rollover
+	if rollover.DiskLowWater == "" {
+		return nil
+	}
+	if rollover.DiskStopWater == "" {
+		rollover.DiskStopWater = defaultDiskStopWater
+	}
+	if rollover.DiskCheckInterval <= 0 {
+		rollover.DiskCheckInterval = defaultDiskCheckInterval
+	}
 
-	fh.archiver, err = archiver.NewLogArchiver(fh.fileDir)
+	lowWater, err := parseWaterMark(rollover.DiskLowWater)
 	if err != nil {
-		return errors.Wrap(err, "create log archiver error")
+		return err
 	}
-
-	if err = fh.archiver.Init(); err != nil {
-		return errors.Wrap(err, "init log archiver error")
+	stopWater, err := parseWaterMark(rollover.DiskStopWater)
+	if err != nil {
+		return err
 	}
 
-	return nil
-}
-
-func (fh *FileHandler) notifyFull() {
-	select {
-	case fh.queueFull <- true:
-	default:
+	if err = os.MkdirAll(fh.fileDir, os.ModePerm); err != nil {
+		return errors.Wrap(err, "create dir error")
 	}
+	guard := newDiskGuard(fh.fileDir, lowWater, stopWater, fh.cleaner,
+		time.Duration(rollover.DiskCheckInterval)*time.Millisecond, fh.exitC)
+	if err = guard.Init(); err != nil {
+		// the guard is optional, keep writing without it
+		opt.GetErrLog().Printf("[DiskGuard] disabled, err: %+v\n", err)
+		return nil
+	}
+	fh.guard = guard
+	return nil
 }
 
-func (fh *FileHandler) runChan() {
-	for {
-		select {
-		case logEntry := <-fh.entryC:
-			fh.appendLogEntry(logEntry)
-		case <-fh.ticker.C:
-			fh.handleLogEntry()
-		case <-fh.queueFull:
-			fh.handleLogEntry()
+// backupExts the extensions of all archive formats, encrypted or not. The
+// uncompressed backup is only counted if it is the archive of the configured
+// format, otherwise it is waiting for the archiver, and the cleaner should
+// neither count it twice nor delete it before archived.
+func (fh *FileHandler) backupExts() []string {
+	exts := make([]string, 0)
+	for _, ext := range archiver.Exts() {
+		if ext != "" || (fh.archiveFmt == archiver.FormatNone && fh.encryptKey == nil) {
+			exts = append(exts, ext)
 		}
+		exts = append(exts, ext+archiver.EncryptExt)
 	}
+	return exts
 }
 
-func (fh *FileHandler) appendLogEntry(entry *core.LogEntry) {
-	fh.asyncMutex.RLock()
-	defer fh.asyncMutex.RUnlock()
+// settingArchive in stream mode, the file is written with gzip extension,
+// and the backups are only moved to the archive dir.
+func (fh *FileHandler) settingArchive() (err error) {
+	rollover := fh.BaseHandler.handlerConfig.Rollover
+	if rollover.Archive == nil {
+		rollover.Archive = config.NewArchiveConfig()
+	}
+	fh.archiveConf = rollover.Archive
 
-	fh.entryBuf = append(fh.entryBuf, entry)
-}
+	if fh.archiveFmt, err = archiver.NewFormat(fh.archiveConf.Format); err != nil {
+		return err
+	}
+	if fh.archiveConf.Level < 0 || fh.archiveConf.Level > 9 {
+		return errors.Errorf("invalid compression level: %d", fh.archiveConf.Level)
+	}
 
-func (fh *FileHandler) handleLogEntry() {
-	fh.asyncMutex.Lock()
-	defer fh.asyncMutex.Unlock()
+	fh.archiveDir = fh.fileDir
+	if fh.archiveConf.Dir != "" {
+		fh.archiveDir = fh.archiveConf.Dir
+	}
 
-	if len(fh.entryBuf) == 0 {
-		return
+	if encrypt := fh.archiveConf.Encrypt; encrypt != nil {
+		if fh.encryptKey, err = archiver.LoadKey(encrypt.KeyFile, encrypt.KeyEnv); err != nil {
+			return errors.Wrap(err, "load encryption key error")
+		}
 	}
 
-	blocks := utils.CalculateBlocks(len(fh.entryBuf), fh.flushSize)
-	for i := 0; i < blocks; i++ {
-		buf := bufferpool.Borrow()
+	if upload := fh.archiveConf.Upload; upload != nil {
+		switch strings.ToLower(upload.Type) {
+		case "http":
+			if upload.Endpoint == "" {
+				return errors.New("upload endpoint is empty")
+			}
+			options := []archiver.HTTPUploaderOpt{archiver.SetHeaders(upload.Headers)}
+			if upload.Timeout > 0 {
+				options = append(options, archiver.SetTimeout(time.Duration(upload.Timeout)*time.Millisecond))
+			}
+			fh.uploader = archiver.NewHTTPUploader(upload.Endpoint, options...)
+		case "dir":
+			if upload.Dir == "" {
+				return errors.New("upload dir is empty")
+			}
+			fh.uploader = archiver.NewDirUploader(upload.Dir)
+		default:
+			return errors.Errorf("unknown upload type: %s", upload.Type)
+		}
+	}
 
-		for j := 0; j < fh.flushSize && i*blocks+j < len(fh.entryBuf); j++ {
-			entry := fh.entryBuf[i*blocks+j]
-			b := fh.formatter.Format(entry)
-			buf.AppendBytes(b.Bytes())
-			b.Free()
+	if fh.archiveConf.Stream {
+		if fh.BaseHandler.handlerConfig.Shared {
+			return errors.New("stream archive can not work in shared mode")
 		}
+		fh.stream = true
+		fh.archiveFmt = archiver.FormatNone
+		fh.filePath += streamExt
+	}
+	return nil
+}
 
-		if err := fh.syncFlush(buf.Bytes()); err != nil {
-			opt.GetErrLog().Printf("sync flush log err: %+v\n", err)
+func (fh *FileHandler) settingArchiver() (err error) {
+	// the backups not archived before restart are rotated files of the naming
+	suffix := ""
+	if fh.stream {
+		suffix = streamExt
+	}
+	baseName := fh.fileName[:len(fh.fileName)-len(fh.fileExt)]
+	matcher, err := fh.naming.Matcher(baseName, fh.fileExt, suffix)
+	if err != nil {
+		return errors.Wrap(err, "create backup name matcher error")
+	}
+
+	options := []archiver.ArchiverOpt{
+		archiver.SetFormat(fh.archiveFmt),
+		archiver.SetLevel(fh.archiveConf.Level),
+		archiver.SetResume(fh.fileDir, matcher),
+		archiver.SetManifest(fh.archiveConf.Manifest),
+		archiver.SetSidecarExts(index.Ext, index.BloomExt),
+	}
+	if fh.encryptKey != nil {
+		options = append(options, archiver.SetEncryption(fh.archiveConf.Encrypt.KeyID, fh.encryptKey))
+	}
+	if fh.uploader != nil {
+		options = append(options, archiver.SetUploader(fh.uploader))
+	}
+	if fh.archiveConf.Delay != "" {
+		delay, err := utils.ParseSeconds(fh.archiveConf.Delay)
+		if err != nil {
+			return errors.Wrap(err, "parse archive delay error")
 		}
-		buf.Free()
+		options = append(options, archiver.SetBackupDelay(time.Duration(delay)*time.Second))
 	}
 
-	fh.entryBuf = fh.entryBuf[:0]
+	fh.archiver, err = archiver.NewLogArchiver(fh.archiveDir, options...)
+	if err != nil {
+		return errors.Wrap(err, "create log archiver error")
+	}
+
+	if err = fh.archiver.Init(); err != nil {
+		return errors.Wrap(err, "init log archiver error")
+	}
+
+	return nil
 }
@@ -0,0 +1,887 @@
+package handler
+
+import (
+	"compress/gzip"
+	"fmt"
+	"github.com/edditen/etlog/config"
+	"github.com/edditen/etlog/core"
+	"github.com/edditen/etlog/handler/index"
+	"github.com/edditen/etlog/handler/naming"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestFileHandler(t *testing.T, setting func(conf *config.HandlerConfig)) (*FileHandler, string) {
+	filePath := path.Join(t.TempDir(), "app.log")
+	conf := config.NewHandlerConfig()
+	conf.File = filePath
+	conf.Levels = allLevels
+	if setting != nil {
+		setting(conf)
+	}
+	h := NewFileHandler(conf)
+	if err := h.Init(); err != nil {
+		t.Fatalf("init err: %+v", err)
+	}
+	t.Cleanup(h.Shutdown)
+	return h, filePath
+}
+
+func fileEntry(msg string) *core.LogEntry {
+	return &core.LogEntry{Time: time.Now(), Level: core.INFO, Msg: msg}
+}
+
+func TestFileHandler_ExternalRotate(t *testing.T) {
+	external := func(mode string) func(conf *config.HandlerConfig) {
+		return func(conf *config.HandlerConfig) {
+			conf.Rollover.ExternalRotate = mode
+			conf.Rollover.ExternalCheckInterval = 60 * 1000
+		}
+	}
+
+	t.Run("when file moved then reopen the path", func(t *testing.T) {
+		h, filePath := newTestFileHandler(t, external(externalRotateReopen))
+		_ = h.Handle(fileEntry("before"))
+		if err := os.Rename(filePath, filePath+".1"); err != nil {
+			t.Fatal(err)
+		}
+		if err := h.checkExternalRotate(); err != nil {
+			t.Fatalf("check err: %+v", err)
+		}
+		_ = h.Handle(fileEntry("after"))
+
+		moved, _ := ioutil.ReadFile(filePath + ".1")
+		current, _ := ioutil.ReadFile(filePath)
+		if strings.Contains(string(moved), "after") || !strings.Contains(string(current), "after") {
+			t.Errorf("moved: %s, current: %s", moved, current)
+		}
+	})
+
+	t.Run("when file deleted then recreate the path", func(t *testing.T) {
+		h, filePath := newTestFileHandler(t, external(externalRotateReopen))
+		_ = h.Handle(fileEntry("before"))
+		_ = os.Remove(filePath)
+		if err := h.checkExternalRotate(); err != nil {
+			t.Fatalf("check err: %+v", err)
+		}
+		_ = h.Handle(fileEntry("after"))
+
+		current, _ := ioutil.ReadFile(filePath)
+		if !strings.Contains(string(current), "after") {
+			t.Errorf("current: %s", current)
+		}
+	})
+
+	t.Run("when file truncated then reset written size", func(t *testing.T) {
+		h, filePath := newTestFileHandler(t, external(externalRotateCopyTruncate))
+		_ = h.Handle(fileEntry("before"))
+		if err := os.Truncate(filePath, 0); err != nil {
+			t.Fatal(err)
+		}
+		if err := h.checkExternalRotate(); err != nil {
+			t.Fatalf("check err: %+v", err)
+		}
+		if size := atomic.LoadInt64(&h.writtenSize); size != 0 {
+			t.Errorf("written size = %d, want 0", size)
+		}
+	})
+
+	t.Run("when reopen then write to the new file", func(t *testing.T) {
+		h, filePath := newTestFileHandler(t, nil)
+		_ = h.Handle(fileEntry("before"))
+		_ = os.Rename(filePath, filePath+".1")
+		if err := h.Reopen(); err != nil {
+			t.Fatalf("reopen err: %+v", err)
+		}
+		_ = h.Handle(fileEntry("after"))
+
+		current, _ := ioutil.ReadFile(filePath)
+		if !strings.Contains(string(current), "after") {
+			t.Errorf("current: %s", current)
+		}
+	})
+}
+
+func TestFileHandler_Shared(t *testing.T) {
+	t.Run("when handlers share the file then no line lost", func(t *testing.T) {
+		dir := t.TempDir()
+		filePath := path.Join(dir, "app.log")
+		newShared := func() *FileHandler {
+			conf := config.NewHandlerConfig()
+			conf.File = filePath
+			conf.Levels = allLevels
+			conf.Shared = true
+			conf.Rollover.RolloverSize = "4k"
+			h := NewFileHandler(conf)
+			if err := h.Init(); err != nil {
+				t.Fatalf("init err: %+v", err)
+			}
+			return h
+		}
+		first, second := newShared(), newShared()
+		defer first.Shutdown()
+		defer second.Shutdown()
+
+		const count = 500
+		done := make(chan struct{})
+		for _, h := range []*FileHandler{first, second} {
+			go func(h *FileHandler) {
+				defer func() { done <- struct{}{} }()
+				for i := 0; i < count; i++ {
+					if err := h.Handle(fileEntry("shared line")); err != nil {
+						t.Errorf("handle err: %+v", err)
+					}
+				}
+			}(h)
+		}
+		<-done
+		<-done
+
+		files, _ := ioutil.ReadDir(dir)
+		lines, backups := 0, 0
+		for _, f := range files {
+			if !strings.HasPrefix(f.Name(), "app") || strings.HasSuffix(f.Name(), lockFileExt) {
+				continue
+			}
+			if f.Name() != "app.log" {
+				backups++
+			}
+			b, _ := ioutil.ReadFile(path.Join(dir, f.Name()))
+			lines += strings.Count(string(b), "shared line")
+		}
+		if lines != 2*count {
+			t.Errorf("lines = %d, want %d", lines, 2*count)
+		}
+		if backups == 0 {
+			t.Errorf("no rotation happened")
+		}
+	})
+}
+
+func TestFileHandler_CalendarRotate(t *testing.T) {
+	const dateNaming = "{name}-{date:2006-01-02}-{seq}{ext}"
+	today := time.Now()
+	midnight := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, time.Local)
+	daysAgo := func(days int) time.Time {
+		return midnight.AddDate(0, 0, -days).Add(15 * time.Hour)
+	}
+	backupName := func(tm time.Time, seq int) string {
+		return fmt.Sprintf("app-%s-%d.log", tm.Format("2006-01-02"), seq)
+	}
+	aligned := func(conf *config.HandlerConfig) {
+		conf.Rollover.RolloverInterval = "1d"
+		conf.Rollover.RolloverAlign = true
+		conf.Rollover.BackupNaming = dateNaming
+	}
+	cron := func(conf *config.HandlerConfig) {
+		conf.Rollover.RolloverAt = "0 0 * * *"
+		conf.Rollover.BackupNaming = dateNaming
+	}
+
+	for name, setting := range map[string]func(conf *config.HandlerConfig){"aligned": aligned, "cron": cron} {
+		t.Run("when "+name+" and file left over then named by its day", func(t *testing.T) {
+			dir := t.TempDir()
+			filePath := path.Join(dir, "app.log")
+			if err := ioutil.WriteFile(filePath, []byte("left over\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			leftAt := daysAgo(3)
+			if err := os.Chtimes(filePath, leftAt, leftAt); err != nil {
+				t.Fatal(err)
+			}
+			conf := config.NewHandlerConfig()
+			conf.File = filePath
+			conf.Levels = allLevels
+			setting(conf)
+			h := NewFileHandler(conf)
+			if err := h.Init(); err != nil {
+				t.Fatalf("init err: %+v", err)
+			}
+			defer h.Shutdown()
+			if err := h.Handle(fileEntry("today")); err != nil {
+				t.Fatal(err)
+			}
+			content, err := ioutil.ReadFile(path.Join(dir, backupName(leftAt, 0)))
+			if err != nil || string(content) != "left over\n" {
+				t.Errorf("backup %s: %q, err: %v, files: %v", backupName(leftAt, 0), content, err, globFiles(dir, "app*"))
+			}
+		})
+
+		t.Run("when "+name+" and period ends then named by the period", func(t *testing.T) {
+			h, filePath := newTestFileHandler(t, setting)
+			if err := h.Handle(fileEntry("yesterday")); err != nil {
+				t.Fatal(err)
+			}
+			// the day ended as if the file was opened yesterday
+			h.rotateAt = midnight
+			if err := h.Handle(fileEntry("today")); err != nil {
+				t.Fatal(err)
+			}
+			if !fileExists(path.Join(path.Dir(filePath), backupName(daysAgo(1), 0))) {
+				t.Errorf("want backup of yesterday, files: %v", globFiles(path.Dir(filePath), "app*"))
+			}
+		})
+	}
+
+	t.Run("when timezone not local then named and matched in it", func(t *testing.T) {
+		// UTC+14, never the local zone of the tests
+		const timezone = "Pacific/Kiritimati"
+		loc, err := time.LoadLocation(timezone)
+		if err != nil {
+			t.Skipf("load timezone err: %v", err)
+		}
+		h, filePath := newTestFileHandler(t, func(conf *config.HandlerConfig) {
+			aligned(conf)
+			conf.Rollover.Timezone = timezone
+		})
+		now := time.Now().In(loc)
+		zoneMidnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+		yesterday := zoneMidnight.AddDate(0, 0, -1)
+
+		if err := h.Handle(fileEntry("yesterday")); err != nil {
+			t.Fatal(err)
+		}
+		h.rotateAt = zoneMidnight
+		if err := h.Handle(fileEntry("today")); err != nil {
+			t.Fatal(err)
+		}
+		name := backupName(yesterday, 0)
+		if !fileExists(path.Join(path.Dir(filePath), name)) {
+			t.Fatalf("want backup %s, files: %v", name, globFiles(path.Dir(filePath), "app*"))
+		}
+		// the cleaner ages the backup by the same time
+		matcher, err := h.naming.Matcher("app", ".log", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, _, ok := matcher.Match(name); !ok || !got.Equal(yesterday) {
+			t.Errorf("Match(%s) = %v, %v, want %v", name, got, ok, yesterday)
+		}
+	})
+}
+
+func TestFileHandler_Naming(t *testing.T) {
+	rotate := func(t *testing.T, h *FileHandler, msg string) {
+		t.Helper()
+		if err := h.Handle(fileEntry(msg)); err != nil {
+			t.Fatal(err)
+		}
+		h.rotateAt = time.Now().Add(-time.Second)
+		if err := h.Rotate(); err != nil {
+			t.Fatalf("rotate err: %+v", err)
+		}
+	}
+	readFile := func(t *testing.T, filename string) string {
+		t.Helper()
+		b, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatalf("read err: %+v", err)
+		}
+		return string(b)
+	}
+
+	t.Run("when rotated twice in a second then sequence increased", func(t *testing.T) {
+		h, filePath := newTestFileHandler(t, func(conf *config.HandlerConfig) {
+			conf.Rollover.BackupNaming = "{name}.{date}-{seq}{ext}"
+		})
+		// both rotations run in the same second
+		time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(time.Second)))
+		rotated := time.Now()
+		rotate(t, h, "first")
+		rotate(t, h, "second")
+
+		dir := path.Dir(filePath)
+		for seq, msg := range []string{"first", "second"} {
+			backup := path.Join(dir, fmt.Sprintf("app.%s-%d.log", rotated.Format(naming.DefaultTimeFormat), seq))
+			if content := readFile(t, backup); !strings.Contains(content, msg) {
+				t.Errorf("backup %s = %q, want %s", backup, content, msg)
+			}
+		}
+	})
+
+	linkTests := []struct {
+		name       string
+		link       func(dir string) string
+		wantTarget func(filePath string) string
+	}{
+		{"when link in the log dir then relative target", func(dir string) string { return "current.log" },
+			func(filePath string) string { return path.Base(filePath) }},
+		{"when link in other dir then absolute target", func(dir string) string { return path.Join(dir, "current.log") },
+			func(filePath string) string { return filePath }},
+	}
+	for _, tt := range linkTests {
+		t.Run(tt.name, func(t *testing.T) {
+			linkDir := t.TempDir()
+			h, filePath := newTestFileHandler(t, func(conf *config.HandlerConfig) {
+				conf.Rollover.CurrentLink = tt.link(linkDir)
+			})
+			link := h.currentLink
+			if err := h.Handle(fileEntry("first")); err != nil {
+				t.Fatal(err)
+			}
+			if target, err := os.Readlink(link); err != nil || target != tt.wantTarget(filePath) {
+				t.Fatalf("link target = %s, err: %v, want %s", target, err, tt.wantTarget(filePath))
+			}
+
+			rotate(t, h, "second")
+			if err := h.Handle(fileEntry("third")); err != nil {
+				t.Fatal(err)
+			}
+			if target, err := os.Readlink(link); err != nil || target != tt.wantTarget(filePath) {
+				t.Errorf("link target = %s, err: %v, want %s", target, err, tt.wantTarget(filePath))
+			}
+			// the link follows the new file rather than the backup
+			if content := readFile(t, link); !strings.Contains(content, "third") || strings.Contains(content, "second") {
+				t.Errorf("link content = %q, want the new file", content)
+			}
+			if tmp := globFiles(path.Dir(link), "current.log.*"); len(tmp) > 0 {
+				t.Errorf("tmp links left: %v", tmp)
+			}
+		})
+	}
+}
+
+func TestFileHandler_BackupExts(t *testing.T) {
+	hasExt := func(exts []string, want string) bool {
+		for _, ext := range exts {
+			if ext == want {
+				return true
+			}
+		}
+		return false
+	}
+	tests := []struct {
+		name    string
+		format  string
+		encrypt bool
+		wantRaw bool
+	}{
+		{"when zip then raw backups not counted", "zip", false, false},
+		{"when none then raw backups counted", "none", false, true},
+		{"when none encrypted then raw backups not counted", "none", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, _ := newTestFileHandler(t, func(conf *config.HandlerConfig) {
+				conf.Rollover.Archive.Format = tt.format
+				if tt.encrypt {
+					keyFile := path.Join(path.Dir(conf.File), "archive.key")
+					if err := ioutil.WriteFile(keyFile, []byte(strings.Repeat("k", 32)), 0600); err != nil {
+						t.Fatal(err)
+					}
+					conf.Rollover.Archive.Encrypt = &config.EncryptConfig{KeyFile: keyFile}
+				}
+			})
+			exts := h.backupExts()
+			if hasExt(exts, "") != tt.wantRaw || !hasExt(exts, ".zip") || !hasExt(exts, ".enc") {
+				t.Errorf("backupExts() = %q", exts)
+			}
+		})
+	}
+
+	t.Run("when raw backup waits for archiving then not cleaned", func(t *testing.T) {
+		h, filePath := newTestFileHandler(t, func(conf *config.HandlerConfig) {
+			conf.Rollover.Archive.Format = "zip"
+			conf.Rollover.BackupCount = 1
+		})
+		dir := path.Dir(filePath)
+		now := time.Now()
+		raw := path.Join(dir, h.naming.Format("app", ".log", now.Add(-time.Minute), 0))
+		archived := path.Join(dir, h.naming.Format("app", ".log", now.Add(-time.Hour), 0)+".zip")
+		for _, name := range []string{raw, archived} {
+			if err := ioutil.WriteFile(name, []byte("backup\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := h.cleaner.Clean(); err != nil {
+			t.Fatalf("clean err: %+v", err)
+		}
+		if !fileExists(raw) || !fileExists(archived) {
+			t.Errorf("files = %v, want both kept", globFiles(dir, "app*"))
+		}
+	})
+}
+
+func TestFileHandler_Shutdown(t *testing.T) {
+	t.Run("when shutdown twice then no panic", func(t *testing.T) {
+		h, filePath := newTestFileHandler(t, nil)
+		if err := h.Handle(fileEntry("hello")); err != nil {
+			t.Fatal(err)
+		}
+		h.Shutdown()
+		h.Shutdown()
+		if b, err := ioutil.ReadFile(filePath); err != nil || !strings.Contains(string(b), "hello") {
+			t.Errorf("content = %q, err: %v", b, err)
+		}
+	})
+}
+
+func TestChunkSize(t *testing.T) {
+	tests := []struct {
+		name  string
+		bs    string
+		limit int
+		want  int
+	}{
+		{"when smaller than limit then all", "a\nb\n", 8, 4},
+		{"when larger then split at line", "aa\nbb\ncc\n", 7, 6},
+		{"when line larger than limit then whole line", "aaaaaa\nb\n", 4, 7},
+		{"when no line end then all", "aaaaaa", 4, 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := chunkSize([]byte(tt.bs), tt.limit); got != tt.want {
+				t.Errorf("chunkSize() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileHandler_Durability(t *testing.T) {
+	durability := func(mode string) func(conf *config.HandlerConfig) {
+		return func(conf *config.HandlerConfig) {
+			conf.Sync.Durability = mode
+			conf.Sync.FsyncInterval = 10
+		}
+	}
+
+	tests := []struct {
+		name      string
+		mode      string
+		batch     bool
+		wantSyncs func(n uint64) bool
+	}{
+		{"when none then never sync", "none", false, func(n uint64) bool { return n == 0 }},
+		{"when every write then sync each entry", "every_write", false, func(n uint64) bool { return n == 3 }},
+		{"when batch of sync path then sync each entry", "batch", false, func(n uint64) bool { return n == 3 }},
+		{"when batch of async path then sync once", "batch", true, func(n uint64) bool { return n == 1 }},
+		{"when interval then sync in background", "interval", false, func(n uint64) bool { return n >= 1 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, _ := newTestFileHandler(t, durability(tt.mode))
+			entries := LogEntries{fileEntry("a"), fileEntry("b"), fileEntry("c")}
+			if tt.batch {
+				if err := h.HandleBatch(entries); err != nil {
+					t.Fatalf("handle err: %+v", err)
+				}
+			} else {
+				for _, e := range entries {
+					if err := h.Handle(e); err != nil {
+						t.Fatalf("handle err: %+v", err)
+					}
+				}
+			}
+			if tt.mode == "interval" {
+				time.Sleep(50 * time.Millisecond)
+			}
+
+			stats := h.FsyncStats()
+			if !tt.wantSyncs(stats.Syncs) || stats.Durability != tt.mode {
+				t.Errorf("FsyncStats() = %+v", stats)
+			}
+			if stats.Syncs > 0 && stats.MaxLatency <= 0 {
+				t.Errorf("latency not recorded: %+v", stats)
+			}
+		})
+	}
+
+	t.Run("when unknown durability then init error", func(t *testing.T) {
+		conf := config.NewHandlerConfig()
+		conf.File = path.Join(t.TempDir(), "app.log")
+		conf.Sync.Durability = "always"
+		if err := NewFileHandler(conf).Init(); err == nil {
+			t.Errorf("want init error")
+		}
+	})
+
+	t.Run("when unknown durable level then init error", func(t *testing.T) {
+		conf := config.NewHandlerConfig()
+		conf.File = path.Join(t.TempDir(), "app.log")
+		conf.Sync.DurableLevel = "critical"
+		if err := NewFileHandler(conf).Init(); err == nil {
+			t.Errorf("want init error")
+		}
+	})
+
+	durableLevel := func(level string) func(conf *config.HandlerConfig) {
+		return func(conf *config.HandlerConfig) {
+			conf.Sync.Durability = "none"
+			conf.Sync.DurableLevel = level
+		}
+	}
+	durableEntry := func(msg string) *core.LogEntry {
+		entry := fileEntry(msg)
+		entry.Durable = true
+		return entry
+	}
+	errorEntry := func(msg string) *core.LogEntry {
+		entry := fileEntry(msg)
+		entry.Level = core.ERROR
+		return entry
+	}
+
+	durableTests := []struct {
+		name      string
+		level     string
+		entries   LogEntries
+		batch     bool
+		wantSyncs uint64
+	}{
+		{"when durable entry then sync it", "", LogEntries{fileEntry("a"), durableEntry("b"), fileEntry("c")}, false, 1},
+		{"when durable entry in batch then sync once", "", LogEntries{durableEntry("a"), durableEntry("b"), fileEntry("c")}, true, 1},
+		{"when no durable entry then never sync", "", LogEntries{fileEntry("a"), fileEntry("b")}, true, 0},
+		{"when at durable level then sync it", "error", LogEntries{fileEntry("a"), errorEntry("b"), errorEntry("c")}, false, 2},
+		{"when below durable level then never sync", "error", LogEntries{fileEntry("a"), fileEntry("b")}, false, 0},
+	}
+	for _, tt := range durableTests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, _ := newTestFileHandler(t, durableLevel(tt.level))
+			if tt.batch {
+				if err := h.HandleBatch(tt.entries); err != nil {
+					t.Fatalf("handle err: %+v", err)
+				}
+			} else {
+				for _, e := range tt.entries {
+					if err := h.Handle(e); err != nil {
+						t.Fatalf("handle err: %+v", err)
+					}
+				}
+			}
+			if stats := h.FsyncStats(); stats.Syncs != tt.wantSyncs {
+				t.Errorf("FsyncStats() = %+v, want %d syncs", stats, tt.wantSyncs)
+			}
+		})
+	}
+
+	t.Run("when durable entry of async write then synced on return", func(t *testing.T) {
+		filePath := path.Join(t.TempDir(), "app.log")
+		conf := config.NewHandlerConfig()
+		conf.Type = "file"
+		conf.File = filePath
+		conf.Levels = allLevels
+		conf.Sync.AsyncWrite = true
+		conf.Sync.FlushInterval = 60 * 1000
+		conf.Sync.Durability = "none"
+		conf.Sync.DurableLevel = "error"
+		h := HandlerFactory(conf)
+		if err := h.Init(); err != nil {
+			t.Fatalf("init err: %+v", err)
+		}
+		t.Cleanup(h.Shutdown)
+
+		for _, e := range []*core.LogEntry{fileEntry("queued"), durableEntry("durable"), errorEntry("error")} {
+			if err := h.Handle(e); err != nil {
+				t.Fatalf("handle err: %+v", err)
+			}
+		}
+
+		b, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			t.Fatalf("read err: %+v", err)
+		}
+		content := string(b)
+		if !strings.Contains(content, "durable") || !strings.Contains(content, "error") || strings.Contains(content, "queued") {
+			t.Errorf("content = %q, want the durable entries only", content)
+		}
+		fh := h.(*AsyncHandler).handler.(*FileHandler)
+		if stats := fh.FsyncStats(); stats.Syncs != 2 {
+			t.Errorf("FsyncStats() = %+v, want 2 syncs", stats)
+		}
+	})
+}
+
+func TestFileHandler_Archive(t *testing.T) {
+	waitFile := func(t *testing.T, pattern string) string {
+		for i := 0; i < 200; i++ {
+			if matches, _ := filepath.Glob(pattern); len(matches) > 0 {
+				return matches[0]
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		t.Fatalf("no file matches %s", pattern)
+		return ""
+	}
+	readGzip := func(t *testing.T, filename string) string {
+		f, err := os.Open(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		r, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatalf("open gzip %s err: %+v", filename, err)
+		}
+		// the active file has no gzip trailer before closed
+		b, err := ioutil.ReadAll(r)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			t.Fatalf("read gzip %s err: %+v", filename, err)
+		}
+		return string(b)
+	}
+
+	t.Run("when gzip format then archive into dir", func(t *testing.T) {
+		archiveDir := t.TempDir()
+		h, _ := newTestFileHandler(t, func(conf *config.HandlerConfig) {
+			conf.Rollover.RolloverSize = "1k"
+			conf.Rollover.Archive.Format = "gzip"
+			conf.Rollover.Archive.Level = 9
+			conf.Rollover.Archive.Delay = "1s"
+			conf.Rollover.Archive.Dir = archiveDir
+		})
+		_ = h.Handle(fileEntry(strings.Repeat("a", 2048)))
+		_ = h.Handle(fileEntry("after"))
+
+		archive := waitFile(t, path.Join(archiveDir, "app.*.log.gz"))
+		if content := readGzip(t, archive); !strings.Contains(content, "aaaa") {
+			t.Errorf("archive content: %s", content)
+		}
+	})
+
+	t.Run("when stream then write gzip directly", func(t *testing.T) {
+		h, filePath := newTestFileHandler(t, func(conf *config.HandlerConfig) {
+			conf.Rollover.RolloverSize = "1k"
+			conf.Rollover.Archive.Stream = true
+			conf.Rollover.Archive.Delay = "1s"
+		})
+		_ = h.Handle(fileEntry("first"))
+		_ = h.Handle(fileEntry("second"))
+
+		content := readGzip(t, filePath+streamExt)
+		if !strings.Contains(content, "first") || !strings.Contains(content, "second") {
+			t.Errorf("stream content: %s", content)
+		}
+
+		// the compressed size counts for the rotation
+		for i := 0; i < 200; i++ {
+			_ = h.Handle(fileEntry(fmt.Sprintf("line %d %x", i, time.Now().UnixNano())))
+		}
+		waitFile(t, path.Join(path.Dir(filePath), "app.*.log.gz"))
+		backups, _ := filepath.Glob(path.Join(path.Dir(filePath), "app.*.log.gz"))
+		content = ""
+		for _, backup := range backups {
+			content += readGzip(t, backup)
+		}
+		if !strings.Contains(content, "first") {
+			t.Errorf("first line not in backups: %v", backups)
+		}
+	})
+
+	t.Run("when stream in shared mode then init error", func(t *testing.T) {
+		conf := config.NewHandlerConfig()
+		conf.File = path.Join(t.TempDir(), "app.log")
+		conf.Shared = true
+		conf.Rollover.Archive.Stream = true
+		if err := NewFileHandler(conf).Init(); err == nil {
+			t.Errorf("want init error")
+		}
+	})
+
+	t.Run("when unknown format then init error", func(t *testing.T) {
+		conf := config.NewHandlerConfig()
+		conf.File = path.Join(t.TempDir(), "app.log")
+		conf.Rollover.Archive.Format = "rar"
+		if err := NewFileHandler(conf).Init(); err == nil {
+			t.Errorf("want init error")
+		}
+	})
+}
+
+func TestFileHandler_TimeIndex(t *testing.T) {
+	t.Run("when rotate then index archived with backup", func(t *testing.T) {
+		archiveDir := t.TempDir()
+		h, filePath := newTestFileHandler(t, func(conf *config.HandlerConfig) {
+			conf.Rollover.RolloverSize = "1k"
+			conf.Rollover.Archive.Delay = "1s"
+			conf.Rollover.Archive.Dir = archiveDir
+			conf.TimeIndex = &config.TimeIndexConfig{Every: 2, Interval: "1h"}
+		})
+		start := time.Date(2021, 6, 15, 12, 0, 0, 0, time.Local)
+		entries := make(LogEntries, 0)
+		for i := 0; i < 5; i++ {
+			entry := fileEntry(fmt.Sprintf("entry %d %s", i, strings.Repeat("a", 200)))
+			entry.Time = start.Add(time.Duration(i) * time.Minute)
+			entries = append(entries, entry)
+		}
+		if err := h.HandleBatch(entries); err != nil {
+			t.Fatal(err)
+		}
+		if err := h.Handle(fileEntry("rotated")); err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 200 && len(globFiles(archiveDir, "app.*.log.zip"+index.Ext)) == 0; i++ {
+			time.Sleep(20 * time.Millisecond)
+		}
+		indexes := globFiles(archiveDir, "app.*.log.zip"+index.Ext)
+		if len(indexes) != 1 {
+			t.Fatalf("archived indexes: %v", indexes)
+		}
+		ix, err := index.ReadFile(indexes[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := h.formatter.Format(entries[0])
+		lineSize := int64(buf.Len())
+		buf.Free()
+		if !ix.Closed || ix.Entries != 5 || len(ix.Points) != 3 ||
+			!ix.First.Equal(start) || !ix.Last.Equal(start.Add(4*time.Minute)) ||
+			ix.Points[1].Offset != 2*lineSize {
+			t.Errorf("index: %+v, line size: %d", ix, lineSize)
+		}
+
+		active, err := index.ReadFile(filePath + index.Ext)
+		if err != nil || active.Closed || len(active.Points) != 1 || active.Points[0].Offset != 0 {
+			t.Errorf("active index: %+v, err: %v", active, err)
+		}
+	})
+
+	t.Run("when stream then init error", func(t *testing.T) {
+		conf := config.NewHandlerConfig()
+		conf.File = path.Join(t.TempDir(), "app.log")
+		conf.Rollover.Archive.Stream = true
+		conf.TimeIndex = &config.TimeIndexConfig{}
+		if err := NewFileHandler(conf).Init(); err == nil {
+			t.Errorf("want init error")
+		}
+	})
+}
+
+func TestFileHandler_Bloom(t *testing.T) {
+	traceEntry := func(msg, traceID string) *core.LogEntry {
+		entry := fileEntry(msg + " " + strings.Repeat("a", 200))
+		entry.Fields = core.Fields{"trace_id": traceID, "user_id": 42}
+		return entry
+	}
+
+	t.Run("when rotate then bloom archived with backup", func(t *testing.T) {
+		archiveDir := t.TempDir()
+		h, filePath := newTestFileHandler(t, func(conf *config.HandlerConfig) {
+			conf.Rollover.RolloverSize = "1k"
+			conf.Rollover.Archive.Delay = "1s"
+			conf.Rollover.Archive.Dir = archiveDir
+			conf.Bloom = &config.BloomConfig{Fields: []string{"trace_id", "user_id"}}
+		})
+		entries := make(LogEntries, 0)
+		for i := 0; i < 5; i++ {
+			entries = append(entries, traceEntry("entry", fmt.Sprintf("t%d", i)))
+		}
+		if err := h.HandleBatch(entries); err != nil {
+			t.Fatal(err)
+		}
+		if err := h.Handle(traceEntry("rotated", "t9")); err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 200 && len(globFiles(archiveDir, "app.*.log.zip"+index.BloomExt)) == 0; i++ {
+			time.Sleep(20 * time.Millisecond)
+		}
+		blooms := globFiles(archiveDir, "app.*.log.zip"+index.BloomExt)
+		if len(blooms) != 1 {
+			t.Fatalf("archived blooms: %v", blooms)
+		}
+		bloom, err := index.ReadBloomFile(blooms[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bloom.Partial() || bloom.Count() != 10 || !bloom.MayContain("trace_id", "t4") ||
+			!bloom.MayContain("user_id", "42") || bloom.MayContain("trace_id", "t9") {
+			t.Errorf("archived bloom: %d values, partial %v", bloom.Count(), bloom.Partial())
+		}
+		if _, err := os.Stat(filePath + index.BloomExt); !os.IsNotExist(err) {
+			t.Errorf("the bloom of the active file should not be on the disk, err: %v", err)
+		}
+	})
+
+	t.Run("when restart then bloom continued", func(t *testing.T) {
+		conf := config.NewHandlerConfig()
+		conf.File = path.Join(t.TempDir(), "app.log")
+		conf.Levels = allLevels
+		conf.Bloom = &config.BloomConfig{Fields: []string{"trace_id"}}
+		for _, traceID := range []string{"before", "after"} {
+			h := NewFileHandler(conf)
+			if err := h.Init(); err != nil {
+				t.Fatal(err)
+			}
+			if err := h.Handle(traceEntry("entry", traceID)); err != nil {
+				t.Fatal(err)
+			}
+			h.Shutdown()
+		}
+		bloom, err := index.ReadBloomFile(conf.File + index.BloomExt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bloom.Partial() || bloom.Count() != 2 || !bloom.MayContain("trace_id", "before") || !bloom.MayContain("trace_id", "after") {
+			t.Errorf("bloom: %d values, partial %v", bloom.Count(), bloom.Partial())
+		}
+	})
+
+	t.Run("when file written without bloom then partial", func(t *testing.T) {
+		filePath := path.Join(t.TempDir(), "app.log")
+		_ = ioutil.WriteFile(filePath, []byte("written before\n"), 0644)
+		conf := config.NewHandlerConfig()
+		conf.File = filePath
+		conf.Levels = allLevels
+		conf.Bloom = &config.BloomConfig{Fields: []string{"trace_id"}}
+		h := NewFileHandler(conf)
+		if err := h.Init(); err != nil {
+			t.Fatal(err)
+		}
+		if err := h.Handle(traceEntry("entry", "after")); err != nil {
+			t.Fatal(err)
+		}
+		h.Shutdown()
+		bloom, err := index.ReadBloomFile(filePath + index.BloomExt)
+		if err != nil || !bloom.Partial() || bloom.Count() != 1 || !bloom.MayContain("trace_id", "any") {
+			t.Errorf("the bloom should be partial, err: %v", err)
+		}
+	})
+
+	t.Run("when archive encrypted then init error", func(t *testing.T) {
+		dir := t.TempDir()
+		keyFile := path.Join(dir, "archive.key")
+		if err := ioutil.WriteFile(keyFile, []byte(strings.Repeat("k", 32)), 0600); err != nil {
+			t.Fatal(err)
+		}
+		conf := config.NewHandlerConfig()
+		conf.File = path.Join(dir, "app.log")
+		conf.Rollover.Archive.Encrypt = &config.EncryptConfig{KeyFile: keyFile}
+		h := NewFileHandler(conf)
+		if err := h.Init(); err != nil {
+			t.Fatalf("init err: %+v", err)
+		}
+		h.Shutdown()
+		conf.Bloom = &config.BloomConfig{Fields: []string{"user_id"}}
+		if err := NewFileHandler(conf).Init(); err == nil {
+			t.Errorf("want init error")
+		}
+	})
+
+	t.Run("when shared or no fields then init error", func(t *testing.T) {
+		for _, shared := range []bool{true, false} {
+			conf := config.NewHandlerConfig()
+			conf.File = path.Join(t.TempDir(), "app.log")
+			conf.Shared = shared
+			conf.Bloom = &config.BloomConfig{}
+			if shared {
+				conf.Bloom.Fields = []string{"trace_id"}
+			}
+			if err := NewFileHandler(conf).Init(); err == nil {
+				t.Errorf("want init error, shared: %v", shared)
+			}
+		}
+	})
+}
+
+func globFiles(dir, pattern string) []string {
+	matches, _ := filepath.Glob(path.Join(dir, pattern))
+	return matches
+}
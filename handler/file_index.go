@@ -0,0 +1,186 @@
+package handler
+
+import (
+	"github.com/edditen/etlog/common/utils"
+	"github.com/edditen/etlog/config"
+	"github.com/edditen/etlog/core"
+	"github.com/edditen/etlog/handler/index"
+	"github.com/edditen/etlog/opt"
+	"github.com/pkg/errors"
+	"os"
+	"time"
+)
+
+const (
+	defaultIndexEvery    = 1000
+	defaultIndexInterval = time.Minute
+
+	defaultBloomExpected      = 100000
+	defaultBloomFalsePositive = 0.01
+)
+
+// written the entry written into the file, with the size of its line.
+type written struct {
+	entry *core.LogEntry
+	size  int
+}
+
+// fileIndex indexes the entries written into the active file by the time
+// and by the field values, the index and the bloom filter are renamed with
+// the file on rotation, then moved with the archive.
+type fileIndex struct {
+	timed    bool
+	every    int
+	interval time.Duration
+	writer   *index.Writer
+
+	fields        []string
+	expected      int
+	falsePositive float64
+	bloom         *index.Bloom
+	file          string
+}
+
+func newFileIndex(timeConf *config.TimeIndexConfig, bloomConf *config.BloomConfig) (*fileIndex, error) {
+	fi := &fileIndex{}
+	if timeConf != nil {
+		fi.timed = true
+		fi.every = timeConf.Every
+		if fi.every <= 0 {
+			fi.every = defaultIndexEvery
+		}
+		fi.interval = defaultIndexInterval
+		if timeConf.Interval != "" {
+			seconds, err := utils.ParseSeconds(timeConf.Interval)
+			if err != nil {
+				return nil, errors.Wrap(err, "parse time index interval error")
+			}
+			fi.interval = time.Duration(seconds) * time.Second
+		}
+	}
+	if bloomConf != nil {
+		if len(bloomConf.Fields) == 0 {
+			return nil, errors.New("bloom fields are empty")
+		}
+		fi.fields = bloomConf.Fields
+		fi.expected = bloomConf.Expected
+		if fi.expected <= 0 {
+			fi.expected = defaultBloomExpected
+		}
+		fi.falsePositive = bloomConf.FalsePositive
+		if fi.falsePositive == 0 {
+			fi.falsePositive = defaultBloomFalsePositive
+		}
+		// fail on init rather than on the first file
+		if _, err := index.NewBloom(fi.fields, fi.expected, fi.falsePositive); err != nil {
+			return nil, err
+		}
+	}
+	return fi, nil
+}
+
+// open opens the index and the bloom filter of the file at the written offset.
+func (fi *fileIndex) open(file string, offset int64) error {
+	var err error
+	if fi.timed {
+		fi.writer, err = index.NewWriter(file+index.Ext, offset, fi.every, fi.interval)
+	}
+	if len(fi.fields) > 0 {
+		if bloomErr := fi.openBloom(file, offset); err == nil {
+			err = bloomErr
+		}
+	}
+	return err
+}
+
+// openBloom continues the bloom filter left by the last run. The filter is
+// kept in memory and removed from the disk until closed, so the filter on
+// the disk never misses the values of the file being written.
+func (fi *fileIndex) openBloom(file string, offset int64) error {
+	fi.file = file
+	bloomFile := file + index.BloomExt
+	if offset > 0 {
+		if bloom, err := index.ReadBloomFile(bloomFile); err == nil && sameFields(bloom.Keys(), fi.fields) {
+			fi.bloom = bloom
+			return errors.Wrap(os.Remove(bloomFile), "remove bloom file error")
+		}
+	}
+	if err := os.Remove(bloomFile); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "remove bloom file error")
+	}
+	bloom, err := index.NewBloom(fi.fields, fi.expected, fi.falsePositive)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		// the file written without the filter may have any value
+		bloom.SetPartial()
+	}
+	fi.bloom = bloom
+	return nil
+}
+
+func (fi *fileIndex) add(entries []written) {
+	if fi.bloom != nil {
+		for _, w := range entries {
+			fi.bloom.AddFields(w.entry.Fields)
+		}
+	}
+	if fi.writer == nil {
+		return
+	}
+	for _, w := range entries {
+		if err := fi.writer.Add(w.entry.Time, w.size); err != nil {
+			opt.GetErrLog().Printf("write time index err: %+v\n", err)
+			return
+		}
+	}
+}
+
+func (fi *fileIndex) close() {
+	if fi.writer != nil {
+		if err := fi.writer.Close(); err != nil {
+			opt.GetErrLog().Printf("close time index err: %+v\n", err)
+		}
+		fi.writer = nil
+	}
+	if fi.bloom != nil {
+		if err := fi.bloom.WriteFile(fi.file + index.BloomExt); err != nil {
+			opt.GetErrLog().Printf("write bloom err: %+v\n", err)
+		}
+		fi.bloom = nil
+	}
+}
+
+// rename moves the index and the bloom filter of the file to the backup.
+func (fi *fileIndex) rename(file, backupFile string) {
+	for _, ext := range []string{index.Ext, index.BloomExt} {
+		if err := os.Rename(file+ext, backupFile+ext); err != nil && !os.IsNotExist(err) {
+			opt.GetErrLog().Printf("rename file index err: %+v\n", err)
+		}
+	}
+}
+
+// reset restarts the index of the file truncated by copytruncate. The bloom
+// filter restarts empty if the file is truncated to empty, otherwise the
+// filter written by close is read back, keeping the values, which are more
+// than the file has.
+func (fi *fileIndex) reset(file string, offset int64) {
+	fi.close()
+	_ = os.Remove(file + index.Ext)
+	if err := fi.open(file, offset); err != nil {
+		opt.GetErrLog().Printf("reset file index err: %+v\n", err)
+	}
+}
+
+func sameFields(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,269 @@
+package handler
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"github.com/edditen/etlog/common/msgpack"
+	"github.com/edditen/etlog/config"
+	"github.com/edditen/etlog/core"
+	"github.com/pkg/errors"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	defaultFluentTag = "etlog"
+	forwardMode      = "forward"
+	packedMode       = "packed"
+	eventTimeExt     = 0
+	ackBufferSize    = 1024
+)
+
+// FluentHandler sends entries to fluentd or fluent bit
+// with the forward protocol over tcp,
+// refer to https://github.com/fluent/fluentd/wiki/Forward-Protocol-Specification-v1
+type FluentHandler struct {
+	*BaseHandler
+	address    string
+	tag        string
+	packed     bool
+	requireAck bool
+	timeout    time.Duration
+	conn       net.Conn
+	batcher    *batcher
+}
+
+func NewFluentHandler(conf *config.HandlerConfig) *FluentHandler {
+	return &FluentHandler{
+		BaseHandler: NewBaseHandler(conf),
+	}
+}
+
+func (fh *FluentHandler) Init() error {
+	if err := fh.BaseHandler.Init(); err != nil {
+		return err
+	}
+
+	remote := settingRemote(fh.BaseHandler.handlerConfig.Remote)
+	if remote.Endpoint == "" {
+		return errors.New("fluent endpoint is empty")
+	}
+	if remote.Tag == "" {
+		remote.Tag = defaultFluentTag
+	}
+	switch strings.ToLower(remote.Mode) {
+	case "", forwardMode:
+		fh.packed = false
+	case packedMode:
+		fh.packed = true
+	default:
+		return errors.Errorf("unknown fluent mode: %s", remote.Mode)
+	}
+
+	fh.address = strings.TrimPrefix(remote.Endpoint, "tcp://")
+	fh.tag = remote.Tag
+	fh.requireAck = remote.RequireAck
+	var err error
+	if fh.batcher, err = newBatcher(remote, fh.forward); err != nil {
+		return err
+	}
+	fh.timeout = time.Duration(remote.Timeout) * time.Millisecond
+
+	return fh.batcher.Init()
+}
+
+func (fh *FluentHandler) Handle(entry *core.LogEntry) error {
+	if !fh.BaseHandler.MarkerMatched(entry.Marker) {
+		return nil
+	}
+	if !fh.BaseHandler.Contains(entry.Level) {
+		return nil
+	}
+	return fh.batcher.Offer(entry)
+}
+
+func (fh *FluentHandler) Shutdown() {
+	fh.batcher.Shutdown()
+	fh.closeConn()
+}
+
+// forward sends the entries grouped by tag, the entries of the failed
+// and the following tags are returned to retry.
+func (fh *FluentHandler) forward(entries LogEntries) (LogEntries, error) {
+	tags := make([]string, 0)
+	groups := make(map[string]LogEntries)
+	for _, entry := range entries {
+		tag := FluentTag(fh.tag, entry.Marker)
+		if _, ok := groups[tag]; !ok {
+			tags = append(tags, tag)
+		}
+		groups[tag] = append(groups[tag], entry)
+	}
+
+	for i, tag := range tags {
+		if err := fh.send(tag, groups[tag]); err != nil {
+			failed := make(LogEntries, 0)
+			for _, t := range tags[i:] {
+				failed = append(failed, groups[t]...)
+			}
+			return failed, err
+		}
+	}
+	return nil, nil
+}
+
+func (fh *FluentHandler) send(tag string, entries LogEntries) error {
+	chunk := ""
+	if fh.requireAck {
+		chunk = newChunkID()
+	}
+	msg := EncodeForward(tag, entries, fh.packed, chunk)
+
+	if err := fh.connect(); err != nil {
+		return err
+	}
+
+	_ = fh.conn.SetDeadline(time.Now().Add(fh.timeout))
+	if _, err := fh.conn.Write(msg); err != nil {
+		fh.closeConn()
+		return errors.Wrap(err, "write fluent message error")
+	}
+
+	if fh.requireAck {
+		if err := fh.readAck(chunk); err != nil {
+			fh.closeConn()
+			return err
+		}
+	}
+	return nil
+}
+
+func (fh *FluentHandler) connect() (err error) {
+	if fh.conn != nil {
+		return nil
+	}
+	fh.conn, err = net.DialTimeout("tcp", fh.address, fh.timeout)
+	if err != nil {
+		fh.conn = nil
+		return errors.Wrap(err, "connect fluent error")
+	}
+	return nil
+}
+
+func (fh *FluentHandler) closeConn() {
+	if fh.conn == nil {
+		return
+	}
+	_ = fh.conn.Close()
+	fh.conn = nil
+}
+
+func (fh *FluentHandler) readAck(chunk string) error {
+	buf := make([]byte, 0, ackBufferSize)
+	tmp := make([]byte, ackBufferSize)
+	for {
+		n, err := fh.conn.Read(tmp)
+		if err != nil {
+			return errors.Wrap(err, "read fluent ack error")
+		}
+		buf = append(buf, tmp[:n]...)
+
+		v, _, err := msgpack.Decode(buf)
+		if err == msgpack.ErrShortBuffer {
+			continue
+		}
+		if err != nil {
+			return errors.Wrap(err, "decode fluent ack error")
+		}
+		if resp, ok := v.(map[string]interface{}); ok && resp["ack"] == chunk {
+			return nil
+		}
+		return errors.Errorf("unexpected fluent ack: %v", v)
+	}
+}
+
+// FluentTag derives the tag from the marker of entry.
+func FluentTag(prefix, marker string) string {
+	if marker == "" {
+		return prefix
+	}
+	return prefix + "." + marker
+}
+
+// EncodeForward encodes the entries in forward mode, [tag, [[time, record]...], option],
+// or packed forward mode, [tag, bin of [time, record]..., option].
+func EncodeForward(tag string, entries LogEntries, packed bool, chunk string) []byte {
+	b := msgpack.AppendArrayHeader(nil, 3)
+	b = msgpack.AppendString(b, tag)
+
+	if packed {
+		events := make([]byte, 0)
+		for _, entry := range entries {
+			events = appendEvent(events, entry)
+		}
+		b = msgpack.AppendBytes(b, events)
+	} else {
+		b = msgpack.AppendArrayHeader(b, len(entries))
+		for _, entry := range entries {
+			b = appendEvent(b, entry)
+		}
+	}
+
+	if chunk != "" {
+		b = msgpack.AppendMapHeader(b, 2)
+		b = msgpack.AppendString(b, "chunk")
+		b = msgpack.AppendString(b, chunk)
+	} else {
+		b = msgpack.AppendMapHeader(b, 1)
+	}
+	b = msgpack.AppendString(b, "size")
+	b = msgpack.AppendInt(b, int64(len(entries)))
+	return b
+}
+
+func appendEvent(b []byte, entry *core.LogEntry) []byte {
+	b = msgpack.AppendArrayHeader(b, 2)
+	b = appendEventTime(b, entry.Time)
+	return appendRecord(b, entry)
+}
+
+// appendEventTime appends the EventTime ext, seconds and nanoseconds in big-endian.
+func appendEventTime(b []byte, t time.Time) []byte {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint32(data, uint32(t.Unix()))
+	binary.BigEndian.PutUint32(data[4:], uint32(t.Nanosecond()))
+	return msgpack.AppendExt(b, eventTimeExt, data)
+}
+
+// appendRecord appends the record with the same keys as the json formatter.
+func appendRecord(b []byte, entry *core.LogEntry) []byte {
+	record := map[string]interface{}{
+		"level": entry.Level.String(),
+		"msg":   entry.Msg,
+	}
+	if entry.UseLoc {
+		record["srcf"] = entry.SrcFile
+		record["line"] = entry.Line
+		record["func"] = entry.FuncName
+	}
+	if entry.Marker != "" {
+		record["marker"] = entry.Marker
+	}
+	if entry.Err != nil {
+		record["error"] = entry.Err.Error()
+	}
+	if len(entry.Fields) > 0 {
+		record["fields"] = map[string]interface{}(entry.Fields)
+	}
+	return msgpack.AppendValue(b, record)
+}
+
+func newChunkID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		binary.BigEndian.PutUint64(id, uint64(time.Now().UnixNano()))
+	}
+	return base64.StdEncoding.EncodeToString(id)
+}
@@ -0,0 +1,125 @@
+package handler
+
+import (
+	"github.com/edditen/etlog/common/msgpack"
+	"github.com/edditen/etlog/config"
+	"github.com/edditen/etlog/core"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestEncodeForward(t *testing.T) {
+	ts := time.Unix(1623730845, 152)
+	entries := LogEntries{
+		{Time: ts, Level: core.INFO, Msg: "hello", Fields: core.Fields{"k": "v"}},
+		{Time: ts, Level: core.ERROR, Msg: "world"},
+	}
+
+	t.Run("when forward mode then entries array", func(t *testing.T) {
+		v, _, err := msgpack.Decode(EncodeForward("app", entries, false, "abc"))
+		if err != nil {
+			t.Fatalf("decode err: %+v", err)
+		}
+		msg := v.([]interface{})
+		if msg[0] != "app" {
+			t.Errorf("unexpected tag: %v", msg[0])
+		}
+		events := msg[1].([]interface{})
+		if len(events) != 2 {
+			t.Fatalf("expect 2 events, got: %d", len(events))
+		}
+		event := events[0].([]interface{})
+		if ext := event[0].(msgpack.Ext); ext.Type != eventTimeExt || len(ext.Data) != 8 {
+			t.Errorf("unexpected event time: %v", ext)
+		}
+		record := event[1].(map[string]interface{})
+		if record["msg"] != "hello" || record["level"] != "INFO" {
+			t.Errorf("unexpected record: %v", record)
+		}
+		option := msg[2].(map[string]interface{})
+		if option["chunk"] != "abc" || option["size"] != int64(2) {
+			t.Errorf("unexpected option: %v", option)
+		}
+	})
+
+	t.Run("when packed mode then entries bin", func(t *testing.T) {
+		v, _, err := msgpack.Decode(EncodeForward("app", entries, true, ""))
+		if err != nil {
+			t.Fatalf("decode err: %+v", err)
+		}
+		stream := v.([]interface{})[1].([]byte)
+		count := 0
+		for len(stream) > 0 {
+			_, n, err := msgpack.Decode(stream)
+			if err != nil {
+				t.Fatalf("decode event err: %+v", err)
+			}
+			stream = stream[n:]
+			count++
+		}
+		if count != 2 {
+			t.Errorf("expect 2 packed events, got: %d", count)
+		}
+	})
+}
+
+func TestFluentHandler_Handle(t *testing.T) {
+	t.Run("when require ack then wait the ack of chunk", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("listen err: %+v", err)
+		}
+		defer ln.Close()
+
+		tags := make(chan string, 10)
+		go func() {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			buf := make([]byte, 0)
+			tmp := make([]byte, 4096)
+			for {
+				n, err := conn.Read(tmp)
+				if err != nil {
+					return
+				}
+				buf = append(buf, tmp[:n]...)
+				v, read, err := msgpack.Decode(buf)
+				if err != nil {
+					continue
+				}
+				buf = buf[read:]
+				msg := v.([]interface{})
+				tags <- msg[0].(string)
+				chunk := msg[2].(map[string]interface{})["chunk"]
+				_, _ = conn.Write(msgpack.AppendValue(nil, map[string]interface{}{"ack": chunk}))
+			}
+		}()
+
+		conf := config.NewHandlerConfig()
+		conf.Marker = "trace"
+		conf.Levels = []string{"info"}
+		conf.Remote.Endpoint = ln.Addr().String()
+		conf.Remote.Tag = "app"
+		conf.Remote.RequireAck = true
+
+		h := NewFluentHandler(conf)
+		if err := h.Init(); err != nil {
+			t.Fatalf("init err: %+v", err)
+		}
+		_ = h.Handle(&core.LogEntry{Time: time.Now(), Level: core.INFO, Marker: "trace", Msg: "hello"})
+		h.Shutdown()
+
+		select {
+		case tag := <-tags:
+			if tag != "app.trace" {
+				t.Errorf("unexpected tag: %s", tag)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("expect message received")
+		}
+	})
+}
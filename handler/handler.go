@@ -3,6 +3,7 @@ package handler
 import (
 	"github.com/edditen/etlog/config"
 	"github.com/edditen/etlog/core"
+	"github.com/edditen/etlog/handler/archiver"
 	"strings"
 )
 
@@ -12,6 +13,11 @@ const (
 	defaultHandleType             = STD
 	STD               HandlerType = iota
 	FILE
+	ELASTICSEARCH
+	OTLP
+	FLUENT
+	RING
+	FINGERS_CROSSED
 )
 
 func NewHandlerType(handlerType string) HandlerType {
@@ -20,6 +26,16 @@ func NewHandlerType(handlerType string) HandlerType {
 		return STD
 	case "FILE":
 		return FILE
+	case "ELASTICSEARCH":
+		return ELASTICSEARCH
+	case "OTLP":
+		return OTLP
+	case "FLUENT":
+		return FLUENT
+	case "RING":
+		return RING
+	case "FINGERS_CROSSED":
+		return FINGERS_CROSSED
 	}
 	return defaultHandleType
 }
@@ -30,6 +46,16 @@ func (h HandlerType) String() string {
 		return "STD"
 	case FILE:
 		return "FILE"
+	case ELASTICSEARCH:
+		return "ELASTICSEARCH"
+	case OTLP:
+		return "OTLP"
+	case FLUENT:
+		return "FLUENT"
+	case RING:
+		return "RING"
+	case FINGERS_CROSSED:
+		return "FINGERS_CROSSED"
 	}
 	return ""
 }
@@ -40,6 +66,29 @@ type Handler interface {
 	Shutdown()
 }
 
+// LevelIgnorer is implemented by the handlers which want the entries
+// even they are below the level of logger.
+type LevelIgnorer interface {
+	IgnoreLevel() bool
+}
+
+// Dumper is implemented by the handlers which keep entries in memory
+// and could dump them on demand.
+type Dumper interface {
+	Dump() error
+}
+
+// Reopener is implemented by the handlers which hold files, and could
+// reopen them after they were rotated by other tools.
+type Reopener interface {
+	Reopen() error
+}
+
+// ArchiveReporter is implemented by the handlers which archive backups.
+type ArchiveReporter interface {
+	ArchiveStats() archiver.Stats
+}
+
 type Flusher interface {
 	Flush(bs []byte) error
 }
@@ -104,15 +153,42 @@ func (bh *BaseHandler) DefaultSetting() {
 	if bh.handlerConfig.Rollover == nil {
 		bh.handlerConfig.Rollover = config.NewRolloverConfig()
 	}
+	if bh.handlerConfig.Remote == nil {
+		bh.handlerConfig.Remote = config.NewRemoteConfig()
+	}
+	if bh.handlerConfig.Ring == nil {
+		bh.handlerConfig.Ring = config.NewRingConfig()
+	}
+	if bh.handlerConfig.Crossed == nil {
+		bh.handlerConfig.Crossed = config.NewCrossedConfig()
+	}
 }
 
 func HandlerFactory(conf *config.HandlerConfig) Handler {
+	h := newHandler(conf)
+	if conf.Sync != nil && conf.Sync.AsyncWrite {
+		return NewAsyncHandler(conf, h)
+	}
+	return h
+}
+
+func newHandler(conf *config.HandlerConfig) Handler {
 	htype := NewHandlerType(conf.Type)
 	switch htype {
 	case STD:
 		return NewStdHandler(conf)
 	case FILE:
 		return NewFileHandler(conf)
+	case ELASTICSEARCH:
+		return NewElasticHandler(conf)
+	case OTLP:
+		return NewOTLPHandler(conf)
+	case FLUENT:
+		return NewFluentHandler(conf)
+	case RING:
+		return NewRingHandler(conf)
+	case FINGERS_CROSSED:
+		return NewCrossedHandler(conf)
 	}
 	return NewStdHandler(conf)
 }
@@ -0,0 +1,314 @@
+package index
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"hash/fnv"
+	"io"
+	"math"
+	"os"
+)
+
+// BloomExt the extension of the bloom filter next to the log file, the
+// backup and the archive, such as "app.2021-06-15.120000.log.zip.bloom".
+const BloomExt = ".bloom"
+
+const (
+	bloomMagic   = "ETLB"
+	bloomVersion = 1
+	maxBloomBits = 1 << 32
+	// bloomPartial the file has the content not added, which may have any value
+	bloomPartial = 1
+)
+
+// Bloom the bloom filter of the field values of a log file, the values of
+// all keys share the filter. A false positive reads the file in vain, but
+// a value added is never missed.
+type Bloom struct {
+	keys    []string
+	k       uint8
+	m       uint64
+	bits    []uint64
+	count   uint64
+	partial bool
+}
+
+// NewBloom sizes the filter for the expected number of values at the false
+// positive rate, more values raise the rate.
+func NewBloom(keys []string, expected int, fpRate float64) (*Bloom, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("bloom keys are empty")
+	}
+	if expected <= 0 || fpRate <= 0 || fpRate >= 1 {
+		return nil, errors.Errorf("invalid bloom expected %d or false positive rate %v", expected, fpRate)
+	}
+	m := uint64(math.Ceil(-float64(expected) * math.Log(fpRate) / (math.Ln2 * math.Ln2)))
+	if m > maxBloomBits {
+		m = maxBloomBits
+	}
+	m = (m + 63) / 64 * 64
+	k := math.Round(float64(m) / float64(expected) * math.Ln2)
+	if k < 1 {
+		k = 1
+	}
+	if k > 16 {
+		k = 16
+	}
+	return &Bloom{
+		keys: keys,
+		k:    uint8(k),
+		m:    m,
+		bits: make([]uint64, m/64),
+	}, nil
+}
+
+// Keys returns the field keys added.
+func (b *Bloom) Keys() []string {
+	return b.keys
+}
+
+// Count returns the number of values added.
+func (b *Bloom) Count() uint64 {
+	return b.count
+}
+
+// Partial returns whether the file has the content not added.
+func (b *Bloom) Partial() bool {
+	return b.partial
+}
+
+// SetPartial marks the file has the content not added, such as written
+// before the filter was enabled.
+func (b *Bloom) SetPartial() {
+	b.partial = true
+}
+
+// Add adds the value of key.
+func (b *Bloom) Add(key, value string) {
+	h1, h2 := bloomHash(key, value)
+	for i := uint64(0); i < uint64(b.k); i++ {
+		bit := (h1 + i*h2) % b.m
+		b.bits[bit/64] |= 1 << (bit % 64)
+	}
+	b.count++
+}
+
+// AddFields adds the values of the keys of the filter in the fields.
+func (b *Bloom) AddFields(fields map[string]interface{}) {
+	if len(fields) == 0 {
+		return
+	}
+	for _, key := range b.keys {
+		if value, ok := fields[key]; ok {
+			b.Add(key, FieldValue(value))
+		}
+	}
+}
+
+// MayContain returns false only if the value of key was never added,
+// the key not in the filter may have any value.
+func (b *Bloom) MayContain(key, value string) bool {
+	if b.partial || !b.hasKey(key) {
+		return true
+	}
+	h1, h2 := bloomHash(key, value)
+	for i := uint64(0); i < uint64(b.k); i++ {
+		bit := (h1 + i*h2) % b.m
+		if b.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *Bloom) hasKey(key string) bool {
+	for _, k := range b.keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// FieldValue the text of the field value as written by the formatters, the
+// json encoding with the string unquoted, so the value decoded from the line,
+// such as json.Number("100000000") of float64(1e8), has the same text.
+func FieldValue(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	b, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	if len(b) > 0 && b[0] == '"' {
+		var s string
+		if err := json.Unmarshal(b, &s); err == nil {
+			return s
+		}
+	}
+	return string(b)
+}
+
+// bloomHash the double hashing of the key and value.
+func bloomHash(key, value string) (uint64, uint64) {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	_, _ = h.Write([]byte{0})
+	_, _ = h.Write([]byte(value))
+	sum := h.Sum64()
+	h1, h2 := sum&math.MaxUint32, sum>>32
+	// the odd step visits all bits
+	return h1, h2 | 1
+}
+
+// WriteTo writes the filter:
+//
+//	"ETLB" | version(1) | flags(1) | k(1) | keys(2) | [key size(2) | key]... | m(8) | count(8) | bits
+func (b *Bloom) WriteTo(w io.Writer) (int64, error) {
+	bw := bufio.NewWriter(w)
+	cw := &countingWriter{w: bw}
+	var flags byte
+	if b.partial {
+		flags |= bloomPartial
+	}
+	_, _ = cw.Write([]byte(bloomMagic))
+	_, _ = cw.Write([]byte{bloomVersion, flags, b.k})
+	_ = binary.Write(cw, binary.BigEndian, uint16(len(b.keys)))
+	for _, key := range b.keys {
+		_ = binary.Write(cw, binary.BigEndian, uint16(len(key)))
+		_, _ = cw.Write([]byte(key))
+	}
+	_ = binary.Write(cw, binary.BigEndian, b.m)
+	_ = binary.Write(cw, binary.BigEndian, b.count)
+	_ = binary.Write(cw, binary.BigEndian, b.bits)
+	if cw.err != nil {
+		return cw.n, errors.Wrap(cw.err, "write bloom error")
+	}
+	return cw.n, errors.Wrap(bw.Flush(), "write bloom error")
+}
+
+// ReadBloom reads the filter written by WriteTo.
+func ReadBloom(r io.Reader) (*Bloom, error) {
+	br := bufio.NewReader(r)
+	head := make([]byte, len(bloomMagic)+3)
+	if _, err := io.ReadFull(br, head); err != nil {
+		return nil, readBloomErr(err, "read bloom header error")
+	}
+	if string(head[:len(bloomMagic)]) != bloomMagic {
+		return nil, errors.Wrap(errInvalid, "not a bloom file")
+	}
+	if version := head[len(bloomMagic)]; version != bloomVersion {
+		return nil, errors.Wrapf(errInvalid, "unsupported bloom version %d", version)
+	}
+	b := &Bloom{partial: head[len(bloomMagic)+1]&bloomPartial != 0, k: head[len(bloomMagic)+2]}
+
+	var keys uint16
+	if err := binary.Read(br, binary.BigEndian, &keys); err != nil {
+		return nil, readBloomErr(err, "read bloom keys error")
+	}
+	for i := 0; i < int(keys); i++ {
+		var size uint16
+		if err := binary.Read(br, binary.BigEndian, &size); err != nil {
+			return nil, readBloomErr(err, "read bloom keys error")
+		}
+		key := make([]byte, size)
+		if _, err := io.ReadFull(br, key); err != nil {
+			return nil, readBloomErr(err, "read bloom keys error")
+		}
+		b.keys = append(b.keys, string(key))
+	}
+
+	if err := binary.Read(br, binary.BigEndian, &b.m); err != nil {
+		return nil, readBloomErr(err, "read bloom size error")
+	}
+	if err := binary.Read(br, binary.BigEndian, &b.count); err != nil {
+		return nil, readBloomErr(err, "read bloom size error")
+	}
+	if b.m == 0 || b.m%64 != 0 || b.m > maxBloomBits || b.k == 0 {
+		return nil, errors.Wrap(errInvalid, "invalid bloom size")
+	}
+	b.bits = make([]uint64, b.m/64)
+	if err := binary.Read(br, binary.BigEndian, b.bits); err != nil {
+		return nil, readBloomErr(err, "read bloom bits error")
+	}
+	return b, nil
+}
+
+// readBloomErr the truncated file is invalid.
+func readBloomErr(err error, message string) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return errors.Wrap(errInvalid, message)
+	}
+	return errors.Wrap(err, message)
+}
+
+// ReadBloomFile reads the filter file.
+func ReadBloomFile(file string) (*Bloom, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadBloom(f)
+}
+
+// WriteFile writes the filter into a temp file then renames, so the filter
+// is never seen partially written.
+func (b *Bloom) WriteFile(file string) error {
+	tmpFile := file + ".tmp"
+	f, err := os.OpenFile(tmpFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return errors.Wrap(err, "create bloom file error")
+	}
+	_, err = b.WriteTo(f)
+	if closeErr := f.Close(); err == nil && closeErr != nil {
+		err = errors.Wrap(closeErr, "close bloom file error")
+	}
+	if err == nil {
+		err = errors.Wrap(os.Rename(tmpFile, file), "rename bloom file error")
+	}
+	if err != nil {
+		_ = os.Remove(tmpFile)
+	}
+	return err
+}
+
+// LookupField returns the files which may have the value of key, the files
+// without a valid filter are kept, as they may have any value.
+func LookupField(files []string, key, value string) ([]string, error) {
+	matched := make([]string, 0, len(files))
+	for _, file := range files {
+		b, err := ReadBloomFile(file + BloomExt)
+		if err != nil {
+			if !os.IsNotExist(err) && errors.Cause(err) != errInvalid {
+				return nil, errors.Wrapf(err, "read bloom of %s error", file)
+			}
+			matched = append(matched, file)
+			continue
+		}
+		if b.MayContain(key, value) {
+			matched = append(matched, file)
+		}
+	}
+	return matched, nil
+}
+
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	cw.err = err
+	return n, err
+}
@@ -0,0 +1,166 @@
+package index
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/edditen/etlog/core"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestBloom(t *testing.T) {
+	newBloom := func(t *testing.T) *Bloom {
+		b, err := NewBloom([]string{"trace_id", "user_id"}, 2000, 0.01)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 1000; i++ {
+			b.AddFields(map[string]interface{}{"trace_id": fmt.Sprintf("t%d", i), "user_id": i, "other": "x"})
+		}
+		return b
+	}
+
+	t.Run("when added then may contain", func(t *testing.T) {
+		b := newBloom(t)
+		for i := 0; i < 1000; i++ {
+			if !b.MayContain("trace_id", fmt.Sprintf("t%d", i)) || !b.MayContain("user_id", fmt.Sprint(i)) {
+				t.Fatalf("value %d missed", i)
+			}
+		}
+		if b.Count() != 2000 {
+			t.Errorf("Count() = %d", b.Count())
+		}
+	})
+
+	t.Run("when not added then mostly not contain", func(t *testing.T) {
+		b := newBloom(t)
+		positives := 0
+		for i := 0; i < 10000; i++ {
+			if b.MayContain("trace_id", fmt.Sprintf("absent%d", i)) {
+				positives++
+			}
+		}
+		// 1% expected
+		if positives > 300 {
+			t.Errorf("false positives %d of 10000", positives)
+		}
+		if b.MayContain("user_id", "t1") && b.MayContain("user_id", "t2") && b.MayContain("user_id", "t3") {
+			t.Errorf("the values should be of the key")
+		}
+	})
+
+	t.Run("when key not in filter or partial then may contain", func(t *testing.T) {
+		b := newBloom(t)
+		if !b.MayContain("other", "y") {
+			t.Errorf("the key not in filter may have any value")
+		}
+		b.SetPartial()
+		if !b.MayContain("trace_id", "absent") {
+			t.Errorf("the partial filter may have any value")
+		}
+	})
+
+	t.Run("when json number then same value", func(t *testing.T) {
+		b := newBloom(t)
+		if !b.MayContain("user_id", FieldValue(json.Number("42"))) {
+			t.Errorf("json number missed")
+		}
+	})
+
+	t.Run("when decoded from the line then same value", func(t *testing.T) {
+		b, err := NewBloom([]string{"amount", "at", "tags", "none"}, 100, 0.001)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fields := core.Fields{
+			"amount": 1e8,
+			"at":     time.Date(2021, 1, 2, 3, 4, 5, 0, time.FixedZone("CST", 8*3600)),
+			"tags":   map[string]interface{}{"b": 0.5, "a": "<x>"},
+			"none":   nil,
+		}
+		b.AddFields(fields)
+
+		// decoded as the parsers do
+		var decoded map[string]interface{}
+		decoder := json.NewDecoder(bytes.NewReader(fields.Bytes()))
+		decoder.UseNumber()
+		if err := decoder.Decode(&decoded); err != nil {
+			t.Fatal(err)
+		}
+		for key, value := range decoded {
+			if !b.MayContain(key, FieldValue(value)) {
+				t.Errorf("%s %q missed", key, FieldValue(value))
+			}
+		}
+		if got := FieldValue(decoded["amount"]); got != "100000000" {
+			t.Errorf("FieldValue() = %s", got)
+		}
+	})
+
+	t.Run("when written then read the same", func(t *testing.T) {
+		b := newBloom(t)
+		b.SetPartial()
+		var buf bytes.Buffer
+		if _, err := b.WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		}
+		read, err := ReadBloom(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if read.k != b.k || read.m != b.m || read.Count() != b.Count() || !read.Partial() ||
+			len(read.Keys()) != 2 || read.Keys()[1] != "user_id" || !bytes.Equal(bitsBytes(read), bitsBytes(b)) {
+			t.Errorf("read %+v", read)
+		}
+	})
+
+	t.Run("when invalid then error", func(t *testing.T) {
+		if _, err := NewBloom(nil, 10, 0.01); err == nil {
+			t.Errorf("want error of empty keys")
+		}
+		if _, err := NewBloom([]string{"k"}, 10, 1); err == nil {
+			t.Errorf("want error of false positive rate")
+		}
+		if _, err := ReadBloom(bytes.NewReader([]byte("garbage garbage"))); err == nil {
+			t.Errorf("want error of invalid file")
+		}
+		var buf bytes.Buffer
+		_, _ = newBloom(t).WriteTo(&buf)
+		if _, err := ReadBloom(bytes.NewReader(buf.Bytes()[:buf.Len()-1])); errors.Cause(err) != errInvalid {
+			t.Errorf("want invalid error of truncated file, got %v", err)
+		}
+	})
+}
+
+func bitsBytes(b *Bloom) []byte {
+	return []byte(fmt.Sprint(b.bits))
+}
+
+func TestLookupField(t *testing.T) {
+	dir := t.TempDir()
+	has, hasNot := path.Join(dir, "a.log"), path.Join(dir, "b.log")
+	for file, value := range map[string]string{has: "abc", hasNot: "xyz"} {
+		b, err := NewBloom([]string{"trace_id"}, 100, 0.001)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.Add("trace_id", value)
+		if err := b.WriteFile(file + BloomExt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	unfiltered, invalid := path.Join(dir, "c.log"), path.Join(dir, "d.log")
+	_ = ioutil.WriteFile(invalid+BloomExt, []byte("ETL"), 0644)
+
+	files, err := LookupField([]string{has, hasNot, unfiltered, invalid}, "trace_id", "abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 || files[0] != has || files[1] != unfiltered || files[2] != invalid {
+		t.Errorf("LookupField() = %v", files)
+	}
+}
@@ -0,0 +1,302 @@
+package index
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Ext the extension of the time index next to the log file, the backup
+// and the archive, such as "app.log.idx" and "app.2021-06-15.120000.log.zip.idx".
+const Ext = ".idx"
+
+const (
+	header     = "etlog-index v1"
+	pointLine  = "p"
+	closedLine = "l"
+	skewLine   = "s"
+)
+
+var errInvalid = errors.New("invalid index")
+
+// Point the offset of the entry, and the newest time of the entries up to
+// it, so the points are in time order. The offset is in the uncompressed
+// content of the log file.
+type Point struct {
+	Time   time.Time
+	Offset int64
+}
+
+// Index the sparse time index of a log file. The entries are mostly in time
+// order within a file, except the ones written ahead of the queued ones,
+// such as the durable entries of AsyncHandler, so any entry is at most Skew
+// older than the entries before it, and the end of range is widened by Skew.
+// The content written before the index created is indexed by a point at the
+// epoch, which is never skipped.
+type Index struct {
+	First   time.Time
+	Last    time.Time
+	Entries int64
+	Points  []Point
+	// Skew the most an entry is older than the newest entry before it
+	Skew time.Duration
+	// Closed the file was closed by the writer, otherwise Last is the time of
+	// the last point, and Entries counts the closed writers only, such as the
+	// file being written.
+	Closed bool
+}
+
+// ReadFile reads the index file, the index of the file being written
+// is read as far as it was flushed.
+func ReadFile(file string) (*Index, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
+
+func Read(r io.Reader) (*Index, error) {
+	ix := &Index{Points: make([]Point, 0)}
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		parts := strings.Fields(scanner.Text())
+		if len(parts) == 0 {
+			continue
+		}
+		if lineNo == 1 && strings.Join(parts, " ") == header {
+			continue
+		}
+		if len(parts) != 3 {
+			return nil, errors.Wrapf(errInvalid, "line %d", lineNo)
+		}
+		nanos, err1 := strconv.ParseInt(parts[1], 10, 64)
+		value, err2 := strconv.ParseInt(parts[2], 10, 64)
+		if err1 != nil || err2 != nil {
+			return nil, errors.Wrapf(errInvalid, "line %d", lineNo)
+		}
+		t := time.Unix(0, nanos)
+
+		switch parts[0] {
+		case pointLine:
+			ix.Points = append(ix.Points, Point{Time: t, Offset: value})
+			ix.Closed = false
+		case closedLine:
+			// the file appended after restart is closed again, the entries add up
+			ix.Entries += value
+			if t.After(ix.Last) {
+				ix.Last = t
+			}
+			ix.Closed = true
+		case skewLine:
+			if skew := time.Duration(nanos); skew > ix.Skew {
+				ix.Skew = skew
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "read index error")
+	}
+
+	if len(ix.Points) > 0 {
+		ix.First = ix.Points[0].Time
+		if last := ix.Points[len(ix.Points)-1].Time; last.After(ix.Last) {
+			ix.Last = last
+		}
+	}
+	return ix, nil
+}
+
+// Overlaps returns whether the file may have the entries in [since, until),
+// the zero time means no bound.
+func (ix *Index) Overlaps(since, until time.Time) bool {
+	if len(ix.Points) == 0 {
+		return !ix.Closed
+	}
+	if !until.IsZero() && !ix.First.Add(-ix.Skew).Before(until) {
+		return false
+	}
+	if !since.IsZero() && ix.Closed && ix.Last.Before(since) {
+		return false
+	}
+	return true
+}
+
+// Seek returns the offset to read the entries at or after t from, which is
+// the last point before t.
+func (ix *Index) Seek(t time.Time) int64 {
+	if len(ix.Points) == 0 || t.IsZero() {
+		return 0
+	}
+	i := sort.Search(len(ix.Points), func(i int) bool {
+		return !ix.Points[i].Time.Before(t)
+	})
+	if i == 0 {
+		return ix.Points[0].Offset
+	}
+	return ix.Points[i-1].Offset
+}
+
+// End returns the offset of the first point at or after t by Skew, where the
+// entries before t end, or -1 if to the end of file.
+func (ix *Index) End(t time.Time) int64 {
+	if t.IsZero() {
+		return -1
+	}
+	t = t.Add(ix.Skew)
+	i := sort.Search(len(ix.Points), func(i int) bool {
+		return !ix.Points[i].Time.Before(t)
+	})
+	if i == len(ix.Points) {
+		return -1
+	}
+	return ix.Points[i].Offset
+}
+
+// Hit the range of file to read, End is -1 if to the end of file.
+// The Index is nil if the file has no index, then the whole file is read.
+type Hit struct {
+	File   string
+	Offset int64
+	End    int64
+	Index  *Index
+}
+
+// Lookup returns the files which may have the entries in [since, until),
+// with the range of each to read, the files without a valid index are
+// read as a whole.
+func Lookup(files []string, since, until time.Time) ([]Hit, error) {
+	hits := make([]Hit, 0, len(files))
+	for _, file := range files {
+		ix, err := ReadFile(file + Ext)
+		if err != nil {
+			if !os.IsNotExist(err) && errors.Cause(err) != errInvalid {
+				return nil, errors.Wrapf(err, "read index of %s error", file)
+			}
+			hits = append(hits, Hit{File: file, End: -1})
+			continue
+		}
+		if !ix.Overlaps(since, until) {
+			continue
+		}
+		hits = append(hits, Hit{File: file, Offset: ix.Seek(since), End: ix.End(until), Index: ix})
+	}
+	return hits, nil
+}
+
+// Writer writes the points of the log file, one point per every entries
+// or interval, whichever first.
+type Writer struct {
+	file      *os.File
+	w         *bufio.Writer
+	every     int
+	interval  time.Duration
+	offset    int64
+	count     int
+	entries   int64
+	pointTime time.Time
+	last      time.Time
+	skew      time.Duration
+	hasPoint  bool
+}
+
+// NewWriter opens the index file of the log file at the offset, the index
+// is appended if the log file is appended, or recreated if the log file is new.
+func NewWriter(file string, offset int64, every int, interval time.Duration) (*Writer, error) {
+	if every <= 0 || interval <= 0 {
+		return nil, errors.Errorf("invalid index every %d or interval %v", every, interval)
+	}
+	flag := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	if offset == 0 {
+		flag |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(file, flag, 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "open index file error")
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, errors.Wrap(err, "stat index file error")
+	}
+
+	iw := &Writer{
+		file:     f,
+		w:        bufio.NewWriter(f),
+		every:    every,
+		interval: interval,
+		offset:   offset,
+	}
+	if info.Size() == 0 {
+		iw.writeLine(header)
+		if offset > 0 {
+			// the content written before the index is unindexed
+			iw.writeLine(fmt.Sprintf("%s 0 0", pointLine))
+		}
+	}
+	return iw, iw.w.Flush()
+}
+
+// Add indexes the entry of size written at the current offset.
+func (iw *Writer) Add(t time.Time, size int) error {
+	defer func() {
+		iw.offset += int64(size)
+		iw.count++
+		iw.entries++
+		if t.After(iw.last) {
+			iw.last = t
+		}
+	}()
+
+	if iw.entries > 0 && iw.last.Sub(t) > iw.skew {
+		// written ahead by the newer entry, the readers widen the ranges
+		iw.skew = iw.last.Sub(t)
+		iw.writeLine(fmt.Sprintf("%s %d 0", skewLine, int64(iw.skew)))
+		if err := iw.w.Flush(); err != nil {
+			return errors.Wrap(err, "write index error")
+		}
+	}
+	if iw.hasPoint && iw.count < iw.every && t.Sub(iw.pointTime) < iw.interval {
+		return nil
+	}
+	iw.hasPoint = true
+	iw.count = 0
+	iw.pointTime = t
+	if iw.entries > 0 && iw.last.After(t) {
+		iw.pointTime = iw.last
+	}
+	iw.writeLine(fmt.Sprintf("%s %d %d", pointLine, iw.pointTime.UnixNano(), iw.offset))
+	// the points are sparse, flushed at once for the readers
+	return errors.Wrap(iw.w.Flush(), "write index error")
+}
+
+// Offset returns the offset of the next entry.
+func (iw *Writer) Offset() int64 {
+	return iw.offset
+}
+
+// Close writes the last time and the number of entries, then closes the file.
+func (iw *Writer) Close() error {
+	if iw.entries > 0 {
+		iw.writeLine(fmt.Sprintf("%s %d %d", closedLine, iw.last.UnixNano(), iw.entries))
+	}
+	err := iw.w.Flush()
+	if closeErr := iw.file.Close(); err == nil {
+		err = closeErr
+	}
+	return errors.Wrap(err, "close index error")
+}
+
+func (iw *Writer) writeLine(line string) {
+	_, _ = iw.w.WriteString(line)
+	_ = iw.w.WriteByte('\n')
+}
@@ -0,0 +1,199 @@
+package index
+
+import (
+	"io/ioutil"
+	"path"
+	"testing"
+	"time"
+)
+
+func writeIndex(t *testing.T, file string, offset int64, every int, interval time.Duration, times []time.Time) {
+	t.Helper()
+	w, err := NewWriter(file, offset, every, interval)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tm := range times {
+		if err := w.Add(tm, 10); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWriter(t *testing.T) {
+	start := time.Date(2021, 6, 15, 3, 0, 0, 0, time.Local)
+	minutes := func(ms ...int) []time.Time {
+		times := make([]time.Time, 0, len(ms))
+		for _, m := range ms {
+			times = append(times, start.Add(time.Duration(m)*time.Minute))
+		}
+		return times
+	}
+
+	t.Run("when every entries or interval then point", func(t *testing.T) {
+		file := path.Join(t.TempDir(), "app.log"+Ext)
+		writeIndex(t, file, 0, 3, 10*time.Minute, minutes(0, 1, 2, 3, 4, 20, 21))
+		ix, err := ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []Point{{start, 0}, {start.Add(3 * time.Minute), 30}, {start.Add(20 * time.Minute), 50}}
+		if len(ix.Points) != len(want) {
+			t.Fatalf("points: %+v", ix.Points)
+		}
+		for i, p := range want {
+			if !ix.Points[i].Time.Equal(p.Time) || ix.Points[i].Offset != p.Offset {
+				t.Errorf("point %d = %+v, want %+v", i, ix.Points[i], p)
+			}
+		}
+		if !ix.Closed || ix.Entries != 7 || !ix.First.Equal(start) || !ix.Last.Equal(start.Add(21*time.Minute)) {
+			t.Errorf("index: %+v", ix)
+		}
+	})
+
+	t.Run("when appended after restart then entries add up", func(t *testing.T) {
+		file := path.Join(t.TempDir(), "app.log"+Ext)
+		writeIndex(t, file, 0, 1, time.Hour, minutes(0, 1))
+		writeIndex(t, file, 20, 1, time.Hour, minutes(2))
+		ix, err := ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ix.Closed || ix.Entries != 3 || len(ix.Points) != 3 || ix.Points[2].Offset != 20 {
+			t.Errorf("index: %+v", ix)
+		}
+	})
+
+	t.Run("when file written before index then unindexed point", func(t *testing.T) {
+		file := path.Join(t.TempDir(), "app.log"+Ext)
+		writeIndex(t, file, 100, 1, time.Hour, minutes(5))
+		ix, err := ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ix.Points) != 2 || ix.Points[0].Offset != 0 || ix.Points[0].Time.UnixNano() != 0 {
+			t.Fatalf("points: %+v", ix.Points)
+		}
+		if !ix.Overlaps(start, start.Add(time.Minute)) || ix.Seek(start.Add(time.Minute)) != 0 {
+			t.Errorf("the unindexed content should be read")
+		}
+	})
+
+	t.Run("when out of order then skew recorded and points sorted", func(t *testing.T) {
+		file := path.Join(t.TempDir(), "app.log"+Ext)
+		// the entry of 5 is written after the one of 10, at the offset 20
+		writeIndex(t, file, 0, 2, time.Hour, minutes(0, 10, 5, 11, 12, 20))
+		ix, err := ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []Point{{start, 0}, {start.Add(10 * time.Minute), 20}, {start.Add(12 * time.Minute), 40}}
+		if len(ix.Points) != len(want) || ix.Skew != 5*time.Minute {
+			t.Fatalf("index: %+v", ix)
+		}
+		for i, p := range want {
+			if !ix.Points[i].Time.Equal(p.Time) || ix.Points[i].Offset != p.Offset {
+				t.Errorf("point %d = %+v, want %+v", i, ix.Points[i], p)
+			}
+		}
+		// the entry of 5 is before 6, read to the point of 12
+		if end := ix.End(start.Add(6 * time.Minute)); end != 40 {
+			t.Errorf("End() = %d, want 40", end)
+		}
+		if seek := ix.Seek(start.Add(5 * time.Minute)); seek != 0 {
+			t.Errorf("Seek() = %d, want 0", seek)
+		}
+	})
+
+	t.Run("when invalid every then error", func(t *testing.T) {
+		if _, err := NewWriter(path.Join(t.TempDir(), "app.log"+Ext), 0, 0, time.Minute); err == nil {
+			t.Errorf("want error")
+		}
+	})
+}
+
+func TestIndex_Range(t *testing.T) {
+	at := func(m int) time.Time {
+		return time.Date(2021, 6, 15, 3, m, 0, 0, time.Local)
+	}
+	ix := &Index{
+		First:  at(0),
+		Last:   at(30),
+		Points: []Point{{at(0), 0}, {at(10), 100}, {at(20), 200}},
+		Closed: true,
+	}
+
+	tests := []struct {
+		name         string
+		since, until time.Time
+		overlaps     bool
+		seek, end    int64
+	}{
+		{"when no bound then whole file", time.Time{}, time.Time{}, true, 0, -1},
+		{"when in the middle then between points", at(12), at(15), true, 100, 200},
+		{"when at point then from previous point", at(10), at(20), true, 0, 200},
+		{"when after the last point then to the end", at(25), time.Time{}, true, 200, -1},
+		{"when before the first then no overlap", time.Time{}, at(0), false, 0, 0},
+		{"when after the last then no overlap", at(31), time.Time{}, false, 200, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ix.Overlaps(tt.since, tt.until); got != tt.overlaps {
+				t.Errorf("Overlaps() = %v, want %v", got, tt.overlaps)
+			}
+			if !tt.overlaps {
+				return
+			}
+			if got := ix.Seek(tt.since); got != tt.seek {
+				t.Errorf("Seek() = %d, want %d", got, tt.seek)
+			}
+			if got := ix.End(tt.until); got != tt.end {
+				t.Errorf("End() = %d, want %d", got, tt.end)
+			}
+		})
+	}
+
+	t.Run("when skewed then the end widened", func(t *testing.T) {
+		skewed := *ix
+		skewed.Skew = 5 * time.Minute
+		if !skewed.Overlaps(time.Time{}, at(-2)) {
+			t.Errorf("the entries before the first point by skew should overlap")
+		}
+		if got := skewed.End(at(12)); got != 200 {
+			t.Errorf("End() = %d, want 200", got)
+		}
+		if got := skewed.End(at(16)); got != -1 {
+			t.Errorf("End() = %d, want -1", got)
+		}
+	})
+
+	t.Run("when open then the last is unknown", func(t *testing.T) {
+		open := *ix
+		open.Closed = false
+		if !open.Overlaps(at(40), time.Time{}) {
+			t.Errorf("the file being written should overlap")
+		}
+	})
+}
+
+func TestLookup(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2021, 6, 15, 3, 0, 0, 0, time.Local)
+	indexed, skipped := path.Join(dir, "a.log"), path.Join(dir, "b.log")
+	writeIndex(t, indexed+Ext, 0, 1, time.Hour, []time.Time{start, start.Add(time.Hour)})
+	writeIndex(t, skipped+Ext, 0, 1, time.Hour, []time.Time{start.Add(-2 * time.Hour)})
+	unindexed, invalid := path.Join(dir, "c.log"), path.Join(dir, "d.log")
+	_ = ioutil.WriteFile(invalid+Ext, []byte("garbage\n"), 0644)
+
+	hits, err := Lookup([]string{indexed, skipped, unindexed, invalid}, start.Add(30*time.Minute), time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) != 3 || hits[0].File != indexed || hits[0].Offset != 0 || hits[0].Index == nil ||
+		hits[1].File != unindexed || hits[1].Index != nil || hits[1].End != -1 || hits[2].File != invalid {
+		t.Errorf("Lookup() = %+v", hits)
+	}
+}
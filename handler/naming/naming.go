@@ -0,0 +1,266 @@
+package naming
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultTemplate   = "{name}.{date}{ext}"
+	DefaultTimeFormat = "2006-01-02.150405"
+)
+
+type segmentKind int
+
+const (
+	literalSeg segmentKind = iota
+	nameSeg
+	dateSeg
+	seqSeg
+	// optSeqSeg the implicit sequence for the template without {seq},
+	// which is empty for 0, and ".n" for the others.
+	optSeqSeg
+	extSeg
+)
+
+type segment struct {
+	kind  segmentKind
+	value string
+}
+
+// Template is the naming template of backup files, the placeholders are:
+//
+//	{name}         the file name without extension
+//	{date:layout}  the backup time in the go layout, {date} means "2006-01-02.150405"
+//	{seq}          the sequence number from 0, to avoid the collision
+//	{ext}          the extension of file, such as ".log"
+//
+// If there is no {seq} in the template, ".n" is inserted before {ext} on collision.
+// The dates are rendered and matched in the local time, or the location set by In.
+type Template struct {
+	raw      string
+	segments []segment
+	loc      *time.Location
+}
+
+func ParseTemplate(tmpl string) (*Template, error) {
+	if tmpl == "" {
+		tmpl = DefaultTemplate
+	}
+
+	t := &Template{raw: tmpl, segments: make([]segment, 0), loc: time.Local}
+	var hasDate, hasSeq bool
+	for rest := tmpl; rest != ""; {
+		start := strings.Index(rest, "{")
+		if start < 0 {
+			t.appendLiteral(rest)
+			break
+		}
+		end := strings.Index(rest[start:], "}")
+		if end < 0 {
+			return nil, errors.Errorf("unclosed placeholder in template: %s", tmpl)
+		}
+		t.appendLiteral(rest[:start])
+
+		placeholder := rest[start+1 : start+end]
+		rest = rest[start+end+1:]
+		switch {
+		case placeholder == "name":
+			t.segments = append(t.segments, segment{kind: nameSeg})
+		case placeholder == "date":
+			t.segments = append(t.segments, segment{kind: dateSeg, value: DefaultTimeFormat})
+			hasDate = true
+		case strings.HasPrefix(placeholder, "date:"):
+			layout := placeholder[len("date:"):]
+			if layout == "" {
+				return nil, errors.Errorf("empty date layout in template: %s", tmpl)
+			}
+			t.segments = append(t.segments, segment{kind: dateSeg, value: layout})
+			hasDate = true
+		case placeholder == "seq":
+			t.segments = append(t.segments, segment{kind: seqSeg})
+			hasSeq = true
+		case placeholder == "ext":
+			t.segments = append(t.segments, segment{kind: extSeg})
+		default:
+			return nil, errors.Errorf("unknown placeholder {%s} in template: %s", placeholder, tmpl)
+		}
+	}
+
+	if !hasDate {
+		return nil, errors.Errorf("no {date} in template: %s", tmpl)
+	}
+	if !hasSeq {
+		t.insertOptSeq()
+	}
+	return t, nil
+}
+
+func (t *Template) appendLiteral(s string) {
+	if s != "" {
+		t.segments = append(t.segments, segment{kind: literalSeg, value: s})
+	}
+}
+
+// insertOptSeq inserts before the last {ext}, or at the end.
+func (t *Template) insertOptSeq() {
+	pos := len(t.segments)
+	for i := len(t.segments) - 1; i >= 0; i-- {
+		if t.segments[i].kind == extSeg {
+			pos = i
+			break
+		}
+	}
+	segments := make([]segment, 0, len(t.segments)+1)
+	segments = append(segments, t.segments[:pos]...)
+	segments = append(segments, segment{kind: optSeqSeg})
+	t.segments = append(segments, t.segments[pos:]...)
+}
+
+// In returns the copy of template rendering and matching the dates in the
+// location, such as the timezone of the rotation schedule.
+func (t *Template) In(loc *time.Location) *Template {
+	c := *t
+	c.loc = loc
+	return &c
+}
+
+// Format renders the file name of the given name, extension, time and sequence.
+func (t *Template) Format(name, ext string, tm time.Time, seq int) string {
+	var sb strings.Builder
+	for _, seg := range t.segments {
+		switch seg.kind {
+		case literalSeg:
+			sb.WriteString(seg.value)
+		case nameSeg:
+			sb.WriteString(name)
+		case dateSeg:
+			sb.WriteString(tm.In(t.loc).Format(seg.value))
+		case seqSeg:
+			sb.WriteString(strconv.Itoa(seq))
+		case optSeqSeg:
+			if seq > 0 {
+				sb.WriteString(fmt.Sprintf(".%d", seq))
+			}
+		case extSeg:
+			sb.WriteString(ext)
+		}
+	}
+	return sb.String()
+}
+
+// Matcher matches the file names rendered by the template for the given name
+// and extension, with an optional suffix such as the archive extension.
+type Matcher struct {
+	template *Template
+	re       *regexp.Regexp
+	loc      *time.Location
+}
+
+func (t *Template) Matcher(name, ext, suffix string) (*Matcher, error) {
+	m := &Matcher{template: t, loc: t.loc}
+
+	var sb strings.Builder
+	sb.WriteString("^")
+	for _, seg := range t.segments {
+		switch seg.kind {
+		case literalSeg:
+			sb.WriteString(regexp.QuoteMeta(seg.value))
+		case nameSeg:
+			sb.WriteString(regexp.QuoteMeta(name))
+		case dateSeg:
+			sb.WriteString("(" + layoutPattern(seg.value) + ")")
+		case seqSeg:
+			sb.WriteString(`(\d+)`)
+		case optSeqSeg:
+			sb.WriteString(`(?:\.(\d+))?`)
+		case extSeg:
+			sb.WriteString(regexp.QuoteMeta(ext))
+		}
+	}
+	sb.WriteString(regexp.QuoteMeta(suffix))
+	sb.WriteString("$")
+
+	re, err := regexp.Compile(sb.String())
+	if err != nil {
+		return nil, errors.Wrap(err, "compile template pattern error")
+	}
+	m.re = re
+	return m, nil
+}
+
+// Match returns the backup time and sequence of the file name.
+func (m *Matcher) Match(filename string) (time.Time, int, bool) {
+	match := m.re.FindStringSubmatch(filename)
+	if match == nil {
+		return time.Time{}, 0, false
+	}
+
+	// the first date placeholder decides the backup time
+	var tm time.Time
+	var seq int
+	group := 0
+	for _, seg := range m.template.segments {
+		switch seg.kind {
+		case dateSeg:
+			group++
+			if !tm.IsZero() {
+				continue
+			}
+			parsed, err := time.ParseInLocation(seg.value, match[group], m.loc)
+			if err != nil {
+				return time.Time{}, 0, false
+			}
+			tm = parsed
+		case seqSeg, optSeqSeg:
+			group++
+			if match[group] != "" {
+				seq, _ = strconv.Atoi(match[group])
+			}
+		}
+	}
+	return tm, seq, true
+}
+
+// layoutPattern converts the go time layout to the regexp pattern,
+// the elements are matched loosely, and checked by parsing.
+func layoutPattern(layout string) string {
+	elements := []struct {
+		elem    string
+		pattern string
+	}{
+		{"2006", `\d{4}`}, {"January", `[A-Za-z]+`}, {"Jan", `[A-Za-z]{3}`},
+		{"Monday", `[A-Za-z]+`}, {"Mon", `[A-Za-z]{3}`}, {"MST", `[A-Za-z]+`},
+		{"01", `\d{2}`}, {"02", `\d{2}`}, {"15", `\d{2}`}, {"03", `\d{2}`},
+		{"04", `\d{2}`}, {"05", `\d{2}`}, {"06", `\d{2}`}, {"PM", `[AP]M`},
+		{"-0700", `[+-]\d{4}`}, {"Z0700", `(?:Z|[+-]\d{4})`},
+		{"1", `\d{1,2}`}, {"2", `\d{1,2}`}, {"3", `\d{1,2}`},
+		{"4", `\d{1,2}`}, {"5", `\d{1,2}`},
+	}
+
+	var sb strings.Builder
+	for rest := layout; rest != ""; {
+		matched := false
+		for _, e := range elements {
+			if strings.HasPrefix(rest, e.elem) {
+				sb.WriteString(e.pattern)
+				rest = rest[len(e.elem):]
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			sb.WriteString(regexp.QuoteMeta(rest[:1]))
+			rest = rest[1:]
+		}
+	}
+	return sb.String()
+}
+
+func (t *Template) String() string {
+	return t.raw
+}
@@ -0,0 +1,101 @@
+package naming
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTemplate_Format(t *testing.T) {
+	tm := time.Date(2021, 6, 22, 22, 37, 30, 0, time.Local)
+	tests := []struct {
+		name     string
+		template string
+		seq      int
+		want     string
+	}{
+		{"default", "", 0, "info.2021-06-22.223730.log"},
+		{"default with seq", "", 2, "info.2021-06-22.223730.2.log"},
+		{"custom", "{name}-{date:2006-01-02}-{seq}{ext}", 0, "info-2021-06-22-0.log"},
+		{"custom with seq", "{name}-{date:2006-01-02}-{seq}{ext}", 3, "info-2021-06-22-3.log"},
+		{"without ext", "{name}_{date:20060102}", 1, "info_20210622.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := ParseTemplate(tt.template)
+			if err != nil {
+				t.Fatalf("ParseTemplate() error = %v", err)
+			}
+			if got := tmpl.Format("info", ".log", tm, tt.seq); got != tt.want {
+				t.Errorf("Format() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTemplate_In(t *testing.T) {
+	loc := time.FixedZone("UTC+14", 14*3600)
+	// the midnight of the location is the day before in the local time
+	day := time.Date(2021, 6, 22, 0, 0, 0, 0, loc)
+	tmpl, err := ParseTemplate("{name}-{date:2006-01-02}-{seq}{ext}")
+	if err != nil {
+		t.Fatalf("ParseTemplate() error = %v", err)
+	}
+	tmpl = tmpl.In(loc)
+
+	filename := tmpl.Format("info", ".log", day.In(time.UTC), 0)
+	if filename != "info-2021-06-22-0.log" {
+		t.Errorf("Format() = %s", filename)
+	}
+	m, err := tmpl.Matcher("info", ".log", "")
+	if err != nil {
+		t.Fatalf("Matcher() error = %v", err)
+	}
+	if got, _, ok := m.Match(filename); !ok || !got.Equal(day) {
+		t.Errorf("Match() = %v, %v, want %v", got, ok, day)
+	}
+}
+
+func TestParseTemplate(t *testing.T) {
+	for _, tmpl := range []string{"{name}{ext}", "{name}.{date{ext}", "{name}.{time}{ext}", "{name}.{date:}{ext}"} {
+		if _, err := ParseTemplate(tmpl); err == nil {
+			t.Errorf("ParseTemplate(%q) want error", tmpl)
+		}
+	}
+}
+
+func TestMatcher_Match(t *testing.T) {
+	tm := time.Date(2021, 6, 22, 22, 37, 30, 0, time.Local)
+	day := time.Date(2021, 6, 22, 0, 0, 0, 0, time.Local)
+	tests := []struct {
+		name     string
+		template string
+		filename string
+		wantTime time.Time
+		wantSeq  int
+		wantOk   bool
+	}{
+		{"default", "", "info.2021-06-22.223730.log.zip", tm, 0, true},
+		{"default with seq", "", "info.2021-06-22.223730.4.log.zip", tm, 4, true},
+		{"custom", "{name}-{date:2006-01-02}-{seq}{ext}", "info-2021-06-22-12.log.zip", day, 12, true},
+		{"other name", "", "error.2021-06-22.223730.log.zip", time.Time{}, 0, false},
+		{"not archived", "", "info.2021-06-22.223730.log", time.Time{}, 0, false},
+		{"invalid date", "", "info.2021-13-22.223730.log.zip", time.Time{}, 0, false},
+		{"current file", "", "info.log.zip", time.Time{}, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := ParseTemplate(tt.template)
+			if err != nil {
+				t.Fatalf("ParseTemplate() error = %v", err)
+			}
+			m, err := tmpl.Matcher("info", ".log", ".zip")
+			if err != nil {
+				t.Fatalf("Matcher() error = %v", err)
+			}
+			gotTime, gotSeq, gotOk := m.Match(tt.filename)
+			if gotOk != tt.wantOk || !gotTime.Equal(tt.wantTime) || gotSeq != tt.wantSeq {
+				t.Errorf("Match() = %v, %d, %v", gotTime, gotSeq, gotOk)
+			}
+		})
+	}
+}
@@ -0,0 +1,306 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/edditen/etlog/config"
+	"github.com/edditen/etlog/core"
+	"github.com/edditen/etlog/opt"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	otlpLogsPath       = "/v1/logs"
+	otlpScopeName      = "github.com/edditen/etlog"
+	defaultServiceName = "unknown_service"
+	jsonContentType    = "application/json"
+)
+
+// OTLPHandler exports entries to an opentelemetry collector
+// through OTLP/HTTP with json encoding.
+type OTLPHandler struct {
+	*BaseHandler
+	endpoint string
+	service  string
+	headers  map[string]string
+	client   *http.Client
+	batcher  *batcher
+}
+
+func NewOTLPHandler(conf *config.HandlerConfig) *OTLPHandler {
+	return &OTLPHandler{
+		BaseHandler: NewBaseHandler(conf),
+	}
+}
+
+func (oh *OTLPHandler) Init() error {
+	if err := oh.BaseHandler.Init(); err != nil {
+		return err
+	}
+
+	remote := settingRemote(oh.BaseHandler.handlerConfig.Remote)
+	if remote.Endpoint == "" {
+		return errors.New("otlp endpoint is empty")
+	}
+	if remote.Service == "" {
+		remote.Service = defaultServiceName
+	}
+
+	oh.endpoint = strings.TrimRight(remote.Endpoint, "/")
+	if !strings.HasSuffix(oh.endpoint, otlpLogsPath) {
+		oh.endpoint += otlpLogsPath
+	}
+	oh.service = remote.Service
+	oh.headers = remote.Headers
+	var err error
+	if oh.batcher, err = newBatcher(remote, oh.export); err != nil {
+		return err
+	}
+	oh.client = &http.Client{Timeout: time.Duration(remote.Timeout) * time.Millisecond}
+
+	return oh.batcher.Init()
+}
+
+func (oh *OTLPHandler) Handle(entry *core.LogEntry) error {
+	if !oh.BaseHandler.MarkerMatched(entry.Marker) {
+		return nil
+	}
+	if !oh.BaseHandler.Contains(entry.Level) {
+		return nil
+	}
+	return oh.batcher.Offer(entry)
+}
+
+func (oh *OTLPHandler) Shutdown() {
+	oh.batcher.Shutdown()
+}
+
+func (oh *OTLPHandler) export(entries LogEntries) (LogEntries, error) {
+	body, err := json.Marshal(NewOTLPLogsRequest(oh.service, entries))
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal otlp request error")
+	}
+
+	req, err := http.NewRequest(http.MethodPost, oh.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Wrap(err, "create otlp request error")
+	}
+	req.Header.Set("Content-Type", jsonContentType)
+	for k, v := range oh.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := oh.client.Do(req)
+	if err != nil {
+		return entries, errors.Wrap(err, "send otlp request error")
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests ||
+		resp.StatusCode == http.StatusBadGateway ||
+		resp.StatusCode == http.StatusServiceUnavailable ||
+		resp.StatusCode == http.StatusGatewayTimeout:
+		return entries, errors.Errorf("otlp export failed, status: %d", resp.StatusCode)
+	case resp.StatusCode >= 300:
+		return nil, errors.Errorf("otlp export rejected, status: %d, body: %s",
+			resp.StatusCode, respBody)
+	}
+
+	oh.checkPartialSuccess(respBody)
+	return nil, nil
+}
+
+func (oh *OTLPHandler) checkPartialSuccess(body []byte) {
+	if len(body) == 0 {
+		return
+	}
+	resp := &otlpLogsResponse{}
+	if err := json.Unmarshal(body, resp); err != nil {
+		return
+	}
+	if resp.PartialSuccess == nil || resp.PartialSuccess.RejectedLogRecords == "" ||
+		resp.PartialSuccess.RejectedLogRecords == "0" {
+		return
+	}
+	opt.GetErrLog().Printf("otlp export partial success, rejected: %s, msg: %s\n",
+		resp.PartialSuccess.RejectedLogRecords, resp.PartialSuccess.ErrorMessage)
+}
+
+// The OTLP logs data model, json encoded as the protobuf json mapping,
+// refer to https://github.com/open-telemetry/opentelemetry-proto
+
+type OTLPLogsRequest struct {
+	ResourceLogs []*OTLPResourceLogs `json:"resourceLogs"`
+}
+
+type OTLPResourceLogs struct {
+	Resource  *OTLPResource    `json:"resource"`
+	ScopeLogs []*OTLPScopeLogs `json:"scopeLogs"`
+}
+
+type OTLPResource struct {
+	Attributes []*OTLPKeyValue `json:"attributes"`
+}
+
+type OTLPScopeLogs struct {
+	Scope      *OTLPScope       `json:"scope"`
+	LogRecords []*OTLPLogRecord `json:"logRecords"`
+}
+
+type OTLPScope struct {
+	Name string `json:"name"`
+}
+
+type OTLPLogRecord struct {
+	TimeUnixNano         string          `json:"timeUnixNano"`
+	ObservedTimeUnixNano string          `json:"observedTimeUnixNano"`
+	SeverityNumber       int             `json:"severityNumber"`
+	SeverityText         string          `json:"severityText"`
+	Body                 *OTLPAnyValue   `json:"body"`
+	Attributes           []*OTLPKeyValue `json:"attributes,omitempty"`
+}
+
+type OTLPKeyValue struct {
+	Key   string        `json:"key"`
+	Value *OTLPAnyValue `json:"value"`
+}
+
+type OTLPAnyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+type otlpLogsResponse struct {
+	PartialSuccess *struct {
+		RejectedLogRecords string `json:"rejectedLogRecords"`
+		ErrorMessage       string `json:"errorMessage"`
+	} `json:"partialSuccess"`
+}
+
+func NewOTLPLogsRequest(service string, entries LogEntries) *OTLPLogsRequest {
+	records := make([]*OTLPLogRecord, 0, len(entries))
+	for _, entry := range entries {
+		records = append(records, NewOTLPLogRecord(entry))
+	}
+
+	return &OTLPLogsRequest{
+		ResourceLogs: []*OTLPResourceLogs{
+			{
+				Resource: &OTLPResource{
+					Attributes: []*OTLPKeyValue{
+						{Key: "service.name", Value: otlpValue(service)},
+					},
+				},
+				ScopeLogs: []*OTLPScopeLogs{
+					{
+						Scope:      &OTLPScope{Name: otlpScopeName},
+						LogRecords: records,
+					},
+				},
+			},
+		},
+	}
+}
+
+func NewOTLPLogRecord(entry *core.LogEntry) *OTLPLogRecord {
+	record := &OTLPLogRecord{
+		TimeUnixNano:         strconv.FormatInt(entry.Time.UnixNano(), 10),
+		ObservedTimeUnixNano: strconv.FormatInt(time.Now().UnixNano(), 10),
+		SeverityNumber:       SeverityNumber(entry.Level),
+		SeverityText:         entry.Level.String(),
+		Body:                 otlpValue(entry.Msg),
+		Attributes:           make([]*OTLPKeyValue, 0, len(entry.Fields)+4),
+	}
+
+	if entry.UseLoc {
+		record.Attributes = append(record.Attributes,
+			&OTLPKeyValue{Key: "code.filepath", Value: otlpValue(entry.SrcFile)},
+			&OTLPKeyValue{Key: "code.lineno", Value: otlpValue(entry.Line)},
+			&OTLPKeyValue{Key: "code.function", Value: otlpValue(entry.FuncName)},
+		)
+	}
+	if entry.Err != nil {
+		record.Attributes = append(record.Attributes,
+			&OTLPKeyValue{Key: "exception.message", Value: otlpValue(entry.Err.Error())})
+	}
+	for k, v := range entry.Fields {
+		record.Attributes = append(record.Attributes,
+			&OTLPKeyValue{Key: k, Value: otlpValue(v)})
+	}
+
+	return record
+}
+
+// SeverityNumber maps the level to the OTLP severity number.
+func SeverityNumber(level core.Level) int {
+	switch level {
+	case core.DEBUG:
+		return 5
+	case core.INFO:
+		return 9
+	case core.DATA:
+		return 10
+	case core.WARN:
+		return 13
+	case core.ERROR:
+		return 17
+	case core.FATAL:
+		return 21
+	}
+	return 0
+}
+
+func otlpValue(v interface{}) *OTLPAnyValue {
+	switch val := v.(type) {
+	case string:
+		return &OTLPAnyValue{StringValue: &val}
+	case bool:
+		return &OTLPAnyValue{BoolValue: &val}
+	case int:
+		return otlpInt(int64(val))
+	case int8:
+		return otlpInt(int64(val))
+	case int16:
+		return otlpInt(int64(val))
+	case int32:
+		return otlpInt(int64(val))
+	case int64:
+		return otlpInt(val)
+	case uint:
+		return otlpInt(int64(val))
+	case uint8:
+		return otlpInt(int64(val))
+	case uint16:
+		return otlpInt(int64(val))
+	case uint32:
+		return otlpInt(int64(val))
+	case float32:
+		f := float64(val)
+		return &OTLPAnyValue{DoubleValue: &f}
+	case float64:
+		return &OTLPAnyValue{DoubleValue: &val}
+	case time.Time:
+		s := val.Format(time.RFC3339Nano)
+		return &OTLPAnyValue{StringValue: &s}
+	case error:
+		s := val.Error()
+		return &OTLPAnyValue{StringValue: &s}
+	}
+	s := fmt.Sprint(v)
+	return &OTLPAnyValue{StringValue: &s}
+}
+
+func otlpInt(i int64) *OTLPAnyValue {
+	s := strconv.FormatInt(i, 10)
+	return &OTLPAnyValue{IntValue: &s}
+}
@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"encoding/json"
+	"github.com/edditen/etlog/config"
+	"github.com/edditen/etlog/core"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNewOTLPLogRecord(t *testing.T) {
+	t.Run("when entry with loc then map to otlp record", func(t *testing.T) {
+		entry := &core.LogEntry{
+			Time:     time.Unix(1623730845, 152),
+			Level:    core.WARN,
+			Msg:      "hello world",
+			Err:      errors.New("oops"),
+			UseLoc:   true,
+			SrcFile:  "hello.go",
+			Line:     123,
+			FuncName: "main.run",
+			Fields:   core.Fields{"count": 3},
+		}
+		record := NewOTLPLogRecord(entry)
+		if record.TimeUnixNano != "1623730845000000152" {
+			t.Errorf("unexpected time: %s", record.TimeUnixNano)
+		}
+		if record.SeverityNumber != 13 || record.SeverityText != "WARN" {
+			t.Errorf("unexpected severity: %d %s", record.SeverityNumber, record.SeverityText)
+		}
+		if *record.Body.StringValue != "hello world" {
+			t.Errorf("unexpected body: %s", *record.Body.StringValue)
+		}
+
+		attrs := make(map[string]*OTLPAnyValue)
+		for _, kv := range record.Attributes {
+			attrs[kv.Key] = kv.Value
+		}
+		if *attrs["code.filepath"].StringValue != "hello.go" ||
+			*attrs["code.lineno"].IntValue != "123" ||
+			*attrs["code.function"].StringValue != "main.run" {
+			t.Errorf("unexpected code attributes: %v", attrs)
+		}
+		if *attrs["exception.message"].StringValue != "oops" {
+			t.Errorf("unexpected exception attribute")
+		}
+		if *attrs["count"].IntValue != "3" {
+			t.Errorf("unexpected field attribute")
+		}
+	})
+}
+
+func TestOTLPHandler_Handle(t *testing.T) {
+	t.Run("when collector unavailable then retry", func(t *testing.T) {
+		var calls int32
+		var received *OTLPLogsRequest
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != otlpLogsPath {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			if atomic.AddInt32(&calls, 1) == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			body, _ := ioutil.ReadAll(r.Body)
+			received = &OTLPLogsRequest{}
+			_ = json.Unmarshal(body, received)
+			_, _ = w.Write([]byte(`{}`))
+		}))
+		defer server.Close()
+
+		conf := config.NewHandlerConfig()
+		conf.Levels = []string{"info"}
+		conf.Remote.Endpoint = server.URL
+		conf.Remote.Service = "demo"
+		conf.Remote.RetryBackoff = 1
+
+		h := NewOTLPHandler(conf)
+		if err := h.Init(); err != nil {
+			t.Fatalf("init err: %+v", err)
+		}
+		_ = h.Handle(&core.LogEntry{Time: time.Now(), Level: core.INFO, Msg: "hello"})
+		h.Shutdown()
+
+		if atomic.LoadInt32(&calls) != 2 {
+			t.Fatalf("expect 2 calls, got: %d", calls)
+		}
+		if received == nil || len(received.ResourceLogs) != 1 {
+			t.Fatalf("expect resource logs received")
+		}
+		records := received.ResourceLogs[0].ScopeLogs[0].LogRecords
+		if len(records) != 1 || *records[0].Body.StringValue != "hello" {
+			t.Errorf("unexpected records: %v", records)
+		}
+		if *received.ResourceLogs[0].Resource.Attributes[0].Value.StringValue != "demo" {
+			t.Errorf("unexpected service name")
+		}
+	})
+}
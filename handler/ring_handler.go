@@ -0,0 +1,163 @@
+package handler
+
+import (
+	"github.com/edditen/etlog/common/bufferpool"
+	"github.com/edditen/etlog/common/queue"
+	"github.com/edditen/etlog/config"
+	"github.com/edditen/etlog/core"
+	"github.com/pkg/errors"
+	"os"
+	"path"
+	"sync"
+)
+
+const (
+	defaultRingSize  = 1000
+	defaultDumpLevel = "error"
+)
+
+var allLevels = []string{"debug", "info", "data", "warn", "error", "fatal"}
+
+// RingHandler keeps the most recent entries of all levels in memory,
+// and dumps them when an entry at or above the dump level arrived.
+type RingHandler struct {
+	*BaseHandler
+	ring        *queue.RingBuffer
+	dumpLevel   core.Level
+	dumpFile    string
+	dumpHandler Handler
+	dumped      uint64
+	dumpMutex   *sync.Mutex
+}
+
+func NewRingHandler(conf *config.HandlerConfig) *RingHandler {
+	return &RingHandler{
+		BaseHandler: NewBaseHandler(conf),
+		dumpMutex:   new(sync.Mutex),
+	}
+}
+
+func (rh *RingHandler) Init() error {
+	rh.BaseHandler.DefaultSetting()
+	if len(rh.BaseHandler.handlerConfig.Levels) == 0 {
+		rh.BaseHandler.handlerConfig.Levels = allLevels
+	}
+	if err := rh.BaseHandler.Init(); err != nil {
+		return err
+	}
+
+	ringConf := rh.BaseHandler.handlerConfig.Ring
+	if ringConf.Size <= 0 {
+		ringConf.Size = defaultRingSize
+	}
+	if ringConf.DumpLevel == "" {
+		ringConf.DumpLevel = defaultDumpLevel
+	}
+	if ringConf.DumpFile == "" && ringConf.Dump == nil {
+		return errors.New("ring dump file and dump handler are both empty")
+	}
+
+	rh.ring = queue.NewRingBuffer(ringConf.Size)
+	rh.dumpLevel = core.NewLevel(ringConf.DumpLevel)
+	rh.dumpFile = ringConf.DumpFile
+
+	return rh.settingDumpHandler(ringConf.Dump)
+}
+
+func (rh *RingHandler) settingDumpHandler(conf *config.HandlerConfig) error {
+	if conf == nil {
+		return nil
+	}
+	// the entries in ring are already filtered by the ring handler
+	conf.Marker = rh.BaseHandler.marker
+	if len(conf.Levels) == 0 {
+		conf.Levels = allLevels
+	}
+
+	rh.dumpHandler = HandlerFactory(conf)
+	if err := rh.dumpHandler.Init(); err != nil {
+		return errors.Wrap(err, "init ring dump handler error")
+	}
+	return nil
+}
+
+func (rh *RingHandler) Handle(entry *core.LogEntry) error {
+	if !rh.BaseHandler.MarkerMatched(entry.Marker) {
+		return nil
+	}
+	if !rh.BaseHandler.Contains(entry.Level) {
+		return nil
+	}
+
+	rh.ring.Put(entry)
+	if entry.Level >= rh.dumpLevel {
+		return rh.Dump()
+	}
+	return nil
+}
+
+func (rh *RingHandler) Shutdown() {
+	if rh.dumpHandler != nil {
+		rh.dumpHandler.Shutdown()
+	}
+}
+
+// IgnoreLevel the ring wants the entries below the level of logger.
+func (rh *RingHandler) IgnoreLevel() bool {
+	return true
+}
+
+// Dump writes the entries in ring which have not been dumped yet.
+func (rh *RingHandler) Dump() error {
+	rh.dumpMutex.Lock()
+	defer rh.dumpMutex.Unlock()
+
+	vals, last := rh.ring.Snapshot(rh.dumped)
+	rh.dumped = last
+	if len(vals) == 0 {
+		return nil
+	}
+
+	entries := make(LogEntries, 0, len(vals))
+	for _, val := range vals {
+		entries = append(entries, val.(*core.LogEntry))
+	}
+
+	if rh.dumpHandler != nil {
+		return rh.dumpToHandler(entries)
+	}
+	return rh.dumpToFile(entries)
+}
+
+func (rh *RingHandler) dumpToHandler(entries LogEntries) error {
+	for _, entry := range entries {
+		if err := rh.dumpHandler.Handle(entry); err != nil {
+			return errors.Wrap(err, "ring dump to handler error")
+		}
+	}
+	return nil
+}
+
+func (rh *RingHandler) dumpToFile(entries LogEntries) error {
+	if err := os.MkdirAll(path.Dir(rh.dumpFile), os.ModePerm); err != nil {
+		return errors.Wrap(err, "create dump dir error")
+	}
+	f, err := os.OpenFile(rh.dumpFile, fileFlag, fileMode)
+	if err != nil {
+		return errors.Wrap(err, "open dump file error")
+	}
+	defer f.Close()
+
+	buf := bufferpool.Borrow()
+	defer buf.Free()
+	for _, entry := range entries {
+		b := rh.BaseHandler.formatter.Format(entry)
+		buf.AppendBytes(b.Bytes())
+		b.Free()
+	}
+
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		return errors.Wrap(err, "write dump file error")
+	}
+	return nil
+}
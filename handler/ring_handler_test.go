@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"github.com/edditen/etlog/config"
+	"github.com/edditen/etlog/core"
+	"io/ioutil"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRingHandler_Handle(t *testing.T) {
+	newHandler := func(t *testing.T, size int) (*RingHandler, string) {
+		dumpFile := path.Join(t.TempDir(), "dump.log")
+		conf := config.NewHandlerConfig()
+		conf.Ring.Size = size
+		conf.Ring.DumpFile = dumpFile
+		h := NewRingHandler(conf)
+		if err := h.Init(); err != nil {
+			t.Fatalf("init err: %+v", err)
+		}
+		return h, dumpFile
+	}
+	entry := func(level core.Level, msg string) *core.LogEntry {
+		return &core.LogEntry{Time: time.Now(), Level: level, Msg: msg}
+	}
+
+	t.Run("when error arrived then dump recent entries", func(t *testing.T) {
+		h, dumpFile := newHandler(t, 2)
+		_ = h.Handle(entry(core.DEBUG, "first"))
+		_ = h.Handle(entry(core.DEBUG, "second"))
+		if err := h.Handle(entry(core.ERROR, "boom")); err != nil {
+			t.Fatalf("handle err: %+v", err)
+		}
+
+		b, err := ioutil.ReadFile(dumpFile)
+		if err != nil {
+			t.Fatalf("read dump err: %+v", err)
+		}
+		lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+		if len(lines) != 2 || !strings.Contains(lines[0], "second") || !strings.Contains(lines[1], "boom") {
+			t.Errorf("unexpected dump: %s", b)
+		}
+	})
+
+	t.Run("when dump twice then only new entries dumped", func(t *testing.T) {
+		h, dumpFile := newHandler(t, 10)
+		_ = h.Handle(entry(core.INFO, "first"))
+		_ = h.Dump()
+		_ = h.Dump()
+		_ = h.Handle(entry(core.INFO, "second"))
+		_ = h.Dump()
+
+		b, _ := ioutil.ReadFile(dumpFile)
+		if strings.Count(string(b), "first") != 1 || strings.Count(string(b), "second") != 1 {
+			t.Errorf("unexpected dump: %s", b)
+		}
+	})
+
+	t.Run("when no error then nothing dumped", func(t *testing.T) {
+		h, dumpFile := newHandler(t, 10)
+		_ = h.Handle(entry(core.WARN, "hello"))
+		if _, err := ioutil.ReadFile(dumpFile); err == nil {
+			t.Errorf("expect no dump file")
+		}
+	})
+}
@@ -5,9 +5,12 @@ import (
 	"github.com/edditen/etlog/config"
 	"github.com/edditen/etlog/core"
 	"github.com/edditen/etlog/handler"
+	"github.com/edditen/etlog/handler/archiver"
+	"github.com/edditen/etlog/handler/cleaner"
 	"github.com/edditen/etlog/opt"
 	"log"
 	"os"
+	"sync/atomic"
 	"time"
 )
 
@@ -43,6 +46,9 @@ var (
 
 var Log Logger
 
+// scopeSeq generates the scope of the loggers derived through With*
+var scopeSeq uint64
+
 func init() {
 	SetDefaultLog(newEtLogger())
 }
@@ -67,12 +73,31 @@ type Logger interface {
 	Enable(level core.Level) bool
 }
 
+// DurableLogger the optional interface of Logger, the entry logged after
+// WithDurable is synced to the disk before the logging returns, even if the
+// handler writes asynchronously.
+type DurableLogger interface {
+	Logger
+	WithDurable() Logger
+}
+
+// Durable returns the logger of durable entries, or the logger itself if
+// it is not a DurableLogger.
+func Durable(l Logger) Logger {
+	if dl, ok := l.(DurableLogger); ok {
+		return dl.WithDurable()
+	}
+	return l
+}
+
 type Handlers = []handler.Handler
 
 type internalLogger struct {
+	scope    uint64
 	err      error
 	fields   core.Fields
 	markers  []string
+	durable  bool
 	etLogger *EtLogger
 }
 
@@ -87,6 +112,9 @@ type EtLogger struct {
 	conf       *config.Config
 	handlers   map[string]*Handlers
 	internal   *internalLogger
+	ignoreLvl  bool
+	signalC    chan os.Signal
+	cleaner    *cleaner.RuleCleaner
 }
 
 func SetConfigPath(configPath string) OptionFunc {
@@ -173,7 +201,18 @@ func (el *EtLogger) init() (err error) {
 		return err
 	}
 	el.logLevel = core.NewLevel(el.conf.LogConf.Level)
+	el.ignoreLvl = hasLevelIgnorer(el.handlers)
 	el.internal = newInternalLogger(el)
+	// the entries logged by EtLogger directly belong to no scope
+	el.internal.scope = 0
+	if el.conf.LogConf.Cleaner != nil {
+		if el.cleaner, err = initCleaner(el.conf.LogConf.Cleaner); err != nil {
+			return err
+		}
+	}
+	if el.conf.LogConf.ReopenOnSighup {
+		el.watchSighup()
+	}
 
 	return nil
 }
@@ -210,8 +249,30 @@ func initHandlers(conf *config.Config) (map[string]*Handlers, error) {
 
 }
 
+func hasLevelIgnorer(handlers map[string]*Handlers) bool {
+	for _, hs := range handlers {
+		if hs == nil {
+			continue
+		}
+		for _, h := range *hs {
+			if ignoreLevel(h) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func ignoreLevel(h handler.Handler) bool {
+	if li, ok := h.(handler.LevelIgnorer); ok {
+		return li.IgnoreLevel()
+	}
+	return false
+}
+
 func newInternalLogger(etLogger *EtLogger) *internalLogger {
 	return &internalLogger{
+		scope:    atomic.AddUint64(&scopeSeq, 1),
 		err:      defaultErr(),
 		fields:   defaultFields(),
 		markers:  defaultMarkers(),
@@ -240,6 +301,11 @@ func (il *internalLogger) WithMarkers(markers ...string) Logger {
 	return il
 }
 
+func (il *internalLogger) WithDurable() Logger {
+	il.durable = true
+	return il
+}
+
 func (il *internalLogger) WithError(err error) Logger {
 	il.err = err
 	return il
@@ -276,6 +342,8 @@ func (il *internalLogger) finalize(level core.Level, msg string) (entry *core.Lo
 	entry.Msg = msg
 	entry.Err = il.err
 	entry.Fields = il.fields
+	entry.Scope = il.scope
+	entry.Durable = il.durable
 	if fname, line, funcName, ok := utils.ShortSourceLoc(il.etLogger.sourceSkip); ok {
 		entry.UseLoc = true
 		entry.SrcFile = fname
@@ -287,7 +355,13 @@ func (il *internalLogger) finalize(level core.Level, msg string) (entry *core.Lo
 }
 
 func (il *internalLogger) Log(level core.Level, msg string) {
-	if !il.Enable(level) {
+	enabled := il.Enable(level)
+	if !enabled {
+		// the entries below level only go to the handlers ignoring level
+		if il.etLogger.ignoreLvl {
+			il.logIgnoreLevel(level, msg)
+		}
+		il.clean()
 		return
 	}
 	entry := il.finalize(level, msg)
@@ -316,10 +390,33 @@ func (il *internalLogger) Log(level core.Level, msg string) {
 	return
 }
 
+func (il *internalLogger) logIgnoreLevel(level core.Level, msg string) {
+	entry := il.finalize(level, msg)
+
+	for marker, handlers := range il.etLogger.handlers {
+		if handlers == nil || !il.contains(marker) {
+			continue
+		}
+
+		e := entry.Copy()
+		e.Marker = marker
+
+		for _, h := range *handlers {
+			if !ignoreLevel(h) {
+				continue
+			}
+			if err := h.Handle(e); err != nil {
+				opt.GetErrLog().Printf("handle log err: %+v\n", err)
+			}
+		}
+	}
+}
+
 func (il *internalLogger) clean() {
 	il.err = defaultErr()
 	il.fields = defaultFields()
 	il.markers = defaultMarkers()
+	il.durable = false
 }
 
 func (il *internalLogger) preHandle(entry *core.LogEntry) {
@@ -421,6 +518,117 @@ func (el *EtLogger) WithMarkers(markers ...string) Logger {
 	return newInternalLogger(el).WithMarkers(markers...)
 }
 
+func (el *EtLogger) WithDurable() Logger {
+	return newInternalLogger(el).WithDurable()
+}
+
+// Dump dumps the entries kept in memory by the handlers, such as ring.
+func (el *EtLogger) Dump() error {
+	for _, hs := range el.handlers {
+		if hs == nil {
+			continue
+		}
+		for _, h := range *hs {
+			if d, ok := h.(handler.Dumper); ok {
+				if err := d.Dump(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Reopen reopens the files of handlers, it should be called after the files
+// were moved by other tools, such as logrotate.
+func (el *EtLogger) Reopen() error {
+	for _, hs := range el.handlers {
+		if hs == nil {
+			continue
+		}
+		for _, h := range *hs {
+			if r, ok := h.(handler.Reopener); ok {
+				if err := r.Reopen(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Stats returns the counters of the async handlers, grouped by marker.
+func (el *EtLogger) Stats() map[string][]handler.AsyncStats {
+	stats := make(map[string][]handler.AsyncStats)
+	for marker, hs := range el.handlers {
+		if hs == nil {
+			continue
+		}
+		for _, h := range *hs {
+			if sr, ok := h.(handler.StatsReporter); ok {
+				stats[marker] = append(stats[marker], sr.Stats())
+			}
+		}
+	}
+	return stats
+}
+
+// ArchiveStats returns the archiver counters of the file handlers,
+// grouped by marker.
+func (el *EtLogger) ArchiveStats() map[string][]archiver.Stats {
+	stats := make(map[string][]archiver.Stats)
+	for marker, hs := range el.handlers {
+		if hs == nil {
+			continue
+		}
+		for _, h := range *hs {
+			if ah, ok := h.(*handler.AsyncHandler); ok {
+				h = ah.Unwrap()
+			}
+			if ar, ok := h.(handler.ArchiveReporter); ok {
+				stats[marker] = append(stats[marker], ar.ArchiveStats())
+			}
+		}
+	}
+	return stats
+}
+
+// FsyncStats returns the fsync counters and latency of the file handlers,
+// grouped by marker.
+func (el *EtLogger) FsyncStats() map[string][]handler.FsyncStats {
+	stats := make(map[string][]handler.FsyncStats)
+	for marker, hs := range el.handlers {
+		if hs == nil {
+			continue
+		}
+		for _, h := range *hs {
+			if ah, ok := h.(*handler.AsyncHandler); ok {
+				h = ah.Unwrap()
+			}
+			if fr, ok := h.(handler.FsyncReporter); ok {
+				stats[marker] = append(stats[marker], fr.FsyncStats())
+			}
+		}
+	}
+	return stats
+}
+
+// Shutdown shutdowns all the handlers, the queued entries will be flushed.
+func (el *EtLogger) Shutdown() {
+	el.stopSighup()
+	if el.cleaner != nil {
+		el.cleaner.Shutdown()
+	}
+	for _, hs := range el.handlers {
+		if hs == nil {
+			continue
+		}
+		for _, h := range *hs {
+			h.Shutdown()
+		}
+	}
+}
+
 func (el *EtLogger) Enable(level core.Level) bool {
 	if level < el.logLevel {
 		return false
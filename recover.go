@@ -0,0 +1,32 @@
+package etlog
+
+import (
+	"github.com/edditen/etlog/opt"
+	"github.com/pkg/errors"
+	"runtime/debug"
+)
+
+// Recover recovers the panic, logs it with the stack and dumps the entries
+// kept in memory, it should be called directly by defer:
+//
+//	defer etlog.Recover()
+func Recover() {
+	if r := recover(); r != nil {
+		handlePanic(r)
+	}
+}
+
+func handlePanic(r interface{}) {
+	if Log == nil {
+		return
+	}
+	Log.WithError(errors.Errorf("panic: %v", r)).
+		WithField("stack", string(debug.Stack())).
+		Error("recovered from panic")
+
+	if el, ok := Log.(*EtLogger); ok {
+		if err := el.Dump(); err != nil {
+			opt.GetErrLog().Printf("dump on panic err: %+v\n", err)
+		}
+	}
+}
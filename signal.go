@@ -0,0 +1,32 @@
+package etlog
+
+import (
+	"github.com/edditen/etlog/opt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// watchSighup reopens the files on SIGHUP, which is sent by logrotate
+// in the postrotate script usually.
+func (el *EtLogger) watchSighup() {
+	el.signalC = make(chan os.Signal, 1)
+	signal.Notify(el.signalC, syscall.SIGHUP)
+
+	go func(signalC chan os.Signal) {
+		for range signalC {
+			if err := el.Reopen(); err != nil {
+				opt.GetErrLog().Printf("reopen on sighup err: %+v\n", err)
+			}
+		}
+	}(el.signalC)
+}
+
+func (el *EtLogger) stopSighup() {
+	if el.signalC == nil {
+		return
+	}
+	signal.Stop(el.signalC)
+	close(el.signalC)
+	el.signalC = nil
+}